@@ -0,0 +1,207 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultContentionThreshold = 100 * time.Microsecond
+	defaultContentionWindow    = time.Second
+	defaultIdleOps             = 1000
+)
+
+// AdaptiveStore starts as a single KVStore and moves its data to a ShardedKVStore when the lock gets contended,
+// then back to a single store once the traffic drops. The mode is reevaluated at the end of every window: the
+// store is sharded if the operations waited on average more than the contention threshold for the lock, and
+// unsharded if fewer than the idle threshold of operations ran in the window.
+//
+// A migration copies every entry with all the operations blocked, it only carries the values since the store is
+// only reachable through the Storer methods.
+type AdaptiveStore[K comparable, V any] struct {
+	// mu is taken for reading by every operation and for writing by the migrations.
+	mu      sync.RWMutex
+	single  *KVStore[K, V]
+	sharded *ShardedKVStore[K, V]
+
+	threshold time.Duration
+	window    time.Duration
+	idleOps   int64
+	clock     Clock
+
+	ops         atomic.Int64
+	windowStart atomic.Int64
+	// evalMu makes sure a single operation reevaluates the mode at the end of a window, the others go on.
+	evalMu sync.Mutex
+	// The wait totals of the single store at the start of the window, guarded by evalMu.
+	lastWaits   uint64
+	lastWaitsUS int64
+}
+
+// AdaptiveOption configures the optional behaviour of an AdaptiveStore.
+type AdaptiveOption func(*adaptiveConfig)
+
+type adaptiveConfig struct {
+	threshold time.Duration
+	window    time.Duration
+	idleOps   int
+	clock     Clock
+}
+
+// WithContentionThreshold sets the average lock wait over a window above which the store is sharded, the defaults
+// are 100µs over 1s.
+func WithContentionThreshold(wait, window time.Duration) AdaptiveOption {
+	return func(c *adaptiveConfig) {
+		c.threshold, c.window = wait, window
+	}
+}
+
+// WithIdleThreshold sets under how many operations per window a sharded store goes back to a single one, the
+// default is 1000.
+func WithIdleThreshold(ops int) AdaptiveOption {
+	return func(c *adaptiveConfig) {
+		c.idleOps = ops
+	}
+}
+
+// WithAdaptiveClock sets the clock the windows are measured with.
+func WithAdaptiveClock(clock Clock) AdaptiveOption {
+	return func(c *adaptiveConfig) {
+		c.clock = clock
+	}
+}
+
+func NewAdaptiveStore[K comparable, V any](opts ...AdaptiveOption) *AdaptiveStore[K, V] {
+	cfg := adaptiveConfig{
+		threshold: defaultContentionThreshold,
+		window:    defaultContentionWindow,
+		idleOps:   defaultIdleOps,
+		clock:     realClock{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	a := &AdaptiveStore[K, V]{
+		single:    NewKVStore[K, V](WithLockMetrics[K, V]()),
+		threshold: cfg.threshold,
+		window:    cfg.window,
+		idleOps:   int64(cfg.idleOps),
+		clock:     cfg.clock,
+	}
+	a.windowStart.Store(a.clock.Now().UnixNano())
+	return a
+}
+
+// Sharded reports whether the store is currently sharded.
+func (a *AdaptiveStore[K, V]) Sharded() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.sharded != nil
+}
+
+// store returns the current representation, must be called with mu held.
+func (a *AdaptiveStore[K, V]) store() Storer[K, V] {
+	if a.sharded != nil {
+		return a.sharded
+	}
+	return a.single
+}
+
+func (a *AdaptiveStore[K, V]) Put(key K, value V) error {
+	a.mu.RLock()
+	err := a.store().Put(key, value)
+	a.mu.RUnlock()
+	a.tick()
+	return err
+}
+
+func (a *AdaptiveStore[K, V]) Get(key K) (V, error) {
+	a.mu.RLock()
+	value, err := a.store().Get(key)
+	a.mu.RUnlock()
+	a.tick()
+	return value, err
+}
+
+func (a *AdaptiveStore[K, V]) Update(key K, value V) error {
+	a.mu.RLock()
+	err := a.store().Update(key, value)
+	a.mu.RUnlock()
+	a.tick()
+	return err
+}
+
+func (a *AdaptiveStore[K, V]) Delete(key K) (V, error) {
+	a.mu.RLock()
+	value, err := a.store().Delete(key)
+	a.mu.RUnlock()
+	a.tick()
+	return value, err
+}
+
+// tick counts an operation and reevaluates the mode if the window is over.
+func (a *AdaptiveStore[K, V]) tick() {
+	ops := a.ops.Add(1)
+	now := a.clock.Now().UnixNano()
+	if time.Duration(now-a.windowStart.Load()) < a.window || !a.evalMu.TryLock() {
+		return
+	}
+	defer a.evalMu.Unlock()
+	// Another operation may have reevaluated it while we were checking.
+	if time.Duration(now-a.windowStart.Load()) < a.window {
+		return
+	}
+
+	a.mu.RLock()
+	var shard, unshard bool
+	if a.sharded == nil {
+		stats := a.single.LockWaitStats()
+		waits, waitsUS := stats.Count-a.lastWaits, stats.TotalMicros-a.lastWaitsUS
+		a.lastWaits, a.lastWaitsUS = stats.Count, stats.TotalMicros
+		shard = waits > 0 && time.Duration(waitsUS/int64(waits))*time.Microsecond > a.threshold
+	} else {
+		unshard = ops < a.idleOps
+	}
+	a.mu.RUnlock()
+
+	switch {
+	case shard:
+		a.migrate(true)
+	case unshard:
+		a.migrate(false)
+	}
+	a.ops.Store(0)
+	a.windowStart.Store(a.clock.Now().UnixNano())
+}
+
+// migrate copies the data to the other representation, with every operation blocked meanwhile.
+func (a *AdaptiveStore[K, V]) migrate(toSharded bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if toSharded {
+		sharded := NewShardedKVStore[K, V]()
+		for key, value := range a.single.data {
+			if !a.single.isExpired(key) {
+				sharded.Put(key, value)
+			}
+		}
+		a.single.Close()
+		a.single, a.sharded = nil, sharded
+		return
+	}
+
+	single := NewKVStore[K, V](WithLockMetrics[K, V]())
+	for _, shard := range a.sharded.shards {
+		for key, value := range shard.data {
+			if !shard.isExpired(key) {
+				single.Put(key, value)
+			}
+		}
+	}
+	a.sharded.Close()
+	a.single, a.sharded = single, nil
+	a.lastWaits, a.lastWaitsUS = 0, 0
+}
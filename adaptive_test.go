@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveStoreShardsUnderContention(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewAdaptiveStore[string, string](
+		WithContentionThreshold(100*time.Microsecond, time.Second),
+		WithIdleThreshold(100),
+		WithAdaptiveClock(clock),
+	)
+	for i := 0; i < 100; i++ {
+		st.Put(fmt.Sprint("seed", i), "v")
+	}
+
+	// Writers and readers keep checking their own keys through the migrations.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key, value := fmt.Sprintf("w%d-%d", w, i%50), fmt.Sprint(i)
+				if err := st.Put(key, value); err != nil {
+					t.Errorf("put %s: %v", key, err)
+					return
+				}
+				if got, err := st.Get(key); err != nil || got != value {
+					t.Errorf("get %s: got %q, %v, want %q", key, got, err, value)
+					return
+				}
+			}
+		}(w)
+	}
+
+	// A burst of contention: the writers wait while the lock is held.
+	st.mu.RLock()
+	single := st.single
+	st.mu.RUnlock()
+	single.mu.Lock()
+	time.Sleep(20 * time.Millisecond)
+	single.mu.Unlock()
+
+	clock.Advance(time.Second)
+	waitFor(t, st.Sharded)
+	for i := 0; i < 100; i++ {
+		if v, err := st.Get(fmt.Sprint("seed", i)); err != nil || v != "v" {
+			t.Fatalf("seed%d was lost by the migration: %q, %v", i, v, err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	// The window of the writers was busy, the next idle one moves it back to a single store.
+	clock.Advance(time.Second)
+	st.Get("seed0")
+	if !st.Sharded() {
+		t.Fatal("the store was unsharded after a busy window")
+	}
+	clock.Advance(time.Second)
+	st.Get("seed0")
+	if st.Sharded() {
+		t.Fatal("the store is still sharded after an idle window")
+	}
+	if v, err := st.Get("seed99"); err != nil || v != "v" {
+		t.Fatalf("seed99 was lost going back: %q, %v", v, err)
+	}
+}
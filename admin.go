@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WithAPIKey makes every request but GET /health and GET /readyz need the key, sent as Authorization: Bearer
+// <key>. The admin key set with WithAdminKey is accepted too.
+func WithAPIKey(key string) ServerOption {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithAdminKey makes the admin routes, the ones under /admin and their older paths like /journal, need this key
+// instead of the API key, so the data can be shared with the applications without the powers to flush or
+// reconfigure the store. Without it the admin routes take the API key like the others.
+func WithAdminKey(key string) ServerOption {
+	return func(s *Server) {
+		s.adminKey = key
+	}
+}
+
+// bearerToken returns the token of the Authorization header, or "" if it isn't a bearer token.
+func bearerToken(c echo.Context) string {
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+func tokenMatches(token, key string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
+
+func unauthorized(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	return echo.NewHTTPError(http.StatusUnauthorized, message)
+}
+
+// authenticated is the middleware checking the API key of the requests, it's a no-op without WithAPIKey.
+func (s *Server) authenticated(next echo.HandlerFunc) echo.HandlerFunc {
+	if s.apiKey == "" {
+		return next
+	}
+	return func(c echo.Context) error {
+		if path := c.Path(); path == "/health" || path == "/readyz" {
+			return next(c)
+		}
+		if token := bearerToken(c); !tokenMatches(token, s.apiKey) && !tokenMatches(token, s.adminKey) {
+			return unauthorized(c, "a valid API key is required")
+		}
+		return next(c)
+	}
+}
+
+// adminOnly is the middleware of the admin routes, it lets only the admin key through when there's one. The
+// API key is rejected with 403 rather than 401: it's valid, it's just not enough.
+func (s *Server) adminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	if s.adminKey == "" {
+		return next
+	}
+	return func(c echo.Context) error {
+		token := bearerToken(c)
+		switch {
+		case tokenMatches(token, s.adminKey):
+			return next(c)
+		case tokenMatches(token, s.apiKey):
+			return echo.NewHTTPError(http.StatusForbidden, "the admin routes need the admin key")
+		}
+		return unauthorized(c, "a valid admin key is required")
+	}
+}
+
+// Flusher is implemented by stores that can remove all their keys at once.
+type Flusher interface {
+	Clear() error
+}
+
+// Compacter is implemented by stores that can release the memory of their deleted keys.
+type Compacter interface {
+	Compact() bool
+}
+
+// handleFlush serves POST /admin/flush, removing every key.
+func (s *Server) handleFlush(c echo.Context) error {
+	flusher, ok := s.Storage.(Flusher)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support flushing")
+	}
+	if err := s.fence(c, flusher.Clear); err != nil {
+		return toHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"msg": "ok"})
+}
+
+// handleCompact serves POST /admin/compact, see KVStore.Compact.
+func (s *Server) handleCompact(c echo.Context) error {
+	compacter, ok := s.Storage.(Compacter)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support compaction")
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"compacted": compacter.Compact()})
+}
+
+// handleGC serves POST /admin/gc, running a garbage collection and returning as much memory as possible to the
+// OS, e.g. after a flush or a compaction. It answers the heap size before and after, in bytes.
+func (s *Server) handleGC(c echo.Context) error {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	return c.JSON(http.StatusOK, map[string]uint64{"heap_before": before.HeapAlloc, "heap_after": after.HeapAlloc})
+}
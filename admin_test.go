@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// adminRoutes are the routes needing the admin key, with their older paths outside of /admin.
+var adminRoutes = []struct{ method, target, body string }{
+	{http.MethodPost, "/admin/config", `{"max_keys": 100}`},
+	{http.MethodPost, "/admin/compact", ""},
+	{http.MethodPost, "/admin/gc", ""},
+	{http.MethodGet, "/admin/stats", ""},
+	{http.MethodGet, "/stats", ""},
+	{http.MethodGet, "/admin/stats/shards", ""},
+	{http.MethodGet, "/stats/shards", ""},
+	{http.MethodGet, "/admin/journal", ""},
+	{http.MethodGet, "/journal", ""},
+	{http.MethodGet, "/admin/slowlog", ""},
+	{http.MethodGet, "/slowlog", ""},
+	{http.MethodGet, "/admin/debug/a", ""},
+	{http.MethodGet, "/debug/a", ""},
+	{http.MethodPost, "/admin/flush", ""},
+}
+
+func newAuthServer(t *testing.T, opts ...ServerOption) (*KVStore[string, string], http.Handler) {
+	t.Helper()
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	opts = append([]ServerOption{WithJournal(10), WithSlowLog(time.Hour, 10), WithDebug()}, opts...)
+	_, h := newTestServer(st, opts...)
+	return st, h
+}
+
+func TestAPIKey(t *testing.T) {
+	_, h := newAuthServer(t, WithAPIKey("app"))
+
+	rec := do(t, h, http.MethodGet, "/kv/a", "")
+	expectStatus(t, rec, http.StatusUnauthorized)
+	if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("got WWW-Authenticate %q", rec.Header().Get("WWW-Authenticate"))
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", "Authorization", "Bearer other"), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", "Authorization", "app"), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", "Authorization", "Bearer app"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/b", "2", "Authorization", "Bearer app"), http.StatusOK)
+
+	// The probes stay open.
+	expectStatus(t, do(t, h, http.MethodGet, "/health", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/readyz", ""), http.StatusOK)
+
+	// Without an admin key, the API key opens the admin routes too.
+	expectStatus(t, do(t, h, http.MethodGet, "/admin/stats", ""), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/admin/stats", "", "Authorization", "Bearer app"), http.StatusOK)
+}
+
+func TestAdminRoutesRejectTheAPIKey(t *testing.T) {
+	st, h := newAuthServer(t, WithAPIKey("app"), WithAdminKey("ops"))
+
+	for _, r := range adminRoutes {
+		rec := do(t, h, r.method, r.target, r.body, "Authorization", "Bearer app")
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s with the API key: got %d, want 403", r.method, r.target, rec.Code)
+		}
+		if rec := do(t, h, r.method, r.target, r.body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a key: got %d, want 401", r.method, r.target, rec.Code)
+		}
+	}
+	if _, err := st.Get("a"); err != nil {
+		t.Fatalf("the store was flushed with the API key: %v", err)
+	}
+
+	for _, r := range adminRoutes {
+		rec := do(t, h, r.method, r.target, r.body, "Authorization", "Bearer ops")
+		// A KVStore has no shards, its 501 for /stats/shards is past the auth.
+		if rec.Code != http.StatusOK && rec.Code != http.StatusNotImplemented {
+			t.Errorf("%s %s with the admin key: got %d", r.method, r.target, rec.Code)
+		}
+	}
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the flush with the admin key didn't go through: %v", err)
+	}
+
+	// The admin key is good for the data too.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "2", "Authorization", "Bearer ops"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", "Authorization", "Bearer app"), http.StatusOK)
+}
+
+func TestAdminKeyWithoutAPIKey(t *testing.T) {
+	_, h := newAuthServer(t, WithAdminKey("ops"))
+
+	// The data stays open, only the admin routes are closed.
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/compact", ""), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/compact", "", "Authorization", "Bearer ops"), http.StatusOK)
+}
+
+func TestFlushAndGC(t *testing.T) {
+	st, h := newAuthServer(t)
+	st.Put("b", "2")
+
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/flush", ""), http.StatusOK)
+	if n := keysLeft(st); n != 0 {
+		t.Fatalf("%d keys are left after the flush", n)
+	}
+
+	rec := do(t, h, http.MethodPost, "/admin/gc", "")
+	expectStatus(t, rec, http.StatusOK)
+	var heap map[string]uint64
+	if err := json.Unmarshal(rec.Body.Bytes(), &heap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := heap["heap_before"]; !ok || heap["heap_after"] == 0 {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	_, h = newTestServer(&countingStore{Storer: NewKVStore[string, string]()})
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/flush", ""), http.StatusNotImplemented)
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/compact", ""), http.StatusNotImplemented)
+}
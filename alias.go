@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxAliasDepth is the longest chain of aliases, an alias pointing to an alias counts twice.
+const maxAliasDepth = 8
+
+// ErrAliasCycle is returned by Alias for an alias that would end up pointing to itself.
+var ErrAliasCycle = errors.New("the alias would create a cycle")
+
+// ErrAliasTooDeep is returned by Alias for an alias that would make a chain of aliases longer than 8.
+var ErrAliasTooDeep = errors.New("the chain of aliases is too long")
+
+// WithAliasCascade makes deleting a key also delete the aliases pointing to it, directly or through other
+// aliases. Without it they're left dangling: reads through them fail with ErrKeyNotFound, and a write through
+// them creates the key again.
+func WithAliasCascade[K comparable, V any]() Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.aliasCascade = true
+	}
+}
+
+// Alias makes alias point to target, like a symlink: every operation on alias, reads, writes and deletes
+// alike, applies to target, and MatchKeys and the other listings only return target. The target doesn't have to
+// exist, and an alias can point to another alias, up to a chain of 8. Calling Alias again on the same alias
+// points it somewhere else. It fails with ErrKeyExists if alias is already a key, and with ErrAliasCycle if
+// target leads back to alias.
+//
+// The aliases are only kept in memory, they aren't in the snapshots or the WAL.
+func (s *KVStore[K, V]) Alias(alias, target K) error {
+	alias, target = s.foldCase(alias), s.foldCase(target)
+	if err := s.validateKey(alias); err != nil {
+		return err
+	}
+
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	if s.Has(alias) {
+		return keyExists(alias)
+	}
+
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+
+	// The chain gets longer on both sides: the aliases leading to alias, and the ones target leads to.
+	depth := 1 + s.aliasesLeadingTo(alias)
+	for key := target; ; depth++ {
+		if key == alias {
+			return fmt.Errorf("%w: (%v) leads back to (%v)", ErrAliasCycle, target, alias)
+		}
+		next, ok := s.aliases[key]
+		if !ok {
+			break
+		}
+		key = next
+	}
+	if depth > maxAliasDepth {
+		return fmt.Errorf("%w: it would be %d long", ErrAliasTooDeep, depth)
+	}
+
+	if s.aliases == nil {
+		s.aliases = make(map[K]K)
+	}
+	s.aliases[alias] = target
+	s.hasAliases.Store(true)
+	return nil
+}
+
+// Unalias removes the alias, the key it pointed to is left alone. It reports whether alias was an alias.
+func (s *KVStore[K, V]) Unalias(alias K) bool {
+	alias = s.foldCase(alias)
+
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+
+	_, ok := s.aliases[alias]
+	delete(s.aliases, alias)
+	return ok
+}
+
+// aliasesLeadingTo returns the length of the longest chain of aliases ending at key, must be called with the
+// alias lock held.
+func (s *KVStore[K, V]) aliasesLeadingTo(key K) int {
+	longest := 0
+	for alias := range s.aliases {
+		n := 0
+		for next := alias; next != key; n++ {
+			var ok bool
+			if next, ok = s.aliases[next]; !ok {
+				n = 0
+				break
+			}
+		}
+		longest = max(longest, n)
+	}
+	return longest
+}
+
+// resolveAlias returns the key the alias points to at the end of its chain, or key itself if it's not an alias.
+func (s *KVStore[K, V]) resolveAlias(key K) K {
+	if !s.hasAliases.Load() {
+		return key
+	}
+
+	s.aliasMu.RLock()
+	defer s.aliasMu.RUnlock()
+
+	for depth := 0; depth < maxAliasDepth; depth++ {
+		target, ok := s.aliases[key]
+		if !ok {
+			break
+		}
+		key = target
+	}
+	return key
+}
+
+// dropAliasesTo deletes the aliases leading to a deleted key for WithAliasCascade, it's called from notify.
+func (s *KVStore[K, V]) dropAliasesTo(key K) {
+	if !s.hasAliases.Load() {
+		return
+	}
+
+	s.aliasMu.Lock()
+	defer s.aliasMu.Unlock()
+
+	dropped := map[K]bool{key: true}
+	for len(dropped) > 0 {
+		next := make(map[K]bool)
+		for alias, target := range s.aliases {
+			if dropped[target] {
+				delete(s.aliases, alias)
+				next[alias] = true
+			}
+		}
+		dropped = next
+	}
+}
+
+// Aliaser is implemented by stores that support aliases.
+type Aliaser[K comparable] interface {
+	Alias(alias, target K) error
+	Unalias(alias K) bool
+}
+
+// handleAlias serves POST /alias/:alias/:target.
+func (s *Server) handleAlias(c echo.Context) error {
+	aliaser, ok := s.Storage.(Aliaser[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support aliases")
+	}
+
+	alias, target := c.Param("alias"), c.Param("target")
+	err := s.fence(c, func() error { return aliaser.Alias(alias, target) })
+	if errors.Is(err, ErrAliasCycle) || errors.Is(err, ErrAliasTooDeep) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"alias": alias, "target": target})
+}
+
+// handleUnalias serves DELETE /alias/:alias, it's 404 if it's not an alias.
+func (s *Server) handleUnalias(c echo.Context) error {
+	aliaser, ok := s.Storage.(Aliaser[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support aliases")
+	}
+
+	alias := c.Param("alias")
+	unaliased := false
+	err := s.fence(c, func() error {
+		unaliased = aliaser.Unalias(alias)
+		return nil
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if !unaliased {
+		return echo.NewHTTPError(http.StatusNotFound, "("+alias+") isn't an alias")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"unaliased": alias})
+}
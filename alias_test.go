@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAliasReadsAndWritesTheTarget(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("user/1", "ada")
+	if err := st.Alias("me", "user/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := st.Get("me"); err != nil || v != "ada" {
+		t.Fatalf("got %q, %v through the alias", v, err)
+	}
+	if err := st.Update("me", "grace"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("user/1"); v != "grace" {
+		t.Fatalf("the update through the alias left the target at %q", v)
+	}
+	// A write to the target shows through the alias.
+	st.Put("user/1", "hedy")
+	if v, _ := st.Get("me"); v != "hedy" {
+		t.Fatalf("got %q through the alias", v)
+	}
+	// The listings only have the target.
+	if keys := st.Filter(func(string, string) bool { return true }); len(keys) != 1 || keys["user/1"] != "hedy" {
+		t.Fatalf("got %v", keys)
+	}
+
+	// An alias to an alias goes all the way.
+	if err := st.Alias("admin", "me"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("admin"); v != "hedy" {
+		t.Fatalf("got %q through two aliases", v)
+	}
+
+	if err := st.Alias("user/1", "other"); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("aliasing a key: got %v, want ErrKeyExists", err)
+	}
+	if !st.Unalias("admin") || st.Unalias("admin") {
+		t.Fatal("Unalias didn't report the alias once")
+	}
+	if _, err := st.Get("admin"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v after Unalias", err)
+	}
+	if v, _ := st.Get("user/1"); v != "hedy" {
+		t.Fatalf("Unalias changed the target to %q", v)
+	}
+}
+
+func TestAliasRejectsCycles(t *testing.T) {
+	st := NewKVStore[string, string]()
+	if err := st.Alias("a", "a"); !errors.Is(err, ErrAliasCycle) {
+		t.Fatalf("an alias to itself: got %v", err)
+	}
+	st.Alias("a", "b")
+	st.Alias("b", "c")
+	if err := st.Alias("c", "a"); !errors.Is(err, ErrAliasCycle) {
+		t.Fatalf("got %v, want ErrAliasCycle", err)
+	}
+	// The rejected alias isn't left behind.
+	st.Put("c", "1")
+	if v, err := st.Get("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestAliasDepthLimit(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("k0", "v")
+	for i := 1; i <= maxAliasDepth; i++ {
+		if err := st.Alias(fmt.Sprint("k", i), fmt.Sprint("k", i-1)); err != nil {
+			t.Fatalf("alias %d: %v", i, err)
+		}
+	}
+	if v, err := st.Get(fmt.Sprint("k", maxAliasDepth)); err != nil || v != "v" {
+		t.Fatalf("got %q, %v at the end of the longest chain", v, err)
+	}
+	if err := st.Alias("too-far", fmt.Sprint("k", maxAliasDepth)); !errors.Is(err, ErrAliasTooDeep) {
+		t.Fatalf("got %v, want ErrAliasTooDeep", err)
+	}
+	// It's too deep just the same when the chain grows from its start.
+	if err := st.Alias("k0-alias", "k0"); err != nil {
+		t.Fatal(err)
+	}
+	st.Delete("k0")
+	if err := st.Alias("k0", "elsewhere"); !errors.Is(err, ErrAliasTooDeep) {
+		t.Fatalf("got %v, want ErrAliasTooDeep", err)
+	}
+}
+
+func TestDeletingTheTargetLeavesTheAliasDangling(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("target", "1")
+	st.Alias("alias", "target")
+
+	st.Delete("target")
+	if _, err := st.Get("alias"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v through a dangling alias", err)
+	}
+	// A write through it creates the target again.
+	st.Put("alias", "2")
+	if v, err := st.Get("target"); err != nil || v != "2" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if !st.Unalias("alias") {
+		t.Fatal("the alias was dropped")
+	}
+}
+
+func TestDeletingTheTargetCascades(t *testing.T) {
+	st := NewKVStore[string, string](WithAliasCascade[string, string]())
+	st.Put("target", "1")
+	st.Put("other", "x")
+	st.Alias("alias", "target")
+	st.Alias("alias-of-alias", "alias")
+	st.Alias("unrelated", "other")
+
+	// Deleting through an alias deletes the target, and with it the aliases.
+	if v, err := st.Delete("alias-of-alias"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if st.Unalias("alias") || st.Unalias("alias-of-alias") {
+		t.Fatal("the aliases to the deleted key were left")
+	}
+	if v, _ := st.Get("unrelated"); v != "x" {
+		t.Fatalf("an alias to another key was dropped: %q", v)
+	}
+	// The name is free again.
+	st.Put("alias", "2")
+	if _, err := st.Get("target"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the write went to the old target: %v", err)
+	}
+}
+
+func TestAliasOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("target", "1")
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPost, "/alias/alias/target", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "{\"alias\":\"alias\",\"target\":\"target\"}\n" {
+		t.Fatalf("got %s", rec.Body)
+	}
+	rec = do(t, h, http.MethodGet, "/kv/alias", "")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"1"`) {
+		t.Fatalf("got %s through the alias", rec.Body)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/alias/target/alias", ""), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPost, "/alias/loop/loop", ""), http.StatusConflict)
+
+	expectStatus(t, do(t, h, http.MethodDelete, "/alias/alias", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/alias/alias", ""), http.StatusNotFound)
+	if v, _ := st.Get("target"); v != "1" {
+		t.Fatalf("removing the alias changed the target to %q", v)
+	}
+
+	// The storage has to support aliases.
+	_, h = newTestServer(&countingStore{Storer: NewKVStore[string, string]()})
+	expectStatus(t, do(t, h, http.MethodPost, "/alias/alias/target", ""), http.StatusNotImplemented)
+}
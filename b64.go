@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// b64Keys is the middleware of the /b64 routes, their keys are base64url encoded so that any byte sequence,
+// slashes and null bytes included, can go through the path. It decodes them in place, the handlers see the raw
+// key like on the other routes. The padding is optional and a malformed key is rejected with 400.
+func b64Keys(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		names, values := c.ParamNames(), c.ParamValues()
+		decoded := make([]string, len(values))
+		for i, value := range values {
+			if i >= len(names) || names[i] == "value" {
+				decoded[i] = value
+				continue
+			}
+			key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "the key must be base64url encoded: "+err.Error())
+			}
+			decoded[i] = string(key)
+		}
+		c.SetParamValues(decoded...)
+		return next(c)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBase64Keys(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+	key := "a/b\x00c/../d?e"
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(key))
+
+	expectStatus(t, do(t, h, http.MethodPut, "/b64/kv/"+encoded, "1"), http.StatusOK)
+	if v, err := st.Get(key); err != nil || v != "1" {
+		t.Fatalf("the raw key holds %q, %v", v, err)
+	}
+
+	for _, target := range []string{"/b64/kv/" + encoded, "/b64/get/" + encoded, "/b64/kv/" + base64.URLEncoding.EncodeToString([]byte(key))} {
+		rec := do(t, h, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		if !strings.Contains(rec.Body.String(), `"value":"1"`) {
+			t.Fatalf("%s: got %s", target, rec.Body)
+		}
+	}
+
+	expectStatus(t, do(t, h, http.MethodPut, "/b64/kv/"+encoded, `{"a": 1}`), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPatch, "/b64/kv/"+encoded, `{"b": 2}`), http.StatusOK)
+	if v, _ := st.Get(key); v != `{"a":1,"b":2}` {
+		t.Fatalf("got %s after the patch", v)
+	}
+	expectStatus(t, do(t, h, http.MethodDelete, "/b64/kv/"+encoded, ""), http.StatusOK)
+	if _, err := st.Get(key); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v after the delete", err)
+	}
+}
+
+func TestBase64KeysRejectMalformedInput(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	// The standard alphabet isn't accepted either, its + and / aren't safe in a path.
+	for _, key := range []string{"!!!", "a", "YWJj+w"} {
+		if rec := do(t, h, http.MethodPut, "/b64/kv/"+key, "1"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", key, rec.Code)
+		}
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/b64/get/!!!", ""), http.StatusBadRequest)
+	if n := len(st.Filter(func(string, string) bool { return true })); n != 0 {
+		t.Fatalf("a malformed key stored %d entries", n)
+	}
+}
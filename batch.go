@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultMaxMGetKeys = 100
+
+// BatchGetter is implemented by stores that can look up several keys at once.
+type BatchGetter[K comparable, V any] interface {
+	GetMany([]K) map[K]V
+}
+
+// ContextBatchGetter is implemented by stores that can look up several keys at once and stop at the deadline of
+// a context, returning the keys they didn't get to after the ones they did.
+type ContextBatchGetter[K comparable, V any] interface {
+	GetManyContext(ctx context.Context, keys []K) (map[K]V, []K)
+}
+
+// GetMany returns the values of the keys that exist, the missing ones are left out of the result. The results
+// are keyed by the keys as given, not by their case-folded or aliased form. It's GetManyContext without a
+// deadline.
+func (s *KVStore[K, V]) GetMany(keys []K) map[K]V {
+	found, _ := s.GetManyContext(context.Background(), keys)
+	return found
+}
+
+// GetManyContext is GetMany stopping at the deadline of ctx, which is checked before every key: the keys it
+// didn't get to are returned apart, after the ones it did. The read lock is taken once per batchChunkSize keys,
+// and released for the misses of a store created WithLoader, which go through the loader like with Get. A load
+// still running at the deadline is left to finish on its own.
+func (s *KVStore[K, V]) GetManyContext(ctx context.Context, keys []K) (map[K]V, []K) {
+	found := make(map[K]V, len(keys))
+	locked := false
+	defer func() {
+		if locked {
+			s.mu.RUnlock()
+		}
+	}()
+
+	for i, key := range keys {
+		if ctx.Err() != nil {
+			return found, keys[i:]
+		}
+		if !locked || i%batchChunkSize == 0 {
+			if locked {
+				s.mu.RUnlock()
+			}
+			s.rlock()
+			locked = true
+		}
+
+		canon := s.canon(key)
+		if value, ok := s.lookup(canon); ok {
+			found[key] = value
+			s.recordAccess(canon)
+			continue
+		}
+		if s.loader == nil || s.deleted(canon) {
+			continue
+		}
+		s.mu.RUnlock()
+		locked = false
+		value, err := getContext[K, V](ctx, s, key)
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return found, keys[i:]
+		}
+		if err == nil {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+// OrderedBatchGetter is implemented by stores that can look up several keys at once and keep their order.
+type OrderedBatchGetter[K comparable, V any] interface {
+	GetManyOrdered([]K) []Result[K, V]
+}
+
+// Result is the outcome of looking up one key of a GetManyOrdered batch.
+type Result[K comparable, V any] struct {
+	Key   K    `json:"key"`
+	Value *V   `json:"value"`
+	Found bool `json:"found"`
+}
+
+// GetManyOrdered is GetMany returning one result per requested key in request order, the missing keys
+// included, so the results can be paired positionally with the keys.
+func (s *KVStore[K, V]) GetManyOrdered(keys []K) []Result[K, V] {
+	return orderResults(keys, s.GetMany(keys))
+}
+
+// orderResults pairs the keys with the values found for them.
+func orderResults[K comparable, V any](keys []K, found map[K]V) []Result[K, V] {
+	results := make([]Result[K, V], len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		if value, ok := found[key]; ok {
+			results[i].Value, results[i].Found = &value, true
+		}
+	}
+	return results
+}
+
+// getMany uses GetManyContext or GetMany when the storage supports them, and falls back to one Get per key
+// otherwise. It stops at the deadline of ctx and returns the keys it didn't get to, after the ones it did: a Get
+// still running then is left to finish on its own. GetMany can't be interrupted, it's only skipped if ctx is
+// already done.
+func getMany[K comparable, V any](ctx context.Context, storage Storer[K, V], keys []K) (map[K]V, []K) {
+	if cbg, ok := storage.(ContextBatchGetter[K, V]); ok {
+		return cbg.GetManyContext(ctx, keys)
+	}
+	if bg, ok := storage.(BatchGetter[K, V]); ok {
+		if ctx.Err() != nil {
+			return map[K]V{}, keys
+		}
+		return bg.GetMany(keys), nil
+	}
+
+	found := make(map[K]V, len(keys))
+	for i, key := range keys {
+		value, err := getContext(ctx, storage, key)
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return found, keys[i:]
+		}
+		if err == nil {
+			found[key] = value
+		}
+	}
+	return found, nil
+}
+
+// getContext calls Get unless ctx is done first, it returns the error of ctx then.
+func getContext[K comparable, V any](ctx context.Context, storage Storer[K, V], key K) (V, error) {
+	var zero V
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value V
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := getWithContext(ctx, storage, key)
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// getManyOrdered uses GetManyOrdered when the storage supports it and can't be interrupted by ctx, and builds the
+// results from getMany otherwise. The keys it didn't get to before the deadline of ctx are left out of the results
+// and returned apart.
+func getManyOrdered[K comparable, V any](ctx context.Context, storage Storer[K, V], keys []K) ([]Result[K, V], []K) {
+	_, interruptible := storage.(ContextBatchGetter[K, V])
+	if og, ok := storage.(OrderedBatchGetter[K, V]); ok && !interruptible {
+		if ctx.Err() != nil {
+			return []Result[K, V]{}, keys
+		}
+		return og.GetManyOrdered(keys), nil
+	}
+
+	found, unfetched := getMany(ctx, storage, keys)
+	return orderResults(keys[:len(keys)-len(unfetched)], found), unfetched
+}
+
+// WithMaxMGetKeys sets how many keys a single GET /mget may ask for, the default is 100.
+func WithMaxMGetKeys(n int) ServerOption {
+	return func(s *Server) {
+		s.maxMGetKeys = n
+	}
+}
+
+// WithBatchTimeout sets how long a GET /mget may take, it answers with the values it got by then, see
+// handleMGet. Without it there's no deadline but the one of the request.
+func WithBatchTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.batchTimeout = d
+	}
+}
+
+// handleMGet serves GET /mget?keys=a,b,c, missing keys are returned as null.
+// With ?ordered=true the response is an array of {"key", "value", "found"} objects in the order of the keys.
+// When the batch timeout or the request runs out before every key was fetched the response holds the keys
+// fetched so far, the others are left out and listed in the X-Unfetched-Keys header, comma-separated, along
+// with X-Partial-Result: true.
+func (s *Server) handleMGet(c echo.Context) error {
+	param := c.QueryParam("keys")
+	if param == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "the keys query parameter is required")
+	}
+	keys := strings.Split(param, ",")
+	if len(keys) > s.maxMGetKeys {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("at most %d keys can be requested at once", s.maxMGetKeys))
+	}
+
+	ctx := c.Request().Context()
+	if s.batchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.batchTimeout)
+		defer cancel()
+	}
+
+	if c.QueryParam("ordered") == "true" {
+		results, unfetched := getManyOrdered(ctx, s.Storage, keys)
+		setUnfetched(c, unfetched)
+		return c.JSON(http.StatusOK, results)
+	}
+
+	found, unfetched := getMany(ctx, s.Storage, keys)
+	setUnfetched(c, unfetched)
+
+	values := make(map[string]*string, len(keys))
+	for _, key := range keys[:len(keys)-len(unfetched)] {
+		if value, ok := found[key]; ok {
+			values[key] = &value
+		} else {
+			values[key] = nil
+		}
+	}
+
+	return c.JSON(http.StatusOK, values)
+}
+
+// setUnfetched sets the headers of a partial GET /mget response.
+func setUnfetched(c echo.Context, unfetched []string) {
+	if len(unfetched) == 0 {
+		return
+	}
+	c.Response().Header().Set("X-Partial-Result", "true")
+	c.Response().Header().Set("X-Unfetched-Keys", strings.Join(unfetched, ","))
+}
+
+// ExistenceCounter is implemented by stores that can count which of several keys exist.
+type ExistenceCounter[K comparable] interface {
+	CountExisting([]K) int
+}
+
+// CountExisting returns how many of the keys exist, under a single read lock and without copying the values.
+// A key listed twice is counted twice. Unlike GetMany it doesn't count as an access to the keys.
+func (s *KVStore[K, V]) CountExisting(keys []K) int {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, key := range keys {
+		if _, ok := s.lookup(s.canon(key)); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// countExisting uses CountExisting when the storage supports it, and falls back to one Get per key otherwise.
+func countExisting[K comparable, V any](storage Storer[K, V], keys []K) int {
+	if counter, ok := storage.(ExistenceCounter[K]); ok {
+		return counter.CountExisting(keys)
+	}
+
+	n := 0
+	for _, key := range keys {
+		if _, err := storage.Get(key); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// handleCount serves POST /count, taking a JSON array of keys and answering how many of them exist. It's limited
+// to the batch size.
+func (s *Server) handleCount(c echo.Context) error {
+	var keys []string
+	if err := json.NewDecoder(c.Request().Body).Decode(&keys); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the body must be a JSON array of keys: "+err.Error())
+	}
+	if err := s.checkBatchSize(len(keys)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"count": countExisting(s.Storage, keys), "requested": len(keys)})
+}
+
+// BulkExpirer is implemented by stores that can set the TTL of several keys at once.
+type BulkExpirer[K comparable] interface {
+	ExpireMany(map[K]time.Duration) (updated []K, missing []K)
+}
+
+// handleBatchExpire serves POST /batch/expire, taking a JSON object mapping keys to durations like "30s".
+// A "0s" duration makes the key permanent.
+func (s *Server) handleBatchExpire(c echo.Context) error {
+	expirer, ok := s.Storage.(BulkExpirer[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support expirations")
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the body must be a JSON object: "+err.Error())
+	}
+	if err := s.checkBatchSize(len(body)); err != nil {
+		return err
+	}
+	chunks := []map[string]time.Duration{{}}
+	for key, param := range body {
+		ttl, err := time.ParseDuration(param)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid ttl for %s: %s", key, param))
+		}
+		if len(chunks[len(chunks)-1]) == batchChunkSize {
+			chunks = append(chunks, map[string]time.Duration{})
+		}
+		chunks[len(chunks)-1][key] = ttl
+	}
+
+	// ExpireMany holds the write lock for its whole batch, big batches are split so they don't hold up the others.
+	var updated, missing []string
+	for i, chunk := range chunks {
+		if i > 0 {
+			runtime.Gosched()
+		}
+		err := s.fence(c, func() error {
+			u, m := expirer.ExpireMany(chunk)
+			updated, missing = append(updated, u...), append(missing, m...)
+			return nil
+		})
+		if err != nil {
+			return toHTTPError(err)
+		}
+	}
+	sort.Strings(updated)
+	sort.Strings(missing)
+
+	return c.JSON(http.StatusOK, map[string][]string{"updated": updated, "missing": missing})
+}
+
+// Expirer is implemented by stores that can set the TTL of a key, optionally only if it has none (NX) or
+// only if it has one (XX).
+type Expirer[K comparable] interface {
+	Expire(key K, ttl time.Duration) error
+	ExpireNX(key K, ttl time.Duration) (bool, error)
+	ExpireXX(key K, ttl time.Duration) (bool, error)
+}
+
+// handleExpire serves POST /expire/:key?ttl=30s, with ?nx=true the TTL is only set if the key has none and
+// with ?xx=true only if it has one. The response tells whether the TTL was applied.
+func (s *Server) handleExpire(c echo.Context) error {
+	expirer, ok := s.Storage.(Expirer[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support expirations")
+	}
+
+	param := c.QueryParam("ttl")
+	ttl, err := time.ParseDuration(param)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid ttl: "+param)
+	}
+	nx, xx := c.QueryParam("nx") == "true", c.QueryParam("xx") == "true"
+
+	key := c.Param("key")
+	applied := true
+	switch {
+	case nx && xx:
+		return echo.NewHTTPError(http.StatusBadRequest, "nx and xx can't be combined")
+	case nx:
+		err = s.fence(c, func() (err error) {
+			applied, err = expirer.ExpireNX(key, ttl)
+			return err
+		})
+	case xx:
+		err = s.fence(c, func() (err error) {
+			applied, err = expirer.ExpireXX(key, ttl)
+			return err
+		})
+	default:
+		err = s.fence(c, func() error { return expirer.Expire(key, ttl) })
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]bool{"applied": applied})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetManyGoesThroughLoader(t *testing.T) {
+	st := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		return "loaded-" + key, key != "none", nil
+	}))
+	st.Put("a", "1")
+
+	got := st.GetMany([]string{"a", "b", "none"})
+	want := map[string]string{"a": "1", "b": "loaded-b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	results := st.GetManyOrdered([]string{"none", "b"})
+	if results[0].Found || !results[1].Found || *results[1].Value != "loaded-b" {
+		t.Fatalf("got %+v", results)
+	}
+}
+
+func TestGetManyContextStopsAtDeadline(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	found, unfetched := st.GetManyContext(ctx, []string{"a", "b"})
+	if len(found) != 0 || !reflect.DeepEqual(unfetched, []string{"a", "b"}) {
+		t.Fatalf("got %v, %v", found, unfetched)
+	}
+}
+
+func TestMGetBatchTimeoutInterruptsLoader(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	st := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		<-release
+		return "late", true, nil
+	}))
+	st.Put("a", "1")
+	st.Put("c", "3")
+	_, h := newTestServer(st, WithBatchTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	rec := do(t, h, http.MethodGet, "/mget?keys=a,slow,c", "")
+	expectStatus(t, rec, http.StatusOK)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the batch took %s despite its timeout", elapsed)
+	}
+	if rec.Header().Get("X-Partial-Result") != "true" || rec.Header().Get("X-Unfetched-Keys") != "slow,c" {
+		t.Fatalf("got headers %v", rec.Header())
+	}
+	var values map[string]*string
+	if err := json.Unmarshal(rec.Body.Bytes(), &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["a"] == nil || *values["a"] != "1" {
+		t.Fatalf("got %s", rec.Body)
+	}
+}
+
+func TestMGetPartialResultOfASlowStore(t *testing.T) {
+	st := &delayedStore{Storer: NewKVStore[string, string](), delay: time.Second}
+	st.Put("a", "1")
+	st.Put("slow", "2")
+	st.Put("b", "3")
+	_, h := newTestServer(st, WithBatchTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	rec := do(t, h, http.MethodGet, "/mget?keys=a,slow,b", "")
+	expectStatus(t, rec, http.StatusOK)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("the batch waited %s for the slow read", elapsed)
+	}
+	if rec.Header().Get("X-Partial-Result") != "true" || rec.Header().Get("X-Unfetched-Keys") != "slow,b" {
+		t.Fatalf("got headers %v", rec.Header())
+	}
+	var values map[string]*string
+	if err := json.Unmarshal(rec.Body.Bytes(), &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["a"] == nil || *values["a"] != "1" {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	rec = do(t, h, http.MethodGet, "/mget?keys=a,slow,b&ordered=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := strings.TrimSpace(rec.Body.String()); got != `[{"key":"a","value":"1","found":true}]` {
+		t.Fatalf("got %s", got)
+	}
+	if rec.Header().Get("X-Unfetched-Keys") != "slow,b" {
+		t.Fatalf("got headers %v", rec.Header())
+	}
+
+	// Within the deadline the result is complete.
+	rec = do(t, h, http.MethodGet, "/mget?keys=a,b", "")
+	if rec.Header().Get("X-Partial-Result") != "" {
+		t.Fatalf("a complete result is marked partial: %v", rec.Header())
+	}
+}
+
+func TestMGet(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("c", "3")
+	_, h := newTestServer(st, WithMaxMGetKeys(3))
+
+	rec := do(t, h, http.MethodGet, "/mget?keys=a,b,c", "")
+	expectStatus(t, rec, http.StatusOK)
+	var values map[string]*string
+	if err := json.Unmarshal(rec.Body.Bytes(), &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || *values["a"] != "1" || values["b"] != nil || *values["c"] != "3" {
+		t.Fatalf("got %s", rec.Body)
+	}
+	if rec.Header().Get("X-Partial-Result") != "" {
+		t.Fatal("a complete result is marked partial")
+	}
+
+	expectStatus(t, do(t, h, http.MethodGet, "/mget?keys=a,b,c,d", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodGet, "/mget", ""), http.StatusBadRequest)
+}
+
+func TestGetManyOrdered(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("c", "3")
+
+	keys := []string{"c", "missing", "a", "b", "c"}
+	results := st.GetManyOrdered(keys)
+	if len(results) != len(keys) {
+		t.Fatalf("got %d results for %d keys", len(results), len(keys))
+	}
+	for i, want := range []struct {
+		found bool
+		value string
+	}{{true, "3"}, {false, ""}, {true, "1"}, {false, ""}, {true, "3"}} {
+		r := results[i]
+		if r.Key != keys[i] || r.Found != want.found || r.Found && *r.Value != want.value || !r.Found && r.Value != nil {
+			t.Errorf("result %d: got %+v, want %s found=%v value=%q", i, r, keys[i], want.found, want.value)
+		}
+	}
+
+	_, h := newTestServer(st)
+	rec := do(t, h, http.MethodGet, "/mget?keys=c,missing,a&ordered=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	want := `[{"key":"c","value":"3","found":true},{"key":"missing","value":null,"found":false},{"key":"a","value":"1","found":true}]`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCountExisting(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	st.Put("a", "1")
+	st.Put("b", "2")
+	st.PutWithTTL("temp", "3", time.Minute)
+	clock.Advance(2 * time.Minute)
+
+	for _, tc := range []struct {
+		keys []string
+		want int
+	}{
+		{nil, 0},
+		{[]string{"a", "b"}, 2},
+		{[]string{"a", "missing", "b", "other"}, 2},
+		{[]string{"a", "a"}, 2},
+		{[]string{"temp"}, 0},
+	} {
+		if got := st.CountExisting(tc.keys); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.keys, got, tc.want)
+		}
+	}
+}
+
+func TestCountExistingIsntAnAccess(t *testing.T) {
+	st := NewKVStoreWithByteCapacity[string, string](2 * entrySize("a", "x"))
+	st.Put("a", "x")
+	st.Put("b", "x")
+	st.CountExisting([]string{"a"})
+	st.Put("c", "x")
+	if _, err := st.Peek("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("counting a saved it from the eviction: %v", err)
+	}
+}
+
+func TestCountOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+
+	for name, storage := range map[string]Storer[string, string]{
+		"counter": st,
+		// Without CountExisting the keys are read one by one.
+		"fallback": &countingStore{Storer: st},
+	} {
+		_, h := newTestServer(storage, WithMaxBatchItems(4))
+		rec := do(t, h, http.MethodPost, "/count", `["a", "missing", "b"]`)
+		expectStatus(t, rec, http.StatusOK)
+		if got := strings.TrimSpace(rec.Body.String()); got != `{"count":2,"requested":3}` {
+			t.Errorf("%s: got %s", name, got)
+		}
+		expectStatus(t, do(t, h, http.MethodPost, "/count", `["a", "b", "c", "d", "e"]`), http.StatusBadRequest)
+		expectStatus(t, do(t, h, http.MethodPost, "/count", `{"a": 1}`), http.StatusBadRequest)
+	}
+}
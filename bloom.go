@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// bloomFilter answers "definitely absent" or "maybe present" for keys. Its bits are atomics so Get can check
+// it without taking the store's lock.
+type bloomFilter struct {
+	bits   []atomic.Uint64
+	m      uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for n keys with a false positive rate of fp.
+func newBloomFilter(n int, fp float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{
+		bits:   make([]atomic.Uint64, (m+63)/64),
+		m:      m,
+		hashes: k,
+	}
+}
+
+// positions derives the bit positions of the key from one 64-bit hash, by double hashing.
+func (b *bloomFilter) positions(key string, fn func(uint64) bool) {
+	h := fnvHash(key)
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	for i := uint64(0); i < b.hashes; i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return
+		}
+	}
+}
+
+func (b *bloomFilter) add(key string) {
+	b.positions(key, func(pos uint64) bool {
+		word, mask := &b.bits[pos/64], uint64(1)<<(pos%64)
+		for {
+			old := word.Load()
+			if old&mask != 0 || word.CompareAndSwap(old, old|mask) {
+				return true
+			}
+		}
+	})
+}
+
+func (b *bloomFilter) mayContain(key string) bool {
+	found := true
+	b.positions(key, func(pos uint64) bool {
+		found = b.bits[pos/64].Load()&(uint64(1)<<(pos%64)) != 0
+		return found
+	})
+	return found
+}
+
+// NewKVStoreWithBloom creates a KVStore with a bloom filter sized for expectedKeys keys and a false positive
+// rate of fp, so that Get can answer most lookups of missing keys without taking the lock.
+// Deleted keys stay in the filter, it's only rebuilt by Clear and LoadSnapshot, so it gets less effective
+// with churn but never reports a present key as missing.
+func NewKVStoreWithBloom[K comparable, V any](expectedKeys int, fp float64, opts ...Option[K, V]) *KVStore[K, V] {
+	s := NewKVStore[K, V](opts...)
+	s.bloomKeys, s.bloomFP = expectedKeys, fp
+	s.bloom.Store(newBloomFilter(expectedKeys, fp))
+	return s
+}
+
+// resetBloom replaces the bloom filter, if any, with one holding only the current keys. It must be called
+// with the write lock held.
+func (s *KVStore[K, V]) resetBloom() {
+	if s.bloom.Load() == nil {
+		return
+	}
+	b := newBloomFilter(max(s.bloomKeys, len(s.data)), s.bloomFP)
+	for key := range s.data {
+		b.add(keyString(key))
+	}
+	s.bloom.Store(b)
+}
+
+// Clear removes every key, the watchers aren't notified. It's logged to the WAL and sent to the replicas as a
+// single record, and like the other writes it fails with ErrWALWrite if a WALStrict store can't log it.
+func (s *KVStore[K, V]) Clear() error {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if err := s.logClear(); err != nil {
+		return err
+	}
+	if s.changes != nil {
+		var key K
+		var value V
+		s.changes.append(EventClear, key, value, s.epoch.Load())
+	}
+	s.clear()
+	return nil
+}
+
+// clear removes every key without logging it, must be called with the write lock held.
+func (s *KVStore[K, V]) clear() {
+	for key := range s.data {
+		s.remove(key)
+	}
+	clear(s.tombstones)
+	s.compactIfSparse()
+	s.resetBloom()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBloomNoFalseNegatives(t *testing.T) {
+	const n = 10000
+	st := NewKVStoreWithBloom[string, string](n, 0.01)
+	for i := 0; i < n; i++ {
+		st.Put(fmt.Sprintf("key%d", i), "v")
+	}
+
+	b := st.bloom.Load()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if !b.mayContain(key) {
+			t.Fatalf("the filter reports %s as absent", key)
+		}
+		if _, err := st.Get(key); err != nil {
+			t.Fatalf("got %v for %s", err, key)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if b.mayContain(fmt.Sprintf("absent%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.03 {
+		t.Fatalf("the false positive rate is %.3f, the filter was sized for 0.01", rate)
+	}
+}
+
+func TestBloomAfterDeleteAndClear(t *testing.T) {
+	st := NewKVStoreWithBloom[string, string](100, 0.01)
+	st.Put("a", "1")
+	st.Delete("a")
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a deleted key", err)
+	}
+	st.Put("a", "2")
+	if v, _ := st.Get("a"); v != "2" {
+		t.Fatalf("got %q for a recreated key", v)
+	}
+
+	st.Put("b", "1")
+	st.Clear()
+	if st.bloom.Load().mayContain("b") {
+		t.Fatal("Clear didn't rebuild the filter")
+	}
+	st.Put("c", "1")
+	if v, err := st.Get("c"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v after Clear", v, err)
+	}
+}
+
+// BenchmarkGetMiss measures looking up missing keys, the bloom filter answers them without taking the lock.
+func BenchmarkGetMiss(b *testing.B) {
+	const n = 100000
+	for _, bc := range []struct {
+		name  string
+		store *KVStore[string, string]
+	}{
+		{"plain", NewKVStore[string, string]()},
+		{"bloom", NewKVStoreWithBloom[string, string](n, 0.01)},
+	} {
+		for i := 0; i < n; i++ {
+			bc.store.Put(fmt.Sprintf("key%d", i), "v")
+		}
+		misses := make([]string, 1024)
+		for i := range misses {
+			misses[i] = fmt.Sprintf("absent%d", i)
+		}
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					bc.store.Get(misses[i%len(misses)])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// The wrappers are all Storers, so any of them can be stacked over any other.
+var (
+	_ Storer[string, string] = (*MetricsStore[string, string])(nil)
+	_ Storer[string, string] = (*TracingStore[string, string])(nil)
+	_ Storer[string, string] = (*HookedStore[string, string])(nil)
+	_ Storer[string, string] = (*TieredStore[string, string])(nil)
+	_ Storer[string, string] = (*CoalescingStore[string, string])(nil)
+)
+
+// Chain stacks the wrappers over base and returns the outermost one. Like the middlewares of a server, the first
+// wrapper is the outermost: Chain(base, Metered(sink), Traced(logger)) has the metrics see every call first,
+// then the tracing, then base.
+func Chain[K comparable, V any](base Storer[K, V], wrappers ...func(Storer[K, V]) Storer[K, V]) Storer[K, V] {
+	s := base
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		s = wrappers[i](s)
+	}
+	return s
+}
+
+// MetricsStore reports the calls to a Storer to a MetricsSink, for the stores that don't report their own like
+// the remote ones. Every operation counts store_<op>s_total and its errors in store_<op>_errors_total, a missing
+// key isn't an error, and is timed in store_<op>_duration_seconds.
+type MetricsStore[K comparable, V any] struct {
+	backing Storer[K, V]
+	sink    MetricsSink
+}
+
+func NewMetricsStore[K comparable, V any](backing Storer[K, V], sink MetricsSink) *MetricsStore[K, V] {
+	return &MetricsStore[K, V]{backing: backing, sink: sink}
+}
+
+// Metered is NewMetricsStore for Chain.
+func Metered[K comparable, V any](sink MetricsSink) func(Storer[K, V]) Storer[K, V] {
+	return func(backing Storer[K, V]) Storer[K, V] {
+		return NewMetricsStore(backing, sink)
+	}
+}
+
+func (s *MetricsStore[K, V]) record(op string, start time.Time, err error) {
+	s.sink.Counter("store_"+op+"s_total", 1)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		s.sink.Counter("store_"+op+"_errors_total", 1)
+	}
+	s.sink.Histogram("store_"+op+"_duration_seconds", time.Since(start).Seconds())
+}
+
+func (s *MetricsStore[K, V]) Put(key K, value V) error {
+	return s.PutContext(context.Background(), key, value)
+}
+
+func (s *MetricsStore[K, V]) PutContext(ctx context.Context, key K, value V) error {
+	start := time.Now()
+	err := putWithContext(ctx, s.backing, key, value)
+	s.record("put", start, err)
+	return err
+}
+
+func (s *MetricsStore[K, V]) Get(key K) (V, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+func (s *MetricsStore[K, V]) GetContext(ctx context.Context, key K) (V, error) {
+	start := time.Now()
+	value, err := getWithContext(ctx, s.backing, key)
+	s.record("get", start, err)
+	return value, err
+}
+
+func (s *MetricsStore[K, V]) Update(key K, value V) error {
+	return s.UpdateContext(context.Background(), key, value)
+}
+
+func (s *MetricsStore[K, V]) UpdateContext(ctx context.Context, key K, value V) error {
+	start := time.Now()
+	err := updateWithContext(ctx, s.backing, key, value)
+	s.record("update", start, err)
+	return err
+}
+
+func (s *MetricsStore[K, V]) Delete(key K) (V, error) {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *MetricsStore[K, V]) DeleteContext(ctx context.Context, key K) (V, error) {
+	start := time.Now()
+	value, err := deleteWithContext(ctx, s.backing, key)
+	s.record("delete", start, err)
+	return value, err
+}
+
+// TracingStore logs every call to a Storer at the Debug level, with its key, how long it took and its error,
+// and the ID of the request it's for when it's called with a context carrying one. The values aren't logged.
+type TracingStore[K comparable, V any] struct {
+	backing Storer[K, V]
+	logger  Logger
+}
+
+func NewTracingStore[K comparable, V any](backing Storer[K, V], logger Logger) *TracingStore[K, V] {
+	return &TracingStore[K, V]{backing: backing, logger: logger}
+}
+
+// Traced is NewTracingStore for Chain.
+func Traced[K comparable, V any](logger Logger) func(Storer[K, V]) Storer[K, V] {
+	return func(backing Storer[K, V]) Storer[K, V] {
+		return NewTracingStore(backing, logger)
+	}
+}
+
+func (s *TracingStore[K, V]) trace(ctx context.Context, op string, key K, start time.Time, err error) {
+	prefix := ""
+	if id := RequestIDFromContext(ctx); id != "" {
+		prefix = "request " + id + ": "
+	}
+	if err != nil {
+		s.logger.Debug("%s%s (%v) failed after %s: %v", prefix, op, key, time.Since(start), err)
+		return
+	}
+	s.logger.Debug("%s%s (%v) took %s", prefix, op, key, time.Since(start))
+}
+
+func (s *TracingStore[K, V]) Put(key K, value V) error {
+	return s.PutContext(context.Background(), key, value)
+}
+
+func (s *TracingStore[K, V]) PutContext(ctx context.Context, key K, value V) error {
+	start := time.Now()
+	err := putWithContext(ctx, s.backing, key, value)
+	s.trace(ctx, "put", key, start, err)
+	return err
+}
+
+func (s *TracingStore[K, V]) Get(key K) (V, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+func (s *TracingStore[K, V]) GetContext(ctx context.Context, key K) (V, error) {
+	start := time.Now()
+	value, err := getWithContext(ctx, s.backing, key)
+	s.trace(ctx, "get", key, start, err)
+	return value, err
+}
+
+func (s *TracingStore[K, V]) Update(key K, value V) error {
+	return s.UpdateContext(context.Background(), key, value)
+}
+
+func (s *TracingStore[K, V]) UpdateContext(ctx context.Context, key K, value V) error {
+	start := time.Now()
+	err := updateWithContext(ctx, s.backing, key, value)
+	s.trace(ctx, "update", key, start, err)
+	return err
+}
+
+func (s *TracingStore[K, V]) Delete(key K) (V, error) {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *TracingStore[K, V]) DeleteContext(ctx context.Context, key K) (V, error) {
+	start := time.Now()
+	value, err := deleteWithContext(ctx, s.backing, key)
+	s.trace(ctx, "delete", key, start, err)
+	return value, err
+}
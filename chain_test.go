@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChainComposesTheWrappers(t *testing.T) {
+	sink := &recordingSink{}
+	logger := &recordingLogger{}
+	base := NewKVStore[string, string]()
+	st := Chain[string, string](base, Metered[string, string](sink), Traced[string, string](logger))
+
+	// The first wrapper is the outermost.
+	metered, ok := st.(*MetricsStore[string, string])
+	if !ok {
+		t.Fatalf("the outermost store is a %T", st)
+	}
+	if _, ok := metered.backing.(*TracingStore[string, string]); !ok {
+		t.Fatalf("the metrics wrap a %T", metered.backing)
+	}
+
+	if err := st.Put("a", "v-1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Get("a"); err != nil || v != "v-1" {
+		t.Fatalf("got %q, %v through the chain", v, err)
+	}
+	if v, _ := base.Get("a"); v != "v-1" {
+		t.Fatalf("the put didn't reach the store: %q", v)
+	}
+	if _, err := st.Get("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a missing key", err)
+	}
+
+	sink.mu.Lock()
+	calls := strings.Join(sink.calls, "\n")
+	sink.mu.Unlock()
+	if n := strings.Count(calls, "counter store_gets_total 1"); n != 2 {
+		t.Fatalf("%d gets were counted, want 2:\n%s", n, calls)
+	}
+	if strings.Contains(calls, "store_get_errors_total") {
+		t.Fatalf("a miss was counted as an error:\n%s", calls)
+	}
+	if !strings.Contains(calls, "histogram store_get_duration_seconds") {
+		t.Fatalf("the gets weren't timed:\n%s", calls)
+	}
+	if !logger.contains("DEBUG get (a) took") {
+		t.Fatalf("the get wasn't traced: %v", logger.messages)
+	}
+	if !logger.contains("DEBUG get (missing) failed") {
+		t.Fatalf("the miss wasn't traced: %v", logger.messages)
+	}
+	// The values are never logged.
+	if logger.contains("v-1") {
+		t.Fatalf("a value was logged: %v", logger.messages)
+	}
+}
+
+func TestChainWithoutWrappers(t *testing.T) {
+	base := NewKVStore[string, string]()
+	if st := Chain[string, string](base); st != Storer[string, string](base) {
+		t.Fatalf("got a %T, want the base store", st)
+	}
+}
+
+func TestTracingCarriesTheRequestID(t *testing.T) {
+	logger := &recordingLogger{}
+	st := NewTracingStore[string, string](NewKVStore[string, string](), logger)
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-1")
+	st.PutContext(ctx, "a", "1")
+	if !logger.contains("request r-1: put (a) took") {
+		t.Fatalf("got %v", logger.messages)
+	}
+}
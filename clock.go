@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is where the time-based features read the time from, so tests can control it with a FakeClock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock that only moves when told to.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// WithClock sets the clock used for TTLs and access times, the default is the system clock.
+func WithClock[K comparable, V any](clock Clock) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.clock = clock
+	}
+}
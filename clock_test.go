@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("got %s", clock.Now())
+	}
+	clock.Advance(time.Hour)
+	if !clock.Now().Equal(start.Add(time.Hour)) {
+		t.Fatalf("got %s after advancing", clock.Now())
+	}
+	clock.Set(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("got %s after setting", clock.Now())
+	}
+}
+
+func TestStoreReadsTheClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+
+	st.PutWithTTL("a", "1", time.Hour)
+	clock.Advance(30 * time.Minute)
+	st.Get("a")
+	if info, _ := st.Inspect("a"); !info.LastAccess.Equal(clock.Now()) || *info.TTLMillis != (30*time.Minute).Milliseconds() {
+		t.Fatalf("the metadata doesn't follow the clock: %+v", info)
+	}
+
+	// A day passes in no time.
+	clock.Advance(24 * time.Hour)
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v past the TTL", err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ringReplicas            = 100
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned by the ClusterClient when the breaker of the node owning the key is open.
+var ErrCircuitOpen = errors.New("the circuit breaker of the node is open")
+
+// hashRing maps keys to nodes with consistent hashing, every node is placed ringReplicas times on the ring.
+type hashRing struct {
+	hashes []uint64
+	owners map[uint64]string
+	nodes  int
+}
+
+func newHashRing(nodes []string) *hashRing {
+	r := &hashRing{owners: make(map[uint64]string), nodes: len(nodes)}
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			h := fnvHash(node + "#" + strconv.Itoa(i))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = node
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// successors returns every node in ring order starting with the owner of the key.
+func (r *hashRing) successors(key string) []string {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	h := fnvHash(key)
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+
+	nodes := make([]string, 0, r.nodes)
+	seen := make(map[string]bool, r.nodes)
+	for i := 0; i < len(r.hashes) && len(nodes) < r.nodes; i++ {
+		node := r.owners[r.hashes[(start+i)%len(r.hashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is the circuit breaker of one node. It opens after threshold consecutive failures, fails fast for
+// the cooldown, then lets a single probe through: the breaker closes if it succeeds and opens again otherwise.
+type breaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func (b *breaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// A probe is already in flight.
+		return false
+	}
+	return true
+}
+
+func (b *breaker) record(failed bool, now time.Time, threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= threshold {
+		b.state, b.openedAt = breakerOpen, now
+	}
+}
+
+// ClusterClient talks to a cluster of servers, each key is sent to the node owning it on a consistent hash ring.
+type ClusterClient struct {
+	ring     *hashRing
+	breakers map[string]*breaker
+	client   *http.Client
+	clock    Clock
+
+	threshold int
+	cooldown  time.Duration
+	fallback  bool
+
+	// replicas are the replicas of every primary node, the eventual reads rotate over them with next.
+	replicas    map[string][]string
+	consistency Consistency
+	next        atomic.Uint64
+}
+
+// Consistency tells where the ClusterClient reads from.
+type Consistency int
+
+const (
+	// ConsistencyStrong reads from the primary owning the key, so a read sees every write acknowledged before it.
+	ConsistencyStrong Consistency = iota
+	// ConsistencyEventual spreads the reads over the primary and its replicas, a replica may be behind.
+	ConsistencyEventual
+)
+
+// ClusterOption configures the optional behaviour of a ClusterClient.
+type ClusterOption func(*ClusterClient)
+
+// WithBreaker sets after how many consecutive failures the breaker of a node opens, and how long it stays open
+// before probing the node again. The defaults are 5 failures and 10s.
+func WithBreaker(threshold int, cooldown time.Duration) ClusterOption {
+	return func(c *ClusterClient) {
+		c.threshold, c.cooldown = threshold, cooldown
+	}
+}
+
+// WithRingFallback sends the requests for a node whose breaker is open, or that just failed, to the next nodes
+// on the ring. It's best-effort: the next node doesn't have the keys of the failed one, so a Get may miss
+// and a Put lands where the owner won't see it once it's back.
+func WithRingFallback() ClusterOption {
+	return func(c *ClusterClient) {
+		c.fallback = true
+	}
+}
+
+// WithNodeReplicas adds replicas to a primary node, servers following it with WithReplica. The writes always go
+// to the primary, the reads only use the replicas with ConsistencyEventual.
+func WithNodeReplicas(primary string, replicas ...string) ClusterOption {
+	return func(c *ClusterClient) {
+		c.replicas[primary] = append(c.replicas[primary], replicas...)
+		for _, replica := range replicas {
+			c.breakers[replica] = &breaker{}
+		}
+	}
+}
+
+// WithReadConsistency sets the consistency of Get, the default is ConsistencyStrong. GetWithConsistency picks it
+// per read.
+func WithReadConsistency(level Consistency) ClusterOption {
+	return func(c *ClusterClient) {
+		c.consistency = level
+	}
+}
+
+// WithHTTPClient sets the http.Client used to reach the nodes, the default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClusterOption {
+	return func(c *ClusterClient) {
+		c.client = client
+	}
+}
+
+// WithClusterClock sets the clock the breakers measure their cooldown with.
+func WithClusterClock(clock Clock) ClusterOption {
+	return func(c *ClusterClient) {
+		c.clock = clock
+	}
+}
+
+// NewClusterClient creates a client for the nodes, given as base URLs like http://10.0.0.1:3000.
+func NewClusterClient(nodes []string, opts ...ClusterOption) *ClusterClient {
+	c := &ClusterClient{
+		ring:      newHashRing(nodes),
+		breakers:  make(map[string]*breaker, len(nodes)),
+		replicas:  make(map[string][]string),
+		client:    http.DefaultClient,
+		clock:     realClock{},
+		threshold: defaultBreakerThreshold,
+		cooldown:  defaultBreakerCooldown,
+	}
+	for _, node := range nodes {
+		c.breakers[node] = &breaker{}
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// nodeError is a failure of the node itself (unreachable, 5xx), as opposed to an answer like 404.
+type nodeError struct {
+	err error
+}
+
+func (e *nodeError) Error() string { return e.err.Error() }
+func (e *nodeError) Unwrap() error { return e.err }
+
+// do runs req against the owner of the key, or its successors with WithRingFallback, going through their breakers.
+func (c *ClusterClient) do(key string, req func(node string) error) error {
+	err := ErrCircuitOpen
+	for _, node := range c.ring.successors(key) {
+		b := c.breakers[node]
+		if !b.allow(c.clock.Now(), c.cooldown) {
+			err = fmt.Errorf("%s: %w", node, ErrCircuitOpen)
+		} else {
+			err = req(node)
+			var ne *nodeError
+			failed := errors.As(err, &ne)
+			b.record(failed, c.clock.Now(), c.threshold)
+			if !failed {
+				return err
+			}
+		}
+		if !c.fallback {
+			break
+		}
+	}
+	return err
+}
+
+// call sends the request to the node and decodes the JSON response into out, a 404 is returned as ErrKeyNotFound.
+func (c *ClusterClient) call(node, method, path string, body io.Reader, key string, out any) error {
+	req, err := http.NewRequest(method, node+path, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return &nodeError{err}
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return keyNotFound(key)
+	case res.StatusCode >= 500:
+		return &nodeError{fmt.Errorf("%s answered %s", node, res.Status)}
+	case res.StatusCode == http.StatusNoContent:
+		// A node created WithMinimalResponses.
+		return nil
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s answered %s", node, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Get reads the key with the consistency set by WithReadConsistency.
+func (c *ClusterClient) Get(key string) (string, error) {
+	return c.GetWithConsistency(key, c.consistency)
+}
+
+// GetWithConsistency reads the key from its primary with ConsistencyStrong. With ConsistencyEventual it reads
+// from the primary or one of its replicas in turn, a replica that fails or whose breaker is open is skipped for
+// the primary.
+func (c *ClusterClient) GetWithConsistency(key string, level Consistency) (string, error) {
+	var body struct {
+		Value string `json:"value"`
+	}
+	path := "/kv/" + url.PathEscape(key)
+
+	if owners := c.ring.successors(key); level == ConsistencyEventual && len(owners) > 0 {
+		if replicas := c.replicas[owners[0]]; len(replicas) > 0 {
+			// 0 is the primary, it's read below like for a strong read.
+			if i := c.next.Add(1) % uint64(len(replicas)+1); i > 0 {
+				replica := replicas[i-1]
+				if b := c.breakers[replica]; b.allow(c.clock.Now(), c.cooldown) {
+					err := c.call(replica, http.MethodGet, path, nil, key, &body)
+					var ne *nodeError
+					failed := errors.As(err, &ne)
+					b.record(failed, c.clock.Now(), c.threshold)
+					if !failed {
+						return body.Value, err
+					}
+				}
+			}
+		}
+	}
+
+	err := c.do(key, func(node string) error {
+		return c.call(node, http.MethodGet, path, nil, key, &body)
+	})
+	return body.Value, err
+}
+
+func (c *ClusterClient) Put(key, value string) error {
+	return c.do(key, func(node string) error {
+		return c.call(node, http.MethodPut, "/kv/"+url.PathEscape(key), strings.NewReader(value), key, &struct{}{})
+	})
+}
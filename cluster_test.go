@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyNode is a node answering 500 while it's down, hits counts the requests that reached it.
+type flakyNode struct {
+	*httptest.Server
+	down atomic.Bool
+	hits atomic.Int32
+}
+
+func newFlakyNode(t *testing.T) *flakyNode {
+	n := &flakyNode{}
+	_, h := newTestServer(NewKVStore[string, string]())
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.hits.Add(1)
+		if n.down.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func TestBreakerOpensAndFailsFast(t *testing.T) {
+	node := newFlakyNode(t)
+	node.down.Store(true)
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewClusterClient([]string{node.URL}, WithBreaker(3, time.Minute), WithClusterClock(clock))
+
+	for i := 0; i < 3; i++ {
+		if err := c.Put("a", "1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("request %d: got %v, want the node's error", i, err)
+		}
+	}
+	if err := c.Put("a", "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+	if n := node.hits.Load(); n != 3 {
+		t.Fatalf("the node got %d requests, the open breaker let some through", n)
+	}
+
+	// After the cooldown a failed probe opens the breaker again right away.
+	clock.Advance(time.Minute)
+	if err := c.Put("a", "1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("the probe got %v", err)
+	}
+	if err := c.Put("a", "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v after a failed probe", err)
+	}
+
+	// And a successful one closes it.
+	node.down.Store(false)
+	clock.Advance(time.Minute)
+	if err := c.Put("a", "1"); err != nil {
+		t.Fatalf("the probe got %v", err)
+	}
+	if v, err := c.Get("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v with the breaker closed", v, err)
+	}
+}
+
+func TestBreakerRingFallback(t *testing.T) {
+	bad, good := newFlakyNode(t), newFlakyNode(t)
+	bad.down.Store(true)
+	c := NewClusterClient([]string{bad.URL, good.URL}, WithBreaker(1, time.Minute), WithRingFallback())
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprint("key", i); c.ring.successors(k)[0] == bad.URL {
+			key = k
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Put(key, "1"); err != nil {
+			t.Fatalf("request %d: got %v with a fallback node up", i, err)
+		}
+	}
+	if n := bad.hits.Load(); n != 1 {
+		t.Fatalf("the failing node got %d requests, want 1", n)
+	}
+	if n := good.hits.Load(); n != 3 {
+		t.Fatalf("the fallback node got %d requests, want 3", n)
+	}
+}
+
+func TestReadConsistency(t *testing.T) {
+	primary, replica := newFlakyNode(t), newFlakyNode(t)
+	// The replica is behind: it still has the value from before the write below.
+	if err := NewClusterClient([]string{replica.URL}).Put("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+	replica.hits.Store(0)
+
+	c := NewClusterClient([]string{primary.URL}, WithNodeReplicas(primary.URL, replica.URL))
+	if err := c.Put("a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if n := replica.hits.Load(); n != 0 {
+		t.Fatal("the write went to the replica")
+	}
+
+	for i := 0; i < 10; i++ {
+		if v, err := c.Get("a"); err != nil || v != "new" {
+			t.Fatalf("strong read %d got %q, %v", i, v, err)
+		}
+	}
+	if n := replica.hits.Load(); n != 0 {
+		t.Fatalf("the strong reads hit the replica %d times", n)
+	}
+
+	seen := map[string]int{}
+	for i := 0; i < 10; i++ {
+		v, err := c.GetWithConsistency("a", ConsistencyEventual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[v]++
+	}
+	if n := replica.hits.Load(); n != 5 || seen["old"] != 5 || seen["new"] != 5 {
+		t.Fatalf("the replica got %d of the eventual reads, they saw %v, want half of them each", n, seen)
+	}
+
+	// WithReadConsistency changes the default of Get.
+	eventual := NewClusterClient([]string{primary.URL}, WithNodeReplicas(primary.URL, replica.URL), WithReadConsistency(ConsistencyEventual))
+	replica.hits.Store(0)
+	eventual.Get("a")
+	eventual.Get("a")
+	if n := replica.hits.Load(); n != 1 {
+		t.Fatalf("the replica got %d of 2 eventual reads", n)
+	}
+}
+
+func TestEventualReadSkipsAFailingReplica(t *testing.T) {
+	primary, replica := newFlakyNode(t), newFlakyNode(t)
+	replica.down.Store(true)
+	c := NewClusterClient([]string{primary.URL}, WithNodeReplicas(primary.URL, replica.URL), WithBreaker(1, time.Minute),
+		WithReadConsistency(ConsistencyEventual))
+	if err := c.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if v, err := c.Get("a"); err != nil || v != "1" {
+			t.Fatalf("read %d got %q, %v with the primary up", i, v, err)
+		}
+	}
+	// The breaker of the replica opened on its first failure.
+	if n := replica.hits.Load(); n != 1 {
+		t.Fatalf("the failing replica got %d requests", n)
+	}
+}
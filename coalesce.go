@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CoalescingStore buffers the writes to a Storer for a short window and only applies the last value written
+// to each key, for hot keys where only the latest value matters. Reads see the buffered values right away.
+// The buffered writes are applied when the window elapses, so the errors of the backing store (full, invalid
+// key...) can't be returned to the writer, they're logged instead.
+type CoalescingStore[K comparable, V any] struct {
+	backing Storer[K, V]
+	window  time.Duration
+	logger  Logger
+
+	// mu is also held while flushing, so a read never misses a value that is on its way to the backing store.
+	mu      sync.Mutex
+	pending map[K]V
+	timer   *time.Timer
+}
+
+// NewCoalescingStore wraps backing, the writes to a key within window of each other are merged into one.
+func NewCoalescingStore[K comparable, V any](backing Storer[K, V], window time.Duration) *CoalescingStore[K, V] {
+	return &CoalescingStore[K, V]{
+		backing: backing,
+		window:  window,
+		logger:  defaultLogger,
+		pending: make(map[K]V),
+	}
+}
+
+// buffer records the write and schedules a flush if there isn't one pending, must be called with the lock held.
+func (s *CoalescingStore[K, V]) buffer(key K, value V) {
+	s.pending[key] = value
+	if s.timer == nil && !testMode.Load() {
+		s.timer = time.AfterFunc(s.window, s.Flush)
+	}
+}
+
+func (s *CoalescingStore[K, V]) Put(key K, value V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buffer(key, value)
+	return nil
+}
+
+func (s *CoalescingStore[K, V]) Get(key K) (V, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value, ok := s.pending[key]; ok {
+		return value, nil
+	}
+	return s.backing.Get(key)
+}
+
+func (s *CoalescingStore[K, V]) Update(key K, value V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pending[key]; !ok {
+		if _, err := s.backing.Get(key); err != nil {
+			return err
+		}
+	}
+	s.buffer(key, value)
+	return nil
+}
+
+// Delete drops the buffered write of the key, if any, and deletes it from the backing store right away.
+func (s *CoalescingStore[K, V]) Delete(key K) (V, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, buffered := s.pending[key]
+	delete(s.pending, key)
+	old, err := s.backing.Delete(key)
+	if buffered {
+		return value, nil
+	}
+	return old, err
+}
+
+// Flush applies the buffered writes to the backing store now.
+func (s *CoalescingStore[K, V]) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	for key, value := range s.pending {
+		if err := s.backing.Put(key, value); err != nil {
+			s.logger.Error("applying the coalesced write of key (%v) failed: %v", key, err)
+		}
+	}
+	s.pending = make(map[K]V)
+}
+
+// Close applies the buffered writes, the store must not be written to afterwards.
+func (s *CoalescingStore[K, V]) Close() error {
+	s.Flush()
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the writes that reach the store it wraps.
+type countingStore struct {
+	Storer[string, string]
+	puts atomic.Int32
+}
+
+func (s *countingStore) Put(key, value string) error {
+	s.puts.Add(1)
+	return s.Storer.Put(key, value)
+}
+
+func TestCoalescingMergesBursts(t *testing.T) {
+	backing := &countingStore{Storer: NewKVStore[string, string]()}
+	// The window never elapses during the test, Flush applies the writes.
+	st := NewCoalescingStore[string, string](backing, time.Hour)
+	defer st.Close()
+
+	for i := 0; i < 100; i++ {
+		st.Put("gauge", fmt.Sprint(i))
+		if v, _ := st.Get("gauge"); v != fmt.Sprint(i) {
+			t.Fatalf("got %q after writing %d", v, i)
+		}
+	}
+	if n := backing.puts.Load(); n != 0 {
+		t.Fatalf("%d writes reached the backing store before the flush", n)
+	}
+
+	st.Flush()
+	if n := backing.puts.Load(); n != 1 {
+		t.Fatalf("%d writes reached the backing store, want 1", n)
+	}
+	if v, _ := backing.Get("gauge"); v != "99" {
+		t.Fatalf("the backing store holds %q", v)
+	}
+}
+
+func TestCoalescingFlushesAfterTheWindow(t *testing.T) {
+	backing := &countingStore{Storer: NewKVStore[string, string]()}
+	st := NewCoalescingStore[string, string](backing, 10*time.Millisecond)
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.Put("a", "2")
+	waitFor(t, func() bool { return backing.puts.Load() == 1 })
+	if v, _ := backing.Get("a"); v != "2" {
+		t.Fatalf("the backing store holds %q", v)
+	}
+}
+
+func TestCoalescingDeleteDropsTheBufferedWrite(t *testing.T) {
+	backing := &countingStore{Storer: NewKVStore[string, string]()}
+	st := NewCoalescingStore[string, string](backing, time.Hour)
+	defer st.Close()
+
+	if err := st.Update("a", "1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v updating a missing key", err)
+	}
+	st.Put("a", "1")
+	if v, err := st.Delete("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	st.Flush()
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the deleted key came back: %v", err)
+	}
+}
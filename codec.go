@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec turns values into bytes and back, it decides the format used by the snapshots and the WAL, see WithCodec,
+// and by the HTTP bodies of WithBodyCodec.
+type Codec[T any] interface {
+	Marshal(T) ([]byte, error)
+	Unmarshal([]byte) (T, error)
+}
+
+// JSONCodec encodes with encoding/json, it's the default.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes with encoding/gob, which is more compact and also handles keys and values json can't (e.g. struct keys).
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec[point]{"json": JSONCodec[point]{}, "gob": GobCodec[point]{}} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Marshal(point{1, 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := codec.Unmarshal(data)
+			if err != nil || got != (point{1, 2}) {
+				t.Fatalf("got %v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestSnapshotWithCodec(t *testing.T) {
+	// Struct keys can't be encoded as JSON object keys, gob handles them.
+	opts := []Option[point, []string]{WithCodec[point, []string](GobCodec[point]{}, GobCodec[[]string]{})}
+	st := NewKVStore[point, []string](opts...)
+	st.Put(point{1, 2}, []string{"a", "b"})
+	st.Put(point{3, 4}, nil)
+
+	var buf bytes.Buffer
+	if err := st.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewKVStore[point, []string](opts...)
+	if err := loaded.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := loaded.Get(point{1, 2}); err != nil || !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatalf("got %v, %v", v, err)
+	}
+	if _, err := loaded.Get(point{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBodyCodec(t *testing.T) {
+	const gobType = "application/x-gob"
+	codec := GobCodec[string]{}
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st, WithBodyCodec(gobType, codec))
+
+	body, _ := codec.Marshal("hello")
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", string(body), "Content-Type", gobType), http.StatusOK)
+	if v, _ := st.Get("a"); v != "hello" {
+		t.Fatalf("got %q stored", v)
+	}
+
+	rec := do(t, h, http.MethodGet, "/kv/a", "", "Accept", "text/html, "+gobType+";q=0.9")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); ct != gobType {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+	if v, err := codec.Unmarshal(rec.Body.Bytes()); err != nil || v != "hello" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+
+	// The others still get JSON.
+	rec = do(t, h, http.MethodGet, "/kv/a", "")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" && ct != "application/json; charset=UTF-8" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+}
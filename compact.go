@@ -0,0 +1,56 @@
+package main
+
+// A store is only compacted once it has shrunk to a quarter of its peak, and had at least compactMinKeys keys:
+// rebuilding the maps copies every key, it's not worth it for a few buckets.
+const (
+	compactRatio   = 4
+	compactMinKeys = 1024
+)
+
+// Compact rebuilds the maps of the store into right-sized ones if most of their keys were deleted, Go maps
+// never shrink so a store that grew large keeps the memory otherwise. It reports whether it did, the memory is
+// returned to the runtime by the next GC. It's not the WAL compaction, the log is left alone.
+//
+// Clear and the sweeper already compact the store after removing keys, Compact is for the stores that shrink
+// through a lot of Deletes.
+func (s *KVStore[K, V]) Compact() bool {
+	s.lock()
+	defer s.mu.Unlock()
+
+	return s.compactIfSparse()
+}
+
+// compactIfSparse rebuilds the maps if the store holds less than a compactRatio of its peak, must be called
+// with the write lock held.
+func (s *KVStore[K, V]) compactIfSparse() bool {
+	n := len(s.data)
+	if s.peakKeys < compactMinKeys || n*compactRatio > s.peakKeys {
+		return false
+	}
+
+	s.data = copyMap(s.data)
+	s.meta = copyMap(s.meta)
+	s.expires = copyMap(s.expires)
+	if s.lru != nil {
+		s.lru.compact()
+	}
+	s.peakKeys = n
+	s.logger.Debug("compacted the store down to %d keys", n)
+	return true
+}
+
+// copyMap copies m into a map sized for its current length. maps.Clone keeps the buckets of m, so it can't be
+// used to shrink it.
+func copyMap[K comparable, T any](m map[K]T) map[K]T {
+	c := make(map[K]T, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (l *lruList[K]) compact() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elems = copyMap(l.elems)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// heapInUse returns the bytes of the heap in use after a GC.
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+func TestCompactReclaimsMemory(t *testing.T) {
+	const keys, kept = 200000, 1000
+	st := NewKVStore[string, string]()
+	for i := 0; i < keys; i++ {
+		st.Put(fmt.Sprint("key", i), "v")
+	}
+	for i := kept; i < keys; i++ {
+		st.Delete(fmt.Sprint("key", i))
+	}
+
+	before := heapInUse()
+	if !st.Compact() {
+		t.Fatal("a store down to 0.5% of its peak wasn't compacted")
+	}
+	after := heapInUse()
+	runtime.KeepAlive(st)
+	if after >= before/2 {
+		t.Fatalf("the heap went from %d to %d bytes, the empty buckets weren't released", before, after)
+	}
+
+	for i := 0; i < kept; i++ {
+		if v, err := st.Get(fmt.Sprint("key", i)); err != nil || v != "v" {
+			t.Fatalf("key%d: got %q, %v after the compaction", i, v, err)
+		}
+	}
+	// It's now sized for what it holds, there's nothing more to do.
+	if st.Compact() {
+		t.Fatal("a compacted store was compacted again")
+	}
+}
+
+func TestCompactSkipsDenseStores(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for i := 0; i < 4*compactMinKeys; i++ {
+		st.Put(fmt.Sprint("key", i), "v")
+	}
+	// Down to half of its peak.
+	for i := 0; i < 2*compactMinKeys; i++ {
+		st.Delete(fmt.Sprint("key", i))
+	}
+	if st.Compact() {
+		t.Fatal("a store at half of its peak was compacted")
+	}
+
+	small := NewKVStore[string, string]()
+	for i := 0; i < compactMinKeys/2; i++ {
+		small.Put(fmt.Sprint("key", i), "v")
+	}
+	for i := 0; i < compactMinKeys/2; i++ {
+		small.Delete(fmt.Sprint("key", i))
+	}
+	if small.Compact() {
+		t.Fatal("a store that never had many keys was compacted")
+	}
+}
+
+func TestCompactOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for i := 0; i < compactMinKeys; i++ {
+		st.Put(fmt.Sprint("key", i), "v")
+	}
+	for i := 0; i < compactMinKeys; i++ {
+		st.Delete(fmt.Sprint("key", i))
+	}
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPost, "/admin/compact", "")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"compacted":true`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	rec = do(t, h, http.MethodPost, "/admin/compact", "")
+	if !strings.Contains(rec.Body.String(), `"compacted":false`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrComputePanicked is returned to the callers waiting on a GetOrCompute whose compute panicked, the panic
+// itself goes on in the caller that ran it.
+var ErrComputePanicked = errors.New("the compute panicked")
+
+// computeCall is a GetOrCompute in flight, the callers asking for the same key wait for done and share its result.
+type computeCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// computeGroup tracks the computations in flight, it has its own lock so that computing a value never holds
+// the lock of the store.
+type computeGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*computeCall[V]
+}
+
+// GetOrCompute returns the value of the key, or if it's missing runs compute, stores its result and returns it.
+// Concurrent callers for the same missing key wait for a single compute instead of all running it, and get its
+// result, error included. A failed compute stores nothing, so the next call tries again.
+func (s *KVStore[K, V]) GetOrCompute(key K, compute func() (V, error)) (V, error) {
+	key = s.canon(key)
+	// Uses get rather than Get so the loader of the store doesn't run in place of compute.
+	if value, _, err := s.get(key); err == nil {
+		return value, nil
+	}
+
+	g := &s.computes
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[K]*computeCall[V])
+	}
+	call := &computeCall[V]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			var zero V
+			call.value, call.err = zero, fmt.Errorf("%w: %v", ErrComputePanicked, r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	// Another caller may have finished computing the key between our Get and taking the slot.
+	if value, _, err := s.get(key); err == nil {
+		call.value = value
+		return value, nil
+	}
+	call.value, call.err = compute()
+	if call.err == nil {
+		call.err = s.Put(key, call.value)
+	}
+	return call.value, call.err
+}
+
+// WithLoader makes Get fall through to load on a miss, the value it finds is stored and returned, it's the
+// read-through cache pattern. load reports false for a key it doesn't have either, Get then fails with
+// ErrKeyNotFound. Like with GetOrCompute, concurrent misses for the same key wait for a single load.
+func WithLoader[K comparable, V any](load func(K) (V, bool, error)) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.loader = load
+	}
+}
+
+// load fetches a missing key with the loader of the store.
+func (s *KVStore[K, V]) load(key K) (V, error) {
+	return s.GetOrCompute(key, func() (V, error) {
+		value, found, err := s.loader(key)
+		if err == nil && !found {
+			err = keyNotFound(key)
+		}
+		return value, err
+	})
+}
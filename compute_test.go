@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrComputeRunsOnce(t *testing.T) {
+	st := NewKVStore[string, string]()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	compute := func() (string, error) {
+		calls.Add(1)
+		<-release
+		return "computed", nil
+	}
+
+	const n = 50
+	var wg, started sync.WaitGroup
+	results := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			v, err := st.GetOrCompute("key", compute)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+	started.Wait()
+	close(release)
+	wg.Wait()
+
+	if c := calls.Load(); c != 1 {
+		t.Fatalf("compute ran %d times", c)
+	}
+	for i, v := range results {
+		if v != "computed" {
+			t.Fatalf("caller %d got %q", i, v)
+		}
+	}
+	if v, _ := st.Get("key"); v != "computed" {
+		t.Fatalf("the result wasn't stored: %q", v)
+	}
+}
+
+func TestGetOrComputeFailure(t *testing.T) {
+	st := NewKVStore[string, string]()
+	boom := errors.New("boom")
+	if _, err := st.GetOrCompute("key", func() (string, error) { return "", boom }); !errors.Is(err, boom) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := st.Get("key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a failed compute stored something: %v", err)
+	}
+	// The next call tries again, and an existing key doesn't compute at all.
+	if v, err := st.GetOrCompute("key", func() (string, error) { return "ok", nil }); err != nil || v != "ok" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, _ := st.GetOrCompute("key", func() (string, error) { t.Fatal("computed an existing key"); return "", nil }); v != "ok" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestGetOrComputePanic(t *testing.T) {
+	st := NewKVStore[string, string]()
+	computing, release := make(chan struct{}), make(chan struct{})
+	panicked := make(chan any)
+	go func() {
+		defer func() { panicked <- recover() }()
+		st.GetOrCompute("key", func() (string, error) {
+			close(computing)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-computing
+
+	waited := make(chan error)
+	go func() {
+		_, err := st.GetOrCompute("key", func() (string, error) { return "", errors.New("computed again") })
+		waited <- err
+	}()
+	// Gives the waiter the time to wait for the compute in flight.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if r := <-panicked; r != "boom" {
+		t.Fatalf("the caller running compute recovered %v, want the panic to go on", r)
+	}
+	if err := <-waited; !errors.Is(err, ErrComputePanicked) {
+		t.Fatalf("the waiter got %v, want ErrComputePanicked", err)
+	}
+	// Nothing was stored and the next call computes again.
+	if v, err := st.GetOrCompute("key", func() (string, error) { return "ok", nil }); err != nil || v != "ok" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestLoaderRunsOncePerMiss(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	st := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		calls.Add(1)
+		<-release
+		return "loaded " + key, true, nil
+	}))
+
+	const n = 50
+	var wg, started sync.WaitGroup
+	results := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			v, err := st.Get("key")
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+	started.Wait()
+	close(release)
+	wg.Wait()
+
+	if c := calls.Load(); c != 1 {
+		t.Fatalf("the loader ran %d times", c)
+	}
+	for i, v := range results {
+		if v != "loaded key" {
+			t.Fatalf("caller %d got %q", i, v)
+		}
+	}
+	// The loaded value is cached.
+	for i := 0; i < 3; i++ {
+		if v, err := st.Get("key"); err != nil || v != "loaded key" {
+			t.Fatalf("got %q, %v", v, err)
+		}
+	}
+	if c := calls.Load(); c != 1 {
+		t.Fatalf("a cached key was loaded again, %d loads", c)
+	}
+}
+
+func TestLoaderMisses(t *testing.T) {
+	boom := errors.New("boom")
+	var calls atomic.Int32
+	st := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		calls.Add(1)
+		switch key {
+		case "broken":
+			return "", false, boom
+		case "missing":
+			return "", false, nil
+		}
+		return "loaded", true, nil
+	}))
+
+	if _, err := st.Get("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a key the loader doesn't have", err)
+	}
+	if _, err := st.Get("broken"); !errors.Is(err, boom) {
+		t.Fatalf("got %v, want the error of the loader", err)
+	}
+	// Neither is cached, the next Get asks again.
+	calls.Store(0)
+	st.Get("missing")
+	st.Get("broken")
+	if c := calls.Load(); c != 2 {
+		t.Fatalf("the loader ran %d times", c)
+	}
+
+	// A key in the store never reaches the loader.
+	st.Put("present", "stored")
+	if v, _ := st.Get("present"); v != "stored" {
+		t.Fatalf("got %q", v)
+	}
+	if c := calls.Load(); c != 2 {
+		t.Fatal("the loader ran for a key in the store")
+	}
+}
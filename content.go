@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ValueDecoder turns the body of a PUT /kv/:key into the value stored, for the content type it's registered for.
+type ValueDecoder func(body []byte) (string, error)
+
+// defaultValueDecoders are the content types a PUT /kv/:key accepts out of the box, a body without a
+// Content-Type is text/plain.
+var defaultValueDecoders = map[string]ValueDecoder{
+	"text/plain":                        decodePlainValue,
+	"application/octet-stream":          decodePlainValue,
+	"application/json":                  decodeJSONValue,
+	"application/x-www-form-urlencoded": decodeFormValue,
+}
+
+// WithValueDecoder makes PUT /kv/:key accept the bodies of contentType, decoded with decode. It can also replace
+// the decoder of one of the default types: text/plain and application/octet-stream stored as is,
+// application/json stored as is except for the strings which are unquoted, and
+// application/x-www-form-urlencoded whose value field is stored.
+func WithValueDecoder(contentType string, decode ValueDecoder) ServerOption {
+	return func(s *Server) {
+		if s.valueDecoders == nil {
+			s.valueDecoders = make(map[string]ValueDecoder, len(defaultValueDecoders)+1)
+			for ct, d := range defaultValueDecoders {
+				s.valueDecoders[ct] = d
+			}
+		}
+		s.valueDecoders[contentType] = decode
+	}
+}
+
+// WithFallbackContentType makes PUT /kv/:key decode the bodies of the content types it doesn't know as
+// contentType, instead of rejecting them with 415 Unsupported Media Type.
+func WithFallbackContentType(contentType string) ServerOption {
+	return func(s *Server) {
+		s.fallbackContentType = contentType
+	}
+}
+
+// WithBodyCodec makes the bodies of contentType go through codec: PUT /kv/:key decodes them with it, and GET
+// /kv/:key answers a request that accepts contentType with the value encoded with it instead of the JSON object.
+// e.g. WithBodyCodec("application/x-gob", GobCodec[string]{}).
+func WithBodyCodec(contentType string, codec Codec[string]) ServerOption {
+	return func(s *Server) {
+		WithValueDecoder(contentType, codec.Unmarshal)(s)
+		if s.bodyCodecs == nil {
+			s.bodyCodecs = make(map[string]Codec[string])
+		}
+		s.bodyCodecs[contentType] = codec
+	}
+}
+
+// acceptedCodec returns the first body codec the Accept header of the request asks for.
+func (s *Server) acceptedCodec(c echo.Context) (string, Codec[string], bool) {
+	if s.bodyCodecs == nil {
+		return "", nil, false
+	}
+	for _, accepted := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if codec, ok := s.bodyCodecs[mediaType]; ok {
+			return mediaType, codec, true
+		}
+	}
+	return "", nil, false
+}
+
+// respondValue answers a read of value with body, or with the value alone if the request accepts one of the
+// body codecs.
+func (s *Server) respondValue(c echo.Context, value string, body any) error {
+	contentType, codec, ok := s.acceptedCodec(c)
+	if !ok {
+		return c.JSON(http.StatusOK, body)
+	}
+	b, err := codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding the value as %s: %w", contentType, err)
+	}
+	return c.Blob(http.StatusOK, contentType, b)
+}
+
+func (s *Server) valueDecoder(contentType string) (ValueDecoder, bool) {
+	decoders := s.valueDecoders
+	if decoders == nil {
+		decoders = defaultValueDecoders
+	}
+	decode, ok := decoders[contentType]
+	return decode, ok
+}
+
+// decodeValue decodes the body of a request according to its Content-Type.
+func (s *Server) decodeValue(c echo.Context, body []byte) (string, error) {
+	contentType := "text/plain"
+	if header := c.Request().Header.Get(echo.HeaderContentType); header != "" {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid Content-Type: "+header)
+		}
+		contentType = mediaType
+	}
+
+	decode, ok := s.valueDecoder(contentType)
+	if !ok && s.fallbackContentType != "" {
+		decode, ok = s.valueDecoder(s.fallbackContentType)
+	}
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported Content-Type: "+contentType)
+	}
+
+	value, err := decode(body)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s body: %v", contentType, err))
+	}
+	return value, nil
+}
+
+func decodePlainValue(body []byte) (string, error) {
+	return string(body), nil
+}
+
+// decodeJSONValue stores a JSON string as its contents, so that "abc" sent as JSON and abc sent as text are
+// the same value, and any other JSON as is.
+func decodeJSONValue(body []byte) (string, error) {
+	var str string
+	if err := json.Unmarshal(body, &str); err == nil {
+		return str, nil
+	}
+	if !json.Valid(body) {
+		return "", errors.New("it isn't valid JSON")
+	}
+	return string(body), nil
+}
+
+func decodeFormValue(body []byte) (string, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", err
+	}
+	if !form.Has("value") {
+		return "", errors.New("the form has no value field")
+	}
+	return form.Get("value"), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPutDecodesByContentType(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	// The same value sent every way there is.
+	for i, tc := range []struct {
+		contentType string
+		body        string
+	}{
+		{"", "hello world"},
+		{"text/plain", "hello world"},
+		{"text/plain; charset=utf-8", "hello world"},
+		{"application/octet-stream", "hello world"},
+		{"application/json", `"hello world"`},
+		{"application/json; charset=utf-8", `"hello world"`},
+		{"application/x-www-form-urlencoded", "value=hello+world&other=ignored"},
+	} {
+		key := fmt.Sprint("k", i)
+		var headers []string
+		if tc.contentType != "" {
+			headers = []string{"Content-Type", tc.contentType}
+		}
+		expectStatus(t, do(t, h, http.MethodPut, "/kv/"+key, tc.body, headers...), http.StatusOK)
+		if v, _ := st.Get(key); v != "hello world" {
+			t.Errorf("%q: stored %q", tc.contentType, v)
+		}
+	}
+
+	// JSON other than a string is stored as is.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/obj", `{"a": 1}`, "Content-Type", "application/json"), http.StatusOK)
+	if v, _ := st.Get("obj"); v != `{"a": 1}` {
+		t.Fatalf("stored %q", v)
+	}
+}
+
+func TestPutRejectsBadBodies(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPut, "/kv/a", "<a/>", "Content-Type", "application/xml")
+	expectStatus(t, rec, http.StatusUnsupportedMediaType)
+	if !strings.Contains(rec.Body.String(), "application/xml") {
+		t.Fatalf("the content type isn't named: %s", rec.Body)
+	}
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "{", "Content-Type", "application/json"), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "other=1", "Content-Type", "application/x-www-form-urlencoded"), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", "Content-Type", "text/"), http.StatusBadRequest)
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a rejected body was stored: %v", err)
+	}
+}
+
+func TestValueDecoderOptions(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st,
+		WithValueDecoder("text/csv", func(body []byte) (string, error) {
+			return strings.ReplaceAll(string(body), ",", " "), nil
+		}),
+		// Replacing a default one.
+		WithValueDecoder("text/plain", func(body []byte) (string, error) {
+			return strings.ToUpper(string(body)), nil
+		}),
+		WithFallbackContentType("application/octet-stream"),
+	)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/csv", "a,b", "Content-Type", "text/csv"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/text", "abc", "Content-Type", "text/plain"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/xml", "<a/>", "Content-Type", "application/xml"), http.StatusOK)
+	// The defaults that weren't replaced are kept.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/json", `"j"`, "Content-Type", "application/json"), http.StatusOK)
+
+	for key, want := range map[string]string{"csv": "a b", "text": "ABC", "xml": "<a/>", "json": "j"} {
+		if v, _ := st.Get(key); v != want {
+			t.Errorf("%s: stored %q, want %q", key, v, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import "context"
+
+// ContextStorer is implemented by the stores taking the context of the request a call is for, so what they log
+// or hand to their hooks can be traced back to it with RequestIDFromContext. The wrappers pass it down to the
+// store they wrap.
+type ContextStorer[K comparable, V any] interface {
+	GetContext(ctx context.Context, key K) (V, error)
+	PutContext(ctx context.Context, key K, value V) error
+	UpdateContext(ctx context.Context, key K, value V) error
+	DeleteContext(ctx context.Context, key K) (V, error)
+}
+
+var (
+	_ ContextStorer[string, string] = (*KVStore[string, string])(nil)
+	_ ContextStorer[string, string] = (*MetricsStore[string, string])(nil)
+	_ ContextStorer[string, string] = (*TracingStore[string, string])(nil)
+	_ ContextStorer[string, string] = (*HookedStore[string, string])(nil)
+)
+
+// getWithContext, putWithContext, updateWithContext and deleteWithContext call storage with ctx if it's a
+// ContextStorer, and fall back to the plain methods otherwise.
+func getWithContext[K comparable, V any](ctx context.Context, storage Storer[K, V], key K) (V, error) {
+	if cs, ok := storage.(ContextStorer[K, V]); ok {
+		return cs.GetContext(ctx, key)
+	}
+	return storage.Get(key)
+}
+
+func putWithContext[K comparable, V any](ctx context.Context, storage Storer[K, V], key K, value V) error {
+	if cs, ok := storage.(ContextStorer[K, V]); ok {
+		return cs.PutContext(ctx, key, value)
+	}
+	return storage.Put(key, value)
+}
+
+func updateWithContext[K comparable, V any](ctx context.Context, storage Storer[K, V], key K, value V) error {
+	if cs, ok := storage.(ContextStorer[K, V]); ok {
+		return cs.UpdateContext(ctx, key, value)
+	}
+	return storage.Update(key, value)
+}
+
+func deleteWithContext[K comparable, V any](ctx context.Context, storage Storer[K, V], key K) (V, error) {
+	if cs, ok := storage.(ContextStorer[K, V]); ok {
+		return cs.DeleteContext(ctx, key)
+	}
+	return storage.Delete(key)
+}
+
+// The KVStore doesn't log the calls itself, it only gives up on the ones whose request is already gone.
+
+func (s *KVStore[K, V]) GetContext(ctx context.Context, key K) (V, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, err
+	}
+	return s.Get(key)
+}
+
+func (s *KVStore[K, V]) PutContext(ctx context.Context, key K, value V) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Put(key, value)
+}
+
+func (s *KVStore[K, V]) UpdateContext(ctx context.Context, key K, value V) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Update(key, value)
+}
+
+func (s *KVStore[K, V]) DeleteContext(ctx context.Context, key K) (V, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, err
+	}
+	return s.Delete(key)
+}
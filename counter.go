@@ -0,0 +1,123 @@
+package main
+
+// PNCounter is a CRDT counter that can be incremented on several replicas independently and merged without coordination.
+// Every replica only ever grows its own slots in P (increments) and N (decrements), merging keeps the highest value seen
+// for each replica and the counter's value is the sum of all the contributions.
+type PNCounter struct {
+	P map[string]uint64 `json:"p"`
+	N map[string]uint64 `json:"n"`
+}
+
+// Value returns the current total of the counter.
+func (c PNCounter) Value() int64 {
+	var total int64
+	for _, n := range c.P {
+		total += int64(n)
+	}
+	for _, n := range c.N {
+		total -= int64(n)
+	}
+	return total
+}
+
+// Increment returns a copy of the counter with delta added to the replica's contribution, delta may be negative.
+func (c PNCounter) Increment(replicaID string, delta int64) PNCounter {
+	c = c.clone()
+	if delta >= 0 {
+		c.P[replicaID] += uint64(delta)
+	} else {
+		c.N[replicaID] += uint64(-delta)
+	}
+	return c
+}
+
+// Merge returns the combination of both counters. It's commutative, associative and idempotent,
+// so replicas end up with the same value no matter how often or in which order they sync.
+func (c PNCounter) Merge(other PNCounter) PNCounter {
+	c = c.clone()
+	for id, n := range other.P {
+		c.P[id] = max(c.P[id], n)
+	}
+	for id, n := range other.N {
+		c.N[id] = max(c.N[id], n)
+	}
+	return c
+}
+
+func (c PNCounter) clone() PNCounter {
+	out := PNCounter{
+		P: make(map[string]uint64, len(c.P)),
+		N: make(map[string]uint64, len(c.N)),
+	}
+	for id, n := range c.P {
+		out.P[id] = n
+	}
+	for id, n := range c.N {
+		out.N[id] = n
+	}
+	return out
+}
+
+// CounterStore is the counter mode of the store: every key holds a PNCounter that this replica increments under its own id.
+// Replicas exchange State and Merge it, instead of overwriting each other's values.
+type CounterStore[K comparable] struct {
+	replicaID string
+	store     *KVStore[K, PNCounter]
+}
+
+// NewCounterStore creates a CounterStore for the replica, the id must be unique across the replicas that will be merged.
+func NewCounterStore[K comparable](replicaID string, opts ...Option[K, PNCounter]) *CounterStore[K] {
+	return &CounterStore[K]{
+		replicaID: replicaID,
+		store:     NewKVStore[K, PNCounter](opts...),
+	}
+}
+
+// Increment adds delta to the counter of the key, creating it if needed, and returns the new total.
+func (s *CounterStore[K]) Increment(key K, delta int64) (int64, error) {
+	c, err := s.store.upsert(s.store.canon(key), func(c PNCounter, _ bool) (PNCounter, error) {
+		return c.Increment(s.replicaID, delta), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.Value(), nil
+}
+
+// Value returns the current total of the counter of the key.
+func (s *CounterStore[K]) Value(key K) (int64, error) {
+	c, err := s.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return c.Value(), nil
+}
+
+// State returns a copy of every counter, to be sent to the other replicas during a sync. The expired ones are
+// left out.
+func (s *CounterStore[K]) State() map[K]PNCounter {
+	s.store.rlock()
+	defer s.store.mu.RUnlock()
+
+	state := make(map[K]PNCounter, len(s.store.data))
+	for key, c := range s.store.data {
+		if s.store.isExpired(key) {
+			continue
+		}
+		state[key] = c.clone()
+	}
+	return state
+}
+
+// Merge folds the state of another replica into this one.
+func (s *CounterStore[K]) Merge(state map[K]PNCounter) error {
+	for key, other := range state {
+		_, err := s.store.upsert(s.store.canon(key), func(c PNCounter, _ bool) (PNCounter, error) {
+			return c.Merge(other), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPNCounterMerge(t *testing.T) {
+	var a, b PNCounter
+	a = a.Increment("a", 5).Increment("a", -2)
+	b = b.Increment("b", 10)
+
+	ab, ba := a.Merge(b), b.Merge(a)
+	if ab.Value() != 13 || ba.Value() != 13 {
+		t.Fatalf("got %d and %d, want 13", ab.Value(), ba.Value())
+	}
+	if again := ab.Merge(b).Merge(a); again.Value() != 13 {
+		t.Fatalf("merging again changed the value to %d", again.Value())
+	}
+	if a.Value() != 3 {
+		t.Fatalf("merging changed the original to %d", a.Value())
+	}
+}
+
+func TestCounterStoreReplicasConverge(t *testing.T) {
+	a := NewCounterStore[string]("a")
+	b := NewCounterStore[string]("b")
+
+	a.Increment("hits", 3)
+	b.Increment("hits", 4)
+	b.Increment("hits", -1)
+	a.Increment("other", 1)
+
+	if err := a.Merge(b.State()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Merge(a.State()); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*CounterStore[string]{a, b} {
+		if v, err := s.Value("hits"); err != nil || v != 6 {
+			t.Fatalf("got %d, %v, want 6", v, err)
+		}
+		if v, err := s.Value("other"); err != nil || v != 1 {
+			t.Fatalf("got %d, %v, want 1", v, err)
+		}
+	}
+
+	// Syncing the same state twice doesn't count it twice.
+	a.Merge(b.State())
+	if v, _ := a.Value("hits"); v != 6 {
+		t.Fatalf("got %d after merging twice", v)
+	}
+	if _, err := a.Value("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestCounterStoreCanonicalKeys(t *testing.T) {
+	s := NewCounterStore[string]("a", WithCaseInsensitiveKeys[PNCounter]())
+	s.Increment("Hits", 2)
+	s.Increment("HITS", 1)
+	if v, err := s.Value("hits"); err != nil || v != 3 {
+		t.Fatalf("got %d, %v, want 3", v, err)
+	}
+
+	var other PNCounter
+	if err := s.Merge(map[string]PNCounter{"OTHER": other.Increment("b", 5)}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Value("other"); err != nil || v != 5 {
+		t.Fatalf("got %d, %v after the merge", v, err)
+	}
+	state := s.State()
+	if len(state) != 2 || state["hits"].Value() != 3 || state["other"].Value() != 5 {
+		t.Fatalf("got %v", state)
+	}
+}
+
+func TestCounterStoreStateSkipsExpired(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := NewCounterStore[string]("a", WithClock[string, PNCounter](clock), WithDefaultTTL[string, PNCounter](time.Minute))
+	s.Increment("old", 1)
+	clock.Advance(30 * time.Second)
+	s.Increment("new", 1)
+	clock.Advance(45 * time.Second)
+
+	if state := s.State(); len(state) != 1 || state["new"].Value() != 1 {
+		t.Fatalf("got %v, want only the counter that hasn't expired", state)
+	}
+}
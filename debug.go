@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// KeyInfo is everything the store tracks about a single key. Immutable is set when a Put can't overwrite the
+// key, in a CreateOnly store. Tombstoned is set for a key deleted within the retention of a store created
+// WithTombstones, only DeletedAt is filled in then.
+type KeyInfo[V any] struct {
+	Value       V          `json:"value"`
+	Version     uint64     `json:"version"`
+	LastAccess  *time.Time `json:"last_access,omitempty"`
+	AccessCount uint64     `json:"access_count"`
+	TTLMillis   *int64     `json:"ttl_ms,omitempty"`
+	Immutable   bool       `json:"immutable"`
+	Tombstoned  bool       `json:"tombstoned"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Inspector is implemented by stores that can report the metadata they keep for a key.
+type Inspector[K comparable, V any] interface {
+	Inspect(K) (KeyInfo[V], error)
+}
+
+// Inspect returns the value and metadata of the key, it does not count as an access. A tombstoned key is
+// reported as such instead of failing with ErrKeyNotFound.
+func (s *KVStore[K, V]) Inspect(key K) (KeyInfo[V], error) {
+	key = s.canon(key)
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.lookup(key)
+	if !ok && s.tombstones != nil && s.deleted(key) {
+		deletedAt := s.tombstones[key]
+		return KeyInfo[V]{Tombstoned: true, DeletedAt: &deletedAt}, nil
+	}
+	if !ok {
+		return KeyInfo[V]{}, keyNotFound(key)
+	}
+
+	m := s.meta[key]
+	info := KeyInfo[V]{
+		Value:       value,
+		Version:     m.version,
+		AccessCount: m.accessCount.Load(),
+		Immutable:   s.putMode == CreateOnly,
+	}
+	if nanos := m.lastAccess.Load(); nanos != 0 {
+		lastAccess := time.Unix(0, nanos)
+		info.LastAccess = &lastAccess
+	}
+	if expiresAt, ok := s.expires[key]; ok {
+		ttl := expiresAt.Sub(s.clock.Now()).Milliseconds()
+		info.TTLMillis = &ttl
+	}
+
+	return info, nil
+}
+
+func (s *Server) handleDebug(c echo.Context) error {
+	inspector, ok := s.Storage.(Inspector[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support inspection")
+	}
+
+	info, err := inspector.Inspect(c.Param("key"))
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, info)
+}
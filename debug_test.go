@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	st.Put("a", "1")
+	st.Put("a", "2")
+	st.Get("a")
+	st.Expire("a", time.Minute)
+
+	info, err := st.Inspect("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Value != "2" || info.Version != 2 || info.AccessCount != 1 || info.Immutable || info.Tombstoned {
+		t.Fatalf("got %+v", info)
+	}
+	if info.LastAccess == nil || !info.LastAccess.Equal(clock.Now()) {
+		t.Fatalf("got last access %v", info.LastAccess)
+	}
+	if info.TTLMillis == nil || *info.TTLMillis != time.Minute.Milliseconds() {
+		t.Fatalf("got TTL %v", info.TTLMillis)
+	}
+
+	// Inspecting isn't an access.
+	if info, _ := st.Inspect("a"); info.AccessCount != 1 {
+		t.Fatalf("got %d accesses after inspecting", info.AccessCount)
+	}
+}
+
+func TestInspectImmutableAndTombstoned(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewKVStore[string, string](
+		WithClock[string, string](clock),
+		WithPutMode[string, string](CreateOnly),
+		WithTombstones[string, string](time.Minute),
+	)
+	st.Put("a", "1")
+	if info, _ := st.Inspect("a"); !info.Immutable {
+		t.Fatalf("the key of a CreateOnly store isn't immutable: %+v", info)
+	}
+
+	st.Delete("a")
+	info, err := st.Inspect("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Tombstoned || info.DeletedAt == nil || !info.DeletedAt.Equal(clock.Now()) {
+		t.Fatalf("got %+v", info)
+	}
+}
+
+func TestDebugEndpoint(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	_, h := newTestServer(st, WithDebug())
+
+	rec := do(t, h, http.MethodGet, "/debug/a", "")
+	expectStatus(t, rec, http.StatusOK)
+	var info KeyInfo[string]
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Value != "1" || info.Version != 1 {
+		t.Fatalf("got %+v", info)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/debug/missing", ""), http.StatusNotFound)
+
+	_, h = newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodGet, "/debug/a", ""), http.StatusNotFound)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultMaxEntries = 1000
+
+// WithMaxEntries sets how many entries GET /entries returns at most, larger stores get 413, the default is 1000.
+func WithMaxEntries(n int) ServerOption {
+	return func(s *Server) {
+		s.maxEntries = n
+	}
+}
+
+// handleEntries serves GET /entries, the whole store as a single JSON object. It's meant for small stores,
+// bigger ones are answered with 413 and should use GET /export instead.
+func (s *Server) handleEntries(c echo.Context) error {
+	filterer, ok := s.Storage.(Filterer[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support listing entries")
+	}
+
+	n := 0
+	entries := filterer.Filter(func(string, string) bool {
+		n++
+		return n <= s.maxEntries
+	})
+	if n > s.maxEntries {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("the store holds more than %d entries, use GET /export to stream them", s.maxEntries))
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// handleExport serves GET /export, every entry as newline delimited JSON in the format POST /import takes.
+func (s *Server) handleExport(c echo.Context) error {
+	filterer, ok := s.Storage.(Filterer[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support listing entries")
+	}
+
+	entries := filterer.Filter(func(string, string) bool { return true })
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(res)
+	for _, key := range keys {
+		value := entries[key]
+		if err := enc.Encode(batchItem{Key: &key, Value: &value}); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEntries(t *testing.T) {
+	st := NewKVStore[string, string]()
+	want := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for key, value := range want {
+		st.Put(key, value)
+	}
+
+	_, h := newTestServer(st, WithMaxEntries(3))
+	rec := do(t, h, http.MethodGet, "/entries", "")
+	expectStatus(t, rec, http.StatusOK)
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	st.Put("d", "4")
+	rec = do(t, h, http.MethodGet, "/entries", "")
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+	if !strings.Contains(rec.Body.String(), "/export") {
+		t.Fatalf("the error doesn't point to the export: %s", rec.Body)
+	}
+}
+
+func TestExport(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("b", "2")
+	st.Put("a", "1")
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodGet, "/export", "")
+	expectStatus(t, rec, http.StatusOK)
+	if want := "{\"key\":\"a\",\"value\":\"1\"}\n{\"key\":\"b\",\"value\":\"2\"}\n"; rec.Body.String() != want {
+		t.Fatalf("got %q, want %q", rec.Body.String(), want)
+	}
+}
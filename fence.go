@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// epochHeader carries the epoch of the writes sent to a server, see Server.fence.
+const epochHeader = "X-Epoch"
+
+// ErrStaleEpoch is returned for the writes fenced with an epoch older than the one of the store, i.e. sent by a
+// primary that has been replaced since.
+var ErrStaleEpoch = errors.New("the epoch is stale")
+
+// Epoch returns the current epoch of the store, 0 until it's first advanced or fenced with a newer one. Like the
+// aliases it's only kept in memory.
+func (s *KVStore[K, V]) Epoch() uint64 {
+	return s.epoch.Load()
+}
+
+// AdvanceEpoch bumps the epoch of the store and returns the new one, the replica being promoted calls it so the
+// writes the old primary may still send are rejected from then on. It waits for the fenced writes in progress.
+func (s *KVStore[K, V]) AdvanceEpoch() uint64 {
+	s.fenceMu.Lock()
+	defer s.fenceMu.Unlock()
+	return s.epoch.Add(1)
+}
+
+// Fenced runs write if epoch isn't older than the epoch of the store, and fails with ErrStaleEpoch otherwise. A
+// newer epoch becomes the epoch of the store, that's how the replicas follow the epoch of their primary.
+// AdvanceEpoch waits for write to return, so no write of the previous epoch lands after it.
+func (s *KVStore[K, V]) Fenced(epoch uint64, write func() error) error {
+	s.fenceMu.RLock()
+	defer s.fenceMu.RUnlock()
+
+	for {
+		current := s.epoch.Load()
+		if epoch < current {
+			return fmt.Errorf("%w: %d is older than %d", ErrStaleEpoch, epoch, current)
+		}
+		if epoch == current || s.epoch.CompareAndSwap(current, epoch) {
+			break
+		}
+	}
+	return write()
+}
+
+// PutAtEpoch is Put fenced with epoch.
+func (s *KVStore[K, V]) PutAtEpoch(epoch uint64, key K, value V) error {
+	return s.Fenced(epoch, func() error {
+		return s.Put(key, value)
+	})
+}
+
+// DeleteAtEpoch is Delete fenced with epoch.
+func (s *KVStore[K, V]) DeleteAtEpoch(epoch uint64, key K) (V, error) {
+	var value V
+	err := s.Fenced(epoch, func() error {
+		var err error
+		value, err = s.Delete(key)
+		return err
+	})
+	return value, err
+}
+
+// Fencer is implemented by stores that reject the writes of a stale epoch.
+type Fencer[K comparable, V any] interface {
+	Epoch() uint64
+	AdvanceEpoch() uint64
+	Fenced(epoch uint64, write func() error) error
+	PutAtEpoch(epoch uint64, key K, value V) error
+	DeleteAtEpoch(epoch uint64, key K) (V, error)
+}
+
+// fence runs write, the store call of a mutating handler, fenced with the epoch of the X-Epoch header of the
+// request: a write from a stale epoch fails with ErrStaleEpoch, answered with 409. Only the store call is
+// fenced, so a promotion only waits for the writes in progress and not for the requests around them, like the
+// long polls. The requests without the header, or to a storage that isn't a Fencer, aren't fenced.
+func (s *Server) fence(c echo.Context, write func() error) error {
+	header := c.Request().Header.Get(epochHeader)
+	fencer, ok := s.Storage.(Fencer[string, string])
+	if header == "" || !ok {
+		return write()
+	}
+	epoch, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid "+epochHeader+": "+header)
+	}
+	return fencer.Fenced(epoch, write)
+}
+
+// handlePromote serves POST /admin/promote, making the server the primary: its replica stops following the old
+// primary, and the epoch is advanced to fence the writes the old primary may still send.
+func (s *Server) handlePromote(c echo.Context) error {
+	fencer, ok := s.Storage.(Fencer[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support epochs")
+	}
+	if s.replica != nil {
+		s.replica.Stop()
+	}
+	return c.JSON(http.StatusOK, map[string]uint64{"epoch": fencer.AdvanceEpoch()})
+}
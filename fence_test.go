@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStaleEpochWritesAfterPromotion(t *testing.T) {
+	st := NewKVStore[string, string]()
+	if err := st.PutAtEpoch(0, "a", "from the old primary"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replica is promoted, the old primary doesn't know yet.
+	if epoch := st.AdvanceEpoch(); epoch != 1 {
+		t.Fatalf("got epoch %d after the promotion, want 1", epoch)
+	}
+	if err := st.PutAtEpoch(0, "a", "stale"); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("got %v, want ErrStaleEpoch", err)
+	}
+	if _, err := st.DeleteAtEpoch(0, "a"); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("Delete: got %v, want ErrStaleEpoch", err)
+	}
+	if v, _ := st.Get("a"); v != "from the old primary" {
+		t.Fatalf("a stale write was applied: %q", v)
+	}
+
+	if err := st.PutAtEpoch(1, "a", "current"); err != nil {
+		t.Fatal(err)
+	}
+	// A newer epoch is followed.
+	if err := st.PutAtEpoch(3, "a", "newer"); err != nil {
+		t.Fatal(err)
+	}
+	if st.Epoch() != 3 {
+		t.Fatalf("got epoch %d, want the newer 3", st.Epoch())
+	}
+	if err := st.PutAtEpoch(1, "a", "stale"); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("got %v for the epoch that was current", err)
+	}
+	if v, _ := st.Get("a"); v != "newer" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestAdvanceEpochWaitsForFencedWrites(t *testing.T) {
+	st := NewKVStore[string, string]()
+	writing, release := make(chan struct{}), make(chan struct{})
+	go st.Fenced(0, func() error {
+		close(writing)
+		<-release
+		return st.Put("a", "1")
+	})
+	<-writing
+
+	advanced := make(chan uint64)
+	go func() { advanced <- st.AdvanceEpoch() }()
+	select {
+	case <-advanced:
+		t.Fatal("the epoch advanced during a write of the previous one")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if epoch := <-advanced; epoch != 1 {
+		t.Fatalf("got epoch %d", epoch)
+	}
+	if v, _ := st.Get("a"); v != "1" {
+		t.Fatalf("the write in progress was lost: %q", v)
+	}
+}
+
+func TestFenceRejectsStaleEpoch(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPost, "/admin/promote", "")
+	expectStatus(t, rec, http.StatusOK)
+	if st.Epoch() != 1 {
+		t.Fatalf("got epoch %d after the promotion, want 1", st.Epoch())
+	}
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "old", epochHeader, "0"), http.StatusConflict)
+	if _, err := st.Get("a"); err == nil {
+		t.Fatal("the write of the stale epoch was applied")
+	}
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", epochHeader, "0"), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "new", epochHeader, "1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/b", "unfenced"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "new", epochHeader, "x"), http.StatusBadRequest)
+
+	// A newer epoch is adopted, the writes of the current one are stale from then on.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "newer", epochHeader, "2"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "new", epochHeader, "1"), http.StatusConflict)
+
+	// The reads aren't fenced.
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", epochHeader, "0"), http.StatusOK)
+	if v, _ := st.Get("a"); v != "newer" {
+		t.Fatalf("got %q", v)
+	}
+
+	// A flush is a write like the others.
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/flush", "", epochHeader, "1"), http.StatusConflict)
+	if keysLeft(st) != 2 {
+		t.Fatalf("the flush of the stale epoch left %d keys, want 2", keysLeft(st))
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/flush", "", epochHeader, "2"), http.StatusOK)
+	if keysLeft(st) != 0 {
+		t.Fatalf("the flush left %d keys", keysLeft(st))
+	}
+}
+
+func TestPromoteDuringFencedLongPoll(t *testing.T) {
+	st := NewKVStore[string, string]()
+	srv, h := newTestServer(st)
+
+	polled := make(chan int)
+	go func() {
+		polled <- do(t, h, http.MethodGet, "/kv/a?wait=5s", "", epochHeader, "0").Code
+	}()
+	waitFor(t, func() bool {
+		srv.waiters.mu.Lock()
+		defer srv.waiters.mu.Unlock()
+		return srv.waiters.total == 1
+	})
+
+	promoted := make(chan int)
+	go func() {
+		// The promotion carries the epoch as well, it isn't fenced with it.
+		promoted <- do(t, h, http.MethodPost, "/admin/promote", "", epochHeader, "0").Code
+	}()
+	select {
+	case code := <-promoted:
+		if code != http.StatusOK {
+			t.Fatalf("the promotion answered %d", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the promotion waited for the long poll")
+	}
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", epochHeader, "1"), http.StatusOK)
+	if code := <-polled; code != http.StatusOK {
+		t.Fatalf("the long poll answered %d", code)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrInvalidField is wrapped by the errors of SetFields for a field the value doesn't have or can't hold.
+var ErrInvalidField = errors.New("invalid field")
+
+// SetFields atomically sets some fields of the struct stored under the key, the others keep their value. The
+// fields are named like in the JSON encoding of the struct, and every value is decoded into the type of its
+// field. V must be a struct or a pointer to one, a pointer is copied rather than modified in place. Nothing is
+// set if one of the fields is unknown or its value doesn't fit, the error then wraps ErrInvalidField.
+func (s *KVStore[K, V]) SetFields(key K, fields map[string]json.RawMessage) (V, error) {
+	return s.UpdateFunc(key, func(old V) (V, error) {
+		return setFields(old, fields)
+	})
+}
+
+func setFields[V any](old V, fields map[string]json.RawMessage) (V, error) {
+	var zero V
+	updated := reflect.New(reflect.TypeOf(&old).Elem()).Elem()
+	updated.Set(reflect.ValueOf(&old).Elem())
+
+	target := updated
+	if target.Kind() == reflect.Pointer {
+		if target.IsNil() {
+			return zero, fmt.Errorf("%w: the value is nil", ErrInvalidField)
+		}
+		copied := reflect.New(target.Type().Elem())
+		copied.Elem().Set(target.Elem())
+		updated.Set(copied)
+		target = copied.Elem()
+	}
+	if target.Kind() != reflect.Struct {
+		return zero, fmt.Errorf("%w: the value is a %s, not a struct", ErrInvalidField, target.Type())
+	}
+
+	for name, raw := range fields {
+		field, ok := fieldByJSONName(target, name)
+		if !ok {
+			return zero, fmt.Errorf("%w: %s has no field %q", ErrInvalidField, target.Type(), name)
+		}
+		value := reflect.New(field.Type())
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return zero, fmt.Errorf("%w: %q: %w", ErrInvalidField, name, err)
+		}
+		field.Set(value.Elem())
+	}
+	return updated.Interface().(V), nil
+}
+
+// fieldByJSONName returns the exported field of the struct named name in its JSON encoding.
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		jsonName := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				jsonName = tagName
+			}
+		}
+		if jsonName == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// FieldSetter is implemented by stores of structs that can set some of their fields atomically.
+type FieldSetter[K comparable, V any] interface {
+	SetFields(K, map[string]json.RawMessage) (V, error)
+}
+
+// handleSetFields serves POST /kv/:key/fields, the body is a JSON object of the fields to set with their new
+// values. It needs a struct store set with WithTypedStore, and answers with the updated value.
+func (s *Server) handleSetFields(c echo.Context) error {
+	if s.setFields == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support setting fields")
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the body must be a JSON object of the fields to set")
+	}
+
+	var value any
+	err = s.fence(c, func() (err error) {
+		value, err = s.setFields(c.Param("key"), fields)
+		return err
+	})
+	if errors.Is(err, ErrInvalidField) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+	return s.mutated(c, map[string]any{"value": value})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type profile struct {
+	Name    string   `json:"name"`
+	Age     int      `json:"age"`
+	Email   string   `json:"email,omitempty"`
+	Tags    []string `json:"tags"`
+	Secret  string   `json:"-"`
+	private int
+}
+
+func TestSetFields(t *testing.T) {
+	st := NewKVStore[string, profile]()
+	st.Put("ada", profile{Name: "Ada", Age: 36, Tags: []string{"math"}, Secret: "s", private: 1})
+
+	updated, err := st.SetFields("ada", map[string]json.RawMessage{
+		"age":   json.RawMessage(`37`),
+		"email": json.RawMessage(`"ada@example.com"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := st.Get("ada")
+	for _, got := range []profile{updated, stored} {
+		if got.Age != 37 || got.Email != "ada@example.com" {
+			t.Fatalf("the fields weren't set: %+v", got)
+		}
+		if got.Name != "Ada" || len(got.Tags) != 1 || got.Secret != "s" || got.private != 1 {
+			t.Fatalf("the other fields changed: %+v", got)
+		}
+	}
+
+	for name, raw := range map[string]string{
+		"nickname": `"A"`,  // unknown
+		"Age":      `38`,   // the Go name rather than the JSON one
+		"Secret":   `"x"`,  // not encoded
+		"private":  `2`,    // not exported
+		"age":      `"38"`, // not an int
+	} {
+		_, err := st.SetFields("ada", map[string]json.RawMessage{"name": json.RawMessage(`"Changed"`), name: json.RawMessage(raw)})
+		if !errors.Is(err, ErrInvalidField) {
+			t.Errorf("%s: got %v, want ErrInvalidField", name, err)
+		}
+	}
+	if v, _ := st.Get("ada"); v.Name != "Ada" || v.Age != 37 {
+		t.Fatalf("a rejected update set some fields: %+v", v)
+	}
+
+	if _, err := st.SetFields("nobody", map[string]json.RawMessage{"age": json.RawMessage(`1`)}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a missing key", err)
+	}
+}
+
+func TestSetFieldsOfPointers(t *testing.T) {
+	original := &profile{Name: "Ada", Age: 36}
+	st := NewKVStore[string, *profile]()
+	st.Put("ada", original)
+
+	if _, err := st.SetFields("ada", map[string]json.RawMessage{"age": json.RawMessage(`37`)}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("ada"); v.Age != 37 || v == original {
+		t.Fatalf("got %+v", v)
+	}
+	if original.Age != 36 {
+		t.Fatal("the pointer was modified in place")
+	}
+
+	ints := NewKVStore[string, int]()
+	ints.Put("n", 1)
+	if _, err := ints.SetFields("n", map[string]json.RawMessage{"a": json.RawMessage(`1`)}); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("got %v for an int value", err)
+	}
+}
+
+func TestSetFieldsOverHTTP(t *testing.T) {
+	users := NewKVStore[string, profile]()
+	users.Put("ada", profile{Name: "Ada", Age: 36, Tags: []string{"math"}})
+	_, h := newTestServer(NewKVStore[string, string](), WithTypedStore[profile](users))
+
+	rec := do(t, h, http.MethodPost, "/kv/ada/fields", `{"age": 37, "tags": ["math", "code"]}`)
+	expectStatus(t, rec, http.StatusOK)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"value":{"name":"Ada","age":37,"tags":["math","code"]}}` {
+		t.Fatalf("got %s", got)
+	}
+
+	rec = do(t, h, http.MethodPost, "/kv/ada/fields", `{"nickname": "A"}`)
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "nickname") {
+		t.Fatalf("the unknown field isn't named: %s", rec.Body)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/kv/ada/fields", `{"age": "old"}`), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/kv/ada/fields", `[1]`), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/kv/nobody/fields", `{"age": 1}`), http.StatusNotFound)
+
+	// Without a struct store there's nothing to set fields in.
+	_, h = newTestServer(NewKVStore[string, string]())
+	expectStatus(t, do(t, h, http.MethodPost, "/kv/ada/fields", `{"age": 1}`), http.StatusNotImplemented)
+}
@@ -2,8 +2,10 @@ module github.com/notlelouch/Go-KeyValue
 
 go 1.21.11
 
+require github.com/labstack/echo/v4 v4.12.0
+
 require (
-	github.com/labstack/echo/v4 v4.12.0 // indirect
+	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -13,4 +15,5 @@ require (
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/time v0.5.0 // indirect
 )
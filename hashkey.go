@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"hash/fnv"
+	"sync"
+)
+
+// HashedKVStore is a Storer for very long keys that doesn't keep the keys themselves, only a fixed-size digest.
+// Entries are indexed by a 64-bit hash of the key, the entries whose keys share that hash are told apart
+// by their SHA-256 digest. The tradeoff is that the original keys can't be enumerated, so none of the
+// features that list keys (scan, snapshots, watches) are available on it.
+type HashedKVStore[V any] struct {
+	mu      sync.RWMutex
+	buckets map[uint64][]hashedEntry[V]
+	// hash picks the bucket of a key, tests swap it to force collisions.
+	hash func(string) uint64
+}
+
+type hashedEntry[V any] struct {
+	digest [sha256.Size]byte
+	value  V
+}
+
+func NewHashedKVStore[V any]() *HashedKVStore[V] {
+	return &HashedKVStore[V]{
+		buckets: make(map[uint64][]hashedEntry[V]),
+		hash:    fnvHash,
+	}
+}
+
+// WithKeyHashing makes the server store a digest of each key instead of the key, to save memory with long keys.
+// GET /scan and the other endpoints that list keys answer 501 in this mode.
+func WithKeyHashing() ServerOption {
+	return func(s *Server) {
+		s.Storage = NewHashedKVStore[string]()
+	}
+}
+
+func fnvHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// find returns the bucket of the key and the position of its entry in it, or -1, must be called with the lock held.
+func (s *HashedKVStore[V]) find(key string) (bucket uint64, digest [sha256.Size]byte, index int) {
+	bucket, digest = s.hash(key), sha256.Sum256([]byte(key))
+	for i, entry := range s.buckets[bucket] {
+		if entry.digest == digest {
+			return bucket, digest, i
+		}
+	}
+	return bucket, digest, -1
+}
+
+func (s *HashedKVStore[V]) Put(key string, value V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, digest, i := s.find(key)
+	if i >= 0 {
+		s.buckets[bucket][i].value = value
+		return nil
+	}
+	s.buckets[bucket] = append(s.buckets[bucket], hashedEntry[V]{digest: digest, value: value})
+
+	return nil
+}
+
+func (s *HashedKVStore[V]) Get(key string) (V, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bucket, _, i := s.find(key)
+	if i < 0 {
+		var zero V
+		return zero, keyNotFound(key)
+	}
+
+	return s.buckets[bucket][i].value, nil
+}
+
+func (s *HashedKVStore[V]) Update(key string, value V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, _, i := s.find(key)
+	if i < 0 {
+		return keyNotFound(key)
+	}
+	s.buckets[bucket][i].value = value
+
+	return nil
+}
+
+func (s *HashedKVStore[V]) Delete(key string) (V, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, _, i := s.find(key)
+	if i < 0 {
+		var zero V
+		return zero, keyNotFound(key)
+	}
+	entries := s.buckets[bucket]
+	value := entries[i].value
+	if len(entries) == 1 {
+		delete(s.buckets, bucket)
+	} else {
+		last := len(entries) - 1
+		entries[i], entries[last] = entries[last], hashedEntry[V]{}
+		s.buckets[bucket] = entries[:last]
+	}
+
+	return value, nil
+}
+
+// Len returns the number of keys.
+func (s *HashedKVStore[V]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, entries := range s.buckets {
+		n += len(entries)
+	}
+	return n
+}
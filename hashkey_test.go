@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHashedStoreCollisions(t *testing.T) {
+	st := NewHashedKVStore[string]()
+	// Every key lands in the same bucket.
+	st.hash = func(string) uint64 { return 42 }
+
+	st.Put("a", "1")
+	st.Put("b", "2")
+	st.Put("c", "3")
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if v, err := st.Get(key); err != nil || v != want {
+			t.Fatalf("%s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+
+	if err := st.Update("b", "22"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Delete("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v deleting a", v, err)
+	}
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a deleted key", err)
+	}
+	if v, _ := st.Get("b"); v != "22" {
+		t.Fatalf("got %q for b", v)
+	}
+	if v, _ := st.Get("c"); v != "3" {
+		t.Fatalf("got %q for c", v)
+	}
+	if err := st.Update("a", "1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v updating a deleted key", err)
+	}
+	if n := st.Len(); n != 2 {
+		t.Fatalf("got %d keys, want 2", n)
+	}
+}
+
+func TestKeyHashingOverHTTP(t *testing.T) {
+	srv := NewServer(":0", WithLogger(NopLogger{}), WithKeyHashing())
+	h := srv.router()
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	if rec := do(t, h, http.MethodGet, "/kv/a", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"1"`) {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/scan", ""), http.StatusNotImplemented)
+}
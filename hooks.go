@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// OperationKind is the Storer method an Operation is for.
+type OperationKind string
+
+const (
+	OperationPut    OperationKind = "put"
+	OperationGet    OperationKind = "get"
+	OperationUpdate OperationKind = "update"
+	OperationDelete OperationKind = "delete"
+)
+
+// Operation is a call to a HookedStore as seen by its hooks, Value is only set for Put and Update. Context is
+// the one the call was made with, context.Background() for the methods not taking one, RequestIDFromContext
+// gives the request it's for.
+type Operation[K comparable, V any] struct {
+	Context context.Context
+	Kind    OperationKind
+	Key     K
+	Value   V
+}
+
+// OperationResult is what the backing store returned for an Operation, Value is the value returned by Get and
+// Delete.
+type OperationResult[V any] struct {
+	Value V
+	Err   error
+}
+
+// HookedStore runs hooks around the calls to a Storer, for validation, auditing or transformations that
+// shouldn't be written into every method. Like the other wrappers it's a Storer itself, so they compose.
+type HookedStore[K comparable, V any] struct {
+	backing Storer[K, V]
+
+	mu     sync.RWMutex
+	before []func(*Operation[K, V]) error
+	after  []func(Operation[K, V], OperationResult[V])
+}
+
+func NewHookedStore[K comparable, V any](backing Storer[K, V]) *HookedStore[K, V] {
+	return &HookedStore[K, V]{backing: backing}
+}
+
+// OnBefore registers a hook called before every operation, in the order they were registered. The hook can
+// change the key or the value of the operation, and an error aborts it: the backing store isn't called, the
+// next hooks aren't either, and the error is returned to the caller and passed to the after hooks.
+func (s *HookedStore[K, V]) OnBefore(hook func(op *Operation[K, V]) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.before = append(s.before, hook)
+}
+
+// OnAfter registers a hook called after every operation, including the ones aborted by a before hook, with
+// what the operation returned.
+func (s *HookedStore[K, V]) OnAfter(hook func(op Operation[K, V], result OperationResult[V])) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.after = append(s.after, hook)
+}
+
+// run calls the backing store through the hooks. The hooks are called without the lock held, so they can
+// register more hooks, which apply from the next operation.
+func (s *HookedStore[K, V]) run(op Operation[K, V], call func(Operation[K, V]) (V, error)) (V, error) {
+	s.mu.RLock()
+	before, after := s.before, s.after
+	s.mu.RUnlock()
+
+	var result OperationResult[V]
+	for _, hook := range before {
+		if result.Err = hook(&op); result.Err != nil {
+			break
+		}
+	}
+	if result.Err == nil {
+		result.Value, result.Err = call(op)
+	}
+	for _, hook := range after {
+		hook(op, result)
+	}
+	return result.Value, result.Err
+}
+
+func (s *HookedStore[K, V]) Put(key K, value V) error {
+	return s.PutContext(context.Background(), key, value)
+}
+
+func (s *HookedStore[K, V]) Get(key K) (V, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+func (s *HookedStore[K, V]) Update(key K, value V) error {
+	return s.UpdateContext(context.Background(), key, value)
+}
+
+func (s *HookedStore[K, V]) Delete(key K) (V, error) {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *HookedStore[K, V]) PutContext(ctx context.Context, key K, value V) error {
+	_, err := s.run(Operation[K, V]{Context: ctx, Kind: OperationPut, Key: key, Value: value}, func(op Operation[K, V]) (V, error) {
+		var zero V
+		return zero, putWithContext(op.Context, s.backing, op.Key, op.Value)
+	})
+	return err
+}
+
+func (s *HookedStore[K, V]) GetContext(ctx context.Context, key K) (V, error) {
+	return s.run(Operation[K, V]{Context: ctx, Kind: OperationGet, Key: key}, func(op Operation[K, V]) (V, error) {
+		return getWithContext(op.Context, s.backing, op.Key)
+	})
+}
+
+func (s *HookedStore[K, V]) UpdateContext(ctx context.Context, key K, value V) error {
+	_, err := s.run(Operation[K, V]{Context: ctx, Kind: OperationUpdate, Key: key, Value: value}, func(op Operation[K, V]) (V, error) {
+		var zero V
+		return zero, updateWithContext(op.Context, s.backing, op.Key, op.Value)
+	})
+	return err
+}
+
+func (s *HookedStore[K, V]) DeleteContext(ctx context.Context, key K) (V, error) {
+	return s.run(Operation[K, V]{Context: ctx, Kind: OperationDelete, Key: key}, func(op Operation[K, V]) (V, error) {
+		return deleteWithContext(op.Context, s.backing, op.Key)
+	})
+}
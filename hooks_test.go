@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBeforeHookVetoesWrites(t *testing.T) {
+	backing := NewKVStore[string, string]()
+	st := NewHookedStore[string, string](backing)
+	errReadOnly := errors.New("read-only key")
+	st.OnBefore(func(op *Operation[string, string]) error {
+		if op.Kind != OperationGet && strings.HasPrefix(op.Key, "config/") {
+			return errReadOnly
+		}
+		return nil
+	})
+	var later int
+	st.OnBefore(func(*Operation[string, string]) error {
+		later++
+		return nil
+	})
+	backing.Put("config/mode", "safe")
+
+	if err := st.Put("config/mode", "unsafe"); !errors.Is(err, errReadOnly) {
+		t.Fatalf("got %v, want the error of the hook", err)
+	}
+	if _, err := st.Delete("config/mode"); !errors.Is(err, errReadOnly) {
+		t.Fatalf("Delete: got %v", err)
+	}
+	if later != 0 {
+		t.Fatal("the hooks after the veto ran")
+	}
+	if v, _ := backing.Get("config/mode"); v != "safe" {
+		t.Fatalf("a vetoed write reached the store: %q", v)
+	}
+
+	// The other operations go through.
+	if v, err := st.Get("config/mode"); err != nil || v != "safe" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if err := st.Put("user/1", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	if later != 2 {
+		t.Fatalf("the second hook ran %d times, want 2", later)
+	}
+}
+
+func TestBeforeHookTransforms(t *testing.T) {
+	backing := NewKVStore[string, string]()
+	st := NewHookedStore[string, string](backing)
+	st.OnBefore(func(op *Operation[string, string]) error {
+		op.Key = strings.ToLower(op.Key)
+		op.Value = strings.TrimSpace(op.Value)
+		return nil
+	})
+
+	st.Put("Ada", "  mathematician ")
+	if v, err := backing.Get("ada"); err != nil || v != "mathematician" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, _ := st.Get("ADA"); v != "mathematician" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestAfterHookObservesResults(t *testing.T) {
+	st := NewHookedStore[string, string](NewKVStore[string, string]())
+	veto := errors.New("no")
+	st.OnBefore(func(op *Operation[string, string]) error {
+		if op.Key == "vetoed" {
+			return veto
+		}
+		return nil
+	})
+	type call struct {
+		kind  OperationKind
+		key   string
+		value string
+		err   error
+	}
+	var calls []call
+	st.OnAfter(func(op Operation[string, string], result OperationResult[string]) {
+		calls = append(calls, call{op.Kind, op.Key, result.Value, result.Err})
+	})
+
+	st.Put("a", "1")
+	st.Get("a")
+	st.Update("missing", "1")
+	st.Delete("a")
+	st.Put("vetoed", "1")
+
+	if len(calls) != 5 {
+		t.Fatalf("the after hook ran %d times: %+v", len(calls), calls)
+	}
+	if got := calls[:2]; !reflect.DeepEqual(got, []call{{OperationPut, "a", "", nil}, {OperationGet, "a", "1", nil}}) {
+		t.Fatalf("got %+v", got)
+	}
+	if c := calls[2]; c.kind != OperationUpdate || !errors.Is(c.err, ErrKeyNotFound) {
+		t.Fatalf("the failed update: %+v", c)
+	}
+	if c := calls[3]; c.kind != OperationDelete || c.value != "1" || c.err != nil {
+		t.Fatalf("the delete: %+v", c)
+	}
+	if c := calls[4]; c.key != "vetoed" || !errors.Is(c.err, veto) {
+		t.Fatalf("the vetoed put: %+v", c)
+	}
+}
+
+func TestHooksOverHTTP(t *testing.T) {
+	st := NewHookedStore[string, string](NewKVStore[string, string]())
+	st.OnBefore(func(op *Operation[string, string]) error {
+		switch {
+		case op.Kind == OperationPut && op.Value == "":
+			return errors.New("empty values aren't allowed")
+		case op.Key == "hidden":
+			return keyNotFound(op.Key)
+		}
+		return nil
+	})
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	// An error of a hook that isn't one of the store's is a server error like any other.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", ""), http.StatusInternalServerError)
+	if v, _ := st.Get("a"); v != "1" {
+		t.Fatalf("got %q", v)
+	}
+	// The errors of the store keep their status.
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/hidden", ""), http.StatusNotFound)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	headerIdempotencyKey  = "Idempotency-Key"
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// idempotentResponse is what the idempotency cache keeps for a key, pending is set while the first request is running.
+// bodySum is the SHA-256 of the body of the first request.
+type idempotentResponse struct {
+	pending     bool
+	bodySum     [sha256.Size]byte
+	status      int
+	contentType string
+	body        []byte
+}
+
+// WithIdempotencyTTL sets how long the response of a request carrying an Idempotency-Key is replayed, the default is 24h.
+func WithIdempotencyTTL(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idempotencyTTL = d
+	}
+}
+
+// responseRecorder keeps a copy of what the handler writes, so it can be replayed.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent makes a mutation safe to retry: the first request with a given Idempotency-Key header is applied
+// and its response cached, the retries get the cached response back without applying the operation again.
+// The cache lives in its own KVStore, keyed by the idempotency key, method and path. Requests without the header
+// and responses with a 5xx status (so the client can retry) aren't cached, and neither are the handlers that
+// panic. Reusing a key with a different body is a client bug, it's rejected with 422.
+func (s *Server) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		idempotencyKey := c.Request().Header.Get(headerIdempotencyKey)
+		if idempotencyKey == "" {
+			return next(c)
+		}
+		cacheKey := idempotencyKey + " " + c.Request().Method + " " + c.Request().URL.Path
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		bodySum := sha256.Sum256(body)
+
+		first, err := s.idempotency.PutIfAbsentWithTTL(cacheKey, idempotentResponse{pending: true, bodySum: bodySum}, s.idempotencyTTL)
+		if err != nil {
+			return toHTTPError(err)
+		}
+		if !first {
+			cached, err := s.idempotency.Get(cacheKey)
+			if err != nil {
+				return toHTTPError(err)
+			}
+			if cached.bodySum != bodySum {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "the Idempotency-Key was already used with a different body")
+			}
+			if cached.pending {
+				return echo.NewHTTPError(http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			}
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.Blob(cached.status, cached.contentType, cached.body)
+		}
+
+		res := c.Response()
+		rec := &responseRecorder{ResponseWriter: res.Writer}
+		res.Writer = rec
+		done := false
+		defer func() {
+			res.Writer = rec.ResponseWriter
+			if !done {
+				// The handler panicked, the key is released so the request can be retried.
+				s.idempotency.Delete(cacheKey)
+			}
+		}()
+
+		// Errors are rendered here rather than by echo after the middleware returns, so they're recorded too.
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		done = true
+
+		if res.Status >= http.StatusInternalServerError {
+			s.idempotency.Delete(cacheKey)
+			return nil
+		}
+		s.idempotency.PutWithTTL(cacheKey, idempotentResponse{
+			bodySum:     bodySum,
+			status:      res.Status,
+			contentType: res.Header().Get(echo.HeaderContentType),
+			body:        rec.body.Bytes(),
+		}, s.idempotencyTTL)
+
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIdempotentRetryAppliedOnce(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	first := do(t, h, http.MethodPost, "/incr/hits", "", headerIdempotencyKey, "k1")
+	expectStatus(t, first, http.StatusOK)
+	retry := do(t, h, http.MethodPost, "/incr/hits", "", headerIdempotencyKey, "k1")
+	expectStatus(t, retry, http.StatusOK)
+
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("the retry got %s, the first request %s", retry.Body, first.Body)
+	}
+	if v, _ := st.Get("hits"); v != "1" {
+		t.Fatalf("the increment was applied to %s", v)
+	}
+
+	// Another key, or no key, applies the operation again.
+	do(t, h, http.MethodPost, "/incr/hits", "", headerIdempotencyKey, "k2")
+	do(t, h, http.MethodPost, "/incr/hits", "")
+	if v, _ := st.Get("hits"); v != "3" {
+		t.Fatalf("got %s", v)
+	}
+}
+
+func TestIdempotencyKeyReusedWithAnotherBody(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string]())
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", headerIdempotencyKey, "k"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "2", headerIdempotencyKey, "k"), http.StatusUnprocessableEntity)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", headerIdempotencyKey, "k"), http.StatusOK)
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	hooked := NewHookedStore[string, string](NewKVStore[string, string]())
+	panics := true
+	hooked.OnBefore(func(*Operation[string, string]) error {
+		if panics {
+			panics = false
+			panic("boom")
+		}
+		return nil
+	})
+	_, h := newTestServer(hooked)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the handler didn't panic")
+			}
+		}()
+		do(t, h, http.MethodPut, "/kv/a", "1", headerIdempotencyKey, "k")
+	}()
+
+	// The retry isn't told the first request is still in progress.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", headerIdempotencyKey, "k"), http.StatusOK)
+	if v, _ := hooked.Get("a"); v != "1" {
+		t.Fatalf("got %q", v)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultMaxBatchItems = 10000
+	// batchChunkSize is how many items of a batch are applied under a single lock, the other requests get
+	// a chance to run between chunks.
+	batchChunkSize = 1000
+)
+
+// WithMaxBatchItems sets how many items a single batch, import or transaction may hold, bigger ones are rejected
+// with 400. The default is 10000.
+func WithMaxBatchItems(n int) ServerOption {
+	return func(s *Server) {
+		s.maxBatchItems = n
+	}
+}
+
+// checkBatchSize rejects the batches over the limit.
+func (s *Server) checkBatchSize(n int) error {
+	if n > s.maxBatchItems {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("at most %d items can be sent at once, got %d", s.maxBatchItems, n))
+	}
+	return nil
+}
+
+// ItemError reports why one item of a batch or import was rejected.
+// Index is the position of the item in the batch array, or the line number (from 1) for an import.
+type ItemError struct {
+	Index int    `json:"index"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// BatchResult summarizes a batch or import: the valid items are applied, every invalid one is reported.
+type BatchResult struct {
+	Applied int         `json:"applied"`
+	Failed  int         `json:"failed"`
+	Errors  []ItemError `json:"errors,omitempty"`
+}
+
+type batchItem struct {
+	Key   *string `json:"key"`
+	Value *string `json:"value"`
+}
+
+// putItem validates and stores one item, and records in the result whether it was applied or why it failed.
+func (s *Server) putItem(c echo.Context, result *BatchResult, index int, item batchItem) {
+	var key string
+	if item.Key != nil {
+		key = *item.Key
+	}
+
+	err := func() error {
+		switch {
+		case key == "":
+			return errors.New("missing key")
+		case item.Value == nil:
+			return errors.New("missing value")
+		}
+		if err := s.checkValue(*item.Value); err != nil {
+			return err
+		}
+		return s.fence(c, func() error {
+			return putWithContext(c.Request().Context(), s.Storage, key, *item.Value)
+		})
+	}()
+
+	if err != nil {
+		result.Failed++
+		result.Errors = append(result.Errors, ItemError{Index: index, Key: key, Error: err.Error()})
+		if key != "" {
+			s.journalOp(c, "put", key, "", http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	result.Applied++
+	s.journalOp(c, "put", key, *item.Value, http.StatusOK)
+}
+
+func batchResponse(c echo.Context, result BatchResult) error {
+	if result.Failed > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, result)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// handleBatchPut serves POST /batch/put, taking a JSON array of {"key": ..., "value": ...} objects.
+// The valid items are stored even if others fail, the response lists every failure with its index
+// and answers 422 if there was at least one. Every item is stored with its own Put, so a big batch
+// doesn't hold the lock of the store for long.
+func (s *Server) handleBatchPut(c echo.Context) error {
+	var items []json.RawMessage
+	if err := json.NewDecoder(c.Request().Body).Decode(&items); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the body must be a JSON array: "+err.Error())
+	}
+	if err := s.checkBatchSize(len(items)); err != nil {
+		return err
+	}
+
+	var result BatchResult
+	for i, raw := range items {
+		var item batchItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ItemError{Index: i, Error: err.Error()})
+			continue
+		}
+		s.putItem(c, &result, i, item)
+	}
+
+	return batchResponse(c, result)
+}
+
+// handleImport serves POST /import, taking newline delimited JSON with one {"key": ..., "value": ...} object per line.
+// Like the batch endpoint it applies the valid lines and reports the invalid ones by line number. An import over
+// the batch limit is rejected with 400 before any line is applied, so it can be retried once split.
+func (s *Server) handleImport(c echo.Context) error {
+	type importLine struct {
+		number int
+		text   string
+	}
+	var lines []importLine
+
+	r := bufio.NewReader(c.Request().Body)
+	for number := 1; ; number++ {
+		text, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return toHTTPError(err)
+		}
+
+		if text = strings.TrimSpace(text); text != "" {
+			if len(lines) == s.maxBatchItems {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(
+					"at most %d items can be imported at once, line %d is over the limit", s.maxBatchItems, number))
+			}
+			lines = append(lines, importLine{number, text})
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	var result BatchResult
+	for _, line := range lines {
+		var item batchItem
+		if err := json.Unmarshal([]byte(line.text), &item); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, ItemError{Index: line.number, Error: err.Error()})
+			continue
+		}
+		s.putItem(c, &result, line.number, item)
+	}
+
+	return batchResponse(c, result)
+}
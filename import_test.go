@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batchResult decodes the BatchResult answered to a batch or an import.
+func batchResult(t *testing.T, body string) BatchResult {
+	t.Helper()
+	var result BatchResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestImportReportsEveryError(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st, WithMaxValueSize(5))
+
+	body := strings.Join([]string{
+		`{"key": "a", "value": "1"}`,
+		`not json`,
+		``,
+		`{"value": "no key"}`,
+		`{"key": "big", "value": "too large"}`,
+		`{"key": "b", "value": "2"}`,
+		`{"key": "c"}`,
+	}, "\n")
+	rec := do(t, h, http.MethodPost, "/import", body)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+
+	result := batchResult(t, rec.Body.String())
+	if result.Applied != 2 || result.Failed != 4 {
+		t.Fatalf("got %+v", result)
+	}
+	lines := []int{2, 4, 5, 7}
+	for i, e := range result.Errors {
+		if e.Index != lines[i] {
+			t.Errorf("error %d is for line %d, want %d: %+v", i, e.Index, lines[i], e)
+		}
+	}
+	if result.Errors[2].Key != "big" {
+		t.Errorf("the oversized value isn't reported with its key: %+v", result.Errors[2])
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := st.Get(key); err != nil {
+			t.Errorf("the valid line for %s wasn't applied: %v", key, err)
+		}
+	}
+}
+
+func TestBatchPutReportsEveryError(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPost, "/batch/put", `[{"key": "a", "value": "1"}, 42, {"key": "b"}, {"key": "c", "value": "3"}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	result := batchResult(t, rec.Body.String())
+	if result.Applied != 2 || result.Failed != 2 || result.Errors[0].Index != 1 || result.Errors[1].Index != 2 {
+		t.Fatalf("got %+v", result)
+	}
+
+	rec = do(t, h, http.MethodPost, "/batch/put", `[{"key": "d", "value": "4"}]`)
+	expectStatus(t, rec, http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPost, "/batch/put", `{}`), http.StatusBadRequest)
+}
+
+func TestBatchLimit(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st, WithMaxBatchItems(3))
+
+	items := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, `{"key": "key%d", "value": "1"}`, i)
+		}
+		return b.String()
+	}
+
+	rec := do(t, h, http.MethodPost, "/batch/put", "["+items(4)+"]")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "at most 3 items") {
+		t.Fatalf("the message doesn't tell the limit: %s", rec.Body)
+	}
+	if _, err := st.Get("key0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a rejected batch was applied: %v", err)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/batch/put", "["+items(3)+"]"), http.StatusOK)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/txn", `{"ops": [`+strings.ReplaceAll(items(4), `"key"`, `"op": "put", "key"`)+`]}`), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/batch/expire", `{"key0": "1m", "key1": "1m", "key2": "1m", "key3": "1m"}`), http.StatusBadRequest)
+
+	// An import over the limit is rejected as a whole too, blank lines aside.
+	lines := func(n int) string {
+		return strings.ReplaceAll(strings.ReplaceAll(items(n), `"key": "key`, `"key": "line`), "},{", "}\n\n{")
+	}
+	rec = do(t, h, http.MethodPost, "/import", lines(5))
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), "line 7 is over the limit") {
+		t.Fatalf("got %s", rec.Body)
+	}
+	if _, err := st.Get("line0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a rejected import was applied: %v", err)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/import", lines(3)+"\n"), http.StatusOK)
+	if v, _ := st.Get("line2"); v != "1" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestBigBatchDoesntStarveReads(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("hot", "1")
+	_, h := newTestServer(st)
+
+	var body strings.Builder
+	body.WriteString("[")
+	for i := 0; i < defaultMaxBatchItems; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"key": "key%d", "value": "%d"}`, i, i)
+	}
+	body.WriteString("]")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do(t, h, http.MethodPost, "/batch/put", body.String()) }()
+
+	var slowest time.Duration
+	reads := 0
+	for finished := false; !finished; {
+		select {
+		case rec := <-done:
+			expectStatus(t, rec, http.StatusOK)
+			if result := batchResult(t, rec.Body.String()); result.Applied != defaultMaxBatchItems {
+				t.Fatalf("got %+v", result)
+			}
+			finished = true
+		default:
+			start := time.Now()
+			if _, err := st.Get("hot"); err != nil {
+				t.Fatal(err)
+			}
+			slowest = max(slowest, time.Since(start))
+			reads++
+		}
+	}
+	if slowest > 100*time.Millisecond {
+		t.Fatalf("a read waited %s for the batch, over %d reads", slowest, reads)
+	}
+	if _, err := st.Get(fmt.Sprintf("key%d", defaultMaxBatchItems-1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrNotInteger is returned by IncrementBounded when the current value of the key isn't an integer.
+var ErrNotInteger = errors.New("the value is not an integer")
+
+// ErrIntegerOverflow is returned by IncrementBounded for a negative delta that would take the value below the
+// smallest int64. There's no lower bound to report it against, unlike a sum going over max.
+var ErrIntegerOverflow = errors.New("the increment overflows an int64")
+
+// errOverBound aborts the upsert of an increment that would go over its bound.
+var errOverBound = errors.New("over the bound")
+
+// IncrementBounded atomically adds delta to the integer value of the key, but only if the result stays ≤ max.
+// It returns the new value and true if the increment was applied, or the current value and false if it wasn't.
+// A missing key counts as 0. The values can be integers or strings holding a decimal integer, anything else
+// fails with ErrNotInteger. A decrement wrapping around below the smallest int64 fails with ErrIntegerOverflow.
+func (s *KVStore[K, V]) IncrementBounded(key K, delta, max int64) (int64, bool, error) {
+	key = s.canon(key)
+	var current int64
+	_, err := s.upsert(key, func(old V, exists bool) (V, error) {
+		if exists {
+			n, err := toInt64(old)
+			if err != nil {
+				return old, err
+			}
+			current = n
+		}
+		next := current + delta
+		// A sum wrapping around past the largest int64 is over any bound, one wrapping around past the smallest
+		// isn't an increment at all.
+		if delta < 0 && next > current {
+			return old, fmt.Errorf("%w: %d%+d", ErrIntegerOverflow, current, delta)
+		}
+		if next > max || (delta > 0 && next < current) {
+			return old, errOverBound
+		}
+		current = next
+		return fromInt64[V](next)
+	})
+	if errors.Is(err, errOverBound) {
+		return current, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return current, true, nil
+}
+
+func toInt64[V any](value V) (int64, error) {
+	switch v := any(value).(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrNotInteger, v)
+		}
+		return n, nil
+	}
+	return 0, ErrNotInteger
+}
+
+func fromInt64[V any](n int64) (V, error) {
+	var value V
+	switch p := any(&value).(type) {
+	case *int:
+		*p = int(n)
+	case *int64:
+		*p = n
+	case *int32:
+		if n > math.MaxInt32 || n < math.MinInt32 {
+			return value, fmt.Errorf("%w: %d overflows an int32", ErrNotInteger, n)
+		}
+		*p = int32(n)
+	case *string:
+		*p = strconv.FormatInt(n, 10)
+	default:
+		return value, ErrNotInteger
+	}
+	return value, nil
+}
+
+// BoundedIncrementer is implemented by stores that can increment a value atomically up to a bound.
+type BoundedIncrementer[K comparable] interface {
+	IncrementBounded(key K, delta, max int64) (int64, bool, error)
+}
+
+// handleIncr serves POST /incr/:key?by=&max=, by defaults to 1 and there's no bound without max.
+// An increment that would go over max is rejected with 429 and the current value, for quotas.
+func (s *Server) handleIncr(c echo.Context) error {
+	incrementer, ok := s.Storage.(BoundedIncrementer[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support increments")
+	}
+
+	delta, max := int64(1), int64(math.MaxInt64)
+	var err error
+	if param := c.QueryParam("by"); param != "" {
+		if delta, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid by: "+param)
+		}
+	}
+	if param := c.QueryParam("max"); param != "" {
+		if max, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid max: "+param)
+		}
+	}
+
+	var value int64
+	var applied bool
+	err = s.fence(c, func() (err error) {
+		value, applied, err = incrementer.IncrementBounded(c.Param("key"), delta, max)
+		return err
+	})
+	if errors.Is(err, ErrNotInteger) || errors.Is(err, ErrIntegerOverflow) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if !applied {
+		return c.JSON(http.StatusTooManyRequests, map[string]any{
+			"message": fmt.Sprintf("the increment would go over %d", max),
+			"value":   value,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]int64{"value": value})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIncrementBounded(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for want := int64(2); want <= 10; want += 2 {
+		if n, ok, err := st.IncrementBounded("quota", 2, 10); err != nil || !ok || n != want {
+			t.Fatalf("got %d, %v, %v, want %d", n, ok, err, want)
+		}
+	}
+	if n, ok, err := st.IncrementBounded("quota", 1, 10); err != nil || ok || n != 10 {
+		t.Fatalf("got %d, %v, %v over the bound", n, ok, err)
+	}
+	if v, _ := st.Get("quota"); v != "10" {
+		t.Fatalf("the rejected increment changed the value to %q", v)
+	}
+
+	st.Put("text", "abc")
+	if _, _, err := st.IncrementBounded("text", 1, 10); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("got %v for a value that isn't an integer", err)
+	}
+
+	ints := NewKVStore[string, int64]()
+	ints.Put("n", math.MaxInt64-1)
+	if _, ok, _ := ints.IncrementBounded("n", 2, math.MaxInt64); ok {
+		t.Fatal("an increment overflowing int64 was applied")
+	}
+}
+
+func TestDecrementUnderflow(t *testing.T) {
+	ints := NewKVStore[string, int64]()
+	ints.Put("n", math.MinInt64)
+	if _, ok, err := ints.IncrementBounded("n", -1, math.MaxInt64); ok || !errors.Is(err, ErrIntegerOverflow) {
+		t.Fatalf("got %v, %v, want ErrIntegerOverflow", ok, err)
+	}
+	if v, _ := ints.Get("n"); v != math.MinInt64 {
+		t.Fatalf("the wrapped decrement changed the value to %d", v)
+	}
+	ints.Put("m", math.MinInt64+1)
+	if n, ok, err := ints.IncrementBounded("m", -1, math.MaxInt64); err != nil || !ok || n != math.MinInt64 {
+		t.Fatalf("got %d, %v, %v down to the smallest int64", n, ok, err)
+	}
+
+	st := NewKVStore[string, string]()
+	st.Put("k", "-9223372036854775808")
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodPost, "/incr/k?by=-1", ""), http.StatusConflict)
+	if v, _ := st.Get("k"); v != "-9223372036854775808" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestIncrOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/incr/quota?by=3&max=5", ""), http.StatusOK)
+	rec := do(t, h, http.MethodPost, "/incr/quota?by=3&max=5", "")
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if !strings.Contains(rec.Body.String(), `"value":3`) {
+		t.Fatalf("the rejection doesn't carry the current value: %s", rec.Body)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/incr/quota?by=x", ""), http.StatusBadRequest)
+	st.Put("text", "abc")
+	expectStatus(t, do(t, h, http.MethodPost, "/incr/text", ""), http.StatusConflict)
+}
@@ -0,0 +1,50 @@
+package main
+
+// internEntry is the single copy of a value shared by every key holding it.
+type internEntry struct {
+	value string
+	refs  int
+}
+
+// NewKVStoreWithInterning creates a store keeping a single copy of each distinct value, for workloads where many
+// keys share a few values like a status. A value is freed once no key holds it anymore.
+func NewKVStoreWithInterning[K comparable](opts ...Option[K, string]) *KVStore[K, string] {
+	s := NewKVStore[K, string](opts...)
+	s.interns = make(map[string]*internEntry)
+	return s
+}
+
+// InternedValues returns how many distinct values an interning store holds, 0 for the other stores.
+func (s *KVStore[K, V]) InternedValues() int {
+	s.rlock()
+	defer s.mu.RUnlock()
+	return len(s.interns)
+}
+
+// intern returns the shared copy of the value and takes a reference on it, must be called with the write lock held.
+func (s *KVStore[K, V]) intern(value V) V {
+	str, ok := any(value).(string)
+	if s.interns == nil || !ok {
+		return value
+	}
+	entry, ok := s.interns[str]
+	if !ok {
+		entry = &internEntry{value: str}
+		s.interns[str] = entry
+	}
+	entry.refs++
+	return any(entry.value).(V)
+}
+
+// release drops a reference on the shared copy of the value, must be called with the write lock held.
+func (s *KVStore[K, V]) release(value V) {
+	str, ok := any(value).(string)
+	if s.interns == nil || !ok {
+		return
+	}
+	if entry, ok := s.interns[str]; ok {
+		if entry.refs--; entry.refs == 0 {
+			delete(s.interns, str)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInterning(t *testing.T) {
+	st := NewKVStoreWithInterning[string]()
+	statuses := []string{"active", "idle", "banned"}
+	for i := 0; i < 1000; i++ {
+		st.Put(fmt.Sprint(i), statuses[i%len(statuses)])
+	}
+	if n := st.InternedValues(); n != len(statuses) {
+		t.Fatalf("the intern table holds %d values, want %d", n, len(statuses))
+	}
+	if v, _ := st.Get("4"); v != "idle" {
+		t.Fatalf("got %q", v)
+	}
+
+	// "banned" is freed once the last key holding it changes or goes.
+	for i := 2; i < 1000; i += 3 {
+		if i%2 == 0 {
+			st.Put(fmt.Sprint(i), "active")
+		} else {
+			st.Delete(fmt.Sprint(i))
+		}
+	}
+	if n := st.InternedValues(); n != 2 {
+		t.Fatalf("the intern table holds %d values after dropping one, want 2", n)
+	}
+
+	if n := NewKVStore[string, string]().InternedValues(); n != 0 {
+		t.Fatalf("a plain store reports %d interned values", n)
+	}
+}
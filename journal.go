@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JournalEntry records one request handled by the server. Keys are the path parameters naming keys (or locks),
+// Value is only filled in by a journal created with WithJournalValues.
+//
+// The requests writing several keys, /batch/put, /import and /txn, also record one entry per key they wrote,
+// with the op applied to it, e.g. put, and the same RequestID as the request.
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Op        string    `json:"op"`
+	Keys      []string  `json:"keys,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Status    int       `json:"status"`
+	Success   bool      `json:"success"`
+}
+
+// ring is a ring buffer of the last entries added to it, it's safe for concurrent use.
+type ring[T any] struct {
+	mu      sync.Mutex
+	entries []T
+	next    int
+	full    bool
+}
+
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{entries: make([]T, size)}
+}
+
+func (r *ring[T]) add(entry T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// last returns up to limit of the most recent entries, oldest first.
+func (r *ring[T]) last(limit int) []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	entries := make([]T, limit)
+	for i := range entries {
+		entries[i] = r.entries[(r.next-limit+i+len(r.entries))%len(r.entries)]
+	}
+	return entries
+}
+
+// journal is a ring buffer of the last operations, for debugging "what just happened to this key".
+type journal struct {
+	*ring[JournalEntry]
+	values bool
+}
+
+// WithJournal keeps the last size operations handled by the server in memory, they're served by GET /journal.
+// A size under 1 keeps only the last one. Values are left out unless WithJournalValues is also given.
+func WithJournal(size int) ServerOption {
+	return func(s *Server) {
+		s.journal = &journal{ring: newRing[JournalEntry](max(size, 1)), values: s.journalValues}
+	}
+}
+
+// WithJournalValues makes the journal record the values of the writes too, it's off by default for privacy. It
+// can be given before or after WithJournal.
+func WithJournalValues() ServerOption {
+	return func(s *Server) {
+		s.journalValues = true
+		if s.journal != nil {
+			s.journal.values = true
+		}
+	}
+}
+
+// journaled is the middleware recording every request in the journal.
+func (s *Server) journaled(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Reading the journal shouldn't fill it.
+		if path := c.Path(); path == "/journal" || path == "/admin/journal" {
+			return next(c)
+		}
+
+		err := next(c)
+
+		status := responseStatus(c, err)
+		entry := JournalEntry{
+			Time:      time.Now(),
+			RequestID: requestID(c),
+			Method:    c.Request().Method,
+			Op:        routeOp(c),
+			Keys:      routeKeys(c),
+			Status:    status,
+			Success:   status < http.StatusBadRequest,
+		}
+		if s.journal.values {
+			entry.Value = c.Param("value")
+		}
+		s.journal.add(entry)
+
+		return err
+	}
+}
+
+// journalOp records one of the writes of a request writing several keys, status is what the write would have
+// been answered with on its own.
+func (s *Server) journalOp(c echo.Context, op, key, value string, status int) {
+	if s.journal == nil {
+		return
+	}
+	entry := JournalEntry{
+		Time:      time.Now(),
+		RequestID: requestID(c),
+		Method:    c.Request().Method,
+		Op:        op,
+		Keys:      []string{key},
+		Status:    status,
+		Success:   status < http.StatusBadRequest,
+	}
+	if s.journal.values {
+		entry.Value = value
+	}
+	s.journal.add(entry)
+}
+
+// responseStatus returns the status the request is answered with, once the error of its handler is rendered.
+func responseStatus(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// routeOps are the operations of the routes whose first segment isn't the operation, by method and route. The
+// /b64 routes are the same as the plain ones.
+var routeOps = map[string]string{
+	"GET /kv/:key":               "get",
+	"PUT /kv/:key":               "put",
+	"PATCH /kv/:key":             "patch",
+	"DELETE /kv/:key":            "delete",
+	"POST /kv/:key/fields":       "setfields",
+	"POST /alias/:alias/:target": "alias",
+	"DELETE /alias/:alias":       "unalias",
+	"POST /batch/put":            "put",
+	"POST /batch/expire":         "expire",
+	"GET /watch/prefix/:prefix":  "watch",
+}
+
+// routeOp returns the operation of a request from its method and route, e.g. put for PUT /kv/:key. The routes
+// named after their operation, like /get/:key or /admin/flush, are the first segment after /admin.
+func routeOp(c echo.Context) string {
+	path := strings.TrimPrefix(c.Path(), "/b64")
+	if op, ok := routeOps[c.Request().Method+" "+path]; ok {
+		return op
+	}
+	path = strings.TrimPrefix(path, "/admin")
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// routeKeys returns the path parameters of a request naming keys (or locks), i.e. all of them but the value.
+func routeKeys(c echo.Context) []string {
+	var keys []string
+	for _, name := range c.ParamNames() {
+		if name != "value" {
+			keys = append(keys, c.Param(name))
+		}
+	}
+	return keys
+}
+
+// parseLimit parses the optional ?limit= of the endpoints listing recent entries, 0 means all of them.
+func parseLimit(c echo.Context) (int, error) {
+	param := c.QueryParam("limit")
+	if param == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid limit: "+param)
+	}
+	return limit, nil
+}
+
+// handleJournal serves GET /journal?limit=, the most recent operations oldest first. With ?request_id= only the
+// operations of that request are returned, the limit applies to them.
+func (s *Server) handleJournal(c echo.Context) error {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.QueryParam("request_id")
+	if id == "" {
+		return c.JSON(http.StatusOK, s.journal.last(limit))
+	}
+	entries := []JournalEntry{}
+	for _, entry := range s.journal.last(0) {
+		if entry.RequestID == id {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func journalOf(t *testing.T, h http.Handler, target string) []JournalEntry {
+	t.Helper()
+	rec := do(t, h, http.MethodGet, target, "")
+	expectStatus(t, rec, http.StatusOK)
+	var entries []JournalEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestJournal(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithJournal(100))
+
+	do(t, h, http.MethodPut, "/kv/a", "secret")
+	do(t, h, http.MethodGet, "/kv/a", "")
+	do(t, h, http.MethodGet, "/kv/missing", "")
+	do(t, h, http.MethodDelete, "/kv/a", "")
+
+	type op struct {
+		Method, Op string
+		Keys       []string
+		Status     int
+		Success    bool
+	}
+	var got []op
+	entries := journalOf(t, h, "/journal")
+	for i, e := range entries {
+		got = append(got, op{e.Method, e.Op, e.Keys, e.Status, e.Success})
+		if e.Value != "" {
+			t.Errorf("entry %d holds the value %q, values are redacted by default", i, e.Value)
+		}
+		if e.Time.IsZero() || i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Errorf("entry %d is out of order: %v", i, e.Time)
+		}
+	}
+	want := []op{
+		{http.MethodPut, "put", []string{"a"}, http.StatusOK, true},
+		{http.MethodGet, "get", []string{"a"}, http.StatusOK, true},
+		{http.MethodGet, "get", []string{"missing"}, http.StatusNotFound, false},
+		{http.MethodDelete, "delete", []string{"a"}, http.StatusOK, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if entries := journalOf(t, h, "/journal?limit=1"); len(entries) != 1 || entries[0].Op != "delete" {
+		t.Fatalf("got %+v with a limit of 1", entries)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/journal?limit=-1", ""), http.StatusBadRequest)
+}
+
+func TestJournalKeepsTheLastEntries(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithJournal(2), WithLegacyGetRoutes(), WithJournalValues())
+	do(t, h, http.MethodGet, "/put/a/1", "")
+	do(t, h, http.MethodGet, "/put/b/2", "")
+	do(t, h, http.MethodGet, "/put/c/3", "")
+
+	entries := journalOf(t, h, "/journal")
+	if len(entries) != 2 || entries[0].Keys[0] != "b" || entries[1].Keys[0] != "c" || entries[1].Value != "3" {
+		t.Fatalf("got %+v, want the last two puts with their values", entries)
+	}
+}
+
+func TestJournalSizeUnderOne(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		_, h := newTestServer(NewKVStore[string, string](), WithJournal(size))
+		expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+		expectStatus(t, do(t, h, http.MethodPut, "/kv/b", "2"), http.StatusOK)
+		if entries := journalOf(t, h, "/journal"); len(entries) != 1 || entries[0].Keys[0] != "b" {
+			t.Fatalf("size %d: got %+v, want the last put", size, entries)
+		}
+	}
+}
+
+func TestJournalValuesBeforeJournal(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithJournalValues(), WithJournal(10), WithLegacyGetRoutes())
+	do(t, h, http.MethodGet, "/put/a/1", "")
+	if entries := journalOf(t, h, "/journal"); len(entries) != 1 || entries[0].Value != "1" {
+		t.Fatalf("got %+v, want the put with its value", entries)
+	}
+}
+
+func TestJournalBatchWrites(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithJournal(100))
+	do(t, h, http.MethodPost, "/batch/put", `[{"key": "a", "value": "1"}, {"key": "b", "value": "2"}]`,
+		echo.HeaderXRequestID, "req-1")
+	do(t, h, http.MethodGet, "/kv/a", "")
+
+	entries := journalOf(t, h, "/journal?request_id=req-1")
+	var keys []string
+	for _, e := range entries {
+		if e.RequestID != "req-1" {
+			t.Fatalf("an entry of another request: %+v", e)
+		}
+		keys = append(keys, e.Keys...)
+	}
+	if len(entries) != 3 || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("got %+v, want the two writes and the request", entries)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultMaxRateLimitedKeys = 10000
+
+// WithKeyRateLimit limits the requests on any single key to rate per second, with bursts of up to burst requests.
+// A key over its limit gets 429 while the other keys go on. The limit applies to the routes taking the keys in
+// their path, the batch endpoints aren't limited. The buckets of the maxKeys most recently used keys are kept,
+// the older ones are dropped and start full again. maxKeys defaults to 10000.
+func WithKeyRateLimit(rate float64, burst, maxKeys int) ServerOption {
+	return func(s *Server) {
+		s.keyRate, s.keyBurst, s.maxRateLimitedKeys = rate, burst, maxKeys
+	}
+}
+
+// WithKeyRateLimitClock sets the clock the buckets of WithKeyRateLimit refill with.
+func WithKeyRateLimitClock(clock Clock) ServerOption {
+	return func(s *Server) {
+		s.keyClock = clock
+	}
+}
+
+// tokenBucket holds the tokens left for a key as of last.
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// keyLimiter holds a token bucket per key, the least recently used ones are dropped over maxKeys.
+type keyLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	maxKeys int
+	clock   Clock
+	order   *list.List
+	buckets map[string]*list.Element
+}
+
+func newKeyLimiter(rate float64, burst, maxKeys int, clock Clock) *keyLimiter {
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxRateLimitedKeys
+	}
+	return &keyLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		maxKeys: maxKeys,
+		clock:   clock,
+		order:   list.New(),
+		buckets: make(map[string]*list.Element),
+	}
+}
+
+// bucket returns the bucket of the key refilled up to now, must be called with mu held.
+func (l *keyLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if elem, ok := l.buckets[key]; ok {
+		l.order.MoveToFront(elem)
+		b := elem.Value.(*tokenBucket)
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		return b
+	}
+
+	b := &tokenBucket{key: key, tokens: l.burst, last: now}
+	l.buckets[key] = l.order.PushFront(b)
+	for len(l.buckets) > l.maxKeys {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*tokenBucket).key)
+	}
+	return b
+}
+
+// allow takes a token from the bucket of every key, or none if one of them is empty. A key given twice takes a
+// single token. It returns the key over its limit and how long until it gets a token back.
+func (l *keyLimiter) allow(keys []string) (string, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	buckets := make([]*tokenBucket, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		b := l.bucket(key, now)
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+			return key, wait, false
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return "", 0, true
+}
+
+// keyLimited is the middleware enforcing WithKeyRateLimit.
+func (s *Server) keyLimited() echo.MiddlewareFunc {
+	clock := s.keyClock
+	if clock == nil {
+		clock = realClock{}
+	}
+	limiter := newKeyLimiter(s.keyRate, s.keyBurst, s.maxRateLimitedKeys, clock)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			keys := routeKeys(c)
+			if len(keys) == 0 {
+				return next(c)
+			}
+			if key, wait, ok := limiter.allow(keys); !ok {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("too many requests on key %s, retry later", key))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKeyRateLimit(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("hot", "1")
+	st.Put("cold", "1")
+	_, h := newTestServer(st, WithKeyRateLimit(0.01, 5, 0))
+
+	allowed, limited := 0, 0
+	for i := 0; i < 20; i++ {
+		rec := do(t, h, http.MethodGet, "/kv/hot", "")
+		switch rec.Code {
+		case http.StatusOK:
+			allowed++
+		case http.StatusTooManyRequests:
+			limited++
+			if rec.Header().Get("Retry-After") == "" {
+				t.Fatal("a limited request has no Retry-After")
+			}
+		default:
+			t.Fatalf("got status %d", rec.Code)
+		}
+	}
+	if allowed != 5 || limited != 15 {
+		t.Fatalf("%d requests got through and %d were limited, want the burst of 5 through", allowed, limited)
+	}
+
+	// The other keys go on, and the writes on the hot key are limited as well.
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/cold", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/cold", "2"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/hot", "2"), http.StatusTooManyRequests)
+	// The routes without a key aren't limited.
+	expectStatus(t, do(t, h, http.MethodGet, "/health", ""), http.StatusOK)
+}
+
+func TestKeyLimiterRefills(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	l := newKeyLimiter(2, 2, 0, clock)
+
+	for i := 0; i < 2; i++ {
+		if _, _, ok := l.allow([]string{"a"}); !ok {
+			t.Fatalf("request %d of the burst was limited", i)
+		}
+	}
+	key, wait, ok := l.allow([]string{"a"})
+	if ok || key != "a" || wait != 500*time.Millisecond {
+		t.Fatalf("got %q, %s, %v, want a limited for 500ms", key, wait, ok)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if _, _, ok := l.allow([]string{"a"}); !ok {
+		t.Fatal("the bucket didn't get a token back")
+	}
+	// A request on two keys takes a token from neither if one of them is empty.
+	if key, _, ok := l.allow([]string{"b", "a"}); ok || key != "a" {
+		t.Fatalf("got %q, %v", key, ok)
+	}
+	if _, _, ok := l.allow([]string{"b"}); !ok {
+		t.Fatal("b lost a token to a request that was limited")
+	}
+}
+
+func TestKeyLimiterIsBounded(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	l := newKeyLimiter(1, 1, 3, clock)
+
+	l.allow([]string{"a"})
+	for _, key := range []string{"b", "c", "d"} {
+		l.allow([]string{key})
+	}
+	if n := len(l.buckets); n != 3 {
+		t.Fatalf("the limiter holds %d buckets, at most 3 are kept", n)
+	}
+	// The bucket of a was dropped, it starts full again.
+	if _, _, ok := l.allow([]string{"a"}); !ok {
+		t.Fatal("a is still limited after its bucket was dropped")
+	}
+	if _, _, ok := l.allow([]string{"d"}); ok {
+		t.Fatal("the bucket of d was dropped instead of the oldest")
+	}
+}
+
+func TestKeyRateLimitSameKeyTwice(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	_, h := newTestServer(st, WithKeyRateLimit(0.01, 2, 0))
+
+	// /swap/a/a takes a single token from a, so the burst of 2 lets two of them through.
+	for i := 0; i < 2; i++ {
+		if rec := do(t, h, http.MethodPost, "/swap/a/a", ""); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("swap %d was limited", i)
+		}
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/swap/a/a", ""), http.StatusTooManyRequests)
+}
+
+func TestKeyRateLimitClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	_, h := newTestServer(st, WithKeyRateLimit(1, 1, 0), WithKeyRateLimitClock(clock))
+
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusTooManyRequests)
+	clock.Advance(time.Second)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusOK)
+}
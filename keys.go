@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// KeyMatcher is implemented by stores that can list the keys matching a glob pattern.
+type KeyMatcher[K comparable] interface {
+	MatchKeys(pattern string) []K
+}
+
+// MatchKeys returns the keys matching the glob pattern, where * matches any run of characters and ? a single
+// one, like Redis' KEYS. Keys that aren't strings are matched on their fmt.Sprint form.
+// It goes through every key of the store, so it's meant for admin tooling rather than the hot path.
+func (s *KVStore[K, V]) MatchKeys(pattern string) []K {
+	if s.caseInsensitiveKeys {
+		pattern = strings.ToLower(pattern)
+	}
+
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	var keys []K
+	for key := range s.data {
+		if !s.isExpired(key) && globMatch(pattern, keyString(key)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// WithCaseInsensitiveKeys makes the keys of a string store case-insensitive, e.g. for usernames: every key is
+// lowercased before it's used, so Put("Alice", v) and Get("alice") hit the same entry. It applies to every
+// operation taking keys, prefixes or patterns, and the keys listed by the store are in lower case.
+func WithCaseInsensitiveKeys[V any]() Option[string, V] {
+	return func(s *KVStore[string, V]) {
+		s.caseInsensitiveKeys = true
+	}
+}
+
+// canon returns the key as the store keeps it: lowercased for a store created WithCaseInsensitiveKeys, and the
+// key an alias points to for an alias.
+func (s *KVStore[K, V]) canon(key K) K {
+	return s.resolveAlias(s.foldCase(key))
+}
+
+// foldCase lowercases the key for a store created WithCaseInsensitiveKeys.
+func (s *KVStore[K, V]) foldCase(key K) K {
+	if !s.caseInsensitiveKeys {
+		return key
+	}
+	// WithCaseInsensitiveKeys is only for string stores, so K is string here.
+	return any(strings.ToLower(any(key).(string))).(K)
+}
+
+// globMatch reports whether name matches the pattern, * and ? are the only special characters.
+func globMatch(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	// star is the position of the last * seen in the pattern and match where in name it started matching,
+	// on a mismatch we backtrack there and let the * swallow one more character.
+	star, match := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		// A * is always a wildcard, even against a * in name.
+		case i < len(p) && p[i] == '*':
+			star, match = i, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case star >= 0:
+			match++
+			i, j = star+1, match
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// handleKeys serves GET /keys?pattern=user:*:active, returning at most the scan limit of keys in sorted order.
+// It scans the whole store, don't call it in a loop.
+func (s *Server) handleKeys(c echo.Context) error {
+	matcher, ok := s.Storage.(KeyMatcher[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support listing keys")
+	}
+
+	pattern := c.QueryParam("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	keys := matcher.MatchKeys(pattern)
+	sort.Strings(keys)
+	truncated := len(keys) > s.maxScanResults
+	if truncated {
+		keys = keys[:s.maxScanResults]
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"keys":      keys,
+		"truncated": truncated,
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"user:*:active", "user:42:active", true},
+		{"user:*:active", "user:42:inactive", false},
+		{"user:*:active", "user:a:b:active", true},
+		{"k?y", "key", true},
+		{"k?y", "ky", false},
+		{"k?y", "keey", false},
+		{"h?llo*", "héllo world", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		// A * in the key is matched like any other character.
+		{"*", "*x", true},
+		{"a*", "a*b", true},
+		{"*x", "**x", true},
+		{"a?c", "a*c", true},
+		{"a*c", "a*", false},
+	} {
+		if got := globMatch(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMatchKeys(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for _, key := range []string{"user:1:active", "user:2:active", "user:3:idle", "key", "kay", "keey"} {
+		st.Put(key, "v")
+	}
+
+	for pattern, want := range map[string][]string{
+		"user:*:active": {"user:1:active", "user:2:active"},
+		"k?y":           {"kay", "key"},
+		"keey":          {"keey"},
+		"nothing*":      nil,
+	} {
+		got := st.MatchKeys(pattern)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", pattern, got, want)
+		}
+	}
+}
+
+func TestKeysOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for _, key := range []string{"c", "a", "b", "other"} {
+		st.Put(key, "v")
+	}
+	_, h := newTestServer(st, WithMaxScanResults(2))
+
+	var out struct {
+		Keys      []string `json:"keys"`
+		Truncated bool     `json:"truncated"`
+	}
+	rec := do(t, h, http.MethodGet, "/keys?pattern=?", "")
+	expectStatus(t, rec, http.StatusOK)
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if !reflect.DeepEqual(out.Keys, []string{"a", "b"}) || !out.Truncated {
+		t.Fatalf("got %+v", out)
+	}
+
+	// The default pattern lists the keys starting with a * too.
+	_, all := newTestServer(NewKVStore[string, string]())
+	do(t, all, http.MethodPut, "/kv/*star", "v")
+	do(t, all, http.MethodPut, "/kv/plain", "v")
+	rec = do(t, all, http.MethodGet, "/keys", "")
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if !reflect.DeepEqual(out.Keys, []string{"*star", "plain"}) {
+		t.Fatalf("got %+v", out)
+	}
+
+	rec = do(t, h, http.MethodGet, "/keys?pattern=none*", "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); body != "{\"keys\":[],\"truncated\":false}\n" {
+		t.Fatalf("got %s", body)
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	st := NewKVStore[string, string](WithCaseInsensitiveKeys[string]())
+	st.Put("Alice", "1")
+	for _, key := range []string{"Alice", "alice", "ALICE", "aLiCe"} {
+		if v, err := st.Get(key); err != nil || v != "1" {
+			t.Fatalf("Get(%q): got %q, %v", key, v, err)
+		}
+	}
+
+	// A case variant writes the same entry.
+	st.Put("ALICE", "2")
+	st.Put("Bob", "3")
+	if err := st.Update("BOB", "4"); err != nil {
+		t.Fatal(err)
+	}
+	all := st.Filter(func(string, string) bool { return true })
+	if !reflect.DeepEqual(all, map[string]string{"alice": "2", "bob": "4"}) {
+		t.Fatalf("the store holds %v", all)
+	}
+	for _, pattern := range []string{"al*", "AL*", "?LICE"} {
+		if got := st.MatchKeys(pattern); !reflect.DeepEqual(got, []string{"alice"}) {
+			t.Errorf("%q: got %v", pattern, got)
+		}
+	}
+
+	events, cancel := st.WatchPrefix("AL")
+	defer cancel()
+	st.Put("AlIcE", "5")
+	if e := nextEvent(t, events); e.Key != "alice" || e.Value != "5" {
+		t.Fatalf("got %+v", e)
+	}
+
+	if _, err := st.Delete("ALICE"); err != nil {
+		t.Fatal(err)
+	}
+	if st.Has("alice") || st.Has("Alice") {
+		t.Fatal("the key is still there after a delete in another case")
+	}
+	if e := nextEvent(t, events); e.Type != EventDelete || e.Key != "alice" {
+		t.Fatalf("got %+v", e)
+	}
+}
+
+func TestKeysAreCaseSensitiveByDefault(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("Alice", "1")
+	if _, err := st.Get("alice"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestCaseInsensitiveKeysOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string](WithCaseInsensitiveKeys[string]())
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/Alice", "1"), http.StatusOK)
+	rec := do(t, h, http.MethodGet, "/kv/ALICE", "")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"value":"1"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	rec = do(t, h, http.MethodGet, "/keys?pattern=AL*", "")
+	if !strings.Contains(rec.Body.String(), `"alice"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/alice", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/Alice", ""), http.StatusNotFound)
+}
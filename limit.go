@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultMaxHeavyRequests = 4
+
+// WithMaxHeavyRequests sets how many of the heavy requests (scans, key listings, imports and exports) can run at
+// once, the requests over the limit get 503 right away. The default is 4, 0 means unlimited.
+func WithMaxHeavyRequests(n int) ServerOption {
+	return func(s *Server) {
+		s.maxHeavyRequests = n
+	}
+}
+
+// limited is the middleware of the heavy endpoints, it rejects the request instead of queuing it once
+// maxHeavyRequests are running, so they can't pile up and exhaust the memory.
+func (s *Server) limited() echo.MiddlewareFunc {
+	if s.maxHeavyRequests <= 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+
+	sem := make(chan struct{}, s.maxHeavyRequests)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				return next(c)
+			default:
+				c.Response().Header().Set("Retry-After", "1")
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "too many heavy requests are running, retry later")
+			}
+		}
+	}
+}
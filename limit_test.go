@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// blockingFilterStore is a store whose Filter, behind the heavy endpoints, blocks until release is closed.
+type blockingFilterStore struct {
+	*KVStore[string, string]
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingFilterStore) Filter(pred func(string, string) bool) map[string]string {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.KVStore.Filter(pred)
+}
+
+func TestHeavyRequestsAreLimited(t *testing.T) {
+	st := &blockingFilterStore{KVStore: NewKVStore[string, string](), entered: make(chan struct{}), release: make(chan struct{})}
+	st.Put("a", "1")
+	_, h := newTestServer(st, WithMaxHeavyRequests(2))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- do(t, h, http.MethodGet, "/export", "").Code
+		}()
+		<-st.entered
+	}
+
+	for _, target := range []string{"/export", "/entries"} {
+		rec := do(t, h, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusServiceUnavailable)
+		if rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: the 503 has no Retry-After", target)
+		}
+	}
+	// The light endpoints aren't limited.
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/b", "2"), http.StatusOK)
+
+	close(st.release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("a running heavy request got %d", code)
+		}
+	}
+
+	// The slots are free again.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do(t, h, http.MethodGet, "/export", "") }()
+	<-st.entered
+	expectStatus(t, <-done, http.StatusOK)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	lockKeyPrefix  = "lock:"
+	defaultLockTTL = 30 * time.Second
+)
+
+// LockStore is what a Locker needs from the store it's built on.
+type LockStore interface {
+	PutIfAbsentWithTTL(key, value string, ttl time.Duration) (bool, error)
+	DeleteIf(key string, pred func(string) bool) (bool, error)
+}
+
+// Locker implements lease based mutexes on top of a store. A lock is a key holding a random token,
+// the TTL releases it if the holder goes away and the token makes sure only the current holder can unlock it.
+type Locker struct {
+	store LockStore
+}
+
+func NewLocker(store LockStore) *Locker {
+	return &Locker{store: store}
+}
+
+// Lock tries to acquire the named lock for ttl, the returned token is needed to Unlock it. A failure of the store
+// counts as not acquiring it, see lock.
+func (l *Locker) Lock(name string, ttl time.Duration) (token string, acquired bool) {
+	token, acquired, err := l.lock(name, ttl)
+	return token, acquired && err == nil
+}
+
+// lock is Lock returning the error of the store apart from the lock being held.
+func (l *Locker) lock(name string, ttl time.Duration) (token string, acquired bool, err error) {
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	acquired, err = l.store.PutIfAbsentWithTTL(lockKeyPrefix+name, token, ttl)
+	if err != nil || !acquired {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Unlock releases the named lock if it's still held with the token. It returns false if the lock
+// expired or was reacquired by someone else in the meantime, or if the store failed, see unlock.
+func (l *Locker) Unlock(name, token string) bool {
+	released, err := l.unlock(name, token)
+	return err == nil && released
+}
+
+// unlock is Unlock returning the error of the store apart from the lock not being held with the token.
+func (l *Locker) unlock(name, token string) (bool, error) {
+	return l.store.DeleteIf(lockKeyPrefix+name, func(current string) bool {
+		return current == token
+	})
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) locker() (*Locker, error) {
+	store, ok := s.Storage.(LockStore)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support locks")
+	}
+	return NewLocker(store), nil
+}
+
+// handleLock serves POST /lock/:name, ?ttl= sets the lease duration (30s by default).
+// It answers 409 Conflict when the lock is already held.
+func (s *Server) handleLock(c echo.Context) error {
+	locker, err := s.locker()
+	if err != nil {
+		return err
+	}
+
+	ttl := defaultLockTTL
+	if param := c.QueryParam("ttl"); param != "" {
+		if ttl, err = time.ParseDuration(param); err != nil || ttl <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid ttl: "+param)
+		}
+	}
+
+	name := c.Param("name")
+	var token string
+	acquired := false
+	err = s.fence(c, func() (err error) {
+		token, acquired, err = locker.lock(name, ttl)
+		return err
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if !acquired {
+		return echo.NewHTTPError(http.StatusConflict, "the lock ("+name+") is already held")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"lock": name, "token": token})
+}
+
+// handleUnlock serves POST /unlock/:name?token=, it answers 409 Conflict when the token doesn't hold the lock.
+func (s *Server) handleUnlock(c echo.Context) error {
+	locker, err := s.locker()
+	if err != nil {
+		return err
+	}
+
+	name := c.Param("name")
+	unlocked := false
+	err = s.fence(c, func() (err error) {
+		unlocked, err = locker.unlock(name, c.QueryParam("token"))
+		return err
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if !unlocked {
+		return echo.NewHTTPError(http.StatusConflict, "the lock ("+name+") is not held with this token")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"unlocked": name})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	locker := NewLocker(NewKVStore[string, string](WithClock[string, string](clock)))
+
+	token, acquired := locker.Lock("job", time.Minute)
+	if !acquired || token == "" {
+		t.Fatal("the free lock wasn't acquired")
+	}
+	if _, acquired := locker.Lock("job", time.Minute); acquired {
+		t.Fatal("the held lock was acquired again")
+	}
+	if locker.Unlock("job", "wrong") {
+		t.Fatal("the lock was released with the wrong token")
+	}
+	if !locker.Unlock("job", token) {
+		t.Fatal("the holder couldn't release the lock")
+	}
+
+	// A lock left behind expires, and the old holder can't release it once it's reacquired.
+	stale, _ := locker.Lock("job", time.Minute)
+	clock.Advance(time.Minute + time.Second)
+	fresh, acquired := locker.Lock("job", time.Minute)
+	if !acquired {
+		t.Fatal("the expired lock wasn't acquired")
+	}
+	if locker.Unlock("job", stale) {
+		t.Fatal("the expired holder released the lock of the new one")
+	}
+	if !locker.Unlock("job", fresh) {
+		t.Fatal("the new holder couldn't release the lock")
+	}
+}
+
+func TestLockOverHTTP(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string]())
+
+	rec := do(t, h, http.MethodPost, "/lock/job?ttl=1m", "")
+	expectStatus(t, rec, http.StatusOK)
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/lock/job", ""), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPost, "/lock/job?ttl=-1s", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/unlock/job?token=wrong", ""), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPost, "/unlock/job?token="+body["token"], ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPost, "/lock/job", ""), http.StatusOK)
+}
+
+// failingUnlockStore is a store whose DeleteIf fails like a WAL that can't be written.
+type failingUnlockStore struct {
+	*KVStore[string, string]
+}
+
+func (s failingUnlockStore) DeleteIf(string, func(string) bool) (bool, error) {
+	return false, ErrWALWrite
+}
+
+func TestLockStoreErrors(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](1)
+	st.Put("a", "1")
+	if _, acquired := NewLocker(st).Lock("job", time.Minute); acquired {
+		t.Fatal("a lock was acquired in a full store")
+	}
+
+	// The server tells a failure of the store from a lock that's held.
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodPost, "/lock/job", ""), http.StatusInsufficientStorage)
+
+	_, h = newTestServer(failingUnlockStore{NewKVStore[string, string]()})
+	rec := do(t, h, http.MethodPost, "/lock/job", "")
+	expectStatus(t, rec, http.StatusOK)
+	var lock struct{ Token string }
+	json.Unmarshal(rec.Body.Bytes(), &lock)
+	expectStatus(t, do(t, h, http.MethodPost, "/unlock/job?token="+lock.Token, ""), http.StatusInsufficientStorage)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// Logger is what the server and the store log through, so they can be embedded in applications with their own logging.
+type Logger interface {
+	Info(format string, args ...any)
+	Warn(format string, args ...any)
+	Error(format string, args ...any)
+	Debug(format string, args ...any)
+}
+
+// StdLogger logs with the standard library's log package, Debug messages are dropped unless Verbose is set.
+type StdLogger struct {
+	logger  *log.Logger
+	Verbose bool
+}
+
+func NewStdLogger(w io.Writer) *StdLogger {
+	return &StdLogger{logger: log.New(w, "", log.LstdFlags)}
+}
+
+func (l *StdLogger) Info(format string, args ...any) {
+	l.logger.Printf("INFO "+format, args...)
+}
+
+func (l *StdLogger) Warn(format string, args ...any) {
+	l.logger.Printf("WARN "+format, args...)
+}
+
+func (l *StdLogger) Error(format string, args ...any) {
+	l.logger.Printf("ERROR "+format, args...)
+}
+
+func (l *StdLogger) Debug(format string, args ...any) {
+	if l.Verbose {
+		l.logger.Printf("DEBUG "+format, args...)
+	}
+}
+
+// NopLogger discards everything, it's handy to silence tests.
+type NopLogger struct{}
+
+func (NopLogger) Info(string, ...any)  {}
+func (NopLogger) Warn(string, ...any)  {}
+func (NopLogger) Error(string, ...any) {}
+func (NopLogger) Debug(string, ...any) {}
+
+var defaultLogger Logger = NewStdLogger(os.Stderr)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStartupLoggedThroughLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := NewServer("127.0.0.1:0", WithLogger(logger))
+	done := make(chan struct{})
+	go func() {
+		srv.Start()
+		close(done)
+	}()
+	defer func() {
+		srv.Stop()
+		<-done
+	}()
+
+	waitFor(t, func() bool { return logger.contains("INFO HTTP server is running on 127.0.0.1:") })
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(&buf)
+	logger.Info("started %d", 1)
+	logger.Warn("slow")
+	logger.Error("failed")
+	logger.Debug("hidden")
+
+	out := buf.String()
+	for _, want := range []string{"INFO started 1", "WARN slow", "ERROR failed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q wasn't logged: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "hidden") {
+		t.Error("a Debug message was logged without Verbose")
+	}
+
+	logger.Verbose = true
+	logger.Debug("shown")
+	if !strings.Contains(buf.String(), "DEBUG shown") {
+		t.Error("a Debug message wasn't logged with Verbose")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultMaxWait caps the ?wait= of a long poll, so a client can't hold a connection forever.
+const defaultMaxWait = time.Minute
+
+// WithMaxWait caps how long a long poll waits, a longer ?wait= is cut down to d. The default is a minute.
+func WithMaxWait(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.maxWait = d
+	}
+}
+
+// WithMaxWaiters caps how many long polls can wait on the same key at once, the next ones are rejected with 503
+// and a Retry-After so the clients back off. The default is unlimited.
+func WithMaxWaiters(perKey int) ServerOption {
+	return func(s *Server) {
+		s.maxWaitersPerKey = perKey
+	}
+}
+
+// WithMaxTotalWaiters is WithMaxWaiters for all the keys together, each waiter holds a connection and a watch on
+// the store.
+func WithMaxTotalWaiters(n int) ServerOption {
+	return func(s *Server) {
+		s.maxWaiters = n
+	}
+}
+
+// waiterCount counts the long polls in progress, per key and in total.
+type waiterCount struct {
+	mu     sync.Mutex
+	perKey map[string]int
+	total  int
+}
+
+// acquire counts a waiter on key unless it would go over one of the caps, 0 means no cap.
+func (w *waiterCount) acquire(key string, perKey, total int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if total > 0 && w.total >= total || perKey > 0 && w.perKey[key] >= perKey {
+		return false
+	}
+	if w.perKey == nil {
+		w.perKey = make(map[string]int)
+	}
+	w.perKey[key]++
+	w.total++
+	return true
+}
+
+func (w *waiterCount) release(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.perKey[key]--; w.perKey[key] == 0 {
+		delete(w.perKey, key)
+	}
+	w.total--
+}
+
+// Waiter is implemented by stores that can wait for a key to appear or change.
+type Waiter[K comparable, V any] interface {
+	WaitFor(ctx context.Context, key K, version uint64) (V, uint64, error)
+}
+
+// WaitFor returns the value and version of the key as soon as it exists at a version other than version, a
+// zero version waits for the key to exist at all. It returns the context's error if that doesn't happen before
+// ctx is done.
+func (s *KVStore[K, V]) WaitFor(ctx context.Context, key K, version uint64) (V, uint64, error) {
+	key = s.canon(key)
+	// Watches before checking, so a write landing between the check and the wait isn't missed.
+	events, cancel := s.Watch(key)
+	defer cancel()
+
+	value, current, ok := s.versioned(key)
+	if ok && current != version {
+		return value, current, nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			var zero V
+			return zero, 0, ctx.Err()
+		case event, open := <-events:
+			if !open {
+				// The store was closed, nothing will change anymore.
+				events = nil
+				continue
+			}
+			if event.Type != EventPut {
+				continue
+			}
+			// The versions start over once a key is deleted, so any put since we started watching is a change,
+			// even if the version is the same as the one we were given.
+			if value, current, ok := s.versioned(key); ok {
+				return value, current, nil
+			}
+		}
+	}
+}
+
+// versioned returns the value of the key along with its version.
+func (s *KVStore[K, V]) versioned(key K) (V, uint64, bool) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.lookup(key)
+	if !ok {
+		return value, 0, false
+	}
+	return value, s.meta[key].version, true
+}
+
+// handleLongPoll serves GET /get/:key?wait=5s. It answers right away if the key exists, or with an If-Match
+// version if the key is at another version. Otherwise it waits up to the given time for the key to be set, and
+// answers 404, or 304 with If-Match, if it wasn't. The version of the value is returned in the ETag header, to
+// be sent back as If-Match by the next poll.
+func (s *Server) handleLongPoll(c echo.Context) error {
+	waiter, ok := s.Storage.(Waiter[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support waiting for keys")
+	}
+
+	wait, err := time.ParseDuration(c.QueryParam("wait"))
+	if err != nil || wait < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid wait: "+c.QueryParam("wait"))
+	}
+	wait = min(wait, s.maxWait)
+
+	var version uint64
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch != "" {
+		if version, err = parseVersion(ifMatch); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid If-Match version: "+ifMatch)
+		}
+	}
+
+	key := c.Param("key")
+	if !s.waiters.acquire(key, s.maxWaitersPerKey, s.maxWaiters) {
+		c.Response().Header().Set("Retry-After", "1")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "too many clients are waiting, retry later")
+	}
+	defer s.waiters.release(key)
+
+	// The request's context is done when the client disconnects, which stops the wait and frees its place.
+	ctx, cancel := context.WithTimeout(c.Request().Context(), wait)
+	defer cancel()
+
+	value, version, err := waiter.WaitFor(ctx, key, version)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && ifMatch != "":
+		return c.NoContent(http.StatusNotModified)
+	case errors.Is(err, context.DeadlineExceeded):
+		return toHTTPError(keyNotFound(key))
+	case err != nil:
+		return err
+	}
+
+	c.Response().Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+	return c.JSON(http.StatusOK, map[string]string{"value": value})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitingPolls returns how many long polls the server is holding.
+func waitingPolls(srv *Server) int {
+	srv.waiters.mu.Lock()
+	defer srv.waiters.mu.Unlock()
+	return srv.waiters.total
+}
+
+func TestLongPollReturnsWhenTheKeyIsSet(t *testing.T) {
+	st := NewKVStore[string, string]()
+	srv, h := newTestServer(st)
+
+	polled := make(chan *httptest.ResponseRecorder)
+	go func() {
+		polled <- do(t, h, http.MethodGet, "/get/config?wait=10s", "")
+	}()
+	waitFor(t, func() bool { return waitingPolls(srv) == 1 })
+
+	st.Put("config", "v1")
+	select {
+	case rec := <-polled:
+		expectStatus(t, rec, http.StatusOK)
+		if !strings.Contains(rec.Body.String(), `"v1"`) || rec.Header().Get("ETag") == "" {
+			t.Fatalf("got %s with the headers %v", rec.Body, rec.Header())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the poll didn't return when the key was set")
+	}
+	if n := waitingPolls(srv); n != 0 {
+		t.Fatalf("%d polls are still counted", n)
+	}
+}
+
+func TestLongPollIfMatch(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("config", "v1")
+	srv, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodGet, "/get/config?wait=10s", "")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+
+	// Unchanged until the timeout.
+	expectStatus(t, do(t, h, http.MethodGet, "/get/config?wait=10ms", "", "If-Match", etag), http.StatusNotModified)
+
+	polled := make(chan *httptest.ResponseRecorder)
+	go func() {
+		polled <- do(t, h, http.MethodGet, "/get/config?wait=10s", "", "If-Match", etag)
+	}()
+	waitFor(t, func() bool { return waitingPolls(srv) == 1 })
+	st.Put("config", "v2")
+	rec = <-polled
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"v2"`) || rec.Header().Get("ETag") == etag {
+		t.Fatalf("got %s with the ETag %s", rec.Body, rec.Header().Get("ETag"))
+	}
+}
+
+func TestLongPollTimesOut(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string]())
+	expectStatus(t, do(t, h, http.MethodGet, "/get/absent?wait=10ms", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/get/absent?wait=soon", ""), http.StatusBadRequest)
+}
+
+func TestLongPollWaiterCaps(t *testing.T) {
+	st := NewKVStore[string, string]()
+	srv, h := newTestServer(st, WithMaxWaiters(2), WithMaxTotalWaiters(3))
+
+	polled := make(chan *httptest.ResponseRecorder, 3)
+	for _, key := range []string{"a", "a", "b"} {
+		key := key
+		go func() {
+			polled <- do(t, h, http.MethodGet, "/get/"+key+"?wait=10s", "")
+		}()
+	}
+	waitFor(t, func() bool { return waitingPolls(srv) == 3 })
+
+	// A third waiter on a, then a first one on c with every place taken.
+	for _, key := range []string{"a", "c"} {
+		rec := do(t, h, http.MethodGet, "/get/"+key+"?wait=10s", "")
+		expectStatus(t, rec, http.StatusServiceUnavailable)
+		if rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: the 503 has no Retry-After", key)
+		}
+	}
+
+	// The ones that were let in are still notified.
+	st.Put("a", "1")
+	st.Put("b", "2")
+	for i := 0; i < 3; i++ {
+		select {
+		case rec := <-polled:
+			expectStatus(t, rec, http.StatusOK)
+		case <-time.After(2 * time.Second):
+			t.Fatal("a waiter wasn't notified")
+		}
+	}
+	if n := waitingPolls(srv); n != 0 {
+		t.Fatalf("%d polls are still counted", n)
+	}
+}
+
+func TestLongPollDisconnectFreesItsPlace(t *testing.T) {
+	srv, h := newTestServer(NewKVStore[string, string](), WithMaxWaiters(1))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/get/a?wait=10s", nil).WithContext(ctx)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	waitFor(t, func() bool { return waitingPolls(srv) == 1 })
+	expectStatus(t, do(t, h, http.MethodGet, "/get/a?wait=10ms", ""), http.StatusServiceUnavailable)
+
+	disconnect()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the poll kept waiting after the client left")
+	}
+	if n := waitingPolls(srv); n != 0 {
+		t.Fatalf("%d polls are still counted", n)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/get/a?wait=10ms", ""), http.StatusNotFound)
+}
+
+func TestLongPollMaxWait(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithMaxWait(10*time.Millisecond))
+
+	start := time.Now()
+	expectStatus(t, do(t, h, http.MethodGet, "/get/absent?wait=1h", ""), http.StatusNotFound)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the poll waited %s", elapsed)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// NewKVStoreWithByteCapacity creates a KVStore whose capacity is measured in bytes (the size of the keys plus the values)
+// instead of entries. When a write goes over maxBytes, the least recently used keys are evicted until it fits again,
+// so one large value may evict several small ones. An entry larger than maxBytes on its own is rejected with ErrStoreFull.
+func NewKVStoreWithByteCapacity[K comparable, V any](maxBytes int, opts ...Option[K, V]) *KVStore[K, V] {
+	s := NewKVStore[K, V](opts...)
+	s.maxBytes = maxBytes
+	s.lru = newLRUList[K]()
+	return s
+}
+
+// ErrMemoryLimit is returned by the writes of a store created WithMemoryLimit that would take it over its limit.
+var ErrMemoryLimit = errors.New("the memory limit of the store is reached")
+
+// entryOverhead estimates what an entry takes on top of its key and value: its slot in the map, its metadata
+// and its element in the LRU list.
+const entryOverhead = 96
+
+// WithMemoryLimit caps the estimated memory of the store at limit bytes, counting the keys, the values and a
+// fixed overhead per entry. A write that would go over it fails with ErrMemoryLimit, unless the store was created
+// with NewKVStoreWithByteCapacity: the least recently used keys are then evicted until it fits. It's an estimate,
+// the actual memory of the process is higher.
+func WithMemoryLimit[K comparable, V any](limit int) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.memoryLimit = limit
+	}
+}
+
+// MemoryUsage returns the estimated memory of a store created WithMemoryLimit, see there.
+func (s *KVStore[K, V]) MemoryUsage() int {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	return s.memoryUsage()
+}
+
+// memoryUsage must be called with the lock held.
+func (s *KVStore[K, V]) memoryUsage() int {
+	return s.bytes + len(s.data)*entryOverhead
+}
+
+// WithEvictionWatermarks makes a byte-capacity store start evicting once it holds more than high bytes and
+// keep going until it's down to low, so the following writes don't each pay for an eviction.
+// high should be at most the capacity, it defaults to it and low defaults to high.
+func WithEvictionWatermarks[K comparable, V any](high, low int) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.highWatermark, s.lowWatermark = high, low
+	}
+}
+
+// WithEvictionBatchSize makes a byte-capacity store evict at least n keys every time it evicts.
+func WithEvictionBatchSize[K comparable, V any](n int) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.evictionBatch = n
+	}
+}
+
+// ByteSize returns the total size of the keys and values, it's only tracked by stores created with NewKVStoreWithByteCapacity.
+func (s *KVStore[K, V]) ByteSize() int {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	return s.bytes
+}
+
+// checkCapacity reports whether the value can be stored under the key without going over the limits of the store.
+// Going over the byte capacity is fine as long as evictions can make room. It must be called with the lock held.
+func (s *KVStore[K, V]) checkCapacity(key K, value V) error {
+	if s.maxKeys > 0 && !s.Has(key) && len(s.data) >= s.maxKeys {
+		return ErrStoreFull
+	}
+	if s.maxBytes > 0 {
+		if size := entrySize(key, value); size > s.maxBytes {
+			return fmt.Errorf("%w: the entry takes %d bytes, the capacity is %d", ErrStoreFull, size, s.maxBytes)
+		}
+	}
+	if s.memoryLimit > 0 {
+		size := entrySize(key, value) + entryOverhead
+		if size > s.memoryLimit {
+			return fmt.Errorf("%w: the entry takes %d bytes, the limit is %d", ErrMemoryLimit, size, s.memoryLimit)
+		}
+		// The eviction makes room in a byte-capacity store.
+		if s.lru == nil {
+			usage := s.memoryUsage() + size
+			if m, ok := s.meta[key]; ok && s.Has(key) {
+				usage -= m.size + entryOverhead
+			}
+			if usage > s.memoryLimit {
+				return fmt.Errorf("%w: it would take %d bytes, the limit is %d", ErrMemoryLimit, usage, s.memoryLimit)
+			}
+		}
+	}
+	return nil
+}
+
+// account updates the size and recency of the key after a write, must be called with the write lock held.
+func (s *KVStore[K, V]) account(key K, value V) {
+	if s.maxBytes > 0 || s.memoryLimit > 0 {
+		m := s.meta[key]
+		size := entrySize(key, value)
+		s.bytes += size - m.size
+		m.size = size
+	}
+	if s.lru != nil {
+		s.lru.touch(key)
+	}
+}
+
+// recordAccess marks a read of the key, it's called with only the read lock held.
+func (s *KVStore[K, V]) recordAccess(key K) {
+	s.meta[key].recordAccess(s.clock.Now())
+	if s.lru != nil {
+		s.lru.touch(key)
+	}
+}
+
+// evictOverCapacity evicts the least recently used keys once the store is over its high watermark, until it's
+// down to the low watermark and at least the eviction batch size was evicted, and then until it's under its
+// memory limit. keep is the key that was just written, it's never evicted. It must be called with the write lock
+// held.
+func (s *KVStore[K, V]) evictOverCapacity(keep K) {
+	if s.maxBytes <= 0 {
+		return
+	}
+	defer func() {
+		for s.memoryLimit > 0 && s.memoryUsage() > s.memoryLimit {
+			key, ok := s.lru.oldest()
+			if !ok || key == keep {
+				return
+			}
+			s.evict(key)
+		}
+	}()
+	high, low := s.maxBytes, s.lowWatermark
+	if s.highWatermark > 0 {
+		high = s.highWatermark
+	}
+	if low <= 0 || low > high {
+		low = high
+	}
+	if s.bytes <= high {
+		return
+	}
+	for evicted := 0; s.bytes > low || evicted < s.evictionBatch; evicted++ {
+		key, ok := s.lru.oldest()
+		if !ok || key == keep {
+			return
+		}
+		s.evict(key)
+	}
+}
+
+// evict removes the key to make room and notifies the watchers, must be called with the write lock held.
+func (s *KVStore[K, V]) evict(key K) {
+	value := s.data[key]
+	s.remove(key)
+	s.notify(EventEvict, key, value)
+}
+
+// lruList orders keys from the most to the least recently used. It has its own lock because reads
+// update it while holding only the store's read lock.
+type lruList[K comparable] struct {
+	mu    sync.Mutex
+	order *list.List
+	elems map[K]*list.Element
+}
+
+func newLRUList[K comparable]() *lruList[K] {
+	return &lruList[K]{
+		order: list.New(),
+		elems: make(map[K]*list.Element),
+	}
+}
+
+func (l *lruList[K]) touch(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.elems[key]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.elems[key] = l.order.PushFront(key)
+}
+
+func (l *lruList[K]) remove(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.elems[key]; ok {
+		l.order.Remove(elem)
+		delete(l.elems, key)
+	}
+}
+
+func (l *lruList[K]) oldest() (K, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem := l.order.Back()
+	if elem == nil {
+		var zero K
+		return zero, false
+	}
+	return elem.Value.(K), true
+}
+
+func entrySize[K comparable, V any](key K, value V) int {
+	return sizeOf(key) + sizeOf(value)
+}
+
+// sizeOf estimates how many bytes v takes: the length of strings and byte slices, the in-memory size of
+// fixed size types, and the length of the JSON encoding for anything else.
+func sizeOf(v any) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return 0
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return int(t.Size())
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return int(t.Size())
+	}
+	return len(b)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteCapacityEvicts(t *testing.T) {
+	const capacity = 100
+	st := NewKVStoreWithByteCapacity[string, string](capacity)
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("k%d", i)
+		st.Put(key, strings.Repeat("x", 1+i*3))
+		if size := st.ByteSize(); size > capacity {
+			t.Fatalf("the store holds %d bytes after putting %s, the capacity is %d", size, key, capacity)
+		}
+	}
+	if _, err := st.Get("k9"); err != nil {
+		t.Fatalf("the last key was evicted: %v", err)
+	}
+	if _, err := st.Get("k0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the oldest key wasn't evicted: %v", err)
+	}
+
+	// A large value evicts several small ones.
+	small := NewKVStoreWithByteCapacity[string, string](capacity)
+	for i := 0; i < 5; i++ {
+		small.Put(fmt.Sprintf("s%d", i), strings.Repeat("x", 10))
+	}
+	small.Put("big", strings.Repeat("x", 80))
+	if n := len(small.Filter(func(string, string) bool { return true })); n > 2 {
+		t.Fatalf("%d keys are left next to the big one", n-1)
+	}
+	if small.ByteSize() > capacity {
+		t.Fatalf("the store holds %d bytes", small.ByteSize())
+	}
+
+	if err := small.Put("huge", strings.Repeat("x", capacity)); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("got %v for an entry over the capacity on its own", err)
+	}
+}
+
+func TestByteCapacityEvictsLeastRecentlyUsed(t *testing.T) {
+	st := NewKVStoreWithByteCapacity[string, string](entrySize("a", "1234") * 3)
+	st.Put("a", "1234")
+	st.Put("b", "1234")
+	st.Put("c", "1234")
+	st.Get("a")
+	st.Put("d", "1234")
+
+	if _, err := st.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the least recently used key wasn't evicted: %v", err)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, err := st.Get(key); err != nil {
+			t.Fatalf("%s was evicted: %v", key, err)
+		}
+	}
+
+	// Overwriting a key only accounts for the difference.
+	st.Put("a", "12")
+	if want := entrySize("a", "12") + 2*entrySize("c", "1234"); st.ByteSize() != want {
+		t.Fatalf("got %d bytes, want %d", st.ByteSize(), want)
+	}
+}
+
+func TestEvictionWatermarks(t *testing.T) {
+	size := entrySize("k0000", strings.Repeat("x", 100))
+	high, low := 100*size, 75*size
+	st := NewKVStoreWithByteCapacity[string, string](high, WithEvictionWatermarks[string, string](high, low))
+
+	evictions := 0
+	for i := 0; i < 1000; i++ {
+		before := st.ByteSize()
+		st.Put(fmt.Sprintf("k%04d", i), strings.Repeat("x", 100))
+		after := st.ByteSize()
+		if after > high {
+			t.Fatalf("the store holds %d bytes after %d puts, the high watermark is %d", after, i+1, high)
+		}
+		if after < before+size {
+			evictions++
+			if after > low {
+				t.Fatalf("an eviction stopped at %d bytes, the low watermark is %d", after, low)
+			}
+		}
+	}
+	// Every eviction makes room for the next 25 keys.
+	if evictions > 1000/25 {
+		t.Fatalf("%d puts evicted, want at most %d", evictions, 1000/25)
+	}
+}
+
+func TestEvictionBatchSize(t *testing.T) {
+	size := entrySize("k0000", "x")
+	st := NewKVStoreWithByteCapacity[string, string](10*size, WithEvictionBatchSize[string, string](4))
+	for i := 0; i < 11; i++ {
+		st.Put(fmt.Sprintf("k%04d", i), "x")
+	}
+	if got := st.ByteSize(); got != 7*size {
+		t.Fatalf("the store holds %d bytes, want 7 entries of %d after evicting a batch of 4", got, size)
+	}
+}
+
+// BenchmarkEviction compares evicting one key per write once the store is full with evicting down to a low
+// watermark, see the p99 and max metrics for the latency spread.
+func BenchmarkEviction(b *testing.B) {
+	const entries = 10000
+	value := strings.Repeat("x", 100)
+	size := entrySize("k0000000", value)
+
+	for _, bc := range []struct {
+		name string
+		opts []Option[string, string]
+	}{
+		{"per-op", nil},
+		{"batched", []Option[string, string]{WithEvictionWatermarks[string, string](entries*size, entries*size*9/10)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			st := NewKVStoreWithByteCapacity[string, string](entries*size, bc.opts...)
+			for i := 0; i < entries; i++ {
+				st.Put(fmt.Sprintf("k%07d", i), value)
+			}
+			latencies := make([]time.Duration, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				st.Put(fmt.Sprintf("k%07d", entries+i), value)
+				latencies[i] = time.Since(start)
+			}
+			b.StopTimer()
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+			b.ReportMetric(float64(latencies[len(latencies)-1]), "max-ns")
+		})
+	}
+}
+
+func TestMemoryLimitRejectsWrites(t *testing.T) {
+	per := entrySize("key0", "0123456789") + entryOverhead
+	st := NewKVStore[string, string](WithMemoryLimit[string, string](3 * per))
+	for i := 0; i < 3; i++ {
+		if err := st.Put(fmt.Sprint("key", i), "0123456789"); err != nil {
+			t.Fatalf("put %d within the limit: %v", i, err)
+		}
+	}
+	if n := st.MemoryUsage(); n != 3*per {
+		t.Fatalf("the estimate is %d bytes, want %d", n, 3*per)
+	}
+
+	if err := st.Put("key3", "0123456789"); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("got %v over the limit, want ErrMemoryLimit", err)
+	}
+	// Replacing a value counts only the difference.
+	if err := st.Put("key0", "9876543210"); err != nil {
+		t.Fatalf("an overwrite of the same size: %v", err)
+	}
+	if err := st.Put("key0", "0123456789+"); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("got %v for a bigger value", err)
+	}
+	st.Delete("key0")
+	if err := st.Put("key3", "0123456789"); err != nil {
+		t.Fatalf("the delete didn't free its memory: %v", err)
+	}
+	if err := NewKVStore[string, string](WithMemoryLimit[string, string](per)).Put("key0", strings.Repeat("x", per)); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("got %v for an entry over the whole limit", err)
+	}
+
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/key4", "0123456789"), http.StatusInsufficientStorage)
+}
+
+func TestMemoryLimitEvicts(t *testing.T) {
+	per := entrySize("key0", "0123456789") + entryOverhead
+	st := NewKVStoreWithByteCapacity[string, string](1<<20, WithMemoryLimit[string, string](3*per))
+
+	for i := 0; i < 10; i++ {
+		if err := st.Put(fmt.Sprint("key", i), "0123456789"); err != nil {
+			t.Fatalf("put %d: %v, the eviction should make room", i, err)
+		}
+		if n := st.MemoryUsage(); n > 3*per {
+			t.Fatalf("put %d: the store takes %d bytes, over the limit of %d", i, n, 3*per)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		_, err := st.Get(fmt.Sprint("key", i))
+		if evicted := errors.Is(err, ErrKeyNotFound); evicted != (i < 7) {
+			t.Fatalf("key%d: got %v, want only the 3 most recent keys kept", i, err)
+		}
+	}
+}
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 // We are using generics, K is any type that is comparable so that we can perform equality and relational operations.
@@ -16,20 +29,315 @@ type Storer[K comparable, V any] interface {
 	Delete(K) (V, error)
 }
 
+// ErrKeyNotFound is wrapped by the errors returned for a missing key, so callers can check for it with errors.Is.
+var ErrKeyNotFound = errors.New("does not exist")
+
+// ErrStoreFull is returned by Put when a store created with NewKVStoreWithMaxKeys already holds its maximum number of keys.
+var ErrStoreFull = errors.New("the store is full")
+
+// ErrKeyExists is wrapped by the errors returned when an operation refuses to overwrite an existing key.
+var ErrKeyExists = errors.New("already exists")
+
+// ErrInvalidKey wraps the error of the key validator set with WithKeyValidator.
+var ErrInvalidKey = errors.New("invalid key")
+
+// ErrKeyTooLong is returned by the writes of a store created with WithMaxKeyLength for a key over the limit.
+var ErrKeyTooLong = errors.New("key too long")
+
 // KVStore is succesfully implementing the Storer interface because it implements all the methods mentioned in the interface.
 type KVStore[K comparable, V any] struct {
-	mu   sync.RWMutex
+	mu sync.RWMutex
+	// lockWaits is the lock wait histogram, nil unless the store was created with WithLockMetrics.
+	lockWaits *histogram
+
 	data map[K]V
+	meta map[K]*entryMeta
+	// peakKeys is the most keys the maps held since they were last rebuilt, see Compact.
+	peakKeys int
+
+	// maxKeys is the hard limit on the number of keys, 0 means unlimited.
+	maxKeys int
+
+	// maxBytes is the capacity of a store created with NewKVStoreWithByteCapacity, bytes is how much of it is used
+	// and lru orders the keys for eviction.
+	maxBytes int
+	bytes    int
+	lru      *lruList[K]
+	// memoryLimit is set by WithMemoryLimit, the memory is estimated from bytes.
+	memoryLimit int
+	// The watermarks and batch size set with WithEvictionWatermarks and WithEvictionBatchSize, 0 means the default.
+	highWatermark int
+	lowWatermark  int
+	evictionBatch int
+
+	keyCodec   Codec[K]
+	valueCodec Codec[V]
+
+	keyValidator func(K) error
+	// caseInsensitiveKeys is set by WithCaseInsensitiveKeys.
+	caseInsensitiveKeys bool
+	// aliases maps each alias to the key it points to, it has its own lock since the keys are resolved before the
+	// lock of the store is taken. hasAliases saves the lookups until the first Alias.
+	aliasMu      sync.RWMutex
+	aliases      map[K]K
+	hasAliases   atomic.Bool
+	aliasCascade bool
+	// schema is set by WithValueSchema.
+	schema *jsonSchema
+	// webhook is set by WithEvictionWebhook.
+	webhook *webhook
+	// valueIndex is set by WithValueIndex and WithNumericValueIndex.
+	valueIndex *valueIndex[K, V]
+	// maxKeyLength is set by WithMaxKeyLength, 0 means unlimited.
+	maxKeyLength int
+
+	// snapshotKey signs the snapshots, set with WithSnapshotKey.
+	snapshotKey []byte
+	// legacySnapshots is set by WithLegacySnapshots.
+	legacySnapshots bool
+
+	// bloom is the filter of a store created with NewKVStoreWithBloom, it's nil otherwise.
+	bloom     atomic.Pointer[bloomFilter]
+	bloomKeys int
+	bloomFP   float64
+
+	// interns holds the shared copies of the values of a store created with NewKVStoreWithInterning.
+	interns map[string]*internEntry
+
+	putMode PutMode
+
+	// swapAbsentAsZero makes Swap treat a missing key as holding the zero value instead of failing.
+	swapAbsentAsZero bool
+	// writeGrace is set by WithWriteGracePeriod.
+	writeGrace time.Duration
+	// tombstones holds when the keys were deleted for WithTombstones, it's nil without it.
+	tombstones         map[K]time.Time
+	tombstoneRetention time.Duration
+
+	logger  Logger
+	clock   Clock
+	metrics MetricsSink
+
+	wal                io.Writer
+	walMode            WALMode
+	durabilityDegraded atomic.Bool
+	// persistence is set by WithPersistence, it owns the WAL then.
+	persistence *persistence[K, V]
+
+	watchers *watchHub[K, V]
+	// changes is the change stream of a primary, set with WithChangeLog.
+	changes  *ChangeLog[K, V]
+	computes computeGroup[K, V]
+	// epoch fences the writes of the previous primaries, fenceMu is held for reading by the fenced writes.
+	epoch   atomic.Uint64
+	fenceMu sync.RWMutex
+	// loader is set by WithLoader, Get calls it on a miss.
+	loader func(K) (V, bool, error)
+
+	// expires holds the expiration time of the keys that have a TTL, the sweeper removes them once they're due.
+	expires       map[K]time.Time
+	defaultTTL    time.Duration
+	sweepInterval time.Duration
+	sweepStrategy SweepStrategy
+	sweepOnce     sync.Once
+	sweepWG       sync.WaitGroup
+	// sweepReset hands a new sweep interval set by Reconfigure to the sweeper.
+	sweepReset chan time.Duration
+	stop       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Option configures the optional behaviour of a KVStore, pass them to NewKVStore.
+type Option[K comparable, V any] func(*KVStore[K, V])
+
+// WithCodec sets the codecs used to encode keys and values in snapshots, the default is JSONCodec.
+func WithCodec[K comparable, V any](keyCodec Codec[K], valueCodec Codec[V]) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.keyCodec = keyCodec
+		s.valueCodec = valueCodec
+	}
+}
+
+// WithKeyValidator makes every write check its key with validate first, a failing key is rejected with ErrInvalidKey.
+func WithKeyValidator[K comparable, V any](validate func(K) error) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.keyValidator = validate
+	}
+}
+
+// WithMaxKeyLength makes every write reject the keys longer than n bytes with ErrKeyTooLong, the length is the
+// one of the key formatted as a string. The default is unlimited.
+func WithMaxKeyLength[K comparable, V any](n int) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.maxKeyLength = n
+	}
+}
+
+// PutMode decides what Put does depending on whether the key already exists.
+type PutMode int
+
+const (
+	// Upsert creates or overwrites the key, it's the default.
+	Upsert PutMode = iota
+	// CreateOnly fails with ErrKeyExists if the key exists, like PutIfAbsent.
+	CreateOnly
+	// UpdateOnly fails with ErrKeyNotFound if the key doesn't exist, like Update.
+	UpdateOnly
+)
+
+// WithPutMode sets the semantics of Put and PutWithTTL.
+func WithPutMode[K comparable, V any](mode PutMode) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.putMode = mode
+	}
+}
+
+// WithSwapAbsentAsZero makes Swap treat a missing key as holding the zero value of V, instead of failing with ErrKeyNotFound.
+func WithSwapAbsentAsZero[K comparable, V any]() Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.swapAbsentAsZero = true
+	}
+}
+
+// WithStoreLogger sets the logger used by the store's background tasks, the default logs to stderr.
+func WithStoreLogger[K comparable, V any](l Logger) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.logger = l
+	}
 }
 
 // *KVStore[K, V] indicates that the function returns a pointer to a Storer instance.
 // &KVStore[K, V] line creates a new instance of KVStore and returns its address.
 // The & operator is used to get the address of the newly created Storer instance.
 // NewKVStore is a Constructor Function, it creates and initializes a new KVStore instance.
-func NewKVStore[K comparable, V any]() *KVStore[K, V] {
-	return &KVStore[K, V]{
-		data: make(map[K]V),
+func NewKVStore[K comparable, V any](opts ...Option[K, V]) *KVStore[K, V] {
+	s := &KVStore[K, V]{
+		data:          make(map[K]V),
+		meta:          make(map[K]*entryMeta),
+		keyCodec:      JSONCodec[K]{},
+		valueCodec:    JSONCodec[V]{},
+		expires:       make(map[K]time.Time),
+		watchers:      newWatchHub[K, V](),
+		logger:        defaultLogger,
+		clock:         realClock{},
+		metrics:       NopSink{},
+		sweepInterval: defaultSweepInterval,
+		sweepReset:    make(chan time.Duration, 1),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if p := s.persistence; p != nil {
+		if p.err = p.open(s); p.err != nil {
+			s.logger.Error("recovering the store from %s failed: %v", p.dir, p.err)
+		}
+	}
+	return s
+}
+
+// entryMeta is the bookkeeping kept next to every value.
+// version is only touched under the write lock, the access fields are atomics because Get updates them under the read lock.
+type entryMeta struct {
+	version uint64
+	size    int
+	// lastWrite is only recorded by the stores created WithWriteGracePeriod.
+	lastWrite   time.Time
+	lastAccess  atomic.Int64
+	accessCount atomic.Uint64
+}
+
+func (m *entryMeta) recordAccess(now time.Time) {
+	m.lastAccess.Store(now.UnixNano())
+	m.accessCount.Add(1)
+}
+
+// bumpVersion records a write to the key, must be called with the write lock held.
+func (s *KVStore[K, V]) bumpVersion(key K) {
+	m, ok := s.meta[key]
+	if !ok {
+		m = &entryMeta{}
+		s.meta[key] = m
+	}
+	m.version++
+	if s.writeGrace > 0 {
+		m.lastWrite = s.clock.Now()
+	}
+}
+
+// set stores the value and notifies the watchers, must be called with the write lock held.
+func (s *KVStore[K, V]) set(key K, value V) {
+	if b := s.bloom.Load(); b != nil {
+		b.add(keyString(key))
+	}
+	if old, ok := s.data[key]; ok {
+		s.release(old)
+		if s.valueIndex != nil {
+			s.valueIndex.remove(key, old)
+		}
+	}
+	value = s.intern(value)
+	s.data[key] = value
+	s.peakKeys = max(s.peakKeys, len(s.data))
+	if s.valueIndex != nil {
+		s.valueIndex.add(key, value)
+	}
+	s.bumpVersion(key)
+	s.account(key, value)
+	s.notify(EventPut, key, value)
+	s.evictOverCapacity(key)
+}
+
+// remove deletes the key and everything tracked about it, must be called with the write lock held.
+func (s *KVStore[K, V]) remove(key K) {
+	if m, ok := s.meta[key]; ok {
+		s.bytes -= m.size
+	}
+	if s.lru != nil {
+		s.lru.remove(key)
+	}
+	if value, ok := s.data[key]; ok {
+		s.release(value)
+		if s.valueIndex != nil {
+			s.valueIndex.remove(key, value)
+		}
+	}
+	delete(s.data, key)
+	delete(s.meta, key)
+	delete(s.expires, key)
+}
+
+// validateKey checks the length of the key and runs the key validator if there is one, it's called before
+// taking the lock.
+func (s *KVStore[K, V]) validateKey(key K) error {
+	if s.maxKeyLength > 0 {
+		if n := len(keyString(key)); n > s.maxKeyLength {
+			return fmt.Errorf("%w: it's %d bytes long, at most %d are allowed", ErrKeyTooLong, n, s.maxKeyLength)
+		}
+	}
+	if s.keyValidator == nil {
+		return nil
 	}
+	if err := s.keyValidator(key); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return nil
+}
+
+func keyNotFound[K comparable](key K) error {
+	return fmt.Errorf("the key (%v) %w", key, ErrKeyNotFound)
+}
+
+func keyExists[K comparable](key K) error {
+	return fmt.Errorf("the key (%v) %w", key, ErrKeyExists)
+}
+
+// NewKVStoreWithMaxKeys creates a KVStore that holds at most maxKeys keys.
+// Once full, Put on a new key returns ErrStoreFull instead of evicting anything, updates to existing keys are still allowed.
+func NewKVStoreWithMaxKeys[K comparable, V any](maxKeys int) *KVStore[K, V] {
+	s := NewKVStore[K, V]()
+	s.maxKeys = maxKeys
+	return s
 }
 
 // Note: Has function is not concurrent safe, should be used with a lock/mutex.
@@ -40,52 +348,405 @@ func (s *KVStore[K, V]) Has(key K) bool {
 
 // Put is a method defined on the KVStore struct
 func (s *KVStore[K, V]) Put(key K, value V) error {
-	s.mu.Lock()
+	_, _, err := s.PutReturning(key, value)
+	return err
+}
+
+// PutReturning is Put also returning the value it replaced, existed is false if the key was missing or expired.
+func (s *KVStore[K, V]) PutReturning(key K, value V) (previous V, existed bool, err error) {
+	return s.putReturning(key, value, false)
+}
+
+// ForcePut is PutReturning ignoring the grace period of a store created WithWriteGracePeriod.
+func (s *KVStore[K, V]) ForcePut(key K, value V) (previous V, existed bool, err error) {
+	return s.putReturning(key, value, true)
+}
+
+func (s *KVStore[K, V]) putReturning(key K, value V, force bool) (previous V, existed bool, err error) {
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return previous, false, err
+	}
+
+	s.lock()
 	defer s.mu.Unlock()
 
-	s.data[key] = value
+	if err := s.checkPutMode(key); err != nil {
+		return previous, false, err
+	}
+	if !force {
+		if err := s.checkGracePeriod(key); err != nil {
+			return previous, false, err
+		}
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return previous, false, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return previous, false, err
+	}
+	previous, existed = s.lookup(key)
+	s.set(key, value)
+	// A plain Put replaces the entry, including its TTL, the key gets the default TTL if there is one.
+	s.setTTL(key, s.defaultTTL)
+
+	return previous, existed, nil
+}
 
+// ErrTooSoon is returned by the writes to a key within the grace period of a store created WithWriteGracePeriod.
+var ErrTooSoon = errors.New("the key was written too recently")
+
+// WithWriteGracePeriod rejects the Puts and Updates of a key written less than d ago with ErrTooSoon, to protect
+// keys like configs from a client writing twice by mistake. ForcePut goes through anyway. The grace period
+// starts over with every write, creating the key included.
+func WithWriteGracePeriod[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.writeGrace = d
+	}
+}
+
+// checkGracePeriod enforces WithWriteGracePeriod, must be called with the lock held.
+func (s *KVStore[K, V]) checkGracePeriod(key K) error {
+	if s.writeGrace <= 0 {
+		return nil
+	}
+	m, ok := s.meta[key]
+	if _, exists := s.lookup(key); !exists || !ok || m.lastWrite.IsZero() {
+		return nil
+	}
+	if left := s.writeGrace - s.clock.Now().Sub(m.lastWrite); left > 0 {
+		return fmt.Errorf("%w: (%v) can be written again in %s", ErrTooSoon, key, left)
+	}
+	return nil
+}
+
+// ForcePutter is implemented by stores that can put a key regardless of its write grace period.
+type ForcePutter[K comparable, V any] interface {
+	ForcePut(K, V) (V, bool, error)
+}
+
+// ReturningPutter is implemented by stores that can return the value a Put replaced.
+type ReturningPutter[K comparable, V any] interface {
+	PutReturning(K, V) (V, bool, error)
+}
+
+// checkPutMode enforces the PutMode of the store, must be called with the lock held.
+func (s *KVStore[K, V]) checkPutMode(key K) error {
+	_, exists := s.lookup(key)
+	switch {
+	case s.putMode == CreateOnly && exists:
+		return keyExists(key)
+	case s.putMode == UpdateOnly && !exists:
+		return keyNotFound(key)
+	}
 	return nil
 }
 
+// upsert atomically replaces the value of the key with fn(old value, whether it existed).
+// It follows the same rules as Put for new keys.
+func (s *KVStore[K, V]) upsert(key K, fn func(V, bool) (V, error)) (V, error) {
+	if err := s.validateKey(key); err != nil {
+		var zero V
+		return zero, err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.lookup(key)
+	if !s.Has(key) && s.maxKeys > 0 && len(s.data) >= s.maxKeys {
+		return old, ErrStoreFull
+	}
+	value, err := fn(old, ok)
+	if err != nil {
+		return old, err
+	}
+	if err := s.validateValue(value); err != nil {
+		return old, err
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return old, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return old, err
+	}
+	if !ok {
+		// The key was missing or expired, the new entry starts with the default TTL.
+		s.setTTL(key, s.defaultTTL)
+	}
+	s.set(key, value)
+
+	return value, nil
+}
+
+// Get treats a key whose TTL has elapsed as missing even if the sweeper hasn't removed it yet, and removes it on the spot.
 func (s *KVStore[K, V]) Get(key K) (V, error) {
-	s.mu.RLock()
+	key = s.canon(key)
+	value, _, err := s.get(key)
+	if s.loader != nil && errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrKeyDeleted) {
+		return s.load(key)
+	}
+	return value, err
+}
+
+// get is Get also returning the remaining TTL of the key, zero if it has none. It doesn't go through the loader.
+func (s *KVStore[K, V]) get(key K) (V, time.Duration, error) {
+	if b := s.bloom.Load(); b != nil && !b.mayContain(keyString(key)) {
+		s.metrics.Counter("kv_get_misses_total", 1)
+		var zero V
+		return zero, 0, keyNotFound(key)
+	}
+
+	var ttl time.Duration
+	s.rlock()
+	value, ok := s.lookup(key)
+	if ok {
+		s.recordAccess(key)
+		s.metrics.Counter("kv_get_hits_total", 1)
+		if expiresAt, hasTTL := s.expires[key]; hasTTL {
+			ttl = expiresAt.Sub(s.clock.Now())
+		}
+	} else {
+		s.metrics.Counter("kv_get_misses_total", 1)
+	}
+	expired := !ok && s.Has(key)
+	deleted := !ok && s.tombstones != nil && s.deleted(key)
+	s.mu.RUnlock()
+
+	if expired {
+		s.removeIfExpired(key)
+	}
+	if deleted {
+		return value, 0, keyDeleted(key)
+	}
+	if !ok {
+		return value, 0, keyNotFound(key)
+	}
+
+	return value, ttl, nil
+}
+
+// Load is Get for the callers that only care whether the key is there, like sync.Map's Load: it returns the
+// value and true on a hit, and the zero value and false on a miss. A loader failing counts as a miss, use Get to
+// tell them apart.
+func (s *KVStore[K, V]) Load(key K) (V, bool) {
+	value, err := s.Get(key)
+	if err != nil {
+		var zero V
+		return zero, false
+	}
+	return value, true
+}
+
+// Peek is like Get but doesn't count as an access, the LRU order and the access stats are left untouched.
+func (s *KVStore[K, V]) Peek(key K) (V, error) {
+	key = s.canon(key)
+	s.rlock()
 	defer s.mu.RUnlock()
 
-	value, ok := s.data[key]
+	value, ok := s.lookup(key)
 	if !ok {
-		return value, fmt.Errorf("the key (%v) does not exist", key)
+		return value, keyNotFound(key)
 	}
 
 	return value, nil
 }
 
 func (s *KVStore[K, V]) Update(key K, value V) error {
-	s.mu.Lock()
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return err
+	}
+
+	s.lock()
 	defer s.mu.Unlock()
 
-	if !s.Has(key) {
-		return fmt.Errorf("the key (%v) does not exist", key)
+	if _, ok := s.lookup(key); !ok {
+		return keyNotFound(key)
 	}
-	s.data[key] = value
+	if err := s.checkGracePeriod(key); err != nil {
+		return err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return err
+	}
+	s.set(key, value)
 
 	return nil
 }
 
 func (s *KVStore[K, V]) Delete(key K) (V, error) {
-	s.mu.Lock()
+	key = s.canon(key)
+	s.lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.data[key]
+	value, ok := s.lookup(key)
 	if !ok {
-		return value, fmt.Errorf("the key (%v) does not exist", key)
+		if s.Has(key) {
+			s.expire(key)
+		}
+		return value, keyNotFound(key)
+	}
+	if err := s.logDelete(key); err != nil {
+		var zero V
+		return zero, err
 	}
 
-	delete(s.data, key)
+	s.remove(key)
+	s.notify(EventDelete, key, value)
 
 	return value, nil
 }
 
+// Rename atomically moves the value of oldKey to newKey, along with its version and TTL.
+// If newKey already exists Rename fails with ErrKeyExists, unless overwrite is set.
+func (s *KVStore[K, V]) Rename(oldKey, newKey K, overwrite bool) error {
+	oldKey, newKey = s.canon(oldKey), s.canon(newKey)
+	if err := s.validateKey(newKey); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.lookup(oldKey)
+	if !ok {
+		return keyNotFound(oldKey)
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if _, exists := s.lookup(newKey); exists && !overwrite {
+		return keyExists(newKey)
+	}
+
+	err := s.appendWAL(walRecord[K, V]{op: walDelete, key: oldKey}, walRecord[K, V]{op: walPut, key: newKey, value: value})
+	if err != nil {
+		return err
+	}
+
+	meta := s.meta[oldKey]
+	expiresAt, hasTTL := s.expires[oldKey]
+	s.remove(oldKey)
+	s.remove(newKey)
+	s.notify(EventDelete, oldKey, value)
+
+	if b := s.bloom.Load(); b != nil {
+		b.add(keyString(newKey))
+	}
+	s.data[newKey] = s.intern(value)
+	if s.valueIndex != nil {
+		s.valueIndex.add(newKey, value)
+	}
+	s.meta[newKey] = meta
+	// remove already released the size of the old key, the entry is accounted again under its new key.
+	meta.size = 0
+	s.account(newKey, value)
+	if hasTTL {
+		s.expires[newKey] = expiresAt
+	}
+	s.notify(EventPut, newKey, value)
+
+	return nil
+}
+
+// Swap atomically exchanges the values of the two keys, their TTLs stay with the keys.
+// Both keys must exist, unless the store was created with WithSwapAbsentAsZero.
+func (s *KVStore[K, V]) Swap(keyA, keyB K) error {
+	keyA, keyB = s.canon(keyA), s.canon(keyB)
+	s.lock()
+	defer s.mu.Unlock()
+
+	a, okA := s.lookup(keyA)
+	b, okB := s.lookup(keyB)
+	if !s.swapAbsentAsZero {
+		if !okA {
+			return keyNotFound(keyA)
+		}
+		if !okB {
+			return keyNotFound(keyB)
+		}
+	}
+	if keyA == keyB {
+		return nil
+	}
+	if s.maxKeys > 0 {
+		newKeys := 0
+		for _, key := range []K{keyA, keyB} {
+			if !s.Has(key) {
+				newKeys++
+			}
+		}
+		if len(s.data)+newKeys > s.maxKeys {
+			return ErrStoreFull
+		}
+	}
+
+	err := s.appendWAL(walRecord[K, V]{op: walPut, key: keyA, value: b}, walRecord[K, V]{op: walPut, key: keyB, value: a})
+	if err != nil {
+		return err
+	}
+
+	// A missing or expired key starts again without a TTL.
+	if !okA {
+		delete(s.expires, keyA)
+	}
+	if !okB {
+		delete(s.expires, keyB)
+	}
+	s.set(keyA, b)
+	s.set(keyB, a)
+
+	return nil
+}
+
+// PutIfAbsent stores the value only if the key doesn't exist yet, and reports whether it did.
+func (s *KVStore[K, V]) PutIfAbsent(key K, value V) (bool, error) {
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return false, err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.Has(key) && !s.isExpired(key) {
+		return false, nil
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return false, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return false, err
+	}
+	s.set(key, value)
+	s.setTTL(key, s.defaultTTL)
+
+	return true, nil
+}
+
+// DeleteIf deletes the key only if pred returns true for its current value, and reports whether it did.
+func (s *KVStore[K, V]) DeleteIf(key K, pred func(V) bool) (bool, error) {
+	key = s.canon(key)
+	s.lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.lookup(key)
+	if !ok {
+		return false, keyNotFound(key)
+	}
+	if !pred(value) {
+		return false, nil
+	}
+	if err := s.logDelete(key); err != nil {
+		return false, err
+	}
+	s.remove(key)
+	s.notify(EventDelete, key, value)
+
+	return true, nil
+}
+
 // type Server struct {
 // 	Store Storer[string, string]
 // }
@@ -132,15 +793,156 @@ func (s *KVStore[K, V]) Delete(key K) (V, error) {
 type Server struct {
 	Storage    Storer[string, string]
 	ListenAddr string
+
+	metrics MetricsSink
+
+	// maxHeavyRequests is how many heavy requests can run at once, see WithMaxHeavyRequests.
+	maxHeavyRequests int
+	// keyRate and keyBurst are the per key rate limit and maxRateLimitedKeys how many buckets are kept, see
+	// WithKeyRateLimit.
+	keyRate            float64
+	keyBurst           int
+	maxRateLimitedKeys int
+	// keyClock is set by WithKeyRateLimitClock, nil means the real clock.
+	keyClock Clock
+	// maxWait caps the ?wait= of the long polls, maxWaitersPerKey and maxWaiters how many can wait at once, see
+	// WithMaxWait and WithMaxWaiters.
+	maxWait          time.Duration
+	maxWaitersPerKey int
+	maxWaiters       int
+	waiters          waiterCount
+	// valueDecoders and fallbackContentType are set by WithValueDecoder and WithFallbackContentType, nil means
+	// the defaultValueDecoders.
+	valueDecoders       map[string]ValueDecoder
+	fallbackContentType string
+	// bodyCodecs are set by WithBodyCodec, by content type.
+	bodyCodecs map[string]Codec[string]
+
+	// changes is served to the replicas when set with WithReplicationSource, replica is set by WithReplica.
+	changes *ChangeLog[string, string]
+	replica *Replica
+
+	// accessLog, redactLogs and redactResponses are set by WithAccessLog, WithRedactedLogs and WithRedactedResponses.
+	accessLog       bool
+	redactLogs      bool
+	redactResponses bool
+
+	// legacyRoutes is set by WithLegacyGetRoutes.
+	legacyRoutes bool
+	// minimalResponses is set by WithMinimalResponses.
+	minimalResponses bool
+
+	// allowedOps is set by WithAllowedOps, nil allows everything.
+	allowedOps map[OpType]bool
+
+	// journal is set by WithJournal and slowlog by WithSlowLog, nil when they're disabled. journalValues is set
+	// by WithJournalValues, whichever of the two options comes first.
+	slowlog       *slowLog
+	journal       *journal
+	journalValues bool
+
+	// getTyped is set by WithTypedStore, GET /get/:key reads from it instead of Storage.
+	getTyped func(key string) (any, error)
+	// setFields is set by WithTypedStore for a store implementing FieldSetter.
+	setFields func(key string, fields map[string]json.RawMessage) (any, error)
+
+	// debug enables GET /debug/:key, it's off by default because it exposes internal state.
+	debug bool
+	// apiKey and adminKey are set by WithAPIKey and WithAdminKey.
+	apiKey   string
+	adminKey string
+
+	maxMGetKeys    int
+	batchTimeout   time.Duration
+	maxBatchItems  int
+	maxScanResults int
+	maxEntries     int
+
+	// maxBodySize is the largest request body accepted in bytes, 0 means unlimited (the default).
+	maxBodySize int64
+	// maxValueSize is the largest value accepted in bytes, 0 means unlimited (the default).
+	maxValueSize int
+
+	logger Logger
+
+	// idempotency caches the responses of the requests carrying an Idempotency-Key header.
+	idempotency    *KVStore[string, idempotentResponse]
+	idempotencyTTL time.Duration
+
+	// echo is the instance serving since Listen, Stop shuts it down within drainTimeout. closed is set by Stop,
+	// the server can't be started anymore afterwards.
+	mu           sync.Mutex
+	echo         *echo.Echo
+	closed       bool
+	drainTimeout time.Duration
+	// hooks are the shutdown steps registered with OnShutdown.
+	hooks []shutdownHook
+}
+
+// ServerOption configures the optional behaviour of a Server, pass them to NewServer.
+type ServerOption func(*Server)
+
+// WithLogger sets the logger of the server, the default logs to stderr.
+func WithLogger(l Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = l
+	}
 }
 
-func NewServer(listenAddr string) *Server {
-	return &Server{
-		Storage:    NewKVStore[string, string](),
-		ListenAddr: listenAddr,
+// WithDebug enables the GET /debug/:key endpoint.
+func WithDebug() ServerOption {
+	return func(s *Server) {
+		s.debug = true
 	}
 }
 
+// WithMaxBodySize rejects request bodies larger than n bytes with 413 Request Entity Too Large.
+// Bodies are unlimited by default so existing clients keep working, set it when the server is reachable by untrusted clients.
+func WithMaxBodySize(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// ErrValueTooLarge is returned for values over the limit set with WithMaxValueSize.
+var ErrValueTooLarge = errors.New("the value is too large")
+
+// WithMaxValueSize rejects values larger than n bytes with 413 Request Entity Too Large, values are unlimited by default.
+func WithMaxValueSize(n int) ServerOption {
+	return func(s *Server) {
+		s.maxValueSize = n
+	}
+}
+
+func (s *Server) checkValue(value string) error {
+	if s.maxValueSize > 0 && len(value) > s.maxValueSize {
+		return fmt.Errorf("%w (%d bytes, the limit is %d)", ErrValueTooLarge, len(value), s.maxValueSize)
+	}
+	return nil
+}
+
+func NewServer(listenAddr string, opts ...ServerOption) *Server {
+	s := &Server{
+		Storage:          NewKVStore[string, string](),
+		ListenAddr:       listenAddr,
+		maxMGetKeys:      defaultMaxMGetKeys,
+		maxBatchItems:    defaultMaxBatchItems,
+		maxScanResults:   defaultMaxScanResults,
+		maxEntries:       defaultMaxEntries,
+		maxHeavyRequests: defaultMaxHeavyRequests,
+		logger:           defaultLogger,
+		idempotency:      NewKVStore[string, idempotentResponse](),
+		idempotencyTTL:   defaultIdempotencyTTL,
+		drainTimeout:     defaultDrainTimeout,
+		maxWait:          defaultMaxWait,
+		metrics:          NopSink{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
 // // Basic HTTP server, without using any external frameworks listening on port 3000
 // func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 // 	w.WriteHeader(http.StatusOK)
@@ -155,22 +957,246 @@ func NewServer(listenAddr string) *Server {
 // 	log.Fatal(http.ListenAndServe(s.ListenAddr, nil))
 // }
 
+// toHTTPError maps the store's errors to the matching HTTP status, anything else is left to echo (500).
+func toHTTPError(err error) error {
+	var schemaErr *SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, map[string]any{
+			"message": ErrSchemaValidation.Error(),
+			"errors":  schemaErr.Errors,
+		})
+	case errors.Is(err, ErrSchemaValidation):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrKeyDeleted):
+		return echo.NewHTTPError(http.StatusGone, err.Error())
+	case errors.Is(err, ErrKeyNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidKey), errors.Is(err, ErrKeyTooLong):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrKeyExists), errors.Is(err, ErrStaleEpoch):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrVersionMismatch), errors.Is(err, ErrConditionFailed):
+		return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrValueTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrTooSoon):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrStoreFull), errors.Is(err, ErrWALWrite), errors.Is(err, ErrMemoryLimit):
+		return echo.NewHTTPError(http.StatusInsufficientStorage, err.Error())
+	}
+	return err
+}
+
 // Using the echo web framework.
 func (s *Server) handlePut(c echo.Context) error {
+	return s.put(c, c.Param("key"), c.Param("value"))
+}
+
+// WithLegacyGetRoutes serves the deprecated GET /put/:key/:value, GET /update/:key/:value and GET /delete/:key,
+// for the clients that don't use PUT and DELETE /kv/:key yet. Mutating on GET isn't safe: crawlers
+// and prefetchers follow links, and caches may answer them.
+func WithLegacyGetRoutes() ServerOption {
+	return func(s *Server) {
+		s.legacyRoutes = true
+	}
+}
+
+// WithMinimalResponses makes the successful puts, updates and deletes answer 204 No Content instead of a JSON
+// message. Without it a client gets the same per request by sending Prefer: return=minimal (RFC 7240), and with
+// it Prefer: return=representation brings the JSON message back.
+func WithMinimalResponses() ServerOption {
+	return func(s *Server) {
+		s.minimalResponses = true
+	}
+}
+
+// done answers a successful put, update or delete with body, or with 204 No Content if a minimal response is
+// preferred.
+func (s *Server) done(c echo.Context, body any) error {
+	minimal := s.minimalResponses
+	for _, pref := range strings.Split(c.Request().Header.Get("Prefer"), ",") {
+		switch strings.ToLower(strings.TrimSpace(pref)) {
+		case "return=minimal":
+			minimal = true
+			c.Response().Header().Set("Preference-Applied", "return=minimal")
+		case "return=representation":
+			minimal = false
+			c.Response().Header().Set("Preference-Applied", "return=representation")
+		}
+	}
+	if minimal {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, body)
+}
+
+// deprecated marks the responses of the legacy routes with a Deprecation header.
+func deprecated(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Deprecation", "true")
+		return next(c)
+	}
+}
+
+// handlePutKV serves PUT /kv/:key, the body is the value decoded according to its Content-Type, see
+// WithValueDecoder. With If-Match: * the key is only updated if it exists.
+func (s *Server) handlePutKV(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
 	key := c.Param("key")
-	value := c.Param("value")
+	value, err := s.decodeValue(c, body)
+	if err != nil {
+		return err
+	}
 
-	s.Storage.Put(key, value)
+	if c.Request().Header.Get("If-Match") != "*" {
+		return s.put(c, key, value)
+	}
+	if err := s.checkValue(value); err != nil {
+		return toHTTPError(err)
+	}
+	err = s.fence(c, func() error {
+		return updateWithContext(c.Request().Context(), s.Storage, key, value)
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
+	return s.done(c, map[string]string{"msg": "ok"})
+}
+
+// put stores the value for handlePut and handlePutKV, with ?returnOld=true the replaced value is returned and
+// with ?force=true the write grace period of the storage is ignored.
+func (s *Server) put(c echo.Context, key, value string) error {
+	if err := s.checkValue(value); err != nil {
+		return toHTTPError(err)
+	}
+	returnOld, force := c.QueryParam("returnOld") == "true", c.QueryParam("force") == "true"
+	if !returnOld && !force {
+		err := s.fence(c, func() error {
+			return putWithContext(c.Request().Context(), s.Storage, key, value)
+		})
+		if err != nil {
+			return toHTTPError(err)
+		}
+		return s.done(c, map[string]string{"msg": "ok"})
+	}
+
+	var put func(string, string) (string, bool, error)
+	if force {
+		forcer, ok := s.Storage.(ForcePutter[string, string])
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support forced puts")
+		}
+		put = forcer.ForcePut
+	} else {
+		putter, ok := s.Storage.(ReturningPutter[string, string])
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support returning the old value")
+		}
+		put = putter.PutReturning
+	}
+	var previous string
+	var existed bool
+	err := s.fence(c, func() error {
+		var err error
+		previous, existed, err = put(key, value)
+		return err
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if !returnOld {
+		return s.done(c, map[string]string{"msg": "ok"})
+	}
 
-	return c.JSON(http.StatusOK, map[string]string{"msg": "ok"})
+	body := map[string]any{"msg": "ok", "existed": existed}
+	if existed && !s.redactResponses {
+		body["previous"] = previous
+	}
+	return c.JSON(http.StatusOK, body)
 }
 
 func (s *Server) handleGet(c echo.Context) error {
 	key := c.Param("key")
 
-	value, err := s.Storage.Get(key)
+	if c.QueryParam("wait") != "" {
+		return s.handleLongPoll(c)
+	}
+
+	if s.getTyped != nil {
+		value, err := s.getTyped(key)
+		if err != nil {
+			return toHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, map[string]any{"value": value})
+	}
+
+	ctx := c.Request().Context()
+	var value string
+	var ttl time.Duration
+	var err error
+	switch getter := s.Storage.(type) {
+	case ContextTTLGetter[string, string]:
+		value, ttl, err = getter.GetWithTTLContext(ctx, key)
+	case TTLGetter[string, string]:
+		value, ttl, err = getter.GetWithTTL(key)
+	default:
+		value, err := getWithContext(ctx, s.Storage, key)
+		if err != nil {
+			return toHTTPError(err)
+		}
+		return s.respondValue(c, value, map[string]string{"value": value})
+	}
 	if err != nil {
-		return err
+		return toHTTPError(err)
+	}
+	body := map[string]any{"value": value}
+	if ttl > 0 {
+		// Caches may keep the value until the key expires, but not past it.
+		c.Response().Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl/time.Second)))
+		c.Response().Header().Set("Expires", time.Now().Add(ttl).UTC().Format(http.TimeFormat))
+		if c.QueryParam("withTTL") == "true" {
+			body["ttl_ms"] = ttl.Milliseconds()
+		}
+	}
+
+	return s.respondValue(c, value, body)
+}
+
+// WithTypedStore makes GET /get/:key read from a store of any value type, the value is encoded with its
+// natural JSON type, e.g. {"value": 42} for an int store instead of {"value": "42"}.
+// POST /kv/:key/fields sets fields in it too if it's a FieldSetter, the other endpoints keep using Storage.
+func WithTypedStore[V any](store Storer[string, V]) ServerOption {
+	return func(s *Server) {
+		s.getTyped = func(key string) (any, error) {
+			return store.Get(key)
+		}
+		if setter, ok := store.(FieldSetter[string, V]); ok {
+			s.setFields = func(key string, fields map[string]json.RawMessage) (any, error) {
+				return setter.SetFields(key, fields)
+			}
+		}
+	}
+}
+
+// Peeker is implemented by stores that can read a key without it counting as an access.
+type Peeker[K comparable, V any] interface {
+	Peek(K) (V, error)
+}
+
+// handlePeek serves GET /peek/:key, for monitoring reads that shouldn't affect eviction.
+func (s *Server) handlePeek(c echo.Context) error {
+	peeker, ok := s.Storage.(Peeker[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support peeking")
+	}
+
+	value, err := peeker.Peek(c.Param("key"))
+	if err != nil {
+		return toHTTPError(err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"value": value})
@@ -180,33 +1206,245 @@ func (s *Server) handleUpdate(c echo.Context) error {
 	key := c.Param("key")
 	value := c.Param("value")
 
-	s.Storage.Update(key, value)
+	if err := s.checkValue(value); err != nil {
+		return toHTTPError(err)
+	}
+	err := s.fence(c, func() error {
+		return updateWithContext(c.Request().Context(), s.Storage, key, value)
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
 
-	return c.JSON(http.StatusOK, map[string]string{"updated-value": value})
+	return s.mutated(c, map[string]string{"updated-value": value})
 }
 
 func (s *Server) handleDelete(c echo.Context) error {
 	key := c.Param("key")
 
-	s.Storage.Delete(key)
+	err := s.fence(c, func() error {
+		deleteWithContext(c.Request().Context(), s.Storage, key)
+		return nil
+	})
+	if err != nil {
+		return toHTTPError(err)
+	}
 
-	return c.JSON(http.StatusOK, map[string]string{"deleted-entry": key})
+	return s.done(c, map[string]string{"deleted-entry": key})
 }
 
-func (s *Server) Start() {
-	fmt.Printf("HTTP server is running on port %s", s.ListenAddr)
+// Renamer is implemented by stores that can move an entry to another key atomically.
+type Renamer[K comparable] interface {
+	Rename(oldKey, newKey K, overwrite bool) error
+}
+
+// Swapper is implemented by stores that can exchange the values of two keys atomically.
+type Swapper[K comparable] interface {
+	Swap(keyA, keyB K) error
+}
+
+// handleSwap serves POST /swap/:a/:b.
+func (s *Server) handleSwap(c echo.Context) error {
+	swapper, ok := s.Storage.(Swapper[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support swapping")
+	}
+
+	keyA, keyB := c.Param("a"), c.Param("b")
+	if err := s.fence(c, func() error { return swapper.Swap(keyA, keyB) }); err != nil {
+		return toHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"swapped": keyA, "with": keyB})
+}
+
+// handleRename serves POST /rename/:old/:new, ?overwrite=true replaces an existing destination.
+func (s *Server) handleRename(c echo.Context) error {
+	renamer, ok := s.Storage.(Renamer[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support renaming")
+	}
+
+	oldKey, newKey := c.Param("old"), c.Param("new")
+	overwrite := c.QueryParam("overwrite") == "true"
+
+	if err := s.fence(c, func() error { return renamer.Rename(oldKey, newKey, overwrite) }); err != nil {
+		return toHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"renamed-entry": oldKey, "new-key": newKey})
+}
 
+// router builds the echo instance with all the middlewares and routes of the server.
+func (s *Server) router() *echo.Echo {
 	e := echo.New()
+	// The server reports its own startup through its logger.
+	e.HideBanner = true
+	e.HidePort = true
 
-	e.GET("/put/:key/:value", s.handlePut)
-	e.GET("/get/:key", s.handleGet)
-	e.GET("/update/:key/:value", s.handleUpdate)
-	e.GET("/delete/:key", s.handleDelete)
+	e.Use(s.requestIDs)
+	if s.accessLog {
+		e.Use(s.logged)
+	}
+	e.Use(s.authenticated)
+	if s.maxBodySize > 0 {
+		e.Use(middleware.BodyLimit(strconv.FormatInt(s.maxBodySize, 10)))
+	}
+	read, write, del := s.allow(OpRead), s.allow(OpWrite), s.allow(OpDelete)
+	// The heavy endpoints share a single limit.
+	heavy := s.limited()
+
+	e.GET("/health", s.handleHealth)
+	e.GET("/readyz", s.handleReady)
+
+	if _, nop := s.metrics.(NopSink); !nop {
+		if h, ok := s.metrics.(http.Handler); ok {
+			e.GET("/metrics", echo.WrapHandler(h), read)
+		}
+		e.Use(s.instrumented)
+	}
+	// The admin routes, the older ones outside of /admin are kept for the existing clients.
+	admin := e.Group("/admin", s.adminOnly)
+	admin.POST("/config", s.handleConfig, write)
+	admin.POST("/flush", s.handleFlush, del)
+	admin.POST("/compact", s.handleCompact, write)
+	admin.POST("/gc", s.handleGC, write)
+	admin.POST("/promote", s.handlePromote, write)
+	admin.GET("/stats", s.handleStats, read)
+	e.GET("/stats", s.handleStats, read, s.adminOnly)
+	admin.GET("/stats/shards", s.handleShardStats, read)
+	e.GET("/stats/shards", s.handleShardStats, read, s.adminOnly)
+	if s.journal != nil {
+		admin.GET("/journal", s.handleJournal, read)
+		e.GET("/journal", s.handleJournal, read, s.adminOnly)
+		e.Use(s.journaled)
+	}
+	if s.slowlog != nil {
+		admin.GET("/slowlog", s.handleSlowlog, read)
+		e.GET("/slowlog", s.handleSlowlog, read, s.adminOnly)
+		e.Use(s.slowlogged)
+	}
+	if s.debug {
+		admin.GET("/debug/:key", s.handleDebug, read)
+		e.GET("/debug/:key", s.handleDebug, read, s.adminOnly)
+	}
+	if s.keyRate > 0 {
+		e.Use(s.keyLimited())
+	}
+
+	if s.changes != nil {
+		e.GET("/replication/changes", s.handleChanges, read)
+	}
+	if s.replica != nil {
+		e.GET("/replication/status", s.handleReplicationStatus, read)
+	}
+
+	e.GET("/get/:key", s.handleGet, read)
+	e.GET("/peek/:key", s.handlePeek, read)
+	e.GET("/mget", s.handleMGet, read)
+	e.POST("/count", s.handleCount, read)
+	e.GET("/scan", s.handleScan, read, heavy)
+	e.GET("/keys", s.handleKeys, read, heavy)
+	e.GET("/range", s.handleRange, read)
+	e.GET("/entries", s.handleEntries, read, heavy)
+	e.GET("/export", s.handleExport, read, heavy)
+	e.GET("/watch/prefix/:prefix", s.handleWatchPrefix, read)
+
+	e.GET("/kv/:key", s.handleGet, read)
+
+	// Mutations honor the Idempotency-Key header.
+	if s.legacyRoutes {
+		e.GET("/put/:key/:value", s.handlePut, write, deprecated, s.idempotent)
+		e.GET("/update/:key/:value", s.handleUpdate, write, deprecated, s.idempotent)
+		e.GET("/delete/:key", s.handleDelete, del, deprecated, s.idempotent)
+	}
+	e.PUT("/kv/:key", s.handlePutKV, write, s.idempotent)
+	e.POST("/batch/put", s.handleBatchPut, write, s.idempotent)
+	e.POST("/txn", s.handleTxn, write, s.idempotent)
+	e.POST("/batch/expire", s.handleBatchExpire, write, s.idempotent)
+	e.POST("/expire/:key", s.handleExpire, write, s.idempotent)
+	e.POST("/import", s.handleImport, write, heavy, s.idempotent)
+	e.POST("/rename/:old/:new", s.handleRename, write, s.idempotent)
+	e.POST("/swap/:a/:b", s.handleSwap, write, s.idempotent)
+	e.POST("/alias/:alias/:target", s.handleAlias, write, s.idempotent)
+	e.DELETE("/alias/:alias", s.handleUnalias, del, s.idempotent)
+	e.PATCH("/kv/:key", s.handlePatch, write, s.idempotent)
+	e.POST("/kv/:key/fields", s.handleSetFields, write, s.idempotent)
+	e.DELETE("/kv/:key", s.handleDeleteKV, del, s.idempotent)
+
+	e.POST("/incr/:key", s.handleIncr, write, s.idempotent)
+	e.POST("/lock/:name", s.handleLock, write, s.idempotent)
+	e.POST("/unlock/:name", s.handleUnlock, write, s.idempotent)
+
+	// The same routes with base64url encoded keys, for the keys that can't go through a path as is.
+	e.GET("/b64/get/:key", s.handleGet, read, b64Keys)
+	e.GET("/b64/kv/:key", s.handleGet, read, b64Keys)
+	e.PUT("/b64/kv/:key", s.handlePutKV, write, b64Keys, s.idempotent)
+	e.PATCH("/b64/kv/:key", s.handlePatch, write, b64Keys, s.idempotent)
+	e.DELETE("/b64/kv/:key", s.handleDeleteKV, del, b64Keys, s.idempotent)
+
+	return e
+}
+
+// Listen binds ListenAddr without serving yet, so that Addr tells the port picked for an address like ":0".
+// Start calls it if it wasn't already. It fails with http.ErrServerClosed once the server was stopped.
+func (s *Server) Listen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	e.Start(s.ListenAddr)
+	if s.closed {
+		return http.ErrServerClosed
+	}
+	if s.echo != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.echo = s.router()
+	s.echo.Listener = ln
+	return nil
+}
+
+// Addr returns the address the server listens on, nil before Listen or Start.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.echo == nil {
+		return nil
+	}
+	return s.echo.Listener.Addr()
+}
+
+func (s *Server) Start() {
+	if err := s.Listen(); err != nil {
+		if !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server couldn't listen: %v", err)
+		}
+		return
+	}
+	s.logger.Info("HTTP server is running on %s", s.Addr())
+
+	s.mu.Lock()
+	e := s.echo
+	s.mu.Unlock()
+
+	if err := e.Start(s.ListenAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("HTTP server stopped: %v", err)
+	}
 }
 
 func main() {
 	s := NewServer(":3000")
-	s.Start()
+	go s.Start()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	if err := s.Stop(); err != nil {
+		s.logger.Error("shutting down: %v", err)
+	}
 }
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer returns the handler of a server over st, built like Start builds it but without listening.
+func newTestServer(st Storer[string, string], opts ...ServerOption) (*Server, http.Handler) {
+	srv := NewServer(":0", append([]ServerOption{WithLogger(NopLogger{})}, opts...)...)
+	srv.Storage = st
+	return srv, srv.router()
+}
+
+// do sends a request to h, header is a list of name, value pairs.
+func do(t testing.TB, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectStatus fails t unless rec answered with status.
+func expectStatus(t testing.TB, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+}
+
+// waitFor polls cond until it holds, failing t after a few seconds.
+func waitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// goroutinesRunning returns how many goroutines have fn, like "startSweeper", in their stack.
+func goroutinesRunning(fn string) int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, fn) {
+			count++
+		}
+	}
+	return count
+}
+
+// recordingLogger keeps the messages logged through it.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) log(level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Info(format string, args ...any)  { l.log("INFO", format, args...) }
+func (l *recordingLogger) Warn(format string, args ...any)  { l.log("WARN", format, args...) }
+func (l *recordingLogger) Error(format string, args ...any) { l.log("ERROR", format, args...) }
+func (l *recordingLogger) Debug(format string, args ...any) { l.log("DEBUG", format, args...) }
+
+// contains reports whether a message containing substr was logged.
+func (l *recordingLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.messages {
+		if strings.Contains(m, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMaxKeysRejectsNewKeys(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](2)
+	st.Put("a", "1")
+	st.Put("b", "2")
+
+	if err := st.Put("c", "3"); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("got %v, want ErrStoreFull", err)
+	}
+	if err := st.Put("a", "updated"); err != nil {
+		t.Fatalf("updating a key of a full store: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := st.Get(key); err != nil {
+			t.Fatalf("%s was evicted: %v", key, err)
+		}
+	}
+
+	st.Delete("b")
+	if err := st.Put("c", "3"); err != nil {
+		t.Fatalf("the freed place wasn't reused: %v", err)
+	}
+}
+
+func TestMaxKeysOverHTTP(t *testing.T) {
+	_, h := newTestServer(NewKVStoreWithMaxKeys[string, string](1))
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/b", "2"), http.StatusInsufficientStorage)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string](), WithMaxBodySize(16))
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", strings.Repeat("x", 16)), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", strings.Repeat("x", 17)), http.StatusRequestEntityTooLarge)
+	expectStatus(t, do(t, h, http.MethodPost, "/import", strings.Repeat(`{"key":"k","value":"v"}`+"\n", 10)), http.StatusRequestEntityTooLarge)
+}
+
+func TestRename(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	st.Put("old", "1")
+	st.Put("old", "2")
+	st.Expire("old", time.Minute)
+
+	if err := st.Rename("old", "new", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get("old"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the old key is still there: %v", err)
+	}
+	info, err := st.Inspect("new")
+	if err != nil || info.Value != "2" || info.Version != 2 || info.TTLMillis == nil || *info.TTLMillis != time.Minute.Milliseconds() {
+		t.Fatalf("the entry wasn't moved with its metadata: %+v, %v", info, err)
+	}
+
+	if err := st.Rename("missing", "other", false); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a missing source", err)
+	}
+
+	st.Put("taken", "x")
+	if err := st.Rename("new", "taken", false); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("got %v for an existing destination", err)
+	}
+	if err := st.Rename("new", "taken", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("taken"); v != "2" {
+		t.Fatalf("got %q after overwriting", v)
+	}
+}
+
+func TestRenameOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/rename/a/c", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPost, "/rename/a/c", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPost, "/rename/c/b", ""), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPost, "/rename/c/b?overwrite=true", ""), http.StatusOK)
+	if v, _ := st.Get("b"); v != "1" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestKeyValidator(t *testing.T) {
+	errLong := errors.New("keys are at most 5 bytes")
+	st := NewKVStore[string, string](WithKeyValidator[string, string](func(key string) error {
+		if len(key) > 5 {
+			return errLong
+		}
+		return nil
+	}))
+
+	if err := st.Put("short", "1"); err != nil {
+		t.Fatal(err)
+	}
+	err := st.Put("too-long", "1")
+	if !errors.Is(err, ErrInvalidKey) || !errors.Is(err, errLong) {
+		t.Fatalf("got %v, want ErrInvalidKey wrapping the validator's error", err)
+	}
+	if err := st.Update("too-long", "1"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Update: got %v", err)
+	}
+
+	_, h := newTestServer(st)
+	rec := do(t, h, http.MethodPut, "/kv/too-long", "1")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), errLong.Error()) {
+		t.Fatalf("the validator's message isn't in %s", rec.Body)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		// The readers take both keys under a single read lock, they must never see the same value in both.
+		for {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			if got := st.GetMany([]string{"a", "b"}); got["a"] == got["b"] {
+				done <- fmt.Errorf("both keys hold %q", got["a"])
+				return
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if err := st.Swap("a", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := st.Get("a"); a != "1" {
+		t.Fatalf("got %q after an even number of swaps", a)
+	}
+	st.Swap("a", "b")
+	if a, _ := st.Get("a"); a != "2" {
+		t.Fatalf("got %q after swapping", a)
+	}
+
+	if err := st.Swap("a", "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a missing key", err)
+	}
+}
+
+func TestSwapAbsentAsZero(t *testing.T) {
+	st := NewKVStore[string, string](WithSwapAbsentAsZero[string, string]())
+	st.Put("a", "1")
+
+	if err := st.Swap("a", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Get("missing"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := st.Get("a"); err != nil || v != "" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestSwapOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/swap/a/b", ""), http.StatusOK)
+	if v, _ := st.Get("a"); v != "2" {
+		t.Fatalf("got %q", v)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/swap/a/c", ""), http.StatusNotFound)
+}
+
+func TestPutModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode             PutMode
+		existing, absent error
+		existingStatus   int
+		absentStatus     int
+	}{
+		{Upsert, nil, nil, http.StatusOK, http.StatusOK},
+		{CreateOnly, ErrKeyExists, nil, http.StatusConflict, http.StatusOK},
+		{UpdateOnly, nil, ErrKeyNotFound, http.StatusOK, http.StatusNotFound},
+	} {
+		// PutIfAbsent creates the keys whatever the mode.
+		st := NewKVStore[string, string](WithPutMode[string, string](tc.mode))
+		st.PutIfAbsent("existing", "1")
+		if err := st.Put("existing", "2"); !errors.Is(err, tc.existing) || (tc.existing == nil) != (err == nil) {
+			t.Errorf("mode %v: got %v putting an existing key, want %v", tc.mode, err, tc.existing)
+		}
+		if err := st.Put("absent", "2"); !errors.Is(err, tc.absent) || (tc.absent == nil) != (err == nil) {
+			t.Errorf("mode %v: got %v putting an absent key, want %v", tc.mode, err, tc.absent)
+		}
+
+		st = NewKVStore[string, string](WithPutMode[string, string](tc.mode))
+		st.PutIfAbsent("existing", "1")
+		_, h := newTestServer(st)
+		if rec := do(t, h, http.MethodPut, "/kv/existing", "2"); rec.Code != tc.existingStatus {
+			t.Errorf("mode %v: got %d putting an existing key over HTTP, want %d", tc.mode, rec.Code, tc.existingStatus)
+		}
+		if rec := do(t, h, http.MethodPut, "/kv/absent", "2"); rec.Code != tc.absentStatus {
+			t.Errorf("mode %v: got %d putting an absent key over HTTP, want %d", tc.mode, rec.Code, tc.absentStatus)
+		}
+	}
+}
+
+func TestPeekKeepsEvictionOrder(t *testing.T) {
+	st := NewKVStoreWithByteCapacity[string, string](3 * entrySize("a", "x"))
+	st.Put("a", "x")
+	st.Put("b", "x")
+	st.Put("c", "x")
+
+	// Peeking at the oldest key doesn't save it.
+	if v, err := st.Peek("a"); err != nil || v != "x" {
+		t.Fatalf("got %q, %v peeking", v, err)
+	}
+	st.Put("d", "x")
+	if _, err := st.Peek("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a survived the eviction: %v", err)
+	}
+
+	// Getting it does.
+	st.Get("b")
+	st.Put("e", "x")
+	if _, err := st.Peek("b"); err != nil {
+		t.Fatalf("b was evicted after a get: %v", err)
+	}
+	if _, err := st.Peek("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("c survived the eviction: %v", err)
+	}
+
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodGet, "/peek/b", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/peek/a", ""), http.StatusNotFound)
+}
+
+func TestTypedGet(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	ints := NewKVStore[string, int]()
+	ints.Put("n", 42)
+	users := NewKVStore[string, user]()
+	users.Put("ada", user{Name: "Ada", Age: 36})
+	slices := NewKVStore[string, []string]()
+	slices.Put("tags", []string{"a", "b"})
+
+	for _, tc := range []struct {
+		name string
+		opt  ServerOption
+		key  string
+		want string
+	}{
+		{"int", WithTypedStore[int](ints), "n", `{"value":42}`},
+		{"struct", WithTypedStore[user](users), "ada", `{"value":{"name":"Ada","age":36}}`},
+		{"slice", WithTypedStore[[]string](slices), "tags", `{"value":["a","b"]}`},
+	} {
+		_, h := newTestServer(NewKVStore[string, string](), tc.opt)
+		rec := do(t, h, http.MethodGet, "/get/"+tc.key, "")
+		expectStatus(t, rec, http.StatusOK)
+		if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+		expectStatus(t, do(t, h, http.MethodGet, "/get/absent", ""), http.StatusNotFound)
+	}
+}
+
+func TestPutReturning(t *testing.T) {
+	st := NewKVStore[string, string]()
+	if previous, existed, err := st.PutReturning("a", "1"); err != nil || existed || previous != "" {
+		t.Fatalf("first insert: got %q, %v, %v", previous, existed, err)
+	}
+	if previous, existed, err := st.PutReturning("a", "2"); err != nil || !existed || previous != "1" {
+		t.Fatalf("overwrite: got %q, %v, %v", previous, existed, err)
+	}
+	if v, _ := st.Get("a"); v != "2" {
+		t.Fatalf("got %q", v)
+	}
+
+	_, h := newTestServer(st)
+	rec := do(t, h, http.MethodPut, "/kv/b?returnOld=true", "1")
+	expectStatus(t, rec, http.StatusOK)
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"existed":false,"msg":"ok"}` {
+		t.Fatalf("first insert over HTTP: got %s", body)
+	}
+	rec = do(t, h, http.MethodPut, "/kv/b?returnOld=true", "2")
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"existed":true,"msg":"ok","previous":"1"}` {
+		t.Fatalf("overwrite over HTTP: got %s", body)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, *profile](WithClock[string, *profile](clock))
+	ada := &profile{Name: "Ada"}
+	st.Put("ada", ada)
+	st.Put("nil", nil)
+	st.PutWithTTL("temp", ada, time.Minute)
+
+	if v, ok := st.Load("ada"); !ok || v != ada {
+		t.Fatalf("got %v, %v for a hit", v, ok)
+	}
+	// A stored zero value is still a hit.
+	if v, ok := st.Load("nil"); !ok || v != nil {
+		t.Fatalf("got %v, %v for a nil value", v, ok)
+	}
+	if v, ok := st.Load("absent"); ok || v != nil {
+		t.Fatalf("got %v, %v for a miss", v, ok)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	if v, ok := st.Load("temp"); ok || v != nil {
+		t.Fatalf("got %v, %v for an expired key", v, ok)
+	}
+
+	errDown := errors.New("the source is down")
+	loaded := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		if key == "down" {
+			return "", false, errDown
+		}
+		return "loaded " + key, true, nil
+	}))
+	if v, ok := loaded.Load("a"); !ok || v != "loaded a" {
+		t.Fatalf("got %q, %v through the loader", v, ok)
+	}
+	if v, ok := loaded.Load("down"); ok || v != "" {
+		t.Fatalf("got %q, %v for a failing loader", v, ok)
+	}
+}
+
+func TestWriteGracePeriod(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithWriteGracePeriod[string, string](time.Minute))
+
+	if err := st.Put("config", "1"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	if err := st.Put("config", "2"); !errors.Is(err, ErrTooSoon) {
+		t.Fatalf("got %v, want ErrTooSoon", err)
+	}
+	if err := st.Update("config", "2"); !errors.Is(err, ErrTooSoon) {
+		t.Fatalf("Update: got %v, want ErrTooSoon", err)
+	}
+	if err := st.PutWithTTL("config", "2", time.Hour); !errors.Is(err, ErrTooSoon) {
+		t.Fatalf("PutWithTTL: got %v, want ErrTooSoon", err)
+	}
+	if v, _ := st.Get("config"); v != "1" {
+		t.Fatalf("a rejected write went through: %q", v)
+	}
+	// The other keys have their own grace period.
+	if err := st.Put("other", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if err := st.Put("config", "2"); err != nil {
+		t.Fatalf("after the grace period: %v", err)
+	}
+	// Which starts over with the write.
+	if err := st.Put("config", "3"); !errors.Is(err, ErrTooSoon) {
+		t.Fatalf("got %v right after a write", err)
+	}
+	if previous, existed, err := st.ForcePut("config", "3"); err != nil || !existed || previous != "2" {
+		t.Fatalf("ForcePut: got %q, %v, %v", previous, existed, err)
+	}
+
+	// A deleted key can be created again at once.
+	st.Delete("config")
+	if err := st.Put("config", "4"); err != nil {
+		t.Fatalf("recreating a deleted key: %v", err)
+	}
+}
+
+func TestWriteGracePeriodOverHTTP(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithWriteGracePeriod[string, string](time.Minute))
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/config", "1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/config", "2"), http.StatusTooManyRequests)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/config?force=true", "2"), http.StatusOK)
+	if v, _ := st.Get("config"); v != "2" {
+		t.Fatalf("got %q after the forced put", v)
+	}
+	clock.Advance(time.Minute)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/config", "3"), http.StatusOK)
+
+	// Without ForcePut there's no forcing a put.
+	_, h = newTestServer(&countingStore{Storer: NewKVStore[string, string]()})
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/config?force=true", "1"), http.StatusNotImplemented)
+}
+
+func TestVerbRoutes(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	rec := do(t, h, http.MethodGet, "/kv/a", "")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"value":"1"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "2"), http.StatusOK)
+	if v, _ := st.Get("a"); v != "2" {
+		t.Fatalf("got %q after the second PUT", v)
+	}
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusNotFound)
+
+	// The legacy routes are off by default, and no verb but GET reads a key.
+	st.Put("a", "1")
+	expectStatus(t, do(t, h, http.MethodGet, "/put/b/1", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/update/a/2", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/delete/a", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPost, "/kv/a", "2"), http.StatusMethodNotAllowed)
+	if v, _ := st.Get("a"); v != "1" {
+		t.Fatalf("got %q", v)
+	}
+	if _, err := st.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a legacy route stored a key: %v", err)
+	}
+}
+
+func TestLegacyGetRoutes(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st, WithLegacyGetRoutes())
+
+	rec := do(t, h, http.MethodGet, "/put/a/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("Deprecation") != "true" {
+		t.Fatalf("no Deprecation header: %v", rec.Header())
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/update/a/2", ""), http.StatusOK)
+	if v, _ := st.Get("a"); v != "2" {
+		t.Fatalf("got %q", v)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/delete/a", ""), http.StatusOK)
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	// The routes of a client already moved on aren't marked.
+	if rec := do(t, h, http.MethodPut, "/kv/a", "1"); rec.Header().Get("Deprecation") != "" {
+		t.Fatal("PUT /kv/:key is marked deprecated")
+	}
+}
+
+func TestGetNeverMutates(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+	_, h := newTestServer(st)
+	all := func(string, string) bool { return true }
+	before := st.Filter(all)
+
+	for _, target := range []string{
+		"/kv/a", "/get/a", "/kv/missing", "/peek/b", "/mget?keys=a,b", "/keys", "/scan", "/range?start=a&end=z",
+		"/entries", "/export", "/b64/kv/YQ", "/put/c/3", "/delete/a", "/update/a/3",
+	} {
+		do(t, h, http.MethodGet, target, "")
+	}
+	if after := st.Filter(all); !reflect.DeepEqual(after, before) {
+		t.Fatalf("GETs changed the store: %v, was %v", after, before)
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	const n = 8
+	st := NewKVStore[string, string](WithMaxKeyLength[string, string](n))
+	fits, over := strings.Repeat("k", n), strings.Repeat("k", n+1)
+
+	if err := st.Put(fits, "1"); err != nil {
+		t.Fatalf("a key of %d bytes: %v", n, err)
+	}
+	if err := st.Update(fits, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put(over, "1"); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("Put: got %v for a key of %d bytes, want ErrKeyTooLong", err, n+1)
+	}
+	if err := st.Update(over, "1"); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("Update: got %v", err)
+	}
+	if err := st.Rename(fits, over, false); !errors.Is(err, ErrKeyTooLong) {
+		t.Fatalf("Rename: got %v", err)
+	}
+	if _, err := st.Get(over); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a key over the limit was stored: %v", err)
+	}
+
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/"+fits, "3"), http.StatusOK)
+	rec := do(t, h, http.MethodPut, "/kv/"+over, "3")
+	expectStatus(t, rec, http.StatusBadRequest)
+	if !strings.Contains(rec.Body.String(), ErrKeyTooLong.Error()) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	if v, _ := st.Get(fits); v != "3" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestMaxKeyLengthDefaultsToUnlimited(t *testing.T) {
+	st := NewKVStore[string, string]()
+	if err := st.Put(strings.Repeat("k", 1<<16), "1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinimalResponses(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+
+	// By default the mutations answer with a JSON message.
+	_, h := newTestServer(st)
+	rec := do(t, h, http.MethodPut, "/kv/b", "2")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"msg":"ok"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	expectEmpty := func(rec *httptest.ResponseRecorder) {
+		t.Helper()
+		expectStatus(t, rec, http.StatusNoContent)
+		if rec.Body.Len() != 0 {
+			t.Fatalf("a 204 has the body %s", rec.Body)
+		}
+	}
+	minimal := []string{"Prefer", "return=minimal"}
+	expectEmpty(do(t, h, http.MethodPut, "/kv/b", "3", minimal...))
+	expectEmpty(do(t, h, http.MethodPut, "/kv/b", "4", "If-Match", "*", "Prefer", "return=minimal"))
+	rec = do(t, h, http.MethodDelete, "/kv/b", "", minimal...)
+	expectEmpty(rec)
+	if rec.Header().Get("Preference-Applied") != "return=minimal" {
+		t.Fatalf("the preference isn't acknowledged: %v", rec.Header())
+	}
+	if _, err := st.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	// A read still has its body.
+	if rec := do(t, h, http.MethodGet, "/kv/a", "", minimal...); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+
+	// WithMinimalResponses makes it the default, return=representation brings the body back.
+	_, h = newTestServer(st, WithMinimalResponses())
+	expectEmpty(do(t, h, http.MethodPut, "/kv/b", "2"))
+	expectEmpty(do(t, h, http.MethodDelete, "/kv/b", ""))
+	rec = do(t, h, http.MethodPut, "/kv/b", "2", "Prefer", "return=representation")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"msg":"ok"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	// A failure isn't affected.
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/missing", "1", "If-Match", "*"), http.StatusNotFound)
+}
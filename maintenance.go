@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMaintenanceCheckInterval = time.Minute
+
+// Window is a daily time range, Start and End are offsets from midnight in the local time of the clock.
+// A window whose End is before its Start wraps past midnight, e.g. 22:00–02:00.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// DailyWindow returns the window between the two times of day, e.g. DailyWindow(2, 0, 4, 0) for 02:00–04:00.
+func DailyWindow(startHour, startMinute, endHour, endMinute int) Window {
+	return Window{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}
+}
+
+// opening returns when the occurrence of the window containing t started, ok is false if t is outside the window.
+func (w Window) opening(t time.Time) (start time.Time, ok bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	switch {
+	case w.Start <= w.End:
+		return midnight.Add(w.Start), offset >= w.Start && offset < w.End
+	case offset >= w.Start:
+		return midnight.Add(w.Start), true
+	default:
+		// We're past midnight in a window that opened the day before.
+		return midnight.AddDate(0, 0, -1).Add(w.Start), offset < w.End
+	}
+}
+
+// MaintenanceTask is a heavy operation, like compacting or taking a snapshot, that should only run off-peak.
+type MaintenanceTask struct {
+	Name string
+	Run  func() error
+}
+
+// MaintenanceScheduler runs its tasks once per occurrence of its windows, outside of them the tasks are deferred.
+type MaintenanceScheduler struct {
+	mu      sync.Mutex
+	windows []Window
+	tasks   []MaintenanceTask
+	// lastRun is the opening of the last window occurrence the tasks ran in.
+	lastRun time.Time
+
+	interval time.Duration
+	clock    Clock
+	logger   Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// SchedulerOption configures the optional behaviour of a MaintenanceScheduler.
+type SchedulerOption func(*MaintenanceScheduler)
+
+// WithCheckInterval sets how often a started scheduler checks whether it's inside a window, the default is a minute.
+func WithCheckInterval(interval time.Duration) SchedulerOption {
+	return func(m *MaintenanceScheduler) {
+		m.interval = interval
+	}
+}
+
+// WithSchedulerClock sets where the scheduler reads the time from, the default is the system clock.
+func WithSchedulerClock(clock Clock) SchedulerOption {
+	return func(m *MaintenanceScheduler) {
+		m.clock = clock
+	}
+}
+
+// WithSchedulerLogger sets where the scheduler logs failed tasks.
+func WithSchedulerLogger(logger Logger) SchedulerOption {
+	return func(m *MaintenanceScheduler) {
+		m.logger = logger
+	}
+}
+
+func NewMaintenanceScheduler(windows []Window, tasks []MaintenanceTask, opts ...SchedulerOption) *MaintenanceScheduler {
+	m := &MaintenanceScheduler{
+		windows:  windows,
+		tasks:    tasks,
+		interval: defaultMaintenanceCheckInterval,
+		clock:    realClock{},
+		logger:   defaultLogger,
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// InWindow reports whether the current time is inside one of the windows.
+func (m *MaintenanceScheduler) InWindow() bool {
+	_, ok := m.currentWindow()
+	return ok
+}
+
+func (m *MaintenanceScheduler) currentWindow() (time.Time, bool) {
+	now := m.clock.Now()
+	for _, w := range m.windows {
+		if start, ok := w.opening(now); ok {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// RunPending runs the tasks if the current time is inside a window and they haven't run in it yet, it returns
+// whether they ran. A failing task is logged and doesn't stop the others.
+func (m *MaintenanceScheduler) RunPending() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start, ok := m.currentWindow()
+	if !ok || start.Equal(m.lastRun) {
+		return false
+	}
+	m.lastRun = start
+	for _, task := range m.tasks {
+		if err := task.Run(); err != nil {
+			m.logger.Error("maintenance task %s failed: %v", task.Name, err)
+		}
+	}
+	return true
+}
+
+// Start checks for pending maintenance every check interval until Stop is called.
+func (m *MaintenanceScheduler) Start() {
+	if testMode.Load() {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.RunPending()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops a started scheduler and waits for a running task to finish, it's safe to call more than once.
+func (m *MaintenanceScheduler) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	m.wg.Wait()
+}
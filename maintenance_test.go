@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRunsInsideWindow(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	runs := 0
+	m := NewMaintenanceScheduler(
+		[]Window{DailyWindow(2, 0, 4, 0)},
+		[]MaintenanceTask{{Name: "compact", Run: func() error { runs++; return nil }}},
+		WithSchedulerClock(clock), WithSchedulerLogger(NopLogger{}),
+	)
+
+	if m.InWindow() || m.RunPending() || runs != 0 {
+		t.Fatal("the task ran outside the window")
+	}
+	clock.Set(time.Date(2024, 1, 2, 2, 30, 0, 0, time.UTC))
+	if !m.InWindow() || !m.RunPending() || runs != 1 {
+		t.Fatal("the task didn't run inside the window")
+	}
+	clock.Advance(time.Minute)
+	if m.RunPending() || runs != 1 {
+		t.Fatal("the task ran twice in the same window")
+	}
+	clock.Set(time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC))
+	if m.InWindow() {
+		t.Fatal("the end of the window is inside it")
+	}
+	clock.Set(time.Date(2024, 1, 3, 3, 59, 0, 0, time.UTC))
+	if !m.RunPending() || runs != 2 {
+		t.Fatal("the task didn't run in the next day's window")
+	}
+}
+
+func TestMaintenanceWindowPastMidnight(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC))
+	runs := 0
+	m := NewMaintenanceScheduler(
+		[]Window{DailyWindow(22, 0, 2, 0)},
+		[]MaintenanceTask{{Name: "snapshot", Run: func() error { runs++; return nil }}},
+		WithSchedulerClock(clock),
+	)
+
+	m.RunPending()
+	clock.Set(time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC))
+	if m.RunPending() || runs != 1 {
+		t.Fatalf("the task ran %d times in a window wrapping past midnight", runs)
+	}
+	clock.Set(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC))
+	if m.InWindow() {
+		t.Fatal("noon is inside 22:00–02:00")
+	}
+}
+
+func TestMaintenanceFailingTaskDoesntStopOthers(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC))
+	logger := &recordingLogger{}
+	ran := false
+	m := NewMaintenanceScheduler(
+		[]Window{DailyWindow(2, 0, 4, 0)},
+		[]MaintenanceTask{
+			{Name: "broken", Run: func() error { return errors.New("disk gone") }},
+			{Name: "gc", Run: func() error { ran = true; return nil }},
+		},
+		WithSchedulerClock(clock), WithSchedulerLogger(logger),
+	)
+
+	m.RunPending()
+	if !ran || !logger.contains("maintenance task broken failed: disk gone") {
+		t.Fatalf("ran %t, logged %q", ran, logger.messages)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// lockWaitBuckets are the upper bounds of the lock wait histogram, waits above the last one land in an
+// overflow bucket.
+var lockWaitBuckets = [...]time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// histogram counts durations into the lockWaitBuckets, it's safe for concurrent use.
+type histogram struct {
+	counts [len(lockWaitBuckets) + 1]atomic.Uint64
+	count  atomic.Uint64
+	total  atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(lockWaitBuckets) && d > lockWaitBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.total.Add(int64(d))
+}
+
+// HistogramBucket is one bucket of a histogram, Count is the number of observations ≤ UpperBound and above
+// the bound of the previous bucket. The overflow bucket has an empty UpperBound.
+type HistogramBucket struct {
+	UpperBound string `json:"le,omitempty"`
+	Count      uint64 `json:"count"`
+}
+
+// LockWaitStats describes how long the operations of a store waited to acquire its lock.
+type LockWaitStats struct {
+	Count       uint64            `json:"count"`
+	TotalMicros int64             `json:"total_us"`
+	Buckets     []HistogramBucket `json:"buckets"`
+}
+
+func (h *histogram) stats() LockWaitStats {
+	stats := LockWaitStats{
+		Count:       h.count.Load(),
+		TotalMicros: time.Duration(h.total.Load()).Microseconds(),
+		Buckets:     make([]HistogramBucket, len(h.counts)),
+	}
+	for i := range h.counts {
+		stats.Buckets[i].Count = h.counts[i].Load()
+		if i < len(lockWaitBuckets) {
+			stats.Buckets[i].UpperBound = lockWaitBuckets[i].String()
+		}
+	}
+	return stats
+}
+
+// WithLockMetrics makes the store record how long every operation waits for its lock, see LockWaitStats.
+// Without it acquiring the lock isn't timed at all.
+func WithLockMetrics[K comparable, V any]() Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.lockWaits = &histogram{}
+	}
+}
+
+// lock takes the write lock, timing the wait if lock metrics are enabled.
+func (s *KVStore[K, V]) lock() {
+	if s.lockWaits == nil {
+		s.mu.Lock()
+		return
+	}
+	start := time.Now()
+	s.mu.Lock()
+	wait := time.Since(start)
+	s.lockWaits.observe(wait)
+	s.metrics.Histogram("kv_lock_wait_seconds", wait.Seconds())
+}
+
+// rlock takes the read lock, timing the wait if lock metrics are enabled.
+func (s *KVStore[K, V]) rlock() {
+	if s.lockWaits == nil {
+		s.mu.RLock()
+		return
+	}
+	start := time.Now()
+	s.mu.RLock()
+	wait := time.Since(start)
+	s.lockWaits.observe(wait)
+	s.metrics.Histogram("kv_lock_wait_seconds", wait.Seconds())
+}
+
+// LockWaitStats returns the lock wait histogram, it's empty unless the store was created with WithLockMetrics.
+func (s *KVStore[K, V]) LockWaitStats() LockWaitStats {
+	if s.lockWaits == nil {
+		return (&histogram{}).stats()
+	}
+	return s.lockWaits.stats()
+}
+
+// LockStatser is implemented by stores that can report their lock contention.
+type LockStatser interface {
+	LockWaitStats() LockWaitStats
+}
+
+// handleStats serves GET /stats.
+func (s *Server) handleStats(c echo.Context) error {
+	statser, ok := s.Storage.(LockStatser)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not report stats")
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"lock_wait": statser.LockWaitStats()})
+}
+
+// ShardStatser is implemented by the sharded stores.
+type ShardStatser interface {
+	ShardStats() []ShardStat
+}
+
+// handleShardStats serves GET /stats/shards.
+func (s *Server) handleShardStats(c echo.Context) error {
+	statser, ok := s.Storage.(ShardStatser)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage is not sharded")
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"shards": statser.ShardStats()})
+}
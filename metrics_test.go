@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLockWaitUnderContention(t *testing.T) {
+	st := NewKVStore[string, string](WithLockMetrics[string, string]())
+
+	// Hold the lock while a write waits for it.
+	const hold = 20 * time.Millisecond
+	st.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		st.Put("a", "1")
+	}()
+	time.Sleep(hold)
+	st.mu.Unlock()
+	<-done
+
+	stats := st.LockWaitStats()
+	if stats.Count != 1 {
+		t.Fatalf("got %d waits, want 1", stats.Count)
+	}
+	if stats.TotalMicros < hold.Microseconds() {
+		t.Fatalf("the recorded wait is %dµs, the lock was held for %s", stats.TotalMicros, hold)
+	}
+	var slow uint64
+	for i, b := range stats.Buckets {
+		if i > 4 {
+			slow += b.Count
+		}
+	}
+	if slow != 1 {
+		t.Fatalf("the wait isn't in the buckets above 10ms: %+v", stats.Buckets)
+	}
+
+	_, h := newTestServer(st)
+	rec := do(t, h, http.MethodGet, "/stats", "")
+	expectStatus(t, rec, http.StatusOK)
+	var out struct {
+		LockWait LockWaitStats `json:"lock_wait"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if out.LockWait.Count == 0 {
+		t.Fatalf("got %s", rec.Body)
+	}
+}
+
+func TestLockWaitDisabled(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Get("a")
+	if stats := st.LockWaitStats(); stats.Count != 0 || len(stats.Buckets) != len(lockWaitBuckets)+1 {
+		t.Fatalf("got %+v without lock metrics", stats)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpType is a class of endpoints that WithAllowedOps can enable or disable.
+type OpType string
+
+const (
+	// OpRead covers the endpoints that don't modify the store, including the admin ones like /stats.
+	OpRead OpType = "read"
+	// OpWrite covers the endpoints that create or modify keys.
+	OpWrite OpType = "write"
+	// OpDelete covers the endpoints that delete keys.
+	OpDelete OpType = "delete"
+)
+
+// WithAllowedOps only lets through the requests for the given kinds of operations, the others are rejected
+// with 405 Method Not Allowed. WithAllowedOps(OpRead) makes a read-only node. GET /health and GET /readyz are
+// always allowed.
+func WithAllowedOps(ops ...OpType) ServerOption {
+	return func(s *Server) {
+		s.allowedOps = make(map[OpType]bool, len(ops))
+		for _, op := range ops {
+			s.allowedOps[op] = true
+		}
+	}
+}
+
+// allow returns the middleware rejecting the requests for op if it's not allowed.
+func (s *Server) allow(op OpType) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if s.allowedOps == nil || s.allowedOps[op] {
+			return next
+		}
+		return func(c echo.Context) error {
+			return echo.NewHTTPError(http.StatusMethodNotAllowed, "the "+string(op)+" operations are disabled on this server")
+		}
+	}
+}
+
+// handleHealth serves GET /health, for load balancers and orchestrators.
+func (s *Server) handleHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady serves GET /readyz, it's 503 with the reason when the storage can't persist its writes anymore,
+// e.g. because the disk is full or read-only, so that traffic is moved away before writes are lost.
+func (s *Server) handleReady(c echo.Context) error {
+	if checker, ok := s.Storage.(PersistenceChecker); ok {
+		if err := checker.CheckPersistence(); err != nil {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "reason": err.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyServer(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	_, h := newTestServer(st, WithAllowedOps(OpRead))
+
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/health", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "2"), http.StatusMethodNotAllowed)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", ""), http.StatusMethodNotAllowed)
+	expectStatus(t, do(t, h, http.MethodPost, "/incr/n", ""), http.StatusMethodNotAllowed)
+	if v, _ := st.Get("a"); v != "1" {
+		t.Fatalf("a rejected write changed the value to %q", v)
+	}
+}
+
+func TestNoDeletesServer(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st, WithAllowedOps(OpRead, OpWrite))
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", ""), http.StatusMethodNotAllowed)
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/flush", ""), http.StatusMethodNotAllowed)
+	if _, err := st.Get("a"); err != nil {
+		t.Fatalf("the key was deleted: %v", err)
+	}
+}
+
+func TestReadyzChecksPersistence(t *testing.T) {
+	dir := t.TempDir()
+	st := NewKVStore[string, string](WithPersistence[string, string](PersistAOF, WithDataDir(dir)))
+	defer st.Close()
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodGet, "/readyz", ""), http.StatusOK)
+
+	if err := os.Chmod(dir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0o755)
+	if f, err := os.CreateTemp(dir, "root"); err == nil {
+		// Running as root, the permissions of the directory don't stop its writes.
+		f.Close()
+		os.Remove(f.Name())
+		t.Skip("the data directory is still writable once read-only")
+	}
+
+	rec := do(t, h, http.MethodGet, "/readyz", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if !strings.Contains(rec.Body.String(), "the data directory isn't writable") {
+		t.Fatalf("the reason isn't given: %s", rec.Body)
+	}
+	// Liveness doesn't depend on the disk.
+	expectStatus(t, do(t, h, http.MethodGet, "/health", ""), http.StatusOK)
+}
+
+func TestReadyzWithoutDataDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	st := NewKVStore[string, string](WithPersistence[string, string](PersistSnapshot, WithDataDir(dir)))
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodGet, "/readyz", ""), http.StatusOK)
+
+	// Like a volume gone from under the process.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	rec := do(t, h, http.MethodGet, "/readyz", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if !strings.Contains(rec.Body.String(), `"status":"unavailable"`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	// A store without persistence is always ready.
+	_, h = newTestServer(NewKVStore[string, string]())
+	expectStatus(t, do(t, h, http.MethodGet, "/readyz", ""), http.StatusOK)
+}
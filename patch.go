@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errNotJSON is returned by the patch function when the stored value isn't a JSON document.
+var errNotJSON = errors.New("the value is not a JSON document")
+
+// UpdateFunc atomically replaces the value of an existing key with fn(current value).
+// It fails with ErrKeyNotFound if the key is missing, and leaves the value alone if fn fails.
+func (s *KVStore[K, V]) UpdateFunc(key K, fn func(V) (V, error)) (V, error) {
+	key = s.canon(key)
+	s.lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.lookup(key)
+	if !ok {
+		return old, keyNotFound(key)
+	}
+	value, err := fn(old)
+	if err != nil {
+		return old, err
+	}
+	if err := s.validateValue(value); err != nil {
+		return old, err
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return old, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return old, err
+	}
+	s.set(key, value)
+
+	return value, nil
+}
+
+// FuncUpdater is implemented by stores that can update a value atomically from its current value.
+type FuncUpdater[K comparable, V any] interface {
+	UpdateFunc(K, func(V) (V, error)) (V, error)
+}
+
+// mergePatch applies an RFC 7386 JSON Merge Patch to target: objects are merged recursively, null removes
+// a member and anything else replaces the target.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatch(targetObj[name], value)
+		}
+	}
+	return targetObj
+}
+
+// handlePatch serves PATCH /kv/:key, the body is a JSON Merge Patch applied to the JSON document stored
+// under the key.
+func (s *Server) handlePatch(c echo.Context) error {
+	updater, ok := s.Storage.(FuncUpdater[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support patching")
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	var patch any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the body must be a JSON merge patch: "+err.Error())
+	}
+
+	var value string
+	err = s.fence(c, func() (err error) {
+		value, err = updater.UpdateFunc(c.Param("key"), s.patchWith(patch))
+		return err
+	})
+	if errors.Is(err, errNotJSON) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	return s.mutated(c, map[string]json.RawMessage{"value": json.RawMessage(value)})
+}
+
+// patchWith returns the update applying patch to the JSON value of a key for handlePatch.
+func (s *Server) patchWith(patch any) func(string) (string, error) {
+	return func(old string) (string, error) {
+		var target any
+		if err := json.Unmarshal([]byte(old), &target); err != nil {
+			return "", errNotJSON
+		}
+		merged, err := json.Marshal(mergePatch(target, patch))
+		if err != nil {
+			return "", err
+		}
+		if err := s.checkValue(string(merged)); err != nil {
+			return "", err
+		}
+		return string(merged), nil
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatchKeepsOtherFields(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("user", `{"name":"ada","email":"ada@example.com","address":{"city":"London","zip":"N1"},"tmp":1}`)
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPatch, "/kv/user", `{"email":"ada@example.org","address":{"zip":"N2"},"tmp":null}`)
+	expectStatus(t, rec, http.StatusOK)
+
+	v, _ := st.Get("user")
+	var got map[string]any
+	if err := json.Unmarshal([]byte(v), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":    "ada",
+		"email":   "ada@example.org",
+		"address": map[string]any{"city": "London", "zip": "N2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMergePatchErrors(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("text", "not json")
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPatch, "/kv/absent", `{"a":1}`), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPatch, "/kv/text", `{"a":1}`), http.StatusConflict)
+	expectStatus(t, do(t, h, http.MethodPatch, "/kv/text", `{`), http.StatusBadRequest)
+	if v, _ := st.Get("text"); v != "not json" {
+		t.Fatalf("a failed patch changed the value to %q", v)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSnapshotInterval = 5 * time.Minute
+	defaultAOFSyncInterval  = time.Second
+
+	snapshotFile = "dump.snap"
+	aofFile      = "appendonly.aof"
+	// rotatedAOFFile holds the writes of the log taken before the snapshot in progress, it's removed once the
+	// snapshot is written.
+	rotatedAOFFile = "appendonly.aof.1"
+)
+
+// PersistencePolicy tells how a store created WithPersistence keeps its data on disk.
+type PersistencePolicy int
+
+const (
+	// PersistNone keeps the data in memory only, it's the fastest and everything is lost when the process stops.
+	PersistNone PersistencePolicy = iota
+	// PersistSnapshot writes a snapshot of the whole store every snapshot interval and on Close. The writes don't
+	// pay anything, but a crash loses everything written since the last snapshot, and every snapshot costs a copy
+	// of the store.
+	PersistSnapshot
+	// PersistAOF appends every write to a log before applying it, and syncs the log to the disk every sync
+	// interval. A crash of the process loses nothing, a crash of the machine up to the last sync interval. Every
+	// write pays for a write to the log, and the log grows forever, so the recovery gets slower with time.
+	PersistAOF
+	// PersistBoth writes snapshots and truncates the log every time, the recovery loads the snapshot and replays the
+	// log written since. It's as durable as PersistAOF with a recovery time bounded by the snapshot interval.
+	PersistBoth
+)
+
+// PersistenceOption configures the optional behaviour of WithPersistence.
+type PersistenceOption func(*persistenceConfig)
+
+type persistenceConfig struct {
+	dir              string
+	snapshotInterval time.Duration
+	syncInterval     time.Duration
+}
+
+// WithDataDir sets the directory the snapshot and the log are kept in, it's created if needed. The default is
+// the working directory.
+func WithDataDir(dir string) PersistenceOption {
+	return func(c *persistenceConfig) {
+		c.dir = dir
+	}
+}
+
+// WithSnapshotInterval sets how often the snapshots are written, the default is 5m.
+func WithSnapshotInterval(d time.Duration) PersistenceOption {
+	return func(c *persistenceConfig) {
+		c.snapshotInterval = d
+	}
+}
+
+// WithAOFSyncInterval sets how often the log is synced to the disk, the default is 1s. 0 syncs it on every
+// write, nothing acknowledged is lost then but every write waits for the disk.
+func WithAOFSyncInterval(d time.Duration) PersistenceOption {
+	return func(c *persistenceConfig) {
+		c.syncInterval = d
+	}
+}
+
+// WithPersistence makes the store keep its data on disk with the given policy. When the store is created it
+// recovers from what's on disk, the snapshot first and then the log, and starts the background tasks of the
+// policy, Close stops them and writes a final snapshot. It replaces WithWAL, and like the snapshots and the log
+// it doesn't keep the TTLs. It's meant for a KVStore, the shards of a ShardedKVStore would share the same files.
+//
+// NewKVStore logs a failed recovery and goes on with what it could load, use OpenKVStore to get the error.
+func WithPersistence[K comparable, V any](policy PersistencePolicy, opts ...PersistenceOption) Option[K, V] {
+	cfg := persistenceConfig{
+		dir:              ".",
+		snapshotInterval: defaultSnapshotInterval,
+		syncInterval:     defaultAOFSyncInterval,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(s *KVStore[K, V]) {
+		if policy != PersistNone {
+			s.persistence = &persistence[K, V]{persistenceConfig: cfg, policy: policy}
+		}
+	}
+}
+
+// OpenKVStore is NewKVStore for a store created WithPersistence, it fails if the data on disk can't be recovered.
+func OpenKVStore[K comparable, V any](opts ...Option[K, V]) (*KVStore[K, V], error) {
+	s := NewKVStore[K, V](opts...)
+	if p := s.persistence; p != nil && p.err != nil {
+		s.Close()
+		return nil, p.err
+	}
+	return s, nil
+}
+
+type persistence[K comparable, V any] struct {
+	persistenceConfig
+	policy PersistencePolicy
+	store  *KVStore[K, V]
+	// err is why the recovery failed, OpenKVStore returns it.
+	err error
+
+	aof *aofWriter
+	wg  sync.WaitGroup
+}
+
+func (p *persistence[K, V]) snapshots() bool {
+	return p.policy == PersistSnapshot || p.policy == PersistBoth
+}
+
+func (p *persistence[K, V]) appends() bool {
+	return p.policy == PersistAOF || p.policy == PersistBoth
+}
+
+func (p *persistence[K, V]) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// open recovers the store from the disk and starts the background tasks, it's called by NewKVStore once all
+// the options are applied.
+func (p *persistence[K, V]) open(s *KVStore[K, V]) error {
+	p.store = s
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return err
+	}
+
+	if p.snapshots() {
+		if err := p.recover(snapshotFile, s.LoadSnapshotInto); err != nil {
+			return err
+		}
+	}
+	if p.appends() {
+		// The rotated log can hold writes that are already in the snapshot, replaying them again leaves the keys
+		// with the same values.
+		for _, name := range []string{rotatedAOFFile, aofFile} {
+			if err := p.recover(name, s.ReplayWAL); err != nil {
+				return err
+			}
+		}
+
+		f, err := os.OpenFile(p.path(aofFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		p.aof = &aofWriter{f: f, syncEach: p.syncInterval <= 0}
+		s.wal, s.walMode = p.aof, WALStrict
+	}
+
+	if !testMode.Load() {
+		p.wg.Add(1)
+		go p.run()
+	}
+	return nil
+}
+
+// recover reads the file with load if it exists.
+func (p *persistence[K, V]) recover(name string, load func(io.Reader) error) error {
+	f, err := os.Open(p.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := load(f); err != nil {
+		return fmt.Errorf("recovering from %s: %w", name, err)
+	}
+	return nil
+}
+
+// run writes the snapshots and syncs the log until the store is closed.
+func (p *persistence[K, V]) run() {
+	defer p.wg.Done()
+
+	var snapshots, syncs <-chan time.Time
+	if p.snapshots() && p.snapshotInterval > 0 {
+		ticker := time.NewTicker(p.snapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+	if p.aof != nil && !p.aof.syncEach {
+		ticker := time.NewTicker(p.syncInterval)
+		defer ticker.Stop()
+		syncs = ticker.C
+	}
+
+	for {
+		select {
+		case <-snapshots:
+			if err := p.snapshot(); err != nil {
+				p.store.logger.Error("writing the snapshot failed: %v", err)
+			}
+		case <-syncs:
+			if err := p.aof.sync(); err != nil {
+				p.store.logger.Error("syncing the append-only log failed: %v", err)
+			}
+		case <-p.store.stop:
+			return
+		}
+	}
+}
+
+// snapshot writes a snapshot of the store next to the current one and swaps it in. With PersistBoth the log is
+// rotated at the same time, under the read lock so that no write lands in between.
+func (p *persistence[K, V]) snapshot() error {
+	s := p.store
+	s.rlock()
+	entries, err := s.encodeEntries(nil)
+	if err == nil && p.aof != nil {
+		err = p.aof.rotate(p.path(aofFile), p.path(rotatedAOFFile))
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	path := p.path(snapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = writeEntries(f, entries, s.snapshotKey)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if p.aof != nil {
+		return os.Remove(p.path(rotatedAOFFile))
+	}
+	return nil
+}
+
+// close stops the background tasks, writes the final snapshot and closes the log. It's called by Close.
+func (p *persistence[K, V]) close() error {
+	if p.err != nil {
+		// The store holds only part of what's on disk, a snapshot would overwrite the rest.
+		return nil
+	}
+	p.wg.Wait()
+
+	var errs []error
+	if p.snapshots() {
+		errs = append(errs, p.snapshot())
+	}
+	if p.aof != nil {
+		errs = append(errs, p.aof.close())
+	}
+	return errors.Join(errs...)
+}
+
+// aofWriter is the WAL of a store with an append-only log, it has its own lock since the log is synced and
+// rotated by the background task while the store writes to it.
+type aofWriter struct {
+	mu       sync.Mutex
+	f        *os.File
+	syncEach bool
+}
+
+func (w *aofWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.f.Write(b)
+	if err == nil && w.syncEach {
+		err = w.f.Sync()
+	}
+	return n, err
+}
+
+func (w *aofWriter) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Sync()
+}
+
+// rotate moves the log to rotated and starts a new one. If rotated is still there, because the last snapshot
+// failed, the log is appended to it instead so none of its writes are lost.
+func (w *aofWriter) rotate(path, rotated string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(rotated); err == nil {
+		if err := appendFile(rotated, path); err != nil {
+			return err
+		}
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	return nil
+}
+
+func (w *aofWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.f.Sync()
+	return errors.Join(err, w.f.Close())
+}
+
+// appendFile appends the contents of src to dst, and syncs it.
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// CheckPersistence reports whether the store can still persist its writes: the log must not have failed, and a
+// small file must be writable in the data directory. It's nil for a store without persistence.
+func (s *KVStore[K, V]) CheckPersistence() error {
+	if s.DurabilityDegraded() {
+		return errors.New("the WAL failed, the writes are only kept in memory")
+	}
+	p := s.persistence
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return fmt.Errorf("the recovery failed: %w", p.err)
+	}
+
+	f, err := os.CreateTemp(p.dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("the data directory isn't writable: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte("probe"))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("the data directory isn't writable: %w", err)
+	}
+	return nil
+}
+
+// PersistenceChecker is implemented by stores that can tell whether their writes still reach the disk.
+type PersistenceChecker interface {
+	CheckPersistence() error
+}
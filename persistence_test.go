@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crash drops the store the way a killed process would: no final snapshot and no sync of the log, only its
+// file descriptors are released.
+func crash(st *KVStore[string, string]) {
+	if p := st.persistence; p != nil && p.aof != nil {
+		p.aof.f.Close()
+	}
+}
+
+// recovered opens a new store on the data in dir.
+func recovered(t *testing.T, policy PersistencePolicy, dir string) *KVStore[string, string] {
+	t.Helper()
+	st, err := OpenKVStore[string, string](WithPersistence[string, string](policy, WithDataDir(dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func expectKeys(t *testing.T, st *KVStore[string, string], want map[string]string) {
+	t.Helper()
+	got := st.Filter(func(string, string) bool { return true })
+	if len(got) != len(want) {
+		t.Fatalf("recovered %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("recovered %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPersistenceRecovery(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	for _, tc := range []struct {
+		name   string
+		policy PersistencePolicy
+		// want is what survives a crash after a, b and c were written, a deleted, and a tick between b and c.
+		want map[string]string
+	}{
+		{"none", PersistNone, map[string]string{}},
+		{"snapshot", PersistSnapshot, map[string]string{"b": "2"}},
+		{"aof", PersistAOF, map[string]string{"b": "2", "c": "3"}},
+		{"both", PersistBoth, map[string]string{"b": "2", "c": "3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			st := recovered(t, tc.policy, dir)
+			st.Put("a", "1")
+			st.Put("b", "2")
+			st.Delete("a")
+			if _, err := st.Tick(); err != nil {
+				t.Fatal(err)
+			}
+			st.Put("c", "3")
+			crash(st)
+
+			expectKeys(t, recovered(t, tc.policy, dir), tc.want)
+		})
+	}
+}
+
+func TestPersistenceCloseKeepsEverything(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	for _, policy := range []PersistencePolicy{PersistSnapshot, PersistAOF, PersistBoth} {
+		dir := t.TempDir()
+		st := recovered(t, policy, dir)
+		st.Put("a", "1")
+		st.Put("b", "2")
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+		expectKeys(t, recovered(t, policy, dir), map[string]string{"a": "1", "b": "2"})
+	}
+}
+
+func TestPersistNoneWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	st := recovered(t, PersistNone, dir)
+	st.Put("a", "1")
+	st.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("the data directory holds %d files", len(entries))
+	}
+}
+
+func TestPersistBothTruncatesTheLog(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	dir := t.TempDir()
+	st := recovered(t, PersistBoth, dir)
+	st.Put("a", "1")
+	if _, err := st.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, aofFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("the log holds %d bytes after the snapshot", info.Size())
+	}
+	if _, err := os.Stat(filepath.Join(dir, rotatedAOFFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the rotated log is left: %v", err)
+	}
+}
+
+func TestOpenKVStoreFailsOnACorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte("not a snapshot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKVStore[string, string](WithPersistence[string, string](PersistSnapshot, WithDataDir(dir))); err == nil {
+		t.Fatal("a corrupt snapshot was recovered")
+	}
+	// The snapshot is left for an operator to look at.
+	if b, _ := os.ReadFile(filepath.Join(dir, snapshotFile)); string(b) != "not a snapshot" {
+		t.Fatalf("the snapshot was overwritten with %q", b)
+	}
+}
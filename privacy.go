@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const redacted = "[redacted]"
+
+// WithAccessLog logs every request at the Info level, with its route, status and duration.
+func WithAccessLog() ServerOption {
+	return func(s *Server) {
+		s.accessLog = true
+	}
+}
+
+// WithRedactedLogs replaces the values with [redacted] in the access log, the keys are still logged.
+func WithRedactedLogs() ServerOption {
+	return func(s *Server) {
+		s.redactLogs = true
+	}
+}
+
+// WithRedactedResponses makes the mutations answer {"msg": "ok"} instead of echoing the stored value back,
+// e.g. the updated value of /update or the previous value of /put?returnOld=true. GET still returns values.
+func WithRedactedResponses() ServerOption {
+	return func(s *Server) {
+		s.redactResponses = true
+	}
+}
+
+// logged is the access log middleware.
+func (s *Server) logged(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		s.logger.Info("%s %s %d %s", c.Request().Method, s.loggedPath(c), responseStatus(c, err), time.Since(start))
+		return err
+	}
+}
+
+// loggedPath returns the path of the request rebuilt from its route, with the value redacted if needed.
+func (s *Server) loggedPath(c echo.Context) string {
+	route := c.Path()
+	if route == "" || !strings.Contains(route, ":") {
+		return c.Request().URL.Path
+	}
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := segment[1:]
+		if name == "value" && s.redactLogs {
+			segments[i] = redacted
+		} else {
+			segments[i] = c.Param(name)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// mutated answers a successful mutation with body, or just {"msg": "ok"} with WithRedactedResponses.
+func (s *Server) mutated(c echo.Context, body any) error {
+	if s.redactResponses {
+		return s.done(c, map[string]string{"msg": "ok"})
+	}
+	return s.done(c, body)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactedLogs(t *testing.T) {
+	logger := &recordingLogger{}
+	_, h := newTestServer(NewKVStore[string, string](), WithLogger(logger), WithAccessLog(), WithLegacyGetRoutes(), WithRedactedLogs())
+
+	expectStatus(t, do(t, h, http.MethodGet, "/put/user42/s3cret", ""), http.StatusOK)
+	if !logger.contains("/put/user42/[redacted]") {
+		t.Fatalf("the access log doesn't have the key: %q", logger.messages)
+	}
+	if logger.contains("s3cret") {
+		t.Fatalf("the value was logged: %q", logger.messages)
+	}
+
+	// Without the option the value is logged.
+	logger = &recordingLogger{}
+	_, h = newTestServer(NewKVStore[string, string](), WithLogger(logger), WithAccessLog(), WithLegacyGetRoutes())
+	do(t, h, http.MethodGet, "/put/user42/s3cret", "")
+	if !logger.contains("/put/user42/s3cret") {
+		t.Fatalf("got %q", logger.messages)
+	}
+}
+
+func TestRedactedResponses(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", `{"secret":"s3cret"}`)
+	_, h := newTestServer(st, WithRedactedResponses())
+
+	for _, req := range []struct{ method, target, body, want string }{
+		{http.MethodPut, "/kv/a?returnOld=true", `{"secret":"t0p"}`, `{"existed":true,"msg":"ok"}`},
+		{http.MethodPatch, "/kv/a", `{"secret":"s3cret"}`, `{"msg":"ok"}`},
+	} {
+		rec := do(t, h, req.method, req.target, req.body)
+		expectStatus(t, rec, http.StatusOK)
+		if body := strings.TrimSpace(rec.Body.String()); body != req.want {
+			t.Errorf("%s %s answered %s, want %s", req.method, req.target, body, req.want)
+		}
+	}
+
+	// GET still returns the value.
+	if rec := do(t, h, http.MethodGet, "/kv/a", ""); !strings.Contains(rec.Body.String(), "s3cret") {
+		t.Fatalf("got %s", rec.Body)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrInvalidConfig is returned by Reconfigure for a setting that can't take the given value, or can't be changed
+// on this store at all.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// StoreConfig holds the settings of a store that can be changed at runtime, the nil ones are left as they are.
+// The others, like the codecs or the persistence, are fixed when the store is created.
+type StoreConfig struct {
+	// MaxKeys is the limit of NewKVStoreWithMaxKeys, 0 means unlimited.
+	MaxKeys *int
+	// MaxBytes is the capacity of a store created with NewKVStoreWithByteCapacity, the others can't be given one.
+	MaxBytes *int
+	// MemoryLimit is the limit of WithMemoryLimit, 0 means unlimited.
+	MemoryLimit *int
+	// DefaultTTL is the TTL of WithDefaultTTL, 0 means the keys are permanent.
+	DefaultTTL *time.Duration
+	// SweepInterval is how often the sweeper runs, see WithSweepInterval.
+	SweepInterval *time.Duration
+}
+
+// Reconfigure applies the settings of cfg at once, or none of them if one is invalid. Lowering a capacity takes
+// effect right away: a store with an LRU evicts its least recently used keys down to the new limits, the others
+// keep their keys but refuse the new ones until they're under the limits again. A new default TTL applies to the
+// keys written from now on.
+func (s *KVStore[K, V]) Reconfigure(cfg StoreConfig) error {
+	for name, n := range map[string]*int{"max keys": cfg.MaxKeys, "max bytes": cfg.MaxBytes, "memory limit": cfg.MemoryLimit} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("%w: the %s can't be negative", ErrInvalidConfig, name)
+		}
+	}
+	if cfg.DefaultTTL != nil && *cfg.DefaultTTL < 0 {
+		return fmt.Errorf("%w: the default TTL can't be negative", ErrInvalidConfig)
+	}
+	if cfg.SweepInterval != nil && *cfg.SweepInterval <= 0 {
+		return fmt.Errorf("%w: the sweep interval must be positive", ErrInvalidConfig)
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	if cfg.MaxBytes != nil && (s.lru == nil || *cfg.MaxBytes == 0) {
+		return fmt.Errorf("%w: only a store created with a byte capacity has one, and it can't be removed", ErrInvalidConfig)
+	}
+	if cfg.MaxKeys != nil {
+		s.maxKeys = *cfg.MaxKeys
+	}
+	if cfg.MaxBytes != nil {
+		s.maxBytes = *cfg.MaxBytes
+		// The watermarks were set for the old capacity.
+		s.highWatermark = min(s.highWatermark, s.maxBytes)
+		s.lowWatermark = min(s.lowWatermark, s.highWatermark)
+	}
+	if cfg.MemoryLimit != nil {
+		s.memoryLimit = *cfg.MemoryLimit
+	}
+	if cfg.DefaultTTL != nil {
+		s.defaultTTL = *cfg.DefaultTTL
+	}
+	if cfg.SweepInterval != nil {
+		s.sweepInterval = *cfg.SweepInterval
+		// The sweeper may be waiting on the old interval, it's given the new one. A value it hasn't picked up
+		// yet is replaced.
+		select {
+		case <-s.sweepReset:
+		default:
+		}
+		s.sweepReset <- s.sweepInterval
+	}
+
+	if s.lru != nil {
+		s.evictDownToLimits()
+	}
+	return nil
+}
+
+// evictDownToLimits evicts the least recently used keys until the store is within its limits, must be called
+// with the write lock held.
+func (s *KVStore[K, V]) evictDownToLimits() {
+	over := func() bool {
+		return s.maxKeys > 0 && len(s.data) > s.maxKeys ||
+			s.maxBytes > 0 && s.bytes > s.maxBytes ||
+			s.memoryLimit > 0 && s.memoryUsage() > s.memoryLimit
+	}
+	for over() {
+		key, ok := s.lru.oldest()
+		if !ok {
+			return
+		}
+		s.evict(key)
+	}
+}
+
+// Reconfigurer is implemented by stores whose settings can be changed at runtime.
+type Reconfigurer interface {
+	Reconfigure(StoreConfig) error
+}
+
+// configRequest is the body of POST /admin/config, the durations are strings like "30s".
+type configRequest struct {
+	MaxKeys       *int    `json:"max_keys"`
+	MaxBytes      *int    `json:"max_bytes"`
+	MemoryLimit   *int    `json:"memory_limit"`
+	DefaultTTL    *string `json:"default_ttl"`
+	SweepInterval *string `json:"sweep_interval"`
+}
+
+// handleConfig serves POST /admin/config, taking the settings to change, e.g. {"max_keys": 1000,
+// "default_ttl": "1h"}. The settings it doesn't know, including the ones that can't change at runtime, are
+// rejected with 400.
+func (s *Server) handleConfig(c echo.Context) error {
+	reconfigurer, ok := s.Storage.(Reconfigurer)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage can't be reconfigured")
+	}
+
+	var req configRequest
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid configuration: "+err.Error())
+	}
+
+	cfg := StoreConfig{MaxKeys: req.MaxKeys, MaxBytes: req.MaxBytes, MemoryLimit: req.MemoryLimit}
+	for _, d := range []struct {
+		param *string
+		dst   **time.Duration
+	}{{req.DefaultTTL, &cfg.DefaultTTL}, {req.SweepInterval, &cfg.SweepInterval}} {
+		if d.param == nil {
+			continue
+		}
+		parsed, err := time.ParseDuration(*d.param)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid duration: "+*d.param)
+		}
+		*d.dst = &parsed
+	}
+
+	err := reconfigurer.Reconfigure(cfg)
+	if errors.Is(err, ErrInvalidConfig) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"msg": "ok"})
+}
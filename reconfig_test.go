@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func intPtr(n int) *int                          { return &n }
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+// keysLeft returns how many keys the store holds.
+func keysLeft(st *KVStore[string, string]) int {
+	return len(st.Filter(func(string, string) bool { return true }))
+}
+
+func TestReconfigureCapacityEvicts(t *testing.T) {
+	st := NewKVStoreWithByteCapacity[string, string](10 * entrySize("k0", "v"))
+	for i := 0; i < 10; i++ {
+		st.Put(fmt.Sprint("k", i), "v")
+	}
+	// k0 is now the most recently used.
+	st.Get("k0")
+
+	if err := st.Reconfigure(StoreConfig{MaxBytes: intPtr(4 * entrySize("k0", "v"))}); err != nil {
+		t.Fatal(err)
+	}
+	if n := keysLeft(st); n != 4 {
+		t.Fatalf("%d keys are left, want 4", n)
+	}
+	for _, key := range []string{"k0", "k7", "k8", "k9"} {
+		if _, err := st.Peek(key); err != nil {
+			t.Fatalf("%s was evicted: %v", key, err)
+		}
+	}
+
+	if err := st.Reconfigure(StoreConfig{MaxKeys: intPtr(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := keysLeft(st); n != 2 {
+		t.Fatalf("%d keys are left, want 2", n)
+	}
+	// Like NewKVStoreWithMaxKeys, the new keys over the limit are refused rather than evicting.
+	if err := st.Put("new", "v"); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("got %v over the new limit", err)
+	}
+}
+
+func TestReconfigureWithoutLRUKeepsTheKeys(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](10)
+	for i := 0; i < 5; i++ {
+		st.Put(fmt.Sprint("k", i), "v")
+	}
+	if err := st.Reconfigure(StoreConfig{MaxKeys: intPtr(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := keysLeft(st); n != 5 {
+		t.Fatalf("%d keys are left, an unbounded store doesn't evict", n)
+	}
+	if err := st.Put("new", "v"); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("got %v over the new limit", err)
+	}
+	if err := st.Put("k0", "updated"); err != nil {
+		t.Fatalf("updating a key: %v", err)
+	}
+}
+
+func TestReconfigureDefaultTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+	st.Put("before", "1")
+
+	if err := st.Reconfigure(StoreConfig{DefaultTTL: durationPtr(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	st.Put("after", "2")
+	if _, ttl, _ := st.GetWithTTL("after"); ttl != time.Minute {
+		t.Fatalf("got a TTL of %s, want the new default", ttl)
+	}
+
+	clock.Advance(time.Minute + time.Nanosecond)
+	if _, err := st.Get("after"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v after the default TTL", err)
+	}
+	if v, err := st.Get("before"); err != nil || v != "1" {
+		t.Fatalf("a key written before the change expired: %q, %v", v, err)
+	}
+}
+
+func TestReconfigureSweepInterval(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithSweepInterval[string, string](time.Hour))
+	defer st.Close()
+	st.PutWithTTL("a", "1", time.Minute)
+	clock.Advance(time.Hour)
+
+	if err := st.Reconfigure(StoreConfig{SweepInterval: durationPtr(time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	// The sweeper was waiting an hour, it's removed at the new interval instead.
+	waitFor(t, func() bool { return !stored(st, "a") })
+}
+
+func TestReconfigureRejectsInvalidSettings(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](10)
+	for name, cfg := range map[string]StoreConfig{
+		"negative max keys":    {MaxKeys: intPtr(-1)},
+		"negative TTL":         {MaxKeys: intPtr(1), DefaultTTL: durationPtr(-time.Second)},
+		"zero sweep interval":  {MaxKeys: intPtr(1), SweepInterval: durationPtr(0)},
+		"bytes without an LRU": {MaxKeys: intPtr(1), MaxBytes: intPtr(100)},
+	} {
+		if err := st.Reconfigure(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: got %v, want ErrInvalidConfig", name, err)
+		}
+	}
+	// None of them was applied, not even in part.
+	for i := 0; i < 10; i++ {
+		if err := st.Put(fmt.Sprint("k", i), "v"); err != nil {
+			t.Fatalf("the limit changed: %v", err)
+		}
+	}
+
+	lru := NewKVStoreWithByteCapacity[string, string](100)
+	if err := lru.Reconfigure(StoreConfig{MaxBytes: intPtr(0)}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("removing the byte capacity: got %v", err)
+	}
+}
+
+func TestConfigOverHTTP(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStoreWithByteCapacity[string, string](1<<20, WithClock[string, string](clock))
+	for i := 0; i < 5; i++ {
+		st.Put(fmt.Sprint("k", i), "v")
+	}
+	_, h := newTestServer(st)
+
+	body := fmt.Sprintf(`{"max_bytes": %d, "default_ttl": "1m"}`, 3*entrySize("k0", "v"))
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/config", body), http.StatusOK)
+	if n := keysLeft(st); n != 3 {
+		t.Fatalf("%d keys are left, want 3", n)
+	}
+	st.Put("k9", "v")
+	if n := keysLeft(st); n != 3 {
+		t.Fatalf("%d keys after a put, the oldest wasn't evicted", n)
+	}
+	if _, ttl, _ := st.GetWithTTL("k9"); ttl != time.Minute {
+		t.Fatalf("got a TTL of %s", ttl)
+	}
+
+	for _, body := range []string{
+		`{"max_keys": -1}`,
+		`{"default_ttl": "soon"}`,
+		// The settings fixed at creation can't be changed.
+		`{"codec": "gob"}`,
+		`[1]`,
+	} {
+		expectStatus(t, do(t, h, http.MethodPost, "/admin/config", body), http.StatusBadRequest)
+	}
+
+	_, h = newTestServer(&countingStore{Storer: NewKVStore[string, string]()})
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/config", `{"max_keys": 2}`), http.StatusNotImplemented)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultChangeBatch         = 1000
+	defaultReplicaPollInterval = 200 * time.Millisecond
+)
+
+// Change is an entry of the change stream of a primary, Seq numbers the changes from 1 without gaps. Epoch is
+// the epoch of the primary when the change was made, see KVStore.Fenced.
+type Change[K comparable, V any] struct {
+	Seq   uint64    `json:"seq"`
+	Type  EventType `json:"type"`
+	Key   K         `json:"key"`
+	Value V         `json:"value"`
+	Epoch uint64    `json:"epoch,omitempty"`
+}
+
+// ChangeLog keeps the last changes of a store in a ring buffer, for the replicas to catch up from. Unlike the
+// watchers it never drops a change in the middle, a replica falling further behind than the size of the log
+// gets 410 Gone. Clear is a single EventClear, snapshot loads aren't part of the stream.
+type ChangeLog[K comparable, V any] struct {
+	mu      sync.Mutex
+	changes []Change[K, V]
+	next    int
+	latest  uint64
+}
+
+// NewChangeLog creates a log keeping the last size changes, at least one.
+func NewChangeLog[K comparable, V any](size int) *ChangeLog[K, V] {
+	return &ChangeLog[K, V]{changes: make([]Change[K, V], 0, max(size, 1))}
+}
+
+// WithChangeLog makes the store append every change to log, so it can serve as a primary.
+func WithChangeLog[K comparable, V any](log *ChangeLog[K, V]) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.changes = log
+	}
+}
+
+// append records a change, the store calls it with its write lock held so the sequence follows the writes.
+func (l *ChangeLog[K, V]) append(typ EventType, key K, value V, epoch uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.latest++
+	change := Change[K, V]{Seq: l.latest, Type: typ, Key: key, Value: value, Epoch: epoch}
+	if len(l.changes) < cap(l.changes) {
+		l.changes = append(l.changes, change)
+		return
+	}
+	l.changes[l.next] = change
+	l.next = (l.next + 1) % len(l.changes)
+}
+
+// Since returns up to limit changes following seq, oldest first, along with the latest sequence number.
+// ok is false if changes after seq have already been dropped from the log.
+func (l *ChangeLog[K, V]) Since(seq uint64, limit int) (changes []Change[K, V], latest uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq >= l.latest {
+		return nil, l.latest, true
+	}
+	oldest := l.latest - uint64(len(l.changes)) + 1
+	if seq+1 < oldest {
+		return nil, l.latest, false
+	}
+	for i := seq + 1 - oldest; i < uint64(len(l.changes)) && len(changes) < limit; i++ {
+		changes = append(changes, l.changes[(l.next+int(i))%len(l.changes)])
+	}
+	return changes, l.latest, true
+}
+
+// WithReplicationSource serves the change stream of log on GET /replication/changes, for the replicas of this server.
+func WithReplicationSource(log *ChangeLog[string, string]) ServerOption {
+	return func(s *Server) {
+		s.changes = log
+	}
+}
+
+// handleChanges serves GET /replication/changes?since=N, the changes following the sequence number N.
+func (s *Server) handleChanges(c echo.Context) error {
+	var since uint64
+	if param := c.QueryParam("since"); param != "" {
+		var err error
+		if since, err = strconv.ParseUint(param, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid since: "+param)
+		}
+	}
+
+	changes, latest, ok := s.changes.Since(since, defaultChangeBatch)
+	if !ok {
+		return echo.NewHTTPError(http.StatusGone, fmt.Sprintf("the changes after %d are no longer available", since))
+	}
+	if changes == nil {
+		changes = []Change[string, string]{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"changes": changes, "latest": latest})
+}
+
+type ReplicaState string
+
+const (
+	ReplicaConnecting   ReplicaState = "connecting"
+	ReplicaConnected    ReplicaState = "connected"
+	ReplicaDisconnected ReplicaState = "disconnected"
+	// ReplicaStale means the replica fell behind further than the change log of the primary, it has to be
+	// rebuilt from a snapshot.
+	ReplicaStale ReplicaState = "stale"
+)
+
+// ReplicationStatus is how far a replica is behind its primary. LagSeconds is how long the replica has had
+// changes pending, 0 when it's caught up.
+type ReplicationStatus struct {
+	LastApplied   uint64       `json:"last_applied"`
+	PrimaryLatest uint64       `json:"primary_latest"`
+	LagOps        uint64       `json:"lag_ops"`
+	LagSeconds    float64      `json:"lag_seconds"`
+	State         ReplicaState `json:"state"`
+	LastContact   *time.Time   `json:"last_contact,omitempty"`
+}
+
+// Replica applies the change stream of a primary to a local store, by polling its GET /replication/changes.
+type Replica struct {
+	primary  string
+	store    Storer[string, string]
+	client   *http.Client
+	clock    Clock
+	interval time.Duration
+	logger   Logger
+
+	mu            sync.Mutex
+	lastApplied   uint64
+	primaryLatest uint64
+	state         ReplicaState
+	lastContact   time.Time
+	// behindSince is when the replica last saw changes it hadn't applied yet, zero while it's caught up.
+	behindSince time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// ReplicaOption configures the optional behaviour of a Replica.
+type ReplicaOption func(*Replica)
+
+// WithReplicaPollInterval sets how often the replica polls the primary, the default is 200ms.
+func WithReplicaPollInterval(d time.Duration) ReplicaOption {
+	return func(r *Replica) {
+		r.interval = d
+	}
+}
+
+// WithReplicaHTTPClient sets the http.Client used to reach the primary, the default is http.DefaultClient.
+func WithReplicaHTTPClient(client *http.Client) ReplicaOption {
+	return func(r *Replica) {
+		r.client = client
+	}
+}
+
+// WithReplicaClock sets the clock the lag is measured with.
+func WithReplicaClock(clock Clock) ReplicaOption {
+	return func(r *Replica) {
+		r.clock = clock
+	}
+}
+
+// NewReplica creates a replica of the primary, given as a base URL like http://10.0.0.1:3000, applying its
+// changes to store. The store should start empty, or as a copy of the primary.
+func NewReplica(primary string, store Storer[string, string], opts ...ReplicaOption) *Replica {
+	r := &Replica{
+		primary:  primary,
+		store:    store,
+		client:   http.DefaultClient,
+		clock:    realClock{},
+		interval: defaultReplicaPollInterval,
+		logger:   defaultLogger,
+		state:    ReplicaConnecting,
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Start polls the primary in the background until Stop is called.
+func (r *Replica) Start() {
+	if testMode.Load() {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.Sync(context.Background()); err != nil {
+				r.logger.Debug("replicating from %s failed: %v", r.primary, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background polling, it's safe to call more than once.
+func (r *Replica) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+// Sync fetches and applies a batch of changes from the primary, Start calls it periodically.
+func (r *Replica) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	since := r.lastApplied
+	r.mu.Unlock()
+
+	url := r.primary + "/replication/changes?since=" + strconv.FormatUint(since, 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		r.setState(ReplicaDisconnected)
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		r.setState(ReplicaStale)
+		return fmt.Errorf("%s no longer has the changes after %d", r.primary, since)
+	default:
+		r.setState(ReplicaDisconnected)
+		return fmt.Errorf("%s answered %s", r.primary, res.Status)
+	}
+
+	var body struct {
+		Changes []Change[string, string] `json:"changes"`
+		Latest  uint64                   `json:"latest"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		r.setState(ReplicaDisconnected)
+		return err
+	}
+
+	// A store with epochs follows the epoch of the primary, so that once promoted it fences the writes of the
+	// primary's epoch too.
+	fencer, fenced := r.store.(Fencer[string, string])
+	for _, change := range body.Changes {
+		apply := func() error {
+			switch change.Type {
+			case EventPut:
+				return r.store.Put(change.Key, change.Value)
+			case EventClear:
+				flusher, ok := r.store.(Flusher)
+				if !ok {
+					return errors.New("the store of the replica does not support flushing")
+				}
+				return flusher.Clear()
+			}
+			_, err := r.store.Delete(change.Key)
+			if errors.Is(err, ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		if fenced {
+			err = fencer.Fenced(change.Epoch, apply)
+		} else {
+			err = apply()
+		}
+		if err != nil {
+			return fmt.Errorf("applying change %d: %w", change.Seq, err)
+		}
+		r.mu.Lock()
+		r.lastApplied = change.Seq
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	r.state, r.lastContact = ReplicaConnected, now
+	r.primaryLatest = max(r.primaryLatest, body.Latest)
+	switch {
+	case r.lastApplied >= r.primaryLatest:
+		r.behindSince = time.Time{}
+	case r.behindSince.IsZero():
+		r.behindSince = now
+	}
+	return nil
+}
+
+func (r *Replica) setState(state ReplicaState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+}
+
+// Status returns how far the replica is behind the primary, as of its last contact with it.
+func (r *Replica) Status() ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := ReplicationStatus{
+		LastApplied:   r.lastApplied,
+		PrimaryLatest: r.primaryLatest,
+		State:         r.state,
+	}
+	if r.primaryLatest > r.lastApplied {
+		status.LagOps = r.primaryLatest - r.lastApplied
+	}
+	if !r.behindSince.IsZero() {
+		status.LagSeconds = r.clock.Now().Sub(r.behindSince).Seconds()
+	}
+	if !r.lastContact.IsZero() {
+		lastContact := r.lastContact
+		status.LastContact = &lastContact
+	}
+	return status
+}
+
+// WithReplica serves the status of the replica on GET /replication/status.
+func WithReplica(r *Replica) ServerOption {
+	return func(s *Server) {
+		s.replica = r
+	}
+}
+
+// handleReplicationStatus serves GET /replication/status.
+func (s *Server) handleReplicationStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.replica.Status())
+}
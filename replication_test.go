@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newPrimary serves a store with a change log of size entries, it's closed with the test.
+func newPrimary(t *testing.T, size int) (*KVStore[string, string], *httptest.Server) {
+	t.Helper()
+	log := NewChangeLog[string, string](size)
+	st := NewKVStore[string, string](WithChangeLog[string, string](log))
+	_, h := newTestServer(st, WithReplicationSource(log))
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return st, srv
+}
+
+func TestReplicationStatus(t *testing.T) {
+	primary, srv := newPrimary(t, 10000)
+	// More changes than a batch, so the first sync leaves some pending.
+	for i := 0; i < defaultChangeBatch+500; i++ {
+		primary.Put(fmt.Sprint(i), "v")
+	}
+	primary.Delete("0")
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	local := NewKVStore[string, string]()
+	replica := NewReplica(srv.URL, local, WithReplicaClock(clock))
+	if status := replica.Status(); status.State != ReplicaConnecting || status.LastContact != nil {
+		t.Fatalf("got %+v before the first sync", status)
+	}
+
+	if err := replica.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(3 * time.Second)
+	status := replica.Status()
+	if status.State != ReplicaConnected || status.LastApplied != defaultChangeBatch || status.PrimaryLatest != defaultChangeBatch+501 || status.LagOps != 501 || status.LagSeconds != 3 {
+		t.Fatalf("got %+v after the first batch", status)
+	}
+
+	_, h := newTestServer(local, WithReplica(replica))
+	rec := do(t, h, http.MethodGet, "/replication/status", "")
+	expectStatus(t, rec, http.StatusOK)
+	var served ReplicationStatus
+	json.Unmarshal(rec.Body.Bytes(), &served)
+	if served.LagOps != 501 || served.State != ReplicaConnected {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	if n, err := replica.Tick(); err != nil || n != 501 {
+		t.Fatalf("the catch-up applied %d changes: %v", n, err)
+	}
+	if status := replica.Status(); status.LagOps != 0 || status.LagSeconds != 0 || status.LastApplied != status.PrimaryLatest {
+		t.Fatalf("got %+v after catching up", status)
+	}
+	if _, err := local.Get("0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the delete wasn't replicated: %v", err)
+	}
+	if v, _ := local.Get("1499"); v != "v" {
+		t.Fatalf("the last put wasn't replicated: %q", v)
+	}
+}
+
+func TestReplicaFallingBehindTheLog(t *testing.T) {
+	primary, srv := newPrimary(t, 10)
+	for i := 0; i < 20; i++ {
+		primary.Put(fmt.Sprint(i), "v")
+	}
+	replica := NewReplica(srv.URL, NewKVStore[string, string]())
+	if err := replica.Sync(context.Background()); err == nil {
+		t.Fatal("the sync succeeded without the first changes")
+	}
+	if state := replica.Status().State; state != ReplicaStale {
+		t.Fatalf("got the state %s", state)
+	}
+
+	srv.Close()
+	replica.Sync(context.Background())
+	if state := replica.Status().State; state != ReplicaDisconnected {
+		t.Fatalf("got the state %s with the primary down", state)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/labstack/echo/v4"
+)
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the request it's for.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or "" if there's none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDs is the middleware giving every request an ID: the X-Request-Id sent by the client, or a random one.
+// It's sent back in the X-Request-Id of the response and carried by the context of the request, which the
+// handlers pass down to the stores that are ContextStorers: the hooks of a HookedStore see it and a TracingStore
+// logs it, and the journal and the slow log record it, so all the operations of a request can be traced back
+// to it.
+func (s *Server) requestIDs(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		id := req.Header.Get(echo.HeaderXRequestID)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		c.SetRequest(req.WithContext(ContextWithRequestID(req.Context(), id)))
+		return next(c)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID given to the request by the requestIDs middleware.
+func requestID(c echo.Context) string {
+	return RequestIDFromContext(c.Request().Context())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequestIDEchoed(t *testing.T) {
+	_, h := newTestServer(NewKVStore[string, string]())
+
+	rec := do(t, h, http.MethodGet, "/health", "", echo.HeaderXRequestID, "abc")
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "abc" {
+		t.Fatalf("got X-Request-Id %q, want abc", got)
+	}
+	rec = do(t, h, http.MethodGet, "/health", "")
+	if got := rec.Header().Get(echo.HeaderXRequestID); len(got) != 16 {
+		t.Fatalf("got generated X-Request-Id %q", got)
+	}
+}
+
+func TestRequestIDReachesHooks(t *testing.T) {
+	hooked := NewHookedStore[string, string](NewKVStore[string, string]())
+	seen := map[OperationKind]string{}
+	hooked.OnBefore(func(op *Operation[string, string]) error {
+		seen[op.Kind] = RequestIDFromContext(op.Context)
+		return nil
+	})
+	_, h := newTestServer(hooked)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", echo.HeaderXRequestID, "put-1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", "", echo.HeaderXRequestID, "get-1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", echo.HeaderXRequestID, "delete-1"), http.StatusOK)
+
+	want := map[OperationKind]string{OperationPut: "put-1", OperationGet: "get-1", OperationDelete: "delete-1"}
+	for kind, id := range want {
+		if seen[kind] != id {
+			t.Errorf("the %s hook saw request %q, want %q", kind, seen[kind], id)
+		}
+	}
+
+	// The calls without a context still give the hooks one.
+	hooked.Put("b", "2")
+	if seen[OperationPut] != "" {
+		t.Errorf("got request %q for a plain Put", seen[OperationPut])
+	}
+}
+
+func TestRequestIDTraced(t *testing.T) {
+	logger := &recordingLogger{}
+	st := Chain[string, string](NewKVStore[string, string](), Metered[string, string](NopSink{}), Traced[string, string](logger))
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1", echo.HeaderXRequestID, "trace-me"), http.StatusOK)
+	if !logger.contains("request trace-me: put (a) took") {
+		t.Fatalf("the request ID wasn't traced: %q", logger.messages)
+	}
+}
+
+func TestContextStorerCanceled(t *testing.T) {
+	st := NewKVStore[string, string]()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := putWithContext[string, string](ctx, st, "a", "1"); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, err := st.Get("a"); err == nil {
+		t.Fatal("the canceled put was applied")
+	}
+}
+
+func TestGetCanceledRequest(t *testing.T) {
+	loads := 0
+	st := NewKVStore[string, string](WithLoader[string, string](func(key string) (string, bool, error) {
+		loads++
+		return "loaded", true, nil
+	}))
+	st.PutWithTTL("a", "1", time.Minute)
+	_, h := newTestServer(st, WithLegacyGetRoutes())
+
+	for _, target := range []string{"/kv/a", "/get/a", "/kv/b"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			t.Errorf("GET %s answered 200 for a canceled request", target)
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("the loader ran %d times for canceled requests", loads)
+	}
+
+	if _, ttl, err := st.GetWithTTLContext(context.Background(), "a"); err != nil || ttl <= 0 {
+		t.Fatalf("got %s, %v", ttl, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultMaxScanResults = 1000
+
+// Filterer is implemented by stores that can return the entries matching a predicate.
+type Filterer[K comparable, V any] interface {
+	Filter(func(K, V) bool) map[K]V
+}
+
+// Filter returns a copy of every entry for which pred returns true.
+// pred is called with the read lock held, so it must not call back into the store.
+func (s *KVStore[K, V]) Filter(pred func(K, V) bool) map[K]V {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	matches := make(map[K]V)
+	for key, value := range s.data {
+		if !s.isExpired(key) && pred(key, value) {
+			matches[key] = value
+		}
+	}
+	return matches
+}
+
+// WithMaxScanResults caps how many entries a single GET /scan returns, the default is 1000.
+func WithMaxScanResults(n int) ServerOption {
+	return func(s *Server) {
+		s.maxScanResults = n
+	}
+}
+
+// handleScan serves GET /scan, returning the entries whose value matches all the given
+// ?contains=, ?prefix= and ?regex= parameters. Without any parameter every entry matches.
+func (s *Server) handleScan(c echo.Context) error {
+	filterer, ok := s.Storage.(Filterer[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support scans")
+	}
+
+	contains, prefix := c.QueryParam("contains"), c.QueryParam("prefix")
+
+	var re *regexp.Regexp
+	if expr := c.QueryParam("regex"); expr != "" {
+		var err error
+		if re, err = regexp.Compile(expr); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid regex: "+err.Error())
+		}
+	}
+
+	matched := 0
+	entries := filterer.Filter(func(_ string, value string) bool {
+		if contains != "" && !strings.Contains(value, contains) {
+			return false
+		}
+		if prefix != "" && !strings.HasPrefix(value, prefix) {
+			return false
+		}
+		if re != nil && !re.MatchString(value) {
+			return false
+		}
+		// Keeps counting past the limit so we can tell the client the result was truncated.
+		matched++
+		return matched <= s.maxScanResults
+	})
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"entries":   entries,
+		"truncated": matched > s.maxScanResults,
+	})
+}
+
+// ScanCursor iterates the store in batches without copying all of it, like Redis' SCAN. Start with cursor 0
+// and pass the returned next cursor to the following call, the iteration is over when next is 0.
+// count is how many entries to return per batch, a batch may hold a few more.
+//
+// The guarantees are weak on purpose: a key present for the whole iteration is returned at least once, but a
+// key added or removed meanwhile may or may not be. The keys are visited in the order of their hash, which
+// is what the cursor encodes, so each call still goes through every key of the store.
+func (s *KVStore[K, V]) ScanCursor(cursor uint64, count int) (entries map[K]V, next uint64) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	type candidate struct {
+		hash uint64
+		key  K
+	}
+	var candidates []candidate
+	for key := range s.data {
+		if h := fnvHash(keyString(key)); h >= cursor && !s.isExpired(key) {
+			candidates = append(candidates, candidate{hash: h, key: key})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].hash < candidates[j].hash })
+
+	if count < 1 {
+		count = 1
+	}
+	entries = make(map[K]V)
+	for i, c := range candidates {
+		// Keys sharing a hash must land in the same batch, the cursor can't point between them.
+		if i >= count && c.hash != candidates[i-1].hash {
+			return entries, candidates[i-1].hash + 1
+		}
+		entries[c.key] = s.data[c.key]
+	}
+	return entries, 0
+}
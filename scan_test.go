@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "apple")
+	st.Put("b", "banana")
+	st.Put("c", "cherry")
+
+	got := st.Filter(func(_, v string) bool { return strings.Contains(v, "an") })
+	if !reflect.DeepEqual(got, map[string]string{"b": "banana"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// scan returns the entries of GET /scan with the query.
+func scan(t *testing.T, h http.Handler, query string) (map[string]string, bool) {
+	t.Helper()
+	rec := do(t, h, http.MethodGet, "/scan?"+query, "")
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		Entries   map[string]string `json:"entries"`
+		Truncated bool              `json:"truncated"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Entries, body.Truncated
+}
+
+func TestScanPredicates(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "apple")
+	st.Put("b", "banana")
+	st.Put("c", "cherry")
+	_, h := newTestServer(st)
+
+	for query, want := range map[string]map[string]string{
+		"contains=an":         {"b": "banana"},
+		"prefix=ch":           {"c": "cherry"},
+		"regex=^(a|c)":        {"a": "apple", "c": "cherry"},
+		"contains=e&prefix=a": {"a": "apple"},
+		"regex=x":             {},
+		"":                    {"a": "apple", "b": "banana", "c": "cherry"},
+	} {
+		if got, _ := scan(t, h, query); !reflect.DeepEqual(got, want) {
+			t.Errorf("?%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	expectStatus(t, do(t, h, http.MethodGet, "/scan?regex=(", ""), http.StatusBadRequest)
+}
+
+func TestScanTruncated(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for _, key := range []string{"a", "b", "c"} {
+		st.Put(key, "v")
+	}
+	_, h := newTestServer(st, WithMaxScanResults(2))
+
+	entries, truncated := scan(t, h, "")
+	if len(entries) != 2 || !truncated {
+		t.Fatalf("got %d entries, truncated %t", len(entries), truncated)
+	}
+}
+
+func TestScanCursorCoversTheStore(t *testing.T) {
+	st := NewKVStore[string, string]()
+	for i := 0; i < 500; i++ {
+		st.Put(fmt.Sprintf("stable%d", i), "v")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("churn%d", i%100)
+			if i%2 == 0 {
+				st.Put(key, "v")
+			} else {
+				st.Delete(key)
+			}
+		}
+	}()
+
+	seen := make(map[string]int)
+	batches := 0
+	for cursor := uint64(0); ; {
+		var entries map[string]string
+		entries, cursor = st.ScanCursor(cursor, 50)
+		batches++
+		for key := range entries {
+			seen[key]++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	close(stop)
+	<-done
+
+	for i := 0; i < 500; i++ {
+		if key := fmt.Sprintf("stable%d", i); seen[key] != 1 {
+			t.Fatalf("%s was returned %d times", key, seen[key])
+		}
+	}
+	if batches < 10 {
+		t.Fatalf("the scan took %d batches of 50 for over 500 keys", batches)
+	}
+}
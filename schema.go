@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrSchemaValidation is wrapped by the SchemaError returned for a value that doesn't match the schema set with
+// WithValueSchema.
+var ErrSchemaValidation = errors.New("the value doesn't match the schema")
+
+// SchemaError lists every reason the value doesn't match the schema, each one prefixed with the path of the
+// offending part of the value, e.g. "$.tags[1]: expected string, got number".
+type SchemaError struct {
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSchemaValidation, strings.Join(e.Errors, "; "))
+}
+
+func (e *SchemaError) Unwrap() error { return ErrSchemaValidation }
+
+// WithValueSchema makes every write check its value against a JSON Schema, a value that doesn't match is rejected
+// with a *SchemaError. String and byte slice values are validated as JSON text, the other ones as their JSON
+// encoding. The supported keywords are type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf,
+// anyOf and not, the other ones are ignored. It panics if the schema is invalid.
+func WithValueSchema[K comparable, V any](schema []byte) Option[K, V] {
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		panic(fmt.Sprintf("WithValueSchema: %v", err))
+	}
+	return func(s *KVStore[K, V]) {
+		s.schema = compiled
+	}
+}
+
+// validate checks the key and the value of a write, it's called before taking the lock.
+func (s *KVStore[K, V]) validate(key K, value V) error {
+	if err := s.validateKey(key); err != nil {
+		return err
+	}
+	return s.validateValue(value)
+}
+
+// validateValue checks the value against the schema of the store, if there is one.
+func (s *KVStore[K, V]) validateValue(value V) error {
+	if s.schema == nil {
+		return nil
+	}
+
+	var text []byte
+	switch v := any(value).(type) {
+	case string:
+		text = []byte(v)
+	case []byte:
+		text = v
+	case json.RawMessage:
+		text = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrSchemaValidation, err)
+		}
+		text = b
+	}
+
+	var instance any
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.UseNumber()
+	if err := dec.Decode(&instance); err != nil {
+		return &SchemaError{Errors: []string{"$: invalid JSON: " + err.Error()}}
+	}
+	if errs := s.schema.validate("$", instance, nil); len(errs) > 0 {
+		return &SchemaError{Errors: errs}
+	}
+	return nil
+}
+
+// jsonSchema is a compiled schema, nil fields are keywords the schema doesn't use.
+type jsonSchema struct {
+	// never is the false schema, bool schemas are allowed anywhere a schema is.
+	never bool
+
+	types      []string
+	enum       []any
+	constant   *any
+	properties map[string]*jsonSchema
+	required   []string
+	additional *jsonSchema
+	items      *jsonSchema
+	minItems   *int
+	maxItems   *int
+	minLength  *int
+	maxLength  *int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+	exclMin    *float64
+	exclMax    *float64
+	allOf      []*jsonSchema
+	anyOf      []*jsonSchema
+	not        *jsonSchema
+}
+
+func compileSchema(text []byte) (*jsonSchema, error) {
+	var raw any
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return compileNode("#", raw)
+}
+
+func compileNode(path string, raw any) (*jsonSchema, error) {
+	switch raw := raw.(type) {
+	case bool:
+		return &jsonSchema{never: !raw}, nil
+	case map[string]any:
+		return compileObject(path, raw)
+	}
+	return nil, fmt.Errorf("%s: a schema must be an object or a boolean", path)
+}
+
+func compileObject(path string, raw map[string]any) (*jsonSchema, error) {
+	s := &jsonSchema{}
+	var err error
+
+	switch t := raw["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: the types must be strings", path)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or an array", path)
+	}
+
+	if v, ok := raw["enum"]; ok {
+		values, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", path)
+		}
+		s.enum = values
+	}
+	if v, ok := raw["const"]; ok {
+		s.constant = &v
+	}
+
+	if v, ok := raw["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", path)
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = compileNode(path+"/properties/"+name, prop); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := raw["required"]; ok {
+		names, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array", path)
+		}
+		for _, name := range names {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: the names must be strings", path)
+			}
+			s.required = append(s.required, str)
+		}
+	}
+	if v, ok := raw["additionalProperties"]; ok {
+		if s.additional, err = compileNode(path+"/additionalProperties", v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := raw["items"]; ok {
+		if s.items, err = compileNode(path+"/items", v); err != nil {
+			return nil, err
+		}
+	}
+
+	ints := map[string]**int{"minItems": &s.minItems, "maxItems": &s.maxItems, "minLength": &s.minLength, "maxLength": &s.maxLength}
+	for name, field := range ints {
+		if v, ok := raw[name]; ok {
+			n, err := schemaNumber(v)
+			if err != nil || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", path, name)
+			}
+			i := int(n)
+			*field = &i
+		}
+	}
+	floats := map[string]**float64{"minimum": &s.minimum, "maximum": &s.maximum, "exclusiveMinimum": &s.exclMin, "exclusiveMaximum": &s.exclMax}
+	for name, field := range floats {
+		if v, ok := raw[name]; ok {
+			n, err := schemaNumber(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: must be a number", path, name)
+			}
+			*field = &n
+		}
+	}
+
+	if v, ok := raw["pattern"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", path, err)
+		}
+	}
+
+	for name, field := range map[string]*[]*jsonSchema{"allOf": &s.allOf, "anyOf": &s.anyOf} {
+		if v, ok := raw[name]; ok {
+			list, ok := v.([]any)
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%s/%s: must be a non-empty array", path, name)
+			}
+			for i, sub := range list {
+				compiled, err := compileNode(fmt.Sprintf("%s/%s/%d", path, name, i), sub)
+				if err != nil {
+					return nil, err
+				}
+				*field = append(*field, compiled)
+			}
+		}
+	}
+	if v, ok := raw["not"]; ok {
+		if s.not, err = compileNode(path+"/not", v); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func schemaNumber(v any) (float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("not a number")
+	}
+	return n.Float64()
+}
+
+// validate appends to errs why the instance at path doesn't match the schema.
+func (s *jsonSchema) validate(path string, instance any, errs []string) []string {
+	if s.never {
+		return append(errs, path+": no value is allowed here")
+	}
+
+	if len(s.types) > 0 && !s.matchesType(instance) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), typeOf(instance)))
+	}
+	if s.enum != nil && !containsJSON(s.enum, instance) {
+		errs = append(errs, fmt.Sprintf("%s: must be one of %s", path, encodeJSON(s.enum)))
+	}
+	if s.constant != nil && !equalJSON(*s.constant, instance) {
+		errs = append(errs, fmt.Sprintf("%s: must be %s", path, encodeJSON(*s.constant)))
+	}
+
+	switch v := instance.(type) {
+	case map[string]any:
+		errs = s.validateObject(path, v, errs)
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at least %d items, it has %d", path, *s.minItems, len(v)))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at most %d items, it has %d", path, *s.maxItems, len(v)))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				errs = s.items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case string:
+		n := len([]rune(v))
+		if s.minLength != nil && n < *s.minLength {
+			errs = append(errs, fmt.Sprintf("%s: must be at least %d characters long, it's %d", path, *s.minLength, n))
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			errs = append(errs, fmt.Sprintf("%s: must be at most %d characters long, it's %d", path, *s.maxLength, n))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s: must match %s", path, s.pattern))
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if s.minimum != nil && n < *s.minimum {
+			errs = append(errs, fmt.Sprintf("%s: must be at least %v", path, *s.minimum))
+		}
+		if s.maximum != nil && n > *s.maximum {
+			errs = append(errs, fmt.Sprintf("%s: must be at most %v", path, *s.maximum))
+		}
+		if s.exclMin != nil && n <= *s.exclMin {
+			errs = append(errs, fmt.Sprintf("%s: must be greater than %v", path, *s.exclMin))
+		}
+		if s.exclMax != nil && n >= *s.exclMax {
+			errs = append(errs, fmt.Sprintf("%s: must be less than %v", path, *s.exclMax))
+		}
+	}
+
+	for _, sub := range s.allOf {
+		errs = sub.validate(path, instance, errs)
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.validate(path, instance, nil)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, path+": doesn't match any of the anyOf schemas")
+		}
+	}
+	if s.not != nil && len(s.not.validate(path, instance, nil)) == 0 {
+		errs = append(errs, path+": must not match the not schema")
+	}
+	return errs
+}
+
+func (s *jsonSchema) validateObject(path string, obj map[string]any, errs []string) []string {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+		}
+	}
+
+	// Sorted so the errors come in the same order every time.
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propPath := path + "." + name
+		if prop, ok := s.properties[name]; ok {
+			errs = prop.validate(propPath, obj[name], errs)
+		} else if s.additional != nil {
+			if s.additional.never {
+				errs = append(errs, fmt.Sprintf("%s: unexpected property", propPath))
+			} else {
+				errs = s.additional.validate(propPath, obj[name], errs)
+			}
+		}
+	}
+	return errs
+}
+
+func (s *jsonSchema) matchesType(instance any) bool {
+	actual := typeOf(instance)
+	for _, t := range s.types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded value, numbers without a fractional part are integers.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsJSON(values []any, v any) bool {
+	for _, candidate := range values {
+		if equalJSON(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalJSON compares two decoded values, numbers by their value rather than how they were written.
+func equalJSON(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, v := range a {
+			if w, ok := b[name]; !ok || !equalJSON(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func encodeJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "member"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3}
+	},
+	"additionalProperties": false
+}`
+
+func TestValueSchema(t *testing.T) {
+	st := NewKVStore[string, string](WithValueSchema[string, string]([]byte(userSchema)))
+
+	if err := st.Put("ada", `{"name": "Ada", "age": 36, "role": "admin", "tags": ["math"]}`); err != nil {
+		t.Fatalf("a valid value was rejected: %v", err)
+	}
+
+	err := st.Put("bob", `{"name": "", "age": -1, "role": "root", "tags": ["a", 2], "extra": true}`)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("got %v, want a SchemaError", err)
+	}
+	want := []string{
+		"$.age: must be at least 0",
+		"$.extra: unexpected property",
+		"$.name: must be at least 1 characters long, it's 0",
+		`$.role: must be one of ["admin","member"]`,
+		"$.tags[1]: expected string, got integer",
+	}
+	if !reflect.DeepEqual(schemaErr.Errors, want) {
+		t.Fatalf("got the errors %q, want %q", schemaErr.Errors, want)
+	}
+	if _, err := st.Get("bob"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("an invalid value was stored: %v", err)
+	}
+
+	for value, reason := range map[string]string{
+		`{"name": "Bob"}`: `$: missing required property "age"`,
+		`[1, 2]`:          "$: expected object, got array",
+		`{"name":`:        "$: invalid JSON",
+	} {
+		err := st.Update("ada", value)
+		if !errors.As(err, &schemaErr) || len(schemaErr.Errors) != 1 || !strings.HasPrefix(schemaErr.Errors[0], reason) {
+			t.Errorf("Update(%s): got %v, want %s", value, err, reason)
+		}
+	}
+}
+
+func TestValueSchemaOfTypedValues(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	st := NewKVStore[string, user](WithValueSchema[string, user]([]byte(userSchema)))
+	if err := st.Put("ada", user{Name: "Ada", Age: 36}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put("nobody", user{Age: 1}); !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestInvalidSchemaPanics(t *testing.T) {
+	for _, schema := range []string{`{"type": 1}`, `{"minLength": -1}`, `{"pattern": "("}`, `"object"`, `{`} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("the schema %s was accepted", schema)
+				}
+			}()
+			WithValueSchema[string, string]([]byte(schema))
+		}()
+	}
+}
+
+func TestValueSchemaOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string](WithValueSchema[string, string]([]byte(userSchema)))
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/ada", `{"name": "Ada", "age": 36}`), http.StatusOK)
+	rec := do(t, h, http.MethodPut, "/kv/bob", `{"name": "Bob", "age": "old"}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var body struct {
+		Message string   `json:"message"`
+		Errors  []string `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Message != ErrSchemaValidation.Error() || !reflect.DeepEqual(body.Errors, []string{"$.age: expected integer, got string"}) {
+		t.Fatalf("got %s", rec.Body)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io"
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+)
+
+// ShardedKVStore spreads its keys over several KVStores so that writes to different keys don't all contend on
+// a single lock.
+type ShardedKVStore[K comparable, V any] struct {
+	shards []*KVStore[K, V]
+	// mask is len(shards)-1, the shard count is always a power of two so the shard of a hash is hash & mask.
+	mask uint64
+	// ops counts the operations routed to every shard, for ShardStats.
+	ops []shardOps
+}
+
+type shardOps struct {
+	reads, writes atomic.Uint64
+}
+
+// NewShardedKVStore creates a sharded store with a shard count tuned to the available parallelism, the next
+// power of two ≥ GOMAXPROCS*4. The options are applied to every shard.
+func NewShardedKVStore[K comparable, V any](opts ...Option[K, V]) *ShardedKVStore[K, V] {
+	return NewShardedKVStoreWithShards[K, V](defaultShardCount(), opts...)
+}
+
+// NewShardedKVStoreWithShards creates a sharded store with n shards, n is rounded up to a power of two.
+func NewShardedKVStoreWithShards[K comparable, V any](n int, opts ...Option[K, V]) *ShardedKVStore[K, V] {
+	n = nextPowerOfTwo(n)
+	s := &ShardedKVStore[K, V]{
+		shards: make([]*KVStore[K, V], n),
+		mask:   uint64(n - 1),
+		ops:    make([]shardOps, n),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewKVStore[K, V](opts...)
+	}
+	return s
+}
+
+func defaultShardCount() int {
+	return nextPowerOfTwo(runtime.GOMAXPROCS(0) * 4)
+}
+
+// nextPowerOfTwo returns the smallest power of two ≥ n, and 1 for n ≤ 1.
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// ShardCount returns the number of shards.
+func (s *ShardedKVStore[K, V]) ShardCount() int {
+	return len(s.shards)
+}
+
+// shardIndex maps a key to its shard, by masking its hash instead of taking it modulo the shard count. The key
+// is hashed in its canonical form, so the case variants of a key land on the same shard.
+func (s *ShardedKVStore[K, V]) shardIndex(key K) int {
+	return int(fnvHash(keyString(s.shards[0].foldCase(key))) & s.mask)
+}
+
+func (s *ShardedKVStore[K, V]) shard(key K) *KVStore[K, V] {
+	return s.shards[s.shardIndex(key)]
+}
+
+func (s *ShardedKVStore[K, V]) Put(key K, value V) error {
+	i := s.shardIndex(key)
+	s.ops[i].writes.Add(1)
+	return s.shards[i].Put(key, value)
+}
+
+func (s *ShardedKVStore[K, V]) Get(key K) (V, error) {
+	i := s.shardIndex(key)
+	s.ops[i].reads.Add(1)
+	return s.shards[i].Get(key)
+}
+
+func (s *ShardedKVStore[K, V]) Update(key K, value V) error {
+	i := s.shardIndex(key)
+	s.ops[i].writes.Add(1)
+	return s.shards[i].Update(key, value)
+}
+
+func (s *ShardedKVStore[K, V]) Delete(key K) (V, error) {
+	i := s.shardIndex(key)
+	s.ops[i].writes.Add(1)
+	return s.shards[i].Delete(key)
+}
+
+// ShardStat describes the load of one shard. Reads and Writes count the operations routed to it since the store
+// was created, and LockWait is its lock wait histogram, empty unless the shards were created WithLockMetrics.
+type ShardStat struct {
+	Shard    int           `json:"shard"`
+	Keys     int           `json:"keys"`
+	Reads    uint64        `json:"reads"`
+	Writes   uint64        `json:"writes"`
+	LockWait LockWaitStats `json:"lock_wait"`
+}
+
+// ShardStats returns the stats of every shard in index order. A shard with much more operations or lock wait
+// than the others is hot, because of a hot key or keys the hash spreads badly, see ShardCount.
+func (s *ShardedKVStore[K, V]) ShardStats() []ShardStat {
+	stats := make([]ShardStat, len(s.shards))
+	for i, shard := range s.shards {
+		shard.rlock()
+		keys := len(shard.data)
+		shard.mu.RUnlock()
+
+		stats[i] = ShardStat{
+			Shard:    i,
+			Keys:     keys,
+			Reads:    s.ops[i].reads.Load(),
+			Writes:   s.ops[i].writes.Load(),
+			LockWait: shard.LockWaitStats(),
+		}
+	}
+	return stats
+}
+
+// Close stops the TTL sweepers of all the shards.
+func (s *ShardedKVStore[K, V]) Close() error {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	return nil
+}
+
+// SaveSnapshot writes a point-in-time snapshot of every shard to w, in the same format as KVStore.SaveSnapshot
+// so it can be loaded by either kind of store. All the shards are locked while their entries are encoded,
+// always in index order so it can't deadlock with another snapshot or load.
+func (s *ShardedKVStore[K, V]) SaveSnapshot(w io.Writer) error {
+	entries, err := s.encodeEntries()
+	if err != nil {
+		return err
+	}
+
+	return writeEntries(w, entries, s.shards[0].snapshotKey)
+}
+
+func (s *ShardedKVStore[K, V]) encodeEntries() ([]encodedEntry, error) {
+	for _, shard := range s.shards {
+		shard.rlock()
+		defer shard.mu.RUnlock()
+	}
+
+	var entries []encodedEntry
+	for _, shard := range s.shards {
+		var err error
+		if entries, err = shard.encodeEntries(entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LoadSnapshot replaces the contents of every shard with the entries read from r, which can come from a sharded
+// store with a different shard count or from a plain KVStore. The store is left untouched if r can't be read.
+func (s *ShardedKVStore[K, V]) LoadSnapshot(r io.Reader) error {
+	data, err := s.shards[0].readEntries(r)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]map[K]V, len(s.shards))
+	for i := range parts {
+		parts[i] = make(map[K]V)
+	}
+	for key, value := range data {
+		parts[s.shardIndex(key)][key] = value
+	}
+
+	for _, shard := range s.shards {
+		shard.lock()
+		defer shard.mu.Unlock()
+	}
+	for i, shard := range s.shards {
+		shard.replaceData(parts[i])
+	}
+
+	return nil
+}
+
+// LoadSnapshotInto is KVStore.LoadSnapshotInto for a sharded store, every entry goes straight to its shard. A
+// snapshot rejected by the first pass leaves every shard untouched.
+func (s *ShardedKVStore[K, V]) LoadSnapshotInto(r io.Reader) error {
+	first := s.shards[0]
+	checked, done, err := checkSnapshot(r, first.snapshotKey, first.legacySnapshots, first.keyCodec, first.valueCodec)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	for _, shard := range s.shards {
+		shard.lock()
+		defer shard.mu.Unlock()
+	}
+	for _, shard := range s.shards {
+		shard.replaceData(make(map[K]V))
+	}
+
+	err = decodeEntries(newSnapshotReader(checked, first.snapshotKey, first.legacySnapshots), first.keyCodec, first.valueCodec, func(key K, value V) error {
+		shard := s.shards[s.shardIndex(key)]
+		shard.loadEntry(key, value)
+		shard.evictOverCapacity(key)
+		return nil
+	})
+	for _, shard := range s.shards {
+		if err != nil {
+			shard.replaceData(make(map[K]V))
+		} else {
+			shard.finishLoad()
+		}
+	}
+	return err
+}
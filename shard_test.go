@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestDefaultShardCount(t *testing.T) {
+	st := NewShardedKVStore[string, string]()
+	n := st.ShardCount()
+	if n&(n-1) != 0 {
+		t.Fatalf("the default shard count %d isn't a power of two", n)
+	}
+	if min := runtime.GOMAXPROCS(0) * 4; n < min || n >= 2*min {
+		t.Fatalf("got %d shards, want the next power of two ≥ %d", n, min)
+	}
+
+	for in, want := range map[int]int{0: 1, 1: 1, 2: 2, 3: 4, 5: 8, 16: 16, 17: 32} {
+		if got := NewShardedKVStoreWithShards[string, string](in).ShardCount(); got != want {
+			t.Errorf("asking for %d shards gave %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestShardIndexMatchesModulo(t *testing.T) {
+	st := NewShardedKVStoreWithShards[string, string](16)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if got, want := st.shardIndex(key), int(fnvHash(key)%16); got != want {
+			t.Fatalf("%s maps to shard %d, the modulo gives %d", key, got, want)
+		}
+	}
+}
+
+func TestShardedStore(t *testing.T) {
+	st := NewShardedKVStoreWithShards[string, string](4)
+	for i := 0; i < 100; i++ {
+		st.Put(fmt.Sprintf("key%d", i), fmt.Sprint(i))
+	}
+	for i := 0; i < 100; i++ {
+		if v, err := st.Get(fmt.Sprintf("key%d", i)); err != nil || v != fmt.Sprint(i) {
+			t.Fatalf("got %q, %v", v, err)
+		}
+	}
+	if _, err := st.Delete("key1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Update("key1", "x"); err == nil {
+		t.Fatal("the deleted key was updated")
+	}
+}
+
+func TestShardedSnapshotIsPointInTime(t *testing.T) {
+	const n = 64
+	st := NewShardedKVStoreWithShards[string, int](8)
+	for i := 0; i < n; i++ {
+		st.Put(fmt.Sprintf("k%d", i), 0)
+	}
+
+	// The writer sweeps the keys in order, so at any instant the keys up to some index hold the current round
+	// and the rest the previous one. A snapshot taken shard by shard at different times would break that.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 1; ; round++ {
+			for i := 0; i < n; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				st.Put(fmt.Sprintf("k%d", i), round)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for attempt := 0; attempt < 50; attempt++ {
+		var buf bytes.Buffer
+		if err := st.SaveSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		// Both kinds of store load it.
+		plain := NewKVStore[string, int]()
+		if err := plain.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		sharded := NewShardedKVStoreWithShards[string, int](3)
+		if err := sharded.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		first, _ := plain.Get("k0")
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("k%d", i)
+			v, err := plain.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != first && v != first-1 || i > 0 && v > mustGet(t, plain, fmt.Sprintf("k%d", i-1)) {
+				t.Fatalf("attempt %d: the snapshot is torn at %s = %d, k0 = %d", attempt, key, v, first)
+			}
+			if w := mustGet(t, sharded, key); w != v {
+				t.Fatalf("the sharded store loaded %s = %d, the plain one %d", key, w, v)
+			}
+		}
+	}
+}
+
+func mustGet[V any](t *testing.T, st Storer[string, V], key string) V {
+	t.Helper()
+	v, err := st.Get(key)
+	if err != nil {
+		t.Fatalf("getting %s: %v", key, err)
+	}
+	return v
+}
+
+func TestShardStatsShowTheHotShard(t *testing.T) {
+	st := NewShardedKVStoreWithShards[string, string](8, WithLockMetrics[string, string]())
+	hot := st.shardIndex("hot")
+
+	// A hundred keys all on the same shard, written and read ten times each, and one key on every other shard.
+	var hotKeys []string
+	for i := 0; len(hotKeys) < 100; i++ {
+		if key := fmt.Sprint("key", i); st.shardIndex(key) == hot {
+			hotKeys = append(hotKeys, key)
+		}
+	}
+	for round := 0; round < 10; round++ {
+		for _, key := range hotKeys {
+			st.Put(key, "v")
+			st.Get(key)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprint("cold", i); st.shardIndex(key) != hot {
+			st.Put(key, "v")
+		}
+	}
+
+	stats := st.ShardStats()
+	if len(stats) != 8 {
+		t.Fatalf("got the stats of %d shards", len(stats))
+	}
+	// The busiest of the other shards.
+	var busiest ShardStat
+	for i, s := range stats {
+		if s.Shard != i {
+			t.Fatalf("the stats aren't in index order: %+v", stats)
+		}
+		if i != hot {
+			busiest.Reads = max(busiest.Reads, s.Reads)
+			busiest.Writes = max(busiest.Writes, s.Writes)
+			busiest.LockWait.Count = max(busiest.LockWait.Count, s.LockWait.Count)
+		}
+	}
+	h := stats[hot]
+	if h.Reads != 1000 || busiest.Reads != 0 {
+		t.Fatalf("got %d reads on the hot shard and up to %d on the others", h.Reads, busiest.Reads)
+	}
+	if h.Writes < 1000 || h.Writes < 5*busiest.Writes {
+		t.Fatalf("got %d writes on the hot shard and up to %d on the others", h.Writes, busiest.Writes)
+	}
+	if h.Keys < len(hotKeys) {
+		t.Fatalf("the hot shard holds %d keys", h.Keys)
+	}
+	if h.LockWait.Count < 5*busiest.LockWait.Count {
+		t.Fatalf("the hot shard took its lock %d times, another one %d", h.LockWait.Count, busiest.LockWait.Count)
+	}
+}
+
+func TestShardStatsOverHTTP(t *testing.T) {
+	st := NewShardedKVStoreWithShards[string, string](4)
+	st.Put("a", "1")
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodGet, "/stats/shards", "")
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		Shards []ShardStat `json:"shards"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Shards) != 4 || body.Shards[st.shardIndex("a")].Writes != 1 || body.Shards[st.shardIndex("a")].Keys != 1 {
+		t.Fatalf("got %s", rec.Body)
+	}
+
+	_, h = newTestServer(NewKVStore[string, string]())
+	expectStatus(t, do(t, h, http.MethodGet, "/stats/shards", ""), http.StatusNotImplemented)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+const defaultDrainTimeout = 10 * time.Second
+
+// The priorities of the shutdown steps, Stop runs the hooks in increasing order. The HTTP server always goes
+// first so nothing writes to the store while it's being flushed, and the storage is closed last since the
+// hooks before may still need it.
+const (
+	ShutdownHTTP     = 0
+	ShutdownFlush    = 100
+	ShutdownSnapshot = 200
+	ShutdownClose    = 300
+)
+
+type shutdownHook struct {
+	name     string
+	priority int
+	fn       func(context.Context) error
+}
+
+// WithDrainTimeout sets how long Stop waits for the in-flight requests and the background tasks before
+// force-closing, the default is 10s.
+func WithDrainTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.drainTimeout = d
+	}
+}
+
+// OnShutdown registers fn to be run by Stop, after the hooks of lower priority and before those of higher
+// priority. Hooks of the same priority run in the order they were registered. E.g. a WAL flush is registered
+// with ShutdownFlush and a final snapshot with ShutdownSnapshot, so the snapshot includes the last writes.
+func (s *Server) OnShutdown(name string, priority int, fn func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, shutdownHook{name: name, priority: priority, fn: fn})
+}
+
+// Stop shuts the server down gracefully: it stops accepting connections and waits up to the drain timeout
+// for the in-flight requests, then closes the remaining connections. It then runs the hooks registered with
+// OnShutdown and closes the storage if it implements io.Closer, all within what's left of the same budget.
+// A failing step is logged and the next ones still run. It returns the errors of the steps, and
+// context.DeadlineExceeded if the budget ran out.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+
+	hooks := s.shutdownHooks()
+	done := make(chan error, 1)
+	go func() {
+		var errs []error
+		for _, hook := range hooks {
+			if err := hook.fn(ctx); err != nil {
+				s.logger.Error("shutting down %s failed: %v", hook.name, err)
+				errs = append(errs, err)
+			}
+		}
+		done <- errors.Join(errs...)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.logger.Error("the shutdown didn't finish within %s", s.drainTimeout)
+		return ctx.Err()
+	}
+}
+
+// shutdownHooks returns the hooks registered with OnShutdown along with the built-in ones, sorted by priority.
+func (s *Server) shutdownHooks() []shutdownHook {
+	s.mu.Lock()
+	s.closed = true
+	e := s.echo
+	hooks := []shutdownHook{{name: "http server", priority: ShutdownHTTP, fn: func(ctx context.Context) error {
+		if e == nil {
+			return nil
+		}
+		err := e.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.logger.Error("in-flight requests didn't finish within %s, closing their connections", s.drainTimeout)
+			err = errors.Join(err, e.Close())
+		}
+		// The listener isn't tracked by the http.Server until Start serves it, close it in case it never did.
+		if lnErr := e.Listener.Close(); !errors.Is(lnErr, net.ErrClosed) {
+			err = errors.Join(err, lnErr)
+		}
+		return err
+	}}}
+	hooks = append(hooks, s.hooks...)
+	s.mu.Unlock()
+
+	hooks = append(hooks, closeHook("idempotency cache", s.idempotency))
+	if closer, ok := s.Storage.(io.Closer); ok {
+		hooks = append(hooks, closeHook("storage", closer))
+	}
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].priority < hooks[j].priority })
+	return hooks
+}
+
+func closeHook(name string, closer io.Closer) shutdownHook {
+	return shutdownHook{name: name, priority: ShutdownClose, fn: func(context.Context) error {
+		return closer.Close()
+	}}
+}
+
+// Close is Stop, so that a Server can be used as an io.Closer. It releases the listener and stops the background
+// tasks of the storage, it's safe to call before or while Start runs and more than once.
+func (s *Server) Close() error {
+	return s.Stop()
+}
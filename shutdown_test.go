@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// slowStore is a store whose reads block until release is closed.
+type slowStore struct {
+	Storer[string, string]
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Get(key string) (string, error) {
+	close(s.entered)
+	<-s.release
+	return s.Storer.Get(key)
+}
+
+// startSlowServer serves a slowStore and sends it a read, it returns once the read is in flight.
+func startSlowServer(t *testing.T, drain time.Duration) (srv *Server, st *slowStore, status chan int) {
+	t.Helper()
+	st = &slowStore{Storer: NewKVStore[string, string](), entered: make(chan struct{}), release: make(chan struct{})}
+	st.Put("a", "1")
+	srv = NewServer("127.0.0.1:0", WithLogger(NopLogger{}), WithDrainTimeout(drain))
+	srv.Storage = st
+	go srv.Start()
+	waitFor(t, func() bool { return srv.Addr() != nil })
+
+	status = make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr().String() + "/kv/a")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-st.entered
+	return srv, st, status
+}
+
+func TestStopWaitsForInFlightRequests(t *testing.T) {
+	srv, st, status := startSlowServer(t, 5*time.Second)
+
+	const delay = 50 * time.Millisecond
+	time.AfterFunc(delay, func() { close(st.release) })
+	start := time.Now()
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("Stop returned after %s, before the request finished", elapsed)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Fatalf("the in-flight request got %d", code)
+	}
+}
+
+func TestStopGivesUpAfterTheDrainTimeout(t *testing.T) {
+	srv, st, status := startSlowServer(t, 50*time.Millisecond)
+	defer close(st.release)
+
+	start := time.Now()
+	if err := srv.Stop(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Stop took %s", elapsed)
+	}
+	// The connection of the stuck request was closed.
+	if code := <-status; code != 0 {
+		t.Fatalf("the stuck request got %d", code)
+	}
+}
+
+func TestShutdownHooksRunInOrder(t *testing.T) {
+	srv := NewServer(":0", WithLogger(NopLogger{}))
+	var order []string
+	hook := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	srv.OnShutdown("snapshot", ShutdownSnapshot, hook("snapshot"))
+	srv.OnShutdown("flush", ShutdownFlush, hook("flush"))
+	srv.OnShutdown("flush again", ShutdownFlush, hook("flush again"))
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"flush", "flush again", "snapshot"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("the hooks ran in the order %v, want %v", order, want)
+	}
+}
+
+// closerFunc is a mock subsystem closed by Stop.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestShutdownFailureDoesntAbortTheRest(t *testing.T) {
+	logger := &recordingLogger{}
+	srv := NewServer(":0", WithLogger(logger))
+	var order []string
+	boom := errors.New("disk full")
+	srv.OnShutdown("wal", ShutdownFlush, func(context.Context) error {
+		order = append(order, "wal")
+		return boom
+	})
+	srv.OnShutdown("snapshot", ShutdownSnapshot, func(context.Context) error {
+		order = append(order, "snapshot")
+		return nil
+	})
+	srv.Storage = struct {
+		Storer[string, string]
+		io.Closer
+	}{NewKVStore[string, string](), closerFunc(func() error {
+		order = append(order, "storage")
+		return nil
+	})}
+
+	if err := srv.Stop(); !errors.Is(err, boom) {
+		t.Fatalf("got %v, want the error of the failed hook", err)
+	}
+	if want := []string{"wal", "snapshot", "storage"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("the subsystems were shut down in the order %v, want %v", order, want)
+	}
+	if !logger.contains("shutting down wal failed: disk full") {
+		t.Fatalf("the failure wasn't logged: %q", logger.messages)
+	}
+}
+
+// freeAddr returns a local address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestCloseFreesThePort(t *testing.T) {
+	addr := freeAddr(t)
+	for i := 0; i < 5; i++ {
+		srv := NewServer(addr, WithLogger(NopLogger{}))
+		started := make(chan struct{})
+		go func() {
+			defer close(started)
+			srv.Start()
+		}()
+		waitFor(t, func() bool {
+			resp, err := http.Get("http://" + addr + "/health")
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		})
+
+		if err := srv.Close(); err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		<-started
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("round %d: the port is still taken after Close: %v", i, err)
+		}
+		ln.Close()
+	}
+}
+
+func TestCloseBeforeStart(t *testing.T) {
+	addr := freeAddr(t)
+	srv := NewServer(addr, WithLogger(NopLogger{}))
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("the second Close: %v", err)
+	}
+	srv.Start()
+	if err := srv.Listen(); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("got %v from Listen after Close", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("a closed server took the port: %v", err)
+	}
+	ln.Close()
+
+	// A server that listens but was never started lets go of its port too.
+	srv = NewServer(addr, WithLogger(NopLogger{}))
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Fatalf("the port is still taken: %v", err)
+	}
+	ln.Close()
+}
+
+func TestCloseStopsTheStorage(t *testing.T) {
+	before := goroutinesRunning("startSweeper")
+	st := NewKVStore[string, string](WithSweepInterval[string, string](time.Millisecond))
+	st.PutWithTTL("a", "1", time.Hour)
+	srv, _ := newTestServer(st)
+	if n := goroutinesRunning("startSweeper"); n != before+1 {
+		t.Fatalf("%d sweepers run, want 1", n-before)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := goroutinesRunning("startSweeper"); n != before {
+		t.Fatalf("%d sweepers are left after Close", n-before)
+	}
+}
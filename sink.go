@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MetricsSink is where the store and the server report their metrics, so they aren't tied to a metrics
+// library. Names follow the Prometheus conventions, e.g. kv_puts_total.
+type MetricsSink interface {
+	// Counter adds delta to a counter.
+	Counter(name string, delta float64)
+	// Gauge sets a gauge to value.
+	Gauge(name string, value float64)
+	// Histogram records an observation, durations are in seconds.
+	Histogram(name string, value float64)
+}
+
+// NopSink discards every metric, it's the default.
+type NopSink struct{}
+
+func (NopSink) Counter(string, float64)   {}
+func (NopSink) Gauge(string, float64)     {}
+func (NopSink) Histogram(string, float64) {}
+
+// eventCounters are the counters the store increments for each event.
+var eventCounters = map[EventType]string{
+	EventPut:    "kv_puts_total",
+	EventDelete: "kv_deletes_total",
+	EventExpire: "kv_expirations_total",
+	EventEvict:  "kv_evictions_total",
+}
+
+// WithMetrics makes the store report its writes, reads and size to sink.
+func WithMetrics[K comparable, V any](sink MetricsSink) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.metrics = sink
+	}
+}
+
+// WithMetricsSink makes the server report its requests to sink. If the sink is also an http.Handler, like
+// PrometheusSink, it's served on GET /metrics. The storage reports through its own WithMetrics option.
+func WithMetricsSink(sink MetricsSink) ServerOption {
+	return func(s *Server) {
+		s.metrics = sink
+	}
+}
+
+// instrumented is the middleware counting the requests and timing them.
+func (s *Server) instrumented(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		s.metrics.Counter("http_requests_total", 1)
+		if err != nil {
+			s.metrics.Counter("http_request_errors_total", 1)
+		}
+		s.metrics.Histogram("http_request_duration_seconds", time.Since(start).Seconds())
+		return err
+	}
+}
+
+// promBuckets are the upper bounds of the histograms of PrometheusSink, in seconds.
+var promBuckets = []float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1, 10}
+
+type promHistogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// PrometheusSink keeps the metrics in memory and serves them in the Prometheus text exposition format,
+// so they can be scraped without depending on the Prometheus client library.
+type PrometheusSink struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*promHistogram
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*promHistogram),
+	}
+}
+
+func (p *PrometheusSink) Counter(name string, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counters[name] += delta
+}
+
+func (p *PrometheusSink) Gauge(name string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gauges[name] = value
+}
+
+func (p *PrometheusSink) Histogram(name string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.histograms[name]
+	if !ok {
+		h = &promHistogram{counts: make([]uint64, len(promBuckets))}
+		p.histograms[name] = h
+	}
+	for i, bound := range promBuckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name.
+func (p *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	for _, name := range sortedNames(p.counters) {
+		fmt.Fprintf(cw, "# TYPE %s counter\n%s %s\n", name, name, formatFloat(p.counters[name]))
+	}
+	for _, name := range sortedNames(p.gauges) {
+		fmt.Fprintf(cw, "# TYPE %s gauge\n%s %s\n", name, name, formatFloat(p.gauges[name]))
+	}
+	for _, name := range sortedNames(p.histograms) {
+		h := p.histograms[name]
+		fmt.Fprintf(cw, "# TYPE %s histogram\n", name)
+		var cumulative uint64
+		for i, bound := range promBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(cw, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(cw, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", name, h.count, name, formatFloat(h.sum), name, h.count)
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteTo(w)
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter remembers how much was written and the first error, so WriteTo can report them.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
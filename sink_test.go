@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps the metric calls made to it, as "kind name value".
+type recordingSink struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recordingSink) record(kind, name string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf("%s %s %g", kind, name, value))
+}
+
+func (r *recordingSink) Counter(name string, delta float64)   { r.record("counter", name, delta) }
+func (r *recordingSink) Gauge(name string, value float64)     { r.record("gauge", name, value) }
+func (r *recordingSink) Histogram(name string, value float64) { r.record("histogram", name, value) }
+
+func TestStoreMetrics(t *testing.T) {
+	sink := &recordingSink{}
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithMetrics[string, string](sink), WithClock[string, string](clock))
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.PutWithTTL("b", "2", time.Minute)
+	st.Get("a")
+	st.Get("missing")
+	st.Delete("a")
+	clock.Advance(time.Hour)
+	st.DeleteExpired()
+
+	want := []string{
+		"counter kv_puts_total 1", "gauge kv_keys 1",
+		"counter kv_puts_total 1", "gauge kv_keys 2",
+		"counter kv_get_hits_total 1",
+		"counter kv_get_misses_total 1",
+		"counter kv_deletes_total 1", "gauge kv_keys 1",
+		"counter kv_expirations_total 1", "gauge kv_keys 0",
+	}
+	if !reflect.DeepEqual(sink.calls, want) {
+		t.Fatalf("got the calls\n%s\nwant\n%s", strings.Join(sink.calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	sink := NewPrometheusSink()
+	_, h := newTestServer(NewKVStore[string, string](), WithMetricsSink(sink))
+	do(t, h, http.MethodPut, "/kv/a", "1")
+	do(t, h, http.MethodGet, "/kv/missing", "")
+
+	rec := do(t, h, http.MethodGet, "/metrics", "")
+	expectStatus(t, rec, http.StatusOK)
+	for _, line := range []string{
+		"http_requests_total 2\n",
+		"http_request_errors_total 1\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		"http_request_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("the metrics don't have %q:\n%s", line, rec.Body)
+		}
+	}
+}
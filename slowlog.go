@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SlowEntry is a request that took longer than the slow log threshold, Keys are the path parameters naming keys.
+type SlowEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	Op         string    `json:"op"`
+	Keys       []string  `json:"keys,omitempty"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+type slowLog struct {
+	*ring[SlowEntry]
+	threshold time.Duration
+}
+
+// WithSlowLog keeps the last size requests that took longer than threshold, like the slowlog of Redis. They're
+// served by GET /slowlog and also logged at the warning level as they happen. A size under 1 keeps only the last
+// one.
+func WithSlowLog(threshold time.Duration, size int) ServerOption {
+	return func(s *Server) {
+		s.slowlog = &slowLog{ring: newRing[SlowEntry](max(size, 1)), threshold: threshold}
+	}
+}
+
+// slowlogged is the middleware timing the requests for the slow log.
+func (s *Server) slowlogged(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		elapsed := time.Since(start)
+		if elapsed <= s.slowlog.threshold {
+			return err
+		}
+		entry := SlowEntry{
+			Time:       start,
+			RequestID:  requestID(c),
+			Method:     c.Request().Method,
+			Op:         routeOp(c),
+			Keys:       routeKeys(c),
+			Status:     responseStatus(c, err),
+			DurationMS: float64(elapsed) / float64(time.Millisecond),
+		}
+		s.slowlog.add(entry)
+		s.logger.Warn("slow request %s: %s %s on (%s) took %s", entry.RequestID, entry.Method, entry.Op, strings.Join(entry.Keys, ", "), elapsed)
+
+		return err
+	}
+}
+
+// handleSlowlog serves GET /slowlog?limit=, the most recent slow requests oldest first.
+func (s *Server) handleSlowlog(c echo.Context) error {
+	limit, err := parseLimit(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, s.slowlog.last(limit))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// delayedStore is a store whose reads of the keys starting with slow take delay.
+type delayedStore struct {
+	Storer[string, string]
+	delay time.Duration
+}
+
+func (s *delayedStore) Get(key string) (string, error) {
+	if strings.HasPrefix(key, "slow") {
+		time.Sleep(s.delay)
+	}
+	return s.Storer.Get(key)
+}
+
+func TestSlowlog(t *testing.T) {
+	st := &delayedStore{Storer: NewKVStore[string, string](), delay: 30 * time.Millisecond}
+	st.Put("slow", "1")
+	st.Put("fast", "1")
+	logger := &recordingLogger{}
+	_, h := newTestServer(st, WithSlowLog(10*time.Millisecond, 8), WithLogger(logger))
+
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/fast", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/slow", "", echo.HeaderXRequestID, "slow-1"), http.StatusOK)
+
+	rec := do(t, h, http.MethodGet, "/slowlog", "")
+	expectStatus(t, rec, http.StatusOK)
+	var entries []SlowEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d slow requests, want only the read of slow: %+v", len(entries), entries)
+	}
+	e := entries[0]
+	if e.Method != http.MethodGet || len(e.Keys) != 1 || e.Keys[0] != "slow" || e.Status != http.StatusOK || e.RequestID != "slow-1" {
+		t.Fatalf("got %+v", e)
+	}
+	if e.DurationMS < 30 {
+		t.Fatalf("got a duration of %vms, the read took at least 30ms", e.DurationMS)
+	}
+	if !logger.contains("WARN slow request slow-1") {
+		t.Fatalf("no warning was logged: %v", logger.messages)
+	}
+}
+
+func TestSlowlogKeepsTheMostRecent(t *testing.T) {
+	st := &delayedStore{Storer: NewKVStore[string, string](), delay: 2 * time.Millisecond}
+	st.Put("slow", "1")
+	st.Put("slow-last", "1")
+	_, h := newTestServer(st, WithSlowLog(time.Millisecond, 3))
+
+	for i := 0; i < 5; i++ {
+		do(t, h, http.MethodGet, "/kv/slow", "")
+	}
+	do(t, h, http.MethodGet, "/kv/slow-last", "")
+
+	var entries []SlowEntry
+	if err := json.Unmarshal(do(t, h, http.MethodGet, "/slowlog", "").Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, the slow log keeps 3", len(entries))
+	}
+	if last := entries[len(entries)-1]; len(last.Keys) != 1 || last.Keys[0] != "slow-last" {
+		t.Fatalf("the newest entry is %+v, want the read of slow-last", last)
+	}
+
+	if err := json.Unmarshal(do(t, h, http.MethodGet, "/slowlog?limit=1", "").Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries with limit=1", len(entries))
+	}
+}
+
+func TestSlowlogSizeUnderOne(t *testing.T) {
+	st := &delayedStore{Storer: NewKVStore[string, string](), delay: 2 * time.Millisecond}
+	st.Put("slow-a", "1")
+	st.Put("slow-b", "1")
+	_, h := newTestServer(st, WithSlowLog(time.Millisecond, 0))
+
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/slow-a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/slow-b", ""), http.StatusOK)
+	var entries []SlowEntry
+	if err := json.Unmarshal(do(t, h, http.MethodGet, "/slowlog", "").Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Keys[0] != "slow-b" {
+		t.Fatalf("got %+v, want the last slow request", entries)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// A snapshot is a sequence of entries, each one written as
+//
+//	uvarint(len(key)) key uvarint(len(value)) value
+//
+// where key and value are encoded with the store's codecs. The entries are sorted by their encoded key, so
+// two stores with the same contents write the same bytes whatever order the keys were inserted in.
+//
+// The entries are followed by a trailer
+//
+//	crc32(entries) [hmac-sha256(entries)] flags magic
+//
+// where the HMAC is only there for the stores with a WithSnapshotKey, as told by the flags. A snapshot without
+// the trailer is corrupt, it was cut short, unless the store was created WithLegacySnapshots to load the ones
+// written before the trailer existed.
+
+const (
+	snapshotMagic  = "KVSNAP01"
+	snapshotSigned = 1
+)
+
+// ErrSnapshotCorrupt is returned when loading a snapshot whose checksum doesn't match its contents, e.g. because
+// it was only partially written.
+var ErrSnapshotCorrupt = errors.New("the snapshot is corrupt")
+
+// ErrSnapshotTampered is returned by the stores with a WithSnapshotKey when loading a snapshot whose HMAC is
+// missing or doesn't match, i.e. it wasn't written by a store holding the same key.
+var ErrSnapshotTampered = errors.New("the snapshot signature doesn't match")
+
+// WithLegacySnapshots makes the store load the snapshots without a trailer, written before it existed, instead of
+// failing with ErrSnapshotCorrupt. Nothing tells them from a snapshot cut short at the end of an entry, so it's
+// meant for migrating the old backups. It has no effect on a store WithSnapshotKey, they must be signed.
+func WithLegacySnapshots[K comparable, V any]() Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.legacySnapshots = true
+	}
+}
+
+// WithSnapshotKey makes the store sign its snapshots with an HMAC keyed with key, and refuse to load the
+// snapshots that aren't signed with it.
+func WithSnapshotKey[K comparable, V any](key []byte) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.snapshotKey = key
+	}
+}
+
+// SaveSnapshot writes every entry of the store to w.
+func (s *KVStore[K, V]) SaveSnapshot(w io.Writer) error {
+	s.rlock()
+	entries, err := s.encodeEntries(nil)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return writeEntries(w, entries, s.snapshotKey)
+}
+
+// encodedEntry is an entry encoded with the codecs of the store, ready to be written to a snapshot.
+type encodedEntry struct {
+	key, value []byte
+}
+
+// encodeEntries appends every entry of the store to entries, must be called with the lock held.
+func (s *KVStore[K, V]) encodeEntries(entries []encodedEntry) ([]encodedEntry, error) {
+	for key, value := range s.data {
+		k, err := s.keyCodec.Marshal(key)
+		if err != nil {
+			return nil, fmt.Errorf("encoding key (%v): %w", key, err)
+		}
+		v, err := s.valueCodec.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("encoding value of key (%v): %w", key, err)
+		}
+		entries = append(entries, encodedEntry{key: k, value: v})
+	}
+	return entries, nil
+}
+
+// writeEntries sorts the entries by key and writes them to w followed by the trailer, signed if hmacKey is set.
+func writeEntries(w io.Writer, entries []encodedEntry, hmacKey []byte) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	sum := crc32.NewIEEE()
+	writers := []io.Writer{w, sum}
+	var mac hash.Hash
+	if hmacKey != nil {
+		mac = hmac.New(sha256.New, hmacKey)
+		writers = append(writers, mac)
+	}
+	bw := bufio.NewWriter(io.MultiWriter(writers...))
+	for _, entry := range entries {
+		if err := writeFrame(bw, entry.key); err != nil {
+			return err
+		}
+		if err := writeFrame(bw, entry.value); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	trailer := sum.Sum(nil)
+	flags := byte(0)
+	if mac != nil {
+		trailer = mac.Sum(trailer)
+		flags |= snapshotSigned
+	}
+	trailer = append(append(trailer, flags), snapshotMagic...)
+	_, err := w.Write(trailer)
+	return err
+}
+
+// maxTrailerSize is the size of the trailer of a signed snapshot, the longest one.
+const maxTrailerSize = crc32.Size + sha256.Size + 1 + len(snapshotMagic)
+
+// verifySnapshot reads the whole snapshot from r and checks its trailer, it returns the entries without the
+// trailer. A snapshot without a trailer is returned as is if legacy is set, unless hmacKey is set too.
+func verifySnapshot(r io.Reader, hmacKey []byte, legacy bool) ([]byte, error) {
+	data, err := io.ReadAll(newSnapshotReader(r, hmacKey, legacy))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// snapshotReader reads the entries of a snapshot without its trailer, checking it as they go by. Since the
+// trailer is only known at the end, the last maxTrailerSize bytes read are always held back. Once the entries
+// are all read it returns io.EOF if the trailer matches them, and ErrSnapshotCorrupt or ErrSnapshotTampered
+// otherwise, so a caller reading until io.EOF has read a snapshot that checks out.
+type snapshotReader struct {
+	r       io.Reader
+	hmacKey []byte
+	legacy  bool
+	sum     hash.Hash32
+	mac     hash.Hash
+
+	back []byte
+	// buf is what was read from r but not returned yet.
+	buf []byte
+	eof bool
+	// trailer is the trailer cut off buf at the end, it's nil for a snapshot without one.
+	trailer []byte
+	err     error
+}
+
+func newSnapshotReader(r io.Reader, hmacKey []byte, legacy bool) *snapshotReader {
+	sr := &snapshotReader{r: r, hmacKey: hmacKey, legacy: legacy, sum: crc32.NewIEEE(), back: make([]byte, 64<<10)}
+	if hmacKey != nil {
+		sr.mac = hmac.New(sha256.New, hmacKey)
+	}
+	return sr
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	for !sr.eof && len(sr.buf) <= maxTrailerSize {
+		// Only the held back bytes are left, they're moved to the front to make room.
+		n := copy(sr.back, sr.buf)
+		m, err := sr.r.Read(sr.back[n:])
+		sr.buf = sr.back[:n+m]
+		if errors.Is(err, io.EOF) {
+			sr.eof = true
+			sr.cutTrailer()
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	avail := len(sr.buf)
+	if !sr.eof {
+		avail -= maxTrailerSize
+	}
+	if avail == 0 {
+		if sr.err == nil {
+			sr.err = sr.verify()
+		}
+		return 0, sr.err
+	}
+
+	n := copy(p, sr.buf[:avail])
+	sr.sum.Write(p[:n])
+	if sr.mac != nil {
+		sr.mac.Write(p[:n])
+	}
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+// cutTrailer moves the trailer from the end of buf to trailer, once r is exhausted.
+func (sr *snapshotReader) cutTrailer() {
+	if !bytes.HasSuffix(sr.buf, []byte(snapshotMagic)) {
+		return
+	}
+	n := len(snapshotMagic) + 1
+	if len(sr.buf) < n {
+		sr.err = ErrSnapshotCorrupt
+		return
+	}
+	if flags := sr.buf[len(sr.buf)-n]; flags&snapshotSigned != 0 {
+		n += sha256.Size
+	}
+	n += crc32.Size
+	if len(sr.buf) < n {
+		sr.err = ErrSnapshotCorrupt
+		return
+	}
+	sr.trailer = sr.buf[len(sr.buf)-n:]
+	sr.buf = sr.buf[:len(sr.buf)-n]
+}
+
+// verify checks the trailer against what was read, it returns io.EOF if it matches.
+func (sr *snapshotReader) verify() error {
+	if sr.trailer == nil {
+		if sr.hmacKey != nil {
+			return fmt.Errorf("%w: the snapshot isn't signed", ErrSnapshotTampered)
+		}
+		if !sr.legacy {
+			return fmt.Errorf("%w: the trailer is missing", ErrSnapshotCorrupt)
+		}
+		return io.EOF
+	}
+
+	if sr.sum.Sum32() != binary.BigEndian.Uint32(sr.trailer) {
+		return ErrSnapshotCorrupt
+	}
+	if sr.hmacKey != nil {
+		if sr.trailer[len(sr.trailer)-len(snapshotMagic)-1]&snapshotSigned == 0 {
+			return fmt.Errorf("%w: the snapshot isn't signed", ErrSnapshotTampered)
+		}
+		if !hmac.Equal(sr.mac.Sum(nil), sr.trailer[crc32.Size:crc32.Size+sha256.Size]) {
+			return ErrSnapshotTampered
+		}
+	}
+	return io.EOF
+}
+
+// LoadSnapshot replaces the contents of the store with the entries read from r.
+// Snapshots don't carry TTLs, the loaded keys are permanent.
+// The store is left untouched if the snapshot can't be read.
+func (s *KVStore[K, V]) LoadSnapshot(r io.Reader) error {
+	data, err := s.readEntries(r)
+	if err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	s.replaceData(data)
+
+	return nil
+}
+
+// LoadSnapshotInto replaces the contents of the store with the entries read from r, like LoadSnapshot, but
+// without ever holding two copies of them: the entries are inserted one by one as they're read instead of being
+// decoded into a new map that is swapped in at the end. It's meant for the snapshots too large for that, and
+// evicts as it goes on a store with WithMaxBytes.
+//
+// The snapshot is read twice, see checkSnapshot: a truncated or corrupt one is rejected by the first pass and
+// leaves the store untouched. The write lock is only held for the second, so nobody sees the load half done.
+func (s *KVStore[K, V]) LoadSnapshotInto(r io.Reader) error {
+	checked, done, err := checkSnapshot(r, s.snapshotKey, s.legacySnapshots, s.keyCodec, s.valueCodec)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	s.replaceData(make(map[K]V))
+	err = decodeEntries(newSnapshotReader(checked, s.snapshotKey, s.legacySnapshots), s.keyCodec, s.valueCodec, func(key K, value V) error {
+		s.loadEntry(key, value)
+		s.evictOverCapacity(key)
+		return nil
+	})
+	if err != nil {
+		// The snapshot was fine a moment ago, only reading it again failed.
+		s.replaceData(make(map[K]V))
+		return err
+	}
+	s.finishLoad()
+	return nil
+}
+
+// checkSnapshot makes a first pass over the snapshot read from r, verifying its trailer and decoding every
+// entry without keeping any, and returns a reader over the same snapshot for the pass loading it. r is read
+// again from where it was if it's an io.ReadSeeker like a file, and spooled to a temporary file otherwise. done
+// releases what the second reader holds.
+func checkSnapshot[K comparable, V any](r io.Reader, hmacKey []byte, legacy bool, keyCodec Codec[K], valueCodec Codec[V]) (checked io.Reader, done func(), err error) {
+	rs, ok := r.(io.ReadSeeker)
+	done = func() {}
+	if !ok {
+		f, err := os.CreateTemp("", "kvsnapshot-")
+		if err != nil {
+			return nil, nil, err
+		}
+		done = func() {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			done()
+			return nil, nil, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			done()
+			return nil, nil, err
+		}
+		rs = f
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = decodeEntries(newSnapshotReader(rs, hmacKey, legacy), keyCodec, valueCodec, func(K, V) error { return nil })
+	}
+	if err == nil {
+		_, err = rs.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return rs, done, nil
+}
+
+// readEntries verifies a snapshot and decodes its entries with the codecs of the store.
+func (s *KVStore[K, V]) readEntries(r io.Reader) (map[K]V, error) {
+	snapshot, err := verifySnapshot(r, s.snapshotKey, s.legacySnapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[K]V)
+	err = decodeEntries(bytes.NewReader(snapshot), s.keyCodec, s.valueCodec, func(key K, value V) error {
+		data[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeEntries calls fn with every entry read from r, until r is exhausted or fn fails.
+func decodeEntries[K comparable, V any](r io.Reader, keyCodec Codec[K], valueCodec Codec[V], fn func(K, V) error) error {
+	br := bufio.NewReader(r)
+	for {
+		k, err := readFrame(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err := readFrame(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		key, err := keyCodec.Unmarshal(k)
+		if err != nil {
+			return fmt.Errorf("decoding key: %w", err)
+		}
+		value, err := valueCodec.Unmarshal(v)
+		if err != nil {
+			return fmt.Errorf("decoding value of key (%v): %w", key, err)
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// Preload inserts the entries read from r, in the snapshot format encoded with the given codecs, on top of
+// the current contents of the store and returns how many it inserted. It's meant to warm the store up before
+// it serves traffic: the write lock is held for the whole load instead of being taken once per entry.
+// The entries follow the same rules as Put, loading stops at the first one that is rejected.
+func (s *KVStore[K, V]) Preload(r io.Reader, keyCodec Codec[K], valueCodec Codec[V]) (int, error) {
+	snapshot, err := verifySnapshot(r, s.snapshotKey, s.legacySnapshots)
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	err = decodeEntries(bytes.NewReader(snapshot), keyCodec, valueCodec, func(key K, value V) error {
+		key = s.canon(key)
+		if err := s.validate(key, value); err != nil {
+			return err
+		}
+		if err := s.checkPutMode(key); err != nil {
+			return err
+		}
+		if err := s.checkCapacity(key, value); err != nil {
+			return err
+		}
+		if err := s.logPut(key, value); err != nil {
+			return err
+		}
+		s.set(key, value)
+		s.setTTL(key, s.defaultTTL)
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// replaceData swaps in data as the new contents of the store, must be called with the write lock held.
+func (s *KVStore[K, V]) replaceData(data map[K]V) {
+	s.data = data
+	s.peakKeys = len(data)
+	s.meta = make(map[K]*entryMeta, len(data))
+	s.expires = make(map[K]time.Time)
+	clear(s.tombstones)
+	s.bytes = 0
+	if s.lru != nil {
+		s.lru = newLRUList[K]()
+	}
+
+	if s.interns != nil {
+		s.interns = make(map[string]*internEntry)
+	}
+
+	var last K
+	for key, value := range data {
+		s.loadEntry(key, value)
+		last = key
+	}
+	s.evictOverCapacity(last)
+	s.finishLoad()
+}
+
+// loadEntry inserts an entry read from a snapshot, without the checks and the notifications of set. It must be
+// called with the write lock held, and finishLoad once all the entries are in.
+func (s *KVStore[K, V]) loadEntry(key K, value V) {
+	value = s.intern(value)
+	s.data[key] = value
+	s.bumpVersion(key)
+	s.account(key, value)
+	s.peakKeys = max(s.peakKeys, len(s.data))
+}
+
+// finishLoad rebuilds what is kept for the whole dataset after loading a snapshot, must be called with the write
+// lock held.
+func (s *KVStore[K, V]) finishLoad() {
+	if s.valueIndex != nil {
+		s.valueIndex.reset(s.data)
+	}
+	s.resetBloom()
+}
+
+func writeFrame(w *bufio.Writer, b []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// maxFrameSize bounds the length of a frame, a longer one can only come from a corrupt length.
+const maxFrameSize = 1 << 30
+
+// readFrame reads a frame written by writeFrame. Its length comes from the input, so the frames above 64KiB are
+// read in chunks rather than allocated upfront: a corrupt length fails once the input runs out instead of
+// allocating whatever it says.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("%w: a frame of %d bytes", ErrSnapshotCorrupt, n)
+	}
+	if n <= 64<<10 {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// unexpectedEOF turns a clean EOF in the middle of an entry into io.ErrUnexpectedEOF, the snapshot is truncated.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
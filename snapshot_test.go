@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// snapshotOf returns the snapshot of a store holding n keys.
+func snapshotOf(t testing.TB, n int) []byte {
+	t.Helper()
+	st := NewKVStore[string, string]()
+	for i := 0; i < n; i++ {
+		st.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	var buf bytes.Buffer
+	if err := st.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// onlyReader hides everything but the Read method of a reader, LoadSnapshotInto can't seek it.
+type onlyReader struct{ io.Reader }
+
+func TestLoadSnapshotInto(t *testing.T) {
+	snapshot := snapshotOf(t, 100)
+	for name, r := range map[string]func() io.Reader{
+		"seeker": func() io.Reader { return bytes.NewReader(snapshot) },
+		"stream": func() io.Reader { return onlyReader{bytes.NewReader(snapshot)} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			st := NewKVStore[string, string]()
+			st.Put("stale", "1")
+			if err := st.LoadSnapshotInto(r()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.Get("stale"); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("the key not in the snapshot is still there: %v", err)
+			}
+			for i := 0; i < 100; i++ {
+				if v, err := st.Get(fmt.Sprintf("key%d", i)); err != nil || v != fmt.Sprintf("value%d", i) {
+					t.Fatalf("got %q, %v for key%d", v, err, i)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotIntoLargeFile(t *testing.T) {
+	const keys = 100000
+	path := filepath.Join(t.TempDir(), snapshotFile)
+	if err := os.WriteFile(path, snapshotOf(t, keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Where the stream is spooled for the second pass.
+	spool := t.TempDir()
+	t.Setenv("TMPDIR", spool)
+	for _, seekable := range []bool{true, false} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if !seekable {
+			r = onlyReader{f}
+		}
+		st := NewKVStore[string, string]()
+		err = st.LoadSnapshotInto(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("seekable=%t: %v", seekable, err)
+		}
+		if n := keysLeft(st); n != keys {
+			t.Fatalf("seekable=%t: loaded %d keys, want %d", seekable, n, keys)
+		}
+		for _, i := range []int{0, keys / 2, keys - 1} {
+			if v, err := st.Get(fmt.Sprintf("key%d", i)); err != nil || v != fmt.Sprintf("value%d", i) {
+				t.Fatalf("seekable=%t: got %q, %v for key%d", seekable, v, err, i)
+			}
+		}
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) != 0 {
+		t.Fatalf("the spooled snapshot was left behind: %v", entries)
+	}
+}
+
+func TestLoadSnapshotIntoEvictsAsItGoes(t *testing.T) {
+	const keys, capacity = 10000, 100
+	maxBytes := capacity * entrySize("key9999", "value9999")
+	st := NewKVStoreWithByteCapacity[string, string](maxBytes)
+	if err := st.LoadSnapshotInto(bytes.NewReader(snapshotOf(t, keys))); err != nil {
+		t.Fatal(err)
+	}
+	// The store never went over its capacity to hold the whole snapshot.
+	if st.bytes > maxBytes {
+		t.Fatalf("the store holds %d bytes, over its capacity of %d", st.bytes, maxBytes)
+	}
+	if n := keysLeft(st); n == 0 || n > capacity+capacity/10 {
+		t.Fatalf("the store holds %d of the %d keys", n, keys)
+	}
+}
+
+func TestLoadSnapshotIntoKeepsDataOnCorruption(t *testing.T) {
+	snapshot := snapshotOf(t, 100)
+	tampered := bytes.Clone(snapshot)
+	tampered[len(tampered)/2] ^= 0xff
+
+	for name, bad := range map[string][]byte{
+		"truncated": snapshot[:len(snapshot)/2],
+		"tampered":  tampered,
+	} {
+		for _, seekable := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/seekable=%t", name, seekable), func(t *testing.T) {
+				st := NewKVStore[string, string]()
+				st.Put("kept", "1")
+
+				var r io.Reader = bytes.NewReader(bad)
+				if !seekable {
+					r = onlyReader{r}
+				}
+				if err := st.LoadSnapshotInto(r); err == nil {
+					t.Fatal("the bad snapshot was loaded")
+				}
+				if v, err := st.Get("kept"); err != nil || v != "1" {
+					t.Fatalf("the store lost its contents: %q, %v", v, err)
+				}
+				if _, err := st.Get("key0"); !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("part of the bad snapshot was loaded: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestShardedLoadSnapshotIntoKeepsDataOnCorruption(t *testing.T) {
+	snapshot := snapshotOf(t, 100)
+	st := NewShardedKVStoreWithShards[string, string](4)
+	st.Put("kept", "1")
+
+	if err := st.LoadSnapshotInto(bytes.NewReader(snapshot[:len(snapshot)-3])); err == nil {
+		t.Fatal("the truncated snapshot was loaded")
+	}
+	if v, err := st.Get("kept"); err != nil || v != "1" {
+		t.Fatalf("the store lost its contents: %q, %v", v, err)
+	}
+	if err := st.LoadSnapshotInto(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Get("key42"); err != nil || v != "value42" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestReadFrameBounds(t *testing.T) {
+	huge := binary.AppendUvarint(nil, maxFrameSize+1)
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(huge))); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("got %v for a frame over the limit, want ErrSnapshotCorrupt", err)
+	}
+
+	for _, n := range []uint64{10, 1 << 20} {
+		short := append(binary.AppendUvarint(nil, n), "abc"...)
+		if _, err := readFrame(bufio.NewReader(bytes.NewReader(short))); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("got %v for a short frame of %d bytes, want io.ErrUnexpectedEOF", err, n)
+		}
+	}
+
+	big := bytes.Repeat([]byte("x"), 100<<10)
+	frame := append(binary.AppendUvarint(nil, uint64(len(big))), big...)
+	if b, err := readFrame(bufio.NewReader(bytes.NewReader(frame))); err != nil || !bytes.Equal(b, big) {
+		t.Fatalf("got %d bytes, %v", len(b), err)
+	}
+}
+
+func TestSnapshotIsDeterministic(t *testing.T) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	save := func(keys []string) []byte {
+		st := NewKVStore[string, string]()
+		for _, key := range keys {
+			st.Put(key, "value of "+key)
+		}
+		var buf bytes.Buffer
+		if err := st.SaveSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	forward := save(keys)
+	reversed := make([]string, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	if !bytes.Equal(forward, save(reversed)) {
+		t.Fatal("the same data inserted in another order gives a different snapshot")
+	}
+	if !bytes.Equal(forward, save(keys)) {
+		t.Fatal("saving the same data twice gives different snapshots")
+	}
+
+	sharded := NewShardedKVStoreWithShards[string, string](4)
+	for _, key := range reversed {
+		sharded.Put(key, "value of "+key)
+	}
+	var buf bytes.Buffer
+	sharded.SaveSnapshot(&buf)
+	if !bytes.Equal(forward, buf.Bytes()) {
+		t.Fatal("the sharded store's snapshot differs from the plain one's")
+	}
+}
+
+func TestPreload(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("existing", "1")
+
+	n, err := st.Preload(bytes.NewReader(snapshotOf(t, 50)), JSONCodec[string]{}, JSONCodec[string]{})
+	if err != nil || n != 50 {
+		t.Fatalf("got %d, %v, want 50 entries", n, err)
+	}
+	for i := 0; i < 50; i++ {
+		if v, err := st.Get(fmt.Sprintf("key%d", i)); err != nil || v != fmt.Sprintf("value%d", i) {
+			t.Fatalf("key%d: got %q, %v", i, v, err)
+		}
+	}
+	if v, _ := st.Get("existing"); v != "1" {
+		t.Fatalf("the preload dropped the existing key: %q", v)
+	}
+}
+
+func TestPreloadStopsAtARejectedEntry(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](10)
+	n, err := st.Preload(bytes.NewReader(snapshotOf(t, 50)), JSONCodec[string]{}, JSONCodec[string]{})
+	if !errors.Is(err, ErrStoreFull) || n != 10 {
+		t.Fatalf("got %d, %v, want 10 entries and ErrStoreFull", n, err)
+	}
+}
+
+func TestSnapshotCorruptionIsDetected(t *testing.T) {
+	snapshot := snapshotOf(t, 100)
+	corrupt := bytes.Clone(snapshot)
+	corrupt[len(corrupt)/3] ^= 0x01
+
+	for name, load := range map[string]func(*KVStore[string, string], io.Reader) error{
+		"LoadSnapshot":     (*KVStore[string, string]).LoadSnapshot,
+		"LoadSnapshotInto": (*KVStore[string, string]).LoadSnapshotInto,
+		"Preload": func(st *KVStore[string, string], r io.Reader) error {
+			_, err := st.Preload(r, JSONCodec[string]{}, JSONCodec[string]{})
+			return err
+		},
+	} {
+		st := NewKVStore[string, string]()
+		if err := load(st, bytes.NewReader(corrupt)); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Errorf("%s: got %v for a flipped bit, want ErrSnapshotCorrupt", name, err)
+		}
+		if err := load(st, bytes.NewReader(snapshot)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSnapshotWithoutTrailer(t *testing.T) {
+	snapshot := snapshotOf(t, 10)
+	// A header-less snapshot looks just like one cut short at the end of an entry.
+	bare := snapshot[:len(snapshot)-crc32.Size-1-len(snapshotMagic)]
+
+	if err := NewKVStore[string, string]().LoadSnapshot(bytes.NewReader(bare)); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("got %v without a trailer, want ErrSnapshotCorrupt", err)
+	}
+	legacy := NewKVStore[string, string](WithLegacySnapshots[string, string]())
+	if err := legacy.LoadSnapshot(bytes.NewReader(bare)); err != nil {
+		t.Fatalf("the legacy store rejected the snapshot: %v", err)
+	}
+	if v, _ := legacy.Get("key9"); v != "value9" {
+		t.Fatalf("got %q", v)
+	}
+	// A cut in the middle of an entry is still caught.
+	if err := legacy.LoadSnapshot(bytes.NewReader(bare[:len(bare)-2])); err == nil {
+		t.Fatal("the legacy store loaded a snapshot cut in the middle of an entry")
+	}
+}
+
+func TestSignedSnapshots(t *testing.T) {
+	key := []byte("the backup key")
+	signed := NewKVStore[string, string](WithSnapshotKey[string, string](key))
+	for i := 0; i < 10; i++ {
+		signed.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	var buf bytes.Buffer
+	if err := signed.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	if err := NewKVStore[string, string](WithSnapshotKey[string, string](key)).LoadSnapshot(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("the same key rejected the snapshot: %v", err)
+	}
+	// A store without a key only checks the checksum.
+	if err := NewKVStore[string, string]().LoadSnapshot(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("a store without a key rejected the snapshot: %v", err)
+	}
+
+	other := NewKVStore[string, string](WithSnapshotKey[string, string]([]byte("another key")))
+	if err := other.LoadSnapshot(bytes.NewReader(snapshot)); !errors.Is(err, ErrSnapshotTampered) {
+		t.Fatalf("got %v with another key, want ErrSnapshotTampered", err)
+	}
+
+	// Someone without the key can fix the checksum of what they changed, not the HMAC.
+	tampered := bytes.Clone(snapshot)
+	entries := len(tampered) - maxTrailerSize
+	i := bytes.Index(tampered, []byte("value5"))
+	copy(tampered[i:], "VALUE5")
+	binary.BigEndian.PutUint32(tampered[entries:], crc32.ChecksumIEEE(tampered[:entries]))
+	if err := NewKVStore[string, string]().LoadSnapshot(bytes.NewReader(tampered)); err != nil {
+		t.Fatalf("the fixed checksum doesn't match: %v", err)
+	}
+	keyed := NewKVStore[string, string](WithSnapshotKey[string, string](key), WithLegacySnapshots[string, string]())
+	if err := keyed.LoadSnapshot(bytes.NewReader(tampered)); !errors.Is(err, ErrSnapshotTampered) {
+		t.Fatalf("got %v for a tampered snapshot, want ErrSnapshotTampered", err)
+	}
+
+	// Nor strip the signature, even for a store taking legacy snapshots.
+	if err := keyed.LoadSnapshot(bytes.NewReader(snapshotOf(t, 10))); !errors.Is(err, ErrSnapshotTampered) {
+		t.Fatalf("got %v for an unsigned snapshot, want ErrSnapshotTampered", err)
+	}
+	if _, err := keyed.Get("key0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("a rejected snapshot was loaded: %v", err)
+	}
+}
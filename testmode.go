@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// testMode is set by SetTestMode.
+var testMode atomic.Bool
+
+// SetTestMode is for tests only, it makes everything that runs on a timer wait to be driven explicitly: the stores
+// don't start their sweeper nor their persistence tasks, the CoalescingStores don't schedule their flushes and
+// Start is a no-op for the MaintenanceSchedulers and the Replicas. Along with a FakeClock, a test then advances
+// the time-based behaviour with Tick, Flush, RunPending and Replica.Tick and never has to sleep. It applies to what's
+// started after the call, and to every store of the process.
+func SetTestMode(enabled bool) {
+	testMode.Store(enabled)
+}
+
+// Tick runs once what the background tasks of the store do on their timers: the sweep of the expired keys, and
+// with WithPersistence the sync of the log and a snapshot. It returns how many keys the sweep removed. It's meant
+// for the tests running with SetTestMode, but it can be called anytime.
+func (s *KVStore[K, V]) Tick() (int, error) {
+	n := s.sweep()
+
+	p := s.persistence
+	if p == nil || p.err != nil {
+		return n, nil
+	}
+	var errs []error
+	if p.aof != nil {
+		errs = append(errs, p.aof.sync())
+	}
+	if p.snapshots() {
+		errs = append(errs, p.snapshot())
+	}
+	return n, errors.Join(errs...)
+}
+
+// Tick polls the primary the way the background polling of Start does, as many times as it takes to catch up
+// with it, and returns how many changes it applied. It stops at the first error. It's meant for the tests running
+// with SetTestMode, but it can be called anytime.
+func (r *Replica) Tick() (int, error) {
+	start := r.Status().LastApplied
+	for {
+		before := r.Status().LastApplied
+		err := r.Sync(context.Background())
+		status := r.Status()
+		if err != nil || status.LastApplied >= status.PrimaryLatest || status.LastApplied == before {
+			return int(status.LastApplied - start), err
+		}
+	}
+}
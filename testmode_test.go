@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stored reports whether the key is still in the data of the store, expired or not.
+func stored(st *KVStore[string, string], key string) bool {
+	st.rlock()
+	defer st.mu.RUnlock()
+	_, ok := st.data[key]
+	return ok
+}
+
+func TestTestModeExpiresOnTick(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	before := goroutinesRunning("startSweeper")
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithSweepInterval[string, string](time.Nanosecond))
+	defer st.Close()
+	st.PutWithTTL("a", "1", time.Minute)
+	st.Put("b", "2")
+	if n := goroutinesRunning("startSweeper"); n != before {
+		t.Fatalf("%d sweepers run in test mode", n-before)
+	}
+
+	if n, _ := st.Tick(); n != 0 {
+		t.Fatalf("the tick removed %d keys before their TTL", n)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	// Nothing sweeps it until the tick, however short the interval.
+	if !stored(st, "a") {
+		t.Fatal("the key was removed without a tick")
+	}
+	if n, _ := st.Tick(); n != 1 {
+		t.Fatalf("the tick removed %d keys, want 1", n)
+	}
+	if stored(st, "a") {
+		t.Fatal("the tick didn't remove the expired key")
+	}
+	if v, err := st.Get("b"); err != nil || v != "2" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestTestModeCoalescingFlushesOnDemand(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	backing := &countingStore{Storer: NewKVStore[string, string]()}
+	st := NewCoalescingStore[string, string](backing, time.Nanosecond)
+	st.Put("a", "1")
+	st.Put("a", "2")
+	if st.timer != nil {
+		t.Fatal("a flush was scheduled in test mode")
+	}
+	if n := backing.puts.Load(); n != 0 {
+		t.Fatalf("%d puts reached the backing store before the flush", n)
+	}
+	st.Flush()
+	if n := backing.puts.Load(); n != 1 {
+		t.Fatalf("the flush made %d puts, want 1", n)
+	}
+}
+
+func TestTestModeReplicaTick(t *testing.T) {
+	primary, srv := newPrimary(t, 10000)
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	local := NewKVStore[string, string]()
+	replica := NewReplica(srv.URL, local, WithReplicaPollInterval(time.Nanosecond))
+	// Start returns at once without polling.
+	replica.Start()
+	defer replica.Stop()
+
+	for i := 0; i < defaultChangeBatch+10; i++ {
+		primary.Put(fmt.Sprint(i), "v")
+	}
+	if _, err := local.Get("0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the replica polled on its own: %v", err)
+	}
+
+	// A single tick catches up over several batches.
+	if n, err := replica.Tick(); err != nil || n != defaultChangeBatch+10 {
+		t.Fatalf("the tick applied %d changes, %v", n, err)
+	}
+	if v, _ := local.Get(fmt.Sprint(defaultChangeBatch + 9)); v != "v" {
+		t.Fatalf("got %q", v)
+	}
+	if n, err := replica.Tick(); err != nil || n != 0 {
+		t.Fatalf("a tick with nothing new applied %d changes, %v", n, err)
+	}
+}
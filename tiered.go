@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// WritePolicy decides when the writes to a TieredStore reach its slow tier.
+type WritePolicy int
+
+const (
+	// WriteThrough writes to the slow tier first and then to the fast one, a write that fails on the slow tier
+	// isn't applied at all.
+	WriteThrough WritePolicy = iota
+	// WriteBack only writes to the fast tier and remembers the key as dirty, Flush writes the dirty keys to the
+	// slow tier. The fast tier must not evict keys, or the dirty ones would be lost.
+	WriteBack
+)
+
+// TieredStore puts a fast Storer, usually a KVStore, in front of a slow one. Reads are served by the fast tier
+// and fall back to the slow tier on a miss, which also populates the fast tier.
+type TieredStore[K comparable, V any] struct {
+	fast   Storer[K, V]
+	slow   Storer[K, V]
+	policy WritePolicy
+
+	mu    sync.Mutex
+	dirty map[K]struct{}
+}
+
+func NewTieredStore[K comparable, V any](fast, slow Storer[K, V], policy WritePolicy) *TieredStore[K, V] {
+	return &TieredStore[K, V]{
+		fast:   fast,
+		slow:   slow,
+		policy: policy,
+		dirty:  make(map[K]struct{}),
+	}
+}
+
+func (s *TieredStore[K, V]) Get(key K) (V, error) {
+	if value, err := s.fast.Get(key); err == nil {
+		return value, nil
+	}
+
+	value, err := s.slow.Get(key)
+	if err != nil {
+		return value, err
+	}
+	// The fast tier is only a cache here, failing to populate it doesn't fail the read.
+	s.fast.Put(key, value)
+
+	return value, nil
+}
+
+func (s *TieredStore[K, V]) Put(key K, value V) error {
+	if s.policy == WriteThrough {
+		if err := s.slow.Put(key, value); err != nil {
+			return err
+		}
+		return s.fast.Put(key, value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fast.Put(key, value); err != nil {
+		return err
+	}
+	s.dirty[key] = struct{}{}
+
+	return nil
+}
+
+func (s *TieredStore[K, V]) Update(key K, value V) error {
+	if s.policy == WriteThrough {
+		if err := s.slow.Update(key, value); err != nil {
+			return err
+		}
+		return s.fast.Put(key, value)
+	}
+
+	if _, err := s.Get(key); err != nil {
+		return err
+	}
+	return s.Put(key, value)
+}
+
+// Delete removes the key from both tiers right away, whatever the write policy.
+func (s *TieredStore[K, V]) Delete(key K) (V, error) {
+	s.mu.Lock()
+	delete(s.dirty, key)
+	s.mu.Unlock()
+
+	fastValue, fastErr := s.fast.Delete(key)
+	slowValue, slowErr := s.slow.Delete(key)
+	if slowErr != nil && !errors.Is(slowErr, ErrKeyNotFound) {
+		return slowValue, slowErr
+	}
+	if fastErr == nil {
+		return fastValue, nil
+	}
+	return slowValue, slowErr
+}
+
+// Flush writes the dirty keys of a WriteBack store to the slow tier, the keys that fail stay dirty.
+func (s *TieredStore[K, V]) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for key := range s.dirty {
+		value, err := s.fast.Get(key)
+		if err != nil {
+			// The key is gone from the fast tier, there's nothing left to write back.
+			delete(s.dirty, key)
+			continue
+		}
+		if err := s.slow.Put(key, value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(s.dirty, key)
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes the dirty keys.
+func (s *TieredStore[K, V]) Close() error {
+	return s.Flush()
+}
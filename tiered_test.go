@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// mockTier is a slow tier counting its reads, its writes fail with err when it's set.
+type mockTier struct {
+	Storer[string, string]
+	gets int
+	err  error
+}
+
+func newMockTier() *mockTier {
+	return &mockTier{Storer: NewKVStore[string, string]()}
+}
+
+func (m *mockTier) Get(key string) (string, error) {
+	m.gets++
+	return m.Storer.Get(key)
+}
+
+func (m *mockTier) Put(key, value string) error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.Storer.Put(key, value)
+}
+
+func TestTieredReadThrough(t *testing.T) {
+	fast, slow := NewKVStore[string, string](), newMockTier()
+	slow.Storer.Put("a", "1")
+	st := NewTieredStore[string, string](fast, slow, WriteThrough)
+
+	if v, err := st.Get("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if v, err := fast.Get("a"); err != nil || v != "1" {
+		t.Fatalf("the miss didn't populate the fast tier: %q, %v", v, err)
+	}
+	st.Get("a")
+	if slow.gets != 1 {
+		t.Fatalf("the slow tier was read %d times, want 1", slow.gets)
+	}
+	if _, err := st.Get("absent"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a key missing from both tiers", err)
+	}
+}
+
+func TestTieredWriteThrough(t *testing.T) {
+	fast, slow := NewKVStore[string, string](), newMockTier()
+	st := NewTieredStore[string, string](fast, slow, WriteThrough)
+
+	st.Put("a", "1")
+	for name, tier := range map[string]Storer[string, string]{"fast": fast, "slow": slow.Storer} {
+		if v, _ := tier.Get("a"); v != "1" {
+			t.Fatalf("the %s tier holds %q", name, v)
+		}
+	}
+
+	// A write the slow tier refuses isn't applied to the fast one either.
+	slow.err = errors.New("disk on fire")
+	if err := st.Put("b", "2"); !errors.Is(err, slow.err) {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := fast.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the failed write reached the fast tier: %v", err)
+	}
+
+	if _, err := st.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slow.Storer.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the delete didn't reach the slow tier: %v", err)
+	}
+}
+
+func TestTieredWriteBack(t *testing.T) {
+	fast, slow := NewKVStore[string, string](), newMockTier()
+	st := NewTieredStore[string, string](fast, slow, WriteBack)
+
+	st.Put("a", "1")
+	if _, err := slow.Storer.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the write reached the slow tier before the flush: %v", err)
+	}
+
+	// The keys that fail to flush stay dirty for the next one.
+	slow.err = errors.New("unavailable")
+	if err := st.Flush(); err == nil {
+		t.Fatal("the failed flush returned no error")
+	}
+	slow.err = nil
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := slow.Storer.Get("a"); v != "1" {
+		t.Fatalf("the slow tier holds %q after the flush", v)
+	}
+}
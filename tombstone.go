@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyDeleted is wrapped by the errors Get returns for a key deleted less than the retention of a store created
+// WithTombstones ago. They wrap ErrKeyNotFound too, so the callers that don't care about the difference don't
+// have to change.
+var ErrKeyDeleted = errors.New("was deleted")
+
+func keyDeleted[K comparable](key K) error {
+	return fmt.Errorf("the key (%v) %w and %w", key, ErrKeyDeleted, ErrKeyNotFound)
+}
+
+// WithTombstones makes the store remember the keys it deletes for retention, so that Get can tell a key that
+// was deleted, with ErrKeyDeleted, from one that never existed. Only the Deletes leave a tombstone, the keys that
+// expire or are evicted don't, and writing the key again removes it, as do Clear and loading a snapshot. A
+// deleted key isn't loaded again by the loader of a store created WithLoader until its tombstone is gone. The
+// sweeper, started by the first tombstone if no TTL started it already, drops the tombstones once their
+// retention is over, Get ignores them from then on whether they were dropped or not.
+func WithTombstones[K comparable, V any](retention time.Duration) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.tombstoneRetention = retention
+		s.tombstones = make(map[K]time.Time)
+	}
+}
+
+// recordTombstone keeps the tombstones up to date with an event, it's called from notify with the write lock held.
+func (s *KVStore[K, V]) recordTombstone(typ EventType, key K) {
+	switch typ {
+	case EventDelete:
+		s.tombstones[key] = s.clock.Now()
+		// The sweeper drops them once their retention is over, even on a store without TTLs.
+		s.sweepOnce.Do(s.startSweeper)
+	case EventPut:
+		delete(s.tombstones, key)
+	}
+}
+
+// deleted reports whether the key has a tombstone still within its retention, must be called with the lock held.
+func (s *KVStore[K, V]) deleted(key K) bool {
+	deletedAt, ok := s.tombstones[key]
+	return ok && s.clock.Now().Sub(deletedAt) < s.tombstoneRetention
+}
+
+// PurgeTombstones forgets every deleted key, even the ones still within their retention, and returns how many
+// it forgot. Get reports them as never existing from then on.
+func (s *KVStore[K, V]) PurgeTombstones() int {
+	s.lock()
+	defer s.mu.Unlock()
+
+	n := len(s.tombstones)
+	clear(s.tombstones)
+	return n
+}
+
+// dropExpiredTombstones removes the tombstones past their retention, the sweeper calls it.
+func (s *KVStore[K, V]) dropExpiredTombstones() {
+	if s.tombstoneRetention <= 0 {
+		return
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	for key := range s.tombstones {
+		if !s.deleted(key) {
+			delete(s.tombstones, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// loadingStore returns a store with tombstones whose loader serves every key, and counts its calls.
+func loadingStore(clock Clock) (*KVStore[string, string], *int) {
+	loads := new(int)
+	st := NewKVStore[string, string](
+		WithClock[string, string](clock),
+		WithTombstones[string, string](time.Minute),
+		WithLoader[string, string](func(key string) (string, bool, error) {
+			*loads++
+			return "loaded", true, nil
+		}),
+	)
+	return st, loads
+}
+
+func TestTombstoneReportsDeletedKeys(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithTombstones[string, string](time.Minute))
+
+	st.Put("a", "1")
+	st.Delete("a")
+	_, err := st.Get("a")
+	if !errors.Is(err, ErrKeyDeleted) || !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyDeleted wrapping ErrKeyNotFound", err)
+	}
+	if _, err := st.Get("never"); errors.Is(err, ErrKeyDeleted) {
+		t.Fatalf("a key that never existed is reported deleted: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := st.Get("a"); errors.Is(err, ErrKeyDeleted) || !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v past the retention", err)
+	}
+
+	st.Put("b", "1")
+	st.Delete("b")
+	st.Put("b", "2")
+	if v, err := st.Get("b"); err != nil || v != "2" {
+		t.Fatalf("got %q, %v after writing the deleted key again", v, err)
+	}
+}
+
+func TestTombstoneSkipsLoader(t *testing.T) {
+	st, loads := loadingStore(NewFakeClock(time.Unix(0, 0)))
+
+	st.Put("a", "1")
+	st.Delete("a")
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyDeleted) {
+		t.Fatalf("Get: got %v, want ErrKeyDeleted", err)
+	}
+	if _, _, err := st.GetWithTTL("a"); !errors.Is(err, ErrKeyDeleted) {
+		t.Fatalf("GetWithTTL: got %v, want ErrKeyDeleted", err)
+	}
+	if *loads != 0 {
+		t.Fatalf("the deleted key was loaded %d times", *loads)
+	}
+
+	if v, _, err := st.GetWithTTL("other"); err != nil || v != "loaded" {
+		t.Fatalf("got %q, %v for a key never deleted", v, err)
+	}
+}
+
+func TestTombstonesSweptWithoutTTLs(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](
+		WithClock[string, string](clock),
+		WithTombstones[string, string](time.Minute),
+		WithSweepInterval[string, string](time.Millisecond),
+	)
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.Delete("a")
+	clock.Advance(2 * time.Minute)
+
+	waitFor(t, func() bool {
+		st.rlock()
+		defer st.mu.RUnlock()
+		return len(st.tombstones) == 0
+	})
+}
+
+func TestTombstonesOverHTTP(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithTombstones[string, string](time.Minute))
+	_, h := newTestServer(st)
+
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusGone)
+	expectStatus(t, do(t, h, http.MethodGet, "/get/a", ""), http.StatusGone)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/never", ""), http.StatusNotFound)
+
+	// Past the retention it's a key like any other that doesn't exist.
+	clock.Advance(time.Minute)
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusNotFound)
+
+	// Without tombstones a deleted key is only missing.
+	_, h = newTestServer(NewKVStore[string, string]())
+	do(t, h, http.MethodPut, "/kv/a", "1")
+	do(t, h, http.MethodDelete, "/kv/a", "")
+	expectStatus(t, do(t, h, http.MethodGet, "/kv/a", ""), http.StatusNotFound)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const defaultSweepInterval = time.Second
+
+// The sampled sweep checks sweepSampleSize keys with a TTL at a time, and keeps going while more than a quarter
+// of them had expired, up to sweepMaxRounds rounds per tick. It's the expire cycle of Redis.
+const (
+	sweepSampleSize = 20
+	sweepMaxRounds  = 16
+)
+
+// SweepStrategy decides how the sweeper looks for expired keys.
+type SweepStrategy int
+
+const (
+	// SweepFullScan checks every key with a TTL on every tick, expired keys never outlive a tick.
+	SweepFullScan SweepStrategy = iota
+	// SweepSampled only checks random samples of the keys with a TTL, it bounds the time spent per tick on
+	// huge stores at the cost of expired keys lingering longer. Get still never returns them.
+	SweepSampled
+)
+
+// WithSweepInterval sets how often the sweeper runs, the default is a second.
+func WithSweepInterval[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.sweepInterval = d
+	}
+}
+
+// WithSweepStrategy sets how the sweeper looks for expired keys, the default is SweepFullScan.
+func WithSweepStrategy[K comparable, V any](strategy SweepStrategy) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.sweepStrategy = strategy
+	}
+}
+
+// WithDefaultTTL makes every key stored without an explicit TTL (Put, PutIfAbsent...) expire after d.
+// PutWithTTL still sets its own TTL, and PutWithTTL(key, value, 0) stores a permanent key.
+func WithDefaultTTL[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.defaultTTL = d
+	}
+}
+
+// PutWithTTL stores the value like Put, the key is removed once the ttl has elapsed.
+// It overrides the default TTL of the store, a zero or negative ttl makes the key permanent.
+// The sweeper goroutine is only started by the first PutWithTTL, stores that never use TTLs don't run it at all.
+func (s *KVStore[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPutMode(key); err != nil {
+		return err
+	}
+	if err := s.checkGracePeriod(key); err != nil {
+		return err
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return err
+	}
+	s.set(key, value)
+	s.setTTL(key, ttl)
+
+	return nil
+}
+
+// PutIfAbsentWithTTL is PutIfAbsent for a key that expires after ttl, a key whose TTL has elapsed counts as absent.
+func (s *KVStore[K, V]) PutIfAbsentWithTTL(key K, value V, ttl time.Duration) (bool, error) {
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return false, err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.Has(key) && !s.isExpired(key) {
+		return false, nil
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return false, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return false, err
+	}
+	s.set(key, value)
+	s.setTTL(key, ttl)
+
+	return true, nil
+}
+
+// PutIfStale is for cache fills, it stores the value with the new ttl only if the key is missing or its TTL has
+// elapsed, a fresh entry is left alone. The stale entry it replaces is reported to the watchers as expired.
+func (s *KVStore[K, V]) PutIfStale(key K, value V, ttl time.Duration) (bool, error) {
+	key = s.canon(key)
+	if err := s.validate(key, value); err != nil {
+		return false, err
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.Has(key) {
+		if !s.isExpired(key) {
+			return false, nil
+		}
+		s.expire(key)
+	}
+	if err := s.checkCapacity(key, value); err != nil {
+		return false, err
+	}
+	if err := s.logPut(key, value); err != nil {
+		return false, err
+	}
+	s.set(key, value)
+	s.setTTL(key, ttl)
+
+	return true, nil
+}
+
+// Expire sets the TTL of an existing key, a zero or negative ttl makes the key permanent.
+func (s *KVStore[K, V]) Expire(key K, ttl time.Duration) error {
+	key = s.canon(key)
+	_, err := s.expireIf(key, ttl, func(bool) bool { return true })
+	return err
+}
+
+// ExpireNX sets the TTL of the key only if it has none yet, and reports whether it did. It's for refreshes
+// that shouldn't extend a key that is already expiring.
+func (s *KVStore[K, V]) ExpireNX(key K, ttl time.Duration) (bool, error) {
+	key = s.canon(key)
+	return s.expireIf(key, ttl, func(hasTTL bool) bool { return !hasTTL })
+}
+
+// ExpireXX sets the TTL of the key only if it already has one, and reports whether it did.
+func (s *KVStore[K, V]) ExpireXX(key K, ttl time.Duration) (bool, error) {
+	key = s.canon(key)
+	return s.expireIf(key, ttl, func(hasTTL bool) bool { return hasTTL })
+}
+
+// expireIf sets the TTL of the key if cond, called with whether the key has a TTL, returns true.
+func (s *KVStore[K, V]) expireIf(key K, ttl time.Duration, cond func(hasTTL bool) bool) (bool, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(key); !ok {
+		return false, keyNotFound(key)
+	}
+	if _, hasTTL := s.expires[key]; !cond(hasTTL) {
+		return false, nil
+	}
+	s.setTTL(key, ttl)
+
+	return true, nil
+}
+
+// ExpireMany sets the TTL of several existing keys under a single write lock, a zero or negative ttl makes
+// the key permanent. Keys that are missing or already expired are returned in missing and left alone.
+func (s *KVStore[K, V]) ExpireMany(ttls map[K]time.Duration) (updated []K, missing []K) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	for key, ttl := range ttls {
+		canon := s.canon(key)
+		if _, ok := s.lookup(canon); !ok {
+			missing = append(missing, key)
+			continue
+		}
+		s.setTTL(canon, ttl)
+		updated = append(updated, key)
+	}
+	return updated, missing
+}
+
+// setTTL makes the key expire after ttl and starts the sweeper if needed, a zero or negative ttl makes the key permanent.
+// It must be called with the write lock held.
+func (s *KVStore[K, V]) setTTL(key K, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(s.expires, key)
+		return
+	}
+	s.expires[key] = s.clock.Now().Add(ttl)
+	s.sweepOnce.Do(s.startSweeper)
+}
+
+// lookup returns the value of the key unless it's missing or its TTL has elapsed, must be called with the lock held.
+func (s *KVStore[K, V]) lookup(key K) (V, bool) {
+	value, ok := s.data[key]
+	if !ok || s.isExpired(key) {
+		var zero V
+		return zero, false
+	}
+	return value, true
+}
+
+// removeIfExpired reclaims the key right away if its TTL has elapsed, instead of waiting for the sweeper.
+func (s *KVStore[K, V]) removeIfExpired(key K) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.isExpired(key) {
+		s.expire(key)
+	}
+}
+
+// expire removes a key whose TTL has elapsed and notifies the watchers, must be called with the write lock held.
+func (s *KVStore[K, V]) expire(key K) {
+	value := s.data[key]
+	s.remove(key)
+	s.notify(EventExpire, key, value)
+}
+
+// isExpired reports whether the key has a TTL that has already elapsed, must be called with the lock held.
+func (s *KVStore[K, V]) isExpired(key K) bool {
+	expiresAt, ok := s.expires[key]
+	return ok && s.clock.Now().After(expiresAt)
+}
+
+// TTL returns how long the key has left, ok is false if the key has no expiration.
+func (s *KVStore[K, V]) TTL(key K) (ttl time.Duration, ok bool, err error) {
+	key = s.canon(key)
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.lookup(key); !ok {
+		return 0, false, keyNotFound(key)
+	}
+	expiresAt, ok := s.expires[key]
+	if !ok {
+		return 0, false, nil
+	}
+	return expiresAt.Sub(s.clock.Now()), true, nil
+}
+
+// TTLGetter is implemented by stores that can return a value along with its remaining TTL.
+type TTLGetter[K comparable, V any] interface {
+	GetWithTTL(K) (V, time.Duration, error)
+}
+
+// ContextTTLGetter is a TTLGetter taking the context of the request the call is for, like a ContextStorer.
+type ContextTTLGetter[K comparable, V any] interface {
+	GetWithTTLContext(ctx context.Context, key K) (V, time.Duration, error)
+}
+
+// GetWithTTL is Get also returning how long the key has left, zero if it has no expiration. A value just
+// fetched by the loader has the default TTL.
+func (s *KVStore[K, V]) GetWithTTL(key K) (V, time.Duration, error) {
+	key = s.canon(key)
+	value, ttl, err := s.get(key)
+	if s.loader != nil && errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrKeyDeleted) {
+		value, err = s.load(key)
+		s.rlock()
+		ttl = s.defaultTTL
+		s.mu.RUnlock()
+	}
+	return value, ttl, err
+}
+
+// GetWithTTLContext is GetWithTTL giving up on a call whose request is already gone.
+func (s *KVStore[K, V]) GetWithTTLContext(ctx context.Context, key K) (V, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, 0, err
+	}
+	return s.GetWithTTL(key)
+}
+
+// Close stops the background goroutines of the store and closes the channels of its watchers, it's safe to
+// call more than once. The store can still be used afterwards, but TTLs are no longer swept in the background.
+// A store created WithPersistence writes its final snapshot and closes its log, the writes logged afterwards fail.
+func (s *KVStore[K, V]) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.watchers.closeAll()
+		if s.webhook != nil {
+			s.webhook.close()
+		}
+		if s.persistence != nil {
+			err = s.persistence.close()
+		}
+	})
+	// Makes sure a PutWithTTL racing with Close can't start the sweeper after we've waited for it.
+	s.sweepOnce.Do(func() {})
+	s.sweepWG.Wait()
+	return err
+}
+
+func (s *KVStore[K, V]) startSweeper() {
+	if testMode.Load() {
+		return
+	}
+	// It's called with the write lock held, the sweeper takes the changes of the interval from sweepReset.
+	interval := s.sweepInterval
+	s.sweepWG.Add(1)
+	go func() {
+		defer s.sweepWG.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case d := <-s.sweepReset:
+				ticker.Reset(d)
+			case <-ticker.C:
+				if n := s.sweep(); n > 0 {
+					s.logger.Debug("sweeper removed %d expired keys", n)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// sweep runs one pass of the sweeper with the strategy of the store.
+func (s *KVStore[K, V]) sweep() int {
+	s.dropExpiredTombstones()
+	if s.sweepStrategy == SweepSampled {
+		return s.DeleteExpiredSampled()
+	}
+	return s.DeleteExpired()
+}
+
+// DeleteExpired removes every key whose TTL has elapsed and returns how many were removed.
+// The sweeper calls it periodically, it can also be called directly.
+func (s *KVStore[K, V]) DeleteExpired() int {
+	s.lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	removed := 0
+	for key, expiresAt := range s.expires {
+		if now.After(expiresAt) {
+			s.expire(key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.compactIfSparse()
+	}
+	return removed
+}
+
+// DeleteExpiredSampled removes the expired keys found in random samples of the keys with a TTL, and returns
+// how many were removed. The lock is released between samples so writers aren't held up for long.
+func (s *KVStore[K, V]) DeleteExpiredSampled() int {
+	removed := 0
+	for round := 0; round < sweepMaxRounds; round++ {
+		sampled, expired := s.sweepSample()
+		removed += expired
+		if sampled == 0 || expired*4 <= sampled {
+			break
+		}
+	}
+	return removed
+}
+
+func (s *KVStore[K, V]) sweepSample() (sampled, expired int) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	// Map iteration starts at a random position, which makes the first keys a cheap random sample.
+	for key, expiresAt := range s.expires {
+		if sampled == sweepSampleSize {
+			break
+		}
+		sampled++
+		if now.After(expiresAt) {
+			s.expire(key)
+			expired++
+		}
+	}
+	if expired > 0 {
+		s.compactIfSparse()
+	}
+	return sampled, expired
+}
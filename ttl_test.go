@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutWithTTLExpires(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+
+	if err := st.PutWithTTL("a", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok, err := st.TTL("a"); err != nil || !ok || ttl != time.Minute {
+		t.Fatalf("got %s, %t, %v", ttl, ok, err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := st.Get("a"); err != nil {
+		t.Fatalf("the key expired at its deadline: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v past the TTL", err)
+	}
+
+	// A plain Put makes the key permanent again.
+	st.PutWithTTL("b", "1", time.Minute)
+	st.Put("b", "2")
+	clock.Advance(time.Hour)
+	if v, err := st.Get("b"); err != nil || v != "2" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+}
+
+func TestSweeperStartsLazily(t *testing.T) {
+	before := goroutinesRunning("startSweeper")
+	st := NewKVStore[string, string](WithSweepInterval[string, string](time.Millisecond))
+	st.Put("a", "1")
+	if n := goroutinesRunning("startSweeper"); n != before {
+		t.Fatalf("%d sweepers run before any TTL", n-before)
+	}
+
+	st.PutWithTTL("b", "1", time.Millisecond)
+	st.PutWithTTL("c", "1", time.Millisecond)
+	if n := goroutinesRunning("startSweeper"); n != before+1 {
+		t.Fatalf("%d sweepers run after the TTLs, want 1", n-before)
+	}
+	waitFor(t, func() bool {
+		_, ok, _ := st.TTL("b")
+		return !ok
+	})
+
+	st.Close()
+	st.Close()
+	if n := goroutinesRunning("startSweeper"); n != before {
+		t.Fatalf("%d sweepers are left after Close", n-before)
+	}
+}
+
+func TestGetRemovesExpiredKeys(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithSweepInterval[string, string](time.Hour))
+	defer st.Close()
+
+	st.PutWithTTL("a", "1", time.Second)
+	clock.Advance(2 * time.Second)
+
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for an expired key the sweeper hasn't seen", err)
+	}
+	st.rlock()
+	_, inData := st.data["a"]
+	_, inExpires := st.expires["a"]
+	st.mu.RUnlock()
+	if inData || inExpires {
+		t.Fatal("the expired key wasn't removed on access")
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithDefaultTTL[string, string](time.Minute))
+	defer st.Close()
+
+	st.Put("plain", "1")
+	st.PutWithTTL("permanent", "1", 0)
+	st.PutWithTTL("short", "1", time.Second)
+	if ttl, ok, _ := st.TTL("plain"); !ok || ttl != time.Minute {
+		t.Fatalf("got TTL %s, %t for a plain Put", ttl, ok)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, err := st.Get("short"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("PutWithTTL didn't override the default: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := st.Get("plain"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a plain Put past the default TTL", err)
+	}
+	if _, err := st.Get("permanent"); err != nil {
+		t.Fatalf("PutWithTTL(0) didn't make the key permanent: %v", err)
+	}
+}
+
+func TestPutIfStale(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+
+	st.PutWithTTL("fresh", "old", time.Hour)
+	st.PutWithTTL("stale", "old", time.Minute)
+	clock.Advance(time.Minute + time.Nanosecond)
+
+	for _, tc := range []struct {
+		key   string
+		wrote bool
+		want  string
+	}{
+		{"fresh", false, "old"},
+		{"stale", true, "new"},
+		{"absent", true, "new"},
+	} {
+		wrote, err := st.PutIfStale(tc.key, "new", time.Hour)
+		if err != nil || wrote != tc.wrote {
+			t.Errorf("%s: got %v, %v, want %v", tc.key, wrote, err, tc.wrote)
+		}
+		if v, _ := st.Get(tc.key); v != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.key, v, tc.want)
+		}
+	}
+
+	// The new TTL applies.
+	clock.Advance(time.Hour + time.Nanosecond)
+	if _, err := st.Get("absent"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v past the new TTL", err)
+	}
+}
+
+func TestExpireMany(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.Put("b", "2")
+	st.Put("c", "3")
+	updated, missing := st.ExpireMany(map[string]time.Duration{
+		"a":      time.Minute,
+		"b":      time.Hour,
+		"absent": time.Minute,
+	})
+	sort.Strings(updated)
+	if !reflect.DeepEqual(updated, []string{"a", "b"}) || !reflect.DeepEqual(missing, []string{"absent"}) {
+		t.Fatalf("got updated %v, missing %v", updated, missing)
+	}
+	if _, err := st.Get("absent"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the missing key was created: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := st.Get("a"); err != nil {
+		t.Fatalf("a expired early: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a past its TTL", err)
+	}
+	if _, err := st.Get("b"); err != nil {
+		t.Fatalf("b expired early: %v", err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := st.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for b past its TTL", err)
+	}
+	if _, err := st.Get("c"); err != nil {
+		t.Fatalf("c was given a TTL: %v", err)
+	}
+}
+
+func TestCloseLeavesNoGoroutines(t *testing.T) {
+	// Every goroutine of the package runs a method, so they all have "main.(*" in their stack.
+	before := goroutinesRunning("main.(*")
+
+	for i := 0; i < 50; i++ {
+		st := NewKVStore[string, string](
+			WithSweepInterval[string, string](time.Millisecond),
+			WithPersistence[string, string](PersistBoth, WithDataDir(t.TempDir())),
+			WithEvictionWebhook[string, string]("http://127.0.0.1:0/hook"),
+		)
+		st.PutWithTTL("a", "1", time.Hour)
+		_, cancel := st.Watch("a")
+		cancel()
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+		st.Close()
+	}
+
+	srv := NewServer("127.0.0.1:0", WithLogger(NopLogger{}))
+	go srv.Start()
+	waitFor(t, func() bool { return srv.Addr() != nil })
+	srv.Close()
+	srv.Close()
+
+	waitFor(t, func() bool { return goroutinesRunning("main.(*") <= before })
+}
+
+func TestSweepStrategies(t *testing.T) {
+	SetTestMode(true)
+	defer SetTestMode(false)
+
+	const keys = 10000
+	fill := func(strategy SweepStrategy) *KVStore[string, string] {
+		clock := NewFakeClock(time.Unix(0, 0))
+		st := NewKVStore[string, string](WithClock[string, string](clock), WithSweepStrategy[string, string](strategy))
+		for i := 0; i < keys; i++ {
+			ttl := time.Hour
+			if i%2 == 0 {
+				ttl = time.Minute
+			}
+			st.PutWithTTL(fmt.Sprint(i), "v", ttl)
+		}
+		clock.Advance(2 * time.Minute)
+		return st
+	}
+
+	full := fill(SweepFullScan)
+	if n, _ := full.Tick(); n != keys/2 {
+		t.Fatalf("the full scan removed %d keys in a tick, want %d", n, keys/2)
+	}
+
+	sampled := fill(SweepSampled)
+	n, _ := sampled.Tick()
+	if n == 0 || n > sweepSampleSize*sweepMaxRounds {
+		t.Fatalf("the sampled sweep removed %d keys in a tick, want between 1 and %d", n, sweepSampleSize*sweepMaxRounds)
+	}
+	// It gets there in the end, while more than a quarter of the sample is expired.
+	removed, ticks := n, 1
+	for ; removed < keys/2 && ticks < 10000; ticks++ {
+		n, _ := sampled.Tick()
+		removed += n
+	}
+	if left := len(sampled.expires) - keys/2; left > keys/10 {
+		t.Fatalf("%d expired keys are left after %d ticks", left, ticks)
+	}
+	if _, err := sampled.Get("0"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for an expired key", err)
+	}
+}
+
+func TestExpireNXAndXX(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+
+	st.Put("permanent", "1")
+	st.PutWithTTL("expiring", "1", time.Minute)
+
+	if ok, err := st.ExpireXX("permanent", time.Hour); err != nil || ok {
+		t.Fatalf("XX on a permanent key: got %v, %v", ok, err)
+	}
+	if ok, err := st.ExpireNX("permanent", time.Hour); err != nil || !ok {
+		t.Fatalf("NX on a permanent key: got %v, %v", ok, err)
+	}
+	if ok, err := st.ExpireNX("expiring", time.Hour); err != nil || ok {
+		t.Fatalf("NX on an expiring key: got %v, %v", ok, err)
+	}
+	if _, ok, _ := st.TTL("expiring"); !ok {
+		t.Fatal("the expiring key lost its TTL")
+	}
+	if _, err := st.ExpireNX("absent", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a missing key", err)
+	}
+
+	// NX left the minute alone, XX extends it.
+	clock.Advance(time.Minute + time.Nanosecond)
+	if _, err := st.Get("expiring"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("NX extended the TTL: %v", err)
+	}
+	if _, err := st.Get("permanent"); err != nil {
+		t.Fatalf("the NX TTL expired early: %v", err)
+	}
+	if ok, err := st.ExpireXX("permanent", 2*time.Hour); err != nil || !ok {
+		t.Fatalf("XX on an expiring key: got %v, %v", ok, err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := st.Get("permanent"); err != nil {
+		t.Fatalf("XX didn't extend the TTL: %v", err)
+	}
+}
+
+func TestExpireOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	defer st.Close()
+	st.Put("a", "1")
+	_, h := newTestServer(st)
+
+	for _, tc := range []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/expire/a?ttl=1h&xx=true", http.StatusOK, `{"applied":false}`},
+		{"/expire/a?ttl=1h&nx=true", http.StatusOK, `{"applied":true}`},
+		{"/expire/a?ttl=1h&nx=true", http.StatusOK, `{"applied":false}`},
+		{"/expire/a?ttl=2h&xx=true", http.StatusOK, `{"applied":true}`},
+		{"/expire/a?ttl=1h&nx=true&xx=true", http.StatusBadRequest, ""},
+		{"/expire/a?ttl=soon", http.StatusBadRequest, ""},
+		{"/expire/absent?ttl=1h", http.StatusNotFound, ""},
+	} {
+		rec := do(t, h, http.MethodPost, tc.target, "")
+		if rec.Code != tc.status || tc.body != "" && strings.TrimSpace(rec.Body.String()) != tc.body {
+			t.Errorf("%s: got %d %s, want %d %s", tc.target, rec.Code, rec.Body, tc.status, tc.body)
+		}
+	}
+}
+
+func TestGetWithRemainingTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock))
+	defer st.Close()
+	st.PutWithTTL("a", "1", time.Minute)
+	st.Put("permanent", "1")
+	clock.Advance(15 * time.Second)
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodGet, "/get/a?withTTL=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"ttl_ms":45000,"value":"1"}` {
+		t.Fatalf("got %s", body)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=45" {
+		t.Fatalf("got Cache-Control %q", cc)
+	}
+	if _, err := http.ParseTime(rec.Header().Get("Expires")); err != nil {
+		t.Fatalf("invalid Expires header: %v", err)
+	}
+
+	rec = do(t, h, http.MethodGet, "/get/a", "")
+	if strings.Contains(rec.Body.String(), "ttl_ms") {
+		t.Fatalf("the TTL is returned without withTTL: %s", rec.Body)
+	}
+	rec = do(t, h, http.MethodGet, "/get/permanent?withTTL=true", "")
+	if strings.Contains(rec.Body.String(), "ttl_ms") || rec.Header().Get("Cache-Control") != "" {
+		t.Fatalf("a permanent key got a TTL: %s %v", rec.Body, rec.Header())
+	}
+}
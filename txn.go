@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrConditionFailed is wrapped by the ConditionError returned when a precondition of a transaction doesn't hold.
+var ErrConditionFailed = errors.New("precondition failed")
+
+// ConditionError tells which precondition of a transaction failed, Index is its position in the order they
+// were added.
+type ConditionError[K comparable] struct {
+	Index  int
+	Key    K
+	Reason string
+}
+
+func (e *ConditionError[K]) Error() string {
+	return fmt.Sprintf("condition %d on key (%v) failed: %s", e.Index, e.Key, e.Reason)
+}
+
+func (e *ConditionError[K]) Unwrap() error { return ErrConditionFailed }
+
+type txnCheck[K comparable, V any] struct {
+	key      K
+	expected V
+}
+
+// Txn buffers writes and preconditions, Commit applies all the writes at once if every precondition holds and
+// none otherwise. A Txn isn't safe for concurrent use.
+type Txn[K comparable, V any] struct {
+	s      *KVStore[K, V]
+	checks []txnCheck[K, V]
+	ops    []walRecord[K, V]
+}
+
+// Begin starts a transaction on the store.
+func (s *KVStore[K, V]) Begin() *Txn[K, V] {
+	return &Txn[K, V]{s: s}
+}
+
+// Transactor is implemented by stores that can apply several writes atomically.
+type Transactor[K comparable, V any] interface {
+	Begin() *Txn[K, V]
+}
+
+// CheckEquals adds the precondition that the key exists and holds expected when the transaction commits.
+// Values are compared with reflect.DeepEqual.
+func (t *Txn[K, V]) CheckEquals(key K, expected V) {
+	t.checks = append(t.checks, txnCheck[K, V]{key: t.s.canon(key), expected: expected})
+}
+
+func (t *Txn[K, V]) Put(key K, value V) {
+	t.ops = append(t.ops, walRecord[K, V]{op: walPut, key: t.s.canon(key), value: value})
+}
+
+// Delete removes the key on commit, a missing key isn't an error.
+func (t *Txn[K, V]) Delete(key K) {
+	t.ops = append(t.ops, walRecord[K, V]{op: walDelete, key: t.s.canon(key)})
+}
+
+// Get returns the value of the key as the transaction would leave it, its own pending writes included.
+func (t *Txn[K, V]) Get(key K) (V, error) {
+	key = t.s.canon(key)
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		if op := t.ops[i]; op.key == key {
+			if op.op == walDelete {
+				var zero V
+				return zero, keyNotFound(key)
+			}
+			return op.value, nil
+		}
+	}
+	return t.s.Get(key)
+}
+
+// Commit checks the preconditions and applies the writes in order under a single write lock, the preconditions
+// see the store as it was before the transaction. It returns a *ConditionError for the first failing
+// precondition, and nothing is applied if it fails for any reason. The puts follow the capacity limits of the
+// store but not its PutMode, and they reset the TTL of the key like Put.
+func (t *Txn[K, V]) Commit() error {
+	s := t.s
+	for _, op := range t.ops {
+		if op.op == walPut {
+			if err := s.validate(op.key, op.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	for i, check := range t.checks {
+		current, ok := s.lookup(check.key)
+		if !ok {
+			return &ConditionError[K]{Index: i, Key: check.key, Reason: "the key does not exist"}
+		}
+		if !reflect.DeepEqual(current, check.expected) {
+			return &ConditionError[K]{Index: i, Key: check.key, Reason: fmt.Sprintf("the value is %v, not %v", current, check.expected)}
+		}
+	}
+	if err := s.checkTxnCapacity(t.ops); err != nil {
+		return err
+	}
+	if err := s.appendWAL(t.ops...); err != nil {
+		return err
+	}
+
+	for _, op := range t.ops {
+		if op.op == walPut {
+			s.set(op.key, op.value)
+			s.setTTL(op.key, s.defaultTTL)
+			continue
+		}
+		if value, ok := s.lookup(op.key); ok {
+			s.remove(op.key)
+			s.notify(EventDelete, op.key, value)
+		} else if s.Has(op.key) {
+			s.expire(op.key)
+		}
+	}
+	return nil
+}
+
+// checkTxnCapacity is checkCapacity for all the ops of a transaction together, like Swap the keys and bytes they
+// add are counted across the ops and checked once against the limits. It must be called with the lock held.
+func (s *KVStore[K, V]) checkTxnCapacity(ops []walRecord[K, V]) error {
+	// The size each key the ops touch is left with, -1 for a deleted one.
+	sizes := make(map[K]int)
+	for _, op := range ops {
+		if op.op != walPut {
+			sizes[op.key] = -1
+			continue
+		}
+		size := entrySize(op.key, op.value)
+		if s.maxBytes > 0 && size > s.maxBytes {
+			return fmt.Errorf("%w: the entry takes %d bytes, the capacity is %d", ErrStoreFull, size, s.maxBytes)
+		}
+		if s.memoryLimit > 0 && size+entryOverhead > s.memoryLimit {
+			return fmt.Errorf("%w: the entry takes %d bytes, the limit is %d", ErrMemoryLimit, size+entryOverhead, s.memoryLimit)
+		}
+		sizes[op.key] = size
+	}
+
+	added, removed, grown := 0, 0, 0
+	for key, size := range sizes {
+		existed := s.Has(key)
+		if existed {
+			grown -= s.meta[key].size
+		}
+		switch {
+		case size < 0 && existed:
+			removed++
+		case size >= 0 && !existed:
+			added++
+		}
+		if size >= 0 {
+			grown += size
+		}
+	}
+	if s.maxKeys > 0 && added > 0 && len(s.data)+added-removed > s.maxKeys {
+		return ErrStoreFull
+	}
+	// The eviction makes room in a byte-capacity store.
+	if s.memoryLimit > 0 && s.lru == nil {
+		grown += (added - removed) * entryOverhead
+		if usage := s.memoryUsage() + grown; grown > 0 && usage > s.memoryLimit {
+			return fmt.Errorf("%w: it would take %d bytes, the limit is %d", ErrMemoryLimit, usage, s.memoryLimit)
+		}
+	}
+	return nil
+}
+
+type txnRequest struct {
+	Conditions []struct {
+		Key    string  `json:"key"`
+		Equals *string `json:"equals"`
+	} `json:"conditions"`
+	Ops []struct {
+		Op    string  `json:"op"`
+		Key   string  `json:"key"`
+		Value *string `json:"value"`
+	} `json:"ops"`
+}
+
+// handleTxn serves POST /txn, taking {"conditions": [{"key": ..., "equals": ...}], "ops": [{"op": "put",
+// "key": ..., "value": ...}, {"op": "delete", "key": ...}]}. The ops are applied only if every condition holds,
+// otherwise it answers 412 with the index and key of the failed condition.
+func (s *Server) handleTxn(c echo.Context) error {
+	transactor, ok := s.Storage.(Transactor[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support transactions")
+	}
+
+	var req txnRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid transaction: "+err.Error())
+	}
+	if err := s.checkBatchSize(len(req.Conditions) + len(req.Ops)); err != nil {
+		return err
+	}
+
+	txn := transactor.Begin()
+	for i, cond := range req.Conditions {
+		if cond.Key == "" || cond.Equals == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("condition %d needs a key and equals", i))
+		}
+		txn.CheckEquals(cond.Key, *cond.Equals)
+	}
+	for i, op := range req.Ops {
+		switch {
+		case op.Key == "":
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("op %d has no key", i))
+		case op.Op == "put" && op.Value != nil:
+			if err := s.checkValue(*op.Value); err != nil {
+				return toHTTPError(err)
+			}
+			txn.Put(op.Key, *op.Value)
+		case op.Op == "delete":
+			txn.Delete(op.Key)
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("op %d must be a put with a value or a delete", i))
+		}
+	}
+
+	err := s.fence(c, txn.Commit)
+	var condErr *ConditionError[string]
+	if errors.As(err, &condErr) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, map[string]any{
+			"message":   condErr.Error(),
+			"condition": condErr.Index,
+			"key":       condErr.Key,
+		})
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	for _, op := range req.Ops {
+		var value string
+		if op.Value != nil {
+			value = *op.Value
+		}
+		s.journalOp(c, op.Op, op.Key, value, http.StatusOK)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"committed": true, "applied": len(req.Ops)})
+}
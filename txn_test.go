@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTxnCommit(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("state", "idle")
+	st.Put("owner", "nobody")
+	st.Put("gone", "1")
+
+	txn := st.Begin()
+	txn.CheckEquals("state", "idle")
+	txn.CheckEquals("owner", "nobody")
+	txn.Put("state", "running")
+	txn.Put("owner", "worker-1")
+	txn.Delete("gone")
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{"state": "running", "owner": "worker-1"} {
+		if v, _ := st.Get(key); v != want {
+			t.Errorf("%s: got %q, want %q", key, v, want)
+		}
+	}
+	if _, err := st.Get("gone"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the delete wasn't applied: %v", err)
+	}
+}
+
+func TestTxnFailingConditionAbortsEveryWrite(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("state", "idle")
+	st.Put("owner", "worker-2")
+
+	txn := st.Begin()
+	txn.CheckEquals("state", "idle")
+	txn.CheckEquals("owner", "nobody")
+	txn.Put("state", "running")
+	txn.Put("new", "1")
+	txn.Delete("owner")
+	err := txn.Commit()
+
+	var condErr *ConditionError[string]
+	if !errors.As(err, &condErr) || !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("got %v, want a ConditionError", err)
+	}
+	if condErr.Index != 1 || condErr.Key != "owner" {
+		t.Fatalf("got condition %d on %q, want the second one on owner", condErr.Index, condErr.Key)
+	}
+	if v, _ := st.Get("state"); v != "idle" {
+		t.Fatalf("state: got %q", v)
+	}
+	if v, _ := st.Get("owner"); v != "worker-2" {
+		t.Fatalf("owner: got %q", v)
+	}
+	if _, err := st.Get("new"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("new: got %v", err)
+	}
+
+	// A missing key fails its condition too.
+	txn = st.Begin()
+	txn.CheckEquals("missing", "")
+	txn.Put("new", "1")
+	if err := txn.Commit(); !errors.As(err, &condErr) || condErr.Key != "missing" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestTxnReadsItsOwnWrites(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("b", "2")
+
+	txn := st.Begin()
+	txn.Put("a", "10")
+	txn.Delete("b")
+	txn.Put("c", "3")
+	txn.Put("c", "30")
+
+	if v, err := txn.Get("a"); err != nil || v != "10" {
+		t.Fatalf("a: got %q, %v", v, err)
+	}
+	if _, err := txn.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("b: got %v after its delete", err)
+	}
+	if v, _ := txn.Get("c"); v != "30" {
+		t.Fatalf("c: got %q, want the last put", v)
+	}
+	// Nothing reaches the store before the commit.
+	if v, _ := st.Get("a"); v != "1" {
+		t.Fatalf("the store sees %q before the commit", v)
+	}
+	if _, err := st.Get("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := st.Get("c"); v != "30" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestTxnCapacityCountsEveryOp(t *testing.T) {
+	st := NewKVStoreWithMaxKeys[string, string](2)
+	st.Put("a", "1")
+
+	txn := st.Begin()
+	txn.Put("b", "2")
+	txn.Put("c", "3")
+	if err := txn.Commit(); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("got %v for two new keys with room for one", err)
+	}
+	if _, err := st.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("part of the rejected transaction was applied: %v", err)
+	}
+
+	// A key written twice is counted once, and a key deleted makes room for another.
+	txn = st.Begin()
+	txn.Put("b", "2")
+	txn.Put("b", "3")
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	txn = st.Begin()
+	txn.Delete("a")
+	txn.Put("c", "3")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("a swap of keys at the limit: %v", err)
+	}
+
+	per := entrySize("key0", "0123456789") + entryOverhead
+	limited := NewKVStore[string, string](WithMemoryLimit[string, string](2 * per))
+	limited.Put("key0", "0123456789")
+	txn = limited.Begin()
+	txn.Put("key1", "0123456789")
+	txn.Put("key2", "0123456789")
+	if err := txn.Commit(); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("got %v over the memory limit", err)
+	}
+	txn = limited.Begin()
+	txn.Put("key1", "0123456789")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("within the memory limit: %v", err)
+	}
+}
+
+func TestTxnOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("state", "idle")
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodPost, "/txn", `{
+		"conditions": [{"key": "state", "equals": "running"}],
+		"ops": [{"op": "put", "key": "state", "value": "done"}, {"op": "put", "key": "other", "value": "1"}]
+	}`, "Content-Type", "application/json")
+	expectStatus(t, rec, http.StatusPreconditionFailed)
+	var failed struct {
+		Condition int    `json:"condition"`
+		Key       string `json:"key"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &failed); err != nil || failed.Key != "state" || failed.Condition != 0 {
+		t.Fatalf("got %s, %v", rec.Body, err)
+	}
+	if _, err := st.Get("other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("an aborted transaction wrote: %v", err)
+	}
+
+	rec = do(t, h, http.MethodPost, "/txn", `{
+		"conditions": [{"key": "state", "equals": "idle"}],
+		"ops": [{"op": "put", "key": "state", "value": "done"}, {"op": "delete", "key": "other"}]
+	}`, "Content-Type", "application/json")
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"committed":true`) {
+		t.Fatalf("got %s", rec.Body)
+	}
+	if v, _ := st.Get("state"); v != "done" {
+		t.Fatalf("got %q", v)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/txn", `{"conditions": [{"key": "state"}]}`), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/txn", `{"ops": [{"op": "put", "key": "a"}]}`), http.StatusBadRequest)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrNoValueIndex is returned by KeysInValueRange for a store created without WithValueIndex or
+// WithNumericValueIndex.
+var ErrNoValueIndex = errors.New("the store has no value index")
+
+// ErrInvalidRange is returned by KeysInValueRange for bounds that can't be in the index, e.g. bounds that aren't
+// numbers for a WithNumericValueIndex.
+var ErrInvalidRange = errors.New("invalid range")
+
+// WithValueIndex keeps the keys sorted by their value, so that KeysInValueRange doesn't have to scan the store.
+// Every write pays for it with a binary search and a copy of part of the index.
+func WithValueIndex[K comparable, V cmp.Ordered]() Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.valueIndex = &valueIndex[K, V]{compare: cmp.Compare[V]}
+	}
+}
+
+// WithNumericValueIndex is WithValueIndex for a string store holding numbers, the values are ordered as numbers
+// rather than as strings and the ones that aren't numbers aren't indexed.
+func WithNumericValueIndex[K comparable]() Option[K, string] {
+	return func(s *KVStore[K, string]) {
+		s.valueIndex = &valueIndex[K, string]{
+			compare: func(a, b string) int {
+				x, _ := strconv.ParseFloat(a, 64)
+				y, _ := strconv.ParseFloat(b, 64)
+				return cmp.Compare(x, y)
+			},
+			indexed: func(v string) bool {
+				_, err := strconv.ParseFloat(v, 64)
+				return err == nil
+			},
+		}
+	}
+}
+
+type indexEntry[K comparable, V any] struct {
+	value V
+	key   K
+}
+
+// valueIndex is a slice of the entries sorted by value, the entries with the same value are in insertion order.
+// It's guarded by the lock of the store.
+type valueIndex[K comparable, V any] struct {
+	compare func(a, b V) int
+	// indexed reports whether a value goes in the index, nil means they all do.
+	indexed func(V) bool
+	entries []indexEntry[K, V]
+}
+
+// lowerBound returns the position of the first entry whose value isn't less than value.
+func (x *valueIndex[K, V]) lowerBound(value V) int {
+	return sort.Search(len(x.entries), func(i int) bool { return x.compare(x.entries[i].value, value) >= 0 })
+}
+
+func (x *valueIndex[K, V]) add(key K, value V) {
+	if x.indexed != nil && !x.indexed(value) {
+		return
+	}
+	i := sort.Search(len(x.entries), func(i int) bool { return x.compare(x.entries[i].value, value) > 0 })
+	x.entries = append(x.entries, indexEntry[K, V]{})
+	copy(x.entries[i+1:], x.entries[i:])
+	x.entries[i] = indexEntry[K, V]{value: value, key: key}
+}
+
+func (x *valueIndex[K, V]) remove(key K, value V) {
+	if x.indexed != nil && !x.indexed(value) {
+		return
+	}
+	for i := x.lowerBound(value); i < len(x.entries) && x.compare(x.entries[i].value, value) == 0; i++ {
+		if x.entries[i].key == key {
+			x.entries = append(x.entries[:i], x.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (x *valueIndex[K, V]) reset(data map[K]V) {
+	x.entries = x.entries[:0]
+	for key, value := range data {
+		if x.indexed == nil || x.indexed(value) {
+			x.entries = append(x.entries, indexEntry[K, V]{value: value, key: key})
+		}
+	}
+	sort.SliceStable(x.entries, func(i, j int) bool { return x.compare(x.entries[i].value, x.entries[j].value) < 0 })
+}
+
+// KeysInValueRange returns the keys whose value is between lo and hi, both included, sorted by value. It needs
+// a store created WithValueIndex or WithNumericValueIndex, it fails with ErrNoValueIndex otherwise, and with
+// ErrInvalidRange for bounds that can't be indexed.
+func (s *KVStore[K, V]) KeysInValueRange(lo, hi V) ([]K, error) {
+	x := s.valueIndex
+	if x == nil {
+		return nil, ErrNoValueIndex
+	}
+	if x.indexed != nil && (!x.indexed(lo) || !x.indexed(hi)) {
+		return nil, fmt.Errorf("%w: the bounds (%v) and (%v) can't be indexed", ErrInvalidRange, lo, hi)
+	}
+
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	var keys []K
+	for i := x.lowerBound(lo); i < len(x.entries) && x.compare(x.entries[i].value, hi) <= 0; i++ {
+		if key := x.entries[i].key; !s.isExpired(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// ValueRanger is implemented by stores that can list the keys by the range of their values.
+type ValueRanger[K comparable, V any] interface {
+	KeysInValueRange(lo, hi V) ([]K, error)
+}
+
+// handleRange serves GET /range?min=&max=, the keys whose value is between min and max, both included, sorted by
+// value. It's limited to the scan limit like /keys.
+func (s *Server) handleRange(c echo.Context) error {
+	ranger, ok := s.Storage.(ValueRanger[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support range queries")
+	}
+	lo, hi := c.QueryParam("min"), c.QueryParam("max")
+	if lo == "" || hi == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "min and max are required")
+	}
+
+	keys, err := ranger.KeysInValueRange(lo, hi)
+	switch {
+	case errors.Is(err, ErrNoValueIndex):
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage has no value index")
+	case errors.Is(err, ErrInvalidRange):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	truncated := len(keys) > s.maxScanResults
+	if truncated {
+		keys = keys[:s.maxScanResults]
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"keys":      keys,
+		"truncated": truncated,
+	})
+}
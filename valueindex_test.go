@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeysInValueRange(t *testing.T) {
+	st := NewKVStore[string, int](WithValueIndex[string, int]())
+	for key, value := range map[string]int{"a": 5, "b": 1, "c": 9, "d": 5, "e": 12} {
+		st.Put(key, value)
+	}
+	expectRange := func(lo, hi int, want ...string) {
+		t.Helper()
+		keys, err := st.KeysInValueRange(lo, hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 && len(want) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("[%d, %d]: got %v, want %v", lo, hi, keys, want)
+		}
+	}
+
+	expectRange(5, 9, "a", "c", "d")
+	expectRange(1, 1, "b")
+	expectRange(13, 20)
+
+	// An update moves the key out of the range, and into another one.
+	st.Put("c", 20)
+	expectRange(5, 9, "a", "d")
+	expectRange(13, 20, "c")
+	st.Update("b", 6)
+	expectRange(5, 9, "a", "b", "d")
+
+	st.Delete("a")
+	expectRange(5, 9, "b", "d")
+	expectRange(0, 100, "b", "c", "d", "e")
+}
+
+func TestKeysInValueRangeMatchesAScan(t *testing.T) {
+	st := NewKVStore[string, int](WithValueIndex[string, int]())
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprint("key", rng.Intn(200))
+		if rng.Intn(4) == 0 {
+			st.Delete(key)
+		} else {
+			st.Put(key, rng.Intn(100))
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		lo := rng.Intn(100)
+		hi := lo + rng.Intn(30)
+		keys, err := st.KeysInValueRange(lo, hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j < len(keys); j++ {
+			if mustGet[int](t, st, keys[j-1]) > mustGet[int](t, st, keys[j]) {
+				t.Fatalf("the keys aren't sorted by value: %v", keys)
+			}
+		}
+		want := st.Filter(func(_ string, v int) bool { return v >= lo && v <= hi })
+		if len(keys) != len(want) {
+			t.Fatalf("[%d, %d]: got %d keys, a scan finds %d", lo, hi, len(keys), len(want))
+		}
+		for _, key := range keys {
+			if _, ok := want[key]; !ok {
+				t.Fatalf("[%d, %d]: %s isn't in the range", lo, hi, key)
+			}
+		}
+	}
+}
+
+func TestValueIndexAfterLoadAndExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	st := NewKVStore[string, int](WithValueIndex[string, int](), WithClock[string, int](clock))
+	st.Put("a", 1)
+	st.PutWithTTL("b", 2, time.Second)
+
+	clock.Advance(2 * time.Second)
+	if keys, _ := st.KeysInValueRange(0, 10); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("got %v, the expired key is in the range", keys)
+	}
+
+	src := NewKVStore[string, int]()
+	src.Put("x", 3)
+	src.Put("y", 30)
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := st.KeysInValueRange(0, 10); !reflect.DeepEqual(keys, []string{"x"}) {
+		t.Fatalf("got %v after loading a snapshot", keys)
+	}
+
+	if _, err := NewKVStore[string, int]().KeysInValueRange(0, 1); !errors.Is(err, ErrNoValueIndex) {
+		t.Fatalf("got %v without an index", err)
+	}
+}
+
+func TestRangeOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string](WithNumericValueIndex[string]())
+	for key, value := range map[string]string{"a": "9", "b": "10", "c": "100", "d": "not a number"} {
+		st.Put(key, value)
+	}
+	_, h := newTestServer(st)
+
+	rec := do(t, h, http.MethodGet, "/range?min=5&max=50", "")
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	// Compared as strings 10 and 100 are before 5.
+	if !reflect.DeepEqual(body.Keys, []string{"a", "b"}) {
+		t.Fatalf("got %v", body.Keys)
+	}
+
+	expectStatus(t, do(t, h, http.MethodGet, "/range?min=5", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodGet, "/range?min=x&max=5", ""), http.StatusBadRequest)
+	_, h = newTestServer(NewKVStore[string, string]())
+	expectStatus(t, do(t, h, http.MethodGet, "/range?min=1&max=5", ""), http.StatusNotImplemented)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrVersionMismatch is wrapped by the errors returned when the expected version of a key isn't its current one.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// DeleteWithVersion deletes the key only if its current version is expectedVersion, so an entry modified since
+// it was read isn't deleted by mistake. It fails with ErrVersionMismatch otherwise and leaves the key alone.
+func (s *KVStore[K, V]) DeleteWithVersion(key K, expectedVersion uint64) error {
+	key = s.canon(key)
+	s.lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.lookup(key)
+	if !ok {
+		return keyNotFound(key)
+	}
+	if version := s.meta[key].version; version != expectedVersion {
+		return fmt.Errorf("the key (%v) is at version %d, not %d: %w", key, version, expectedVersion, ErrVersionMismatch)
+	}
+	if err := s.logDelete(key); err != nil {
+		return err
+	}
+
+	s.remove(key)
+	s.notify(EventDelete, key, value)
+
+	return nil
+}
+
+// VersionedDeleter is implemented by stores that can delete a key conditionally on its version.
+type VersionedDeleter[K comparable] interface {
+	DeleteWithVersion(key K, expectedVersion uint64) error
+}
+
+// handleDeleteKV serves DELETE /kv/:key. With an If-Match header carrying the version (as a plain number or
+// an ETag like "3") the key is only deleted at that version, a stale version is answered with 412.
+func (s *Server) handleDeleteKV(c echo.Context) error {
+	key := c.Param("key")
+
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		err := s.fence(c, func() error {
+			_, err := deleteWithContext(c.Request().Context(), s.Storage, key)
+			return err
+		})
+		if err != nil {
+			return toHTTPError(err)
+		}
+		return s.done(c, map[string]string{"deleted-entry": key})
+	}
+
+	deleter, ok := s.Storage.(VersionedDeleter[string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support versioned deletes")
+	}
+	version, err := parseVersion(ifMatch)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid If-Match version: "+ifMatch)
+	}
+	if err := s.fence(c, func() error { return deleter.DeleteWithVersion(key, version) }); err != nil {
+		return toHTTPError(err)
+	}
+
+	return s.done(c, map[string]string{"deleted-entry": key})
+}
+
+// parseVersion parses the version of an If-Match header, given as a plain number or an ETag like "3" or W/"3".
+func parseVersion(ifMatch string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteWithVersion(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	info, _ := st.Inspect("a")
+	read := info.Version
+	st.Put("a", "2")
+
+	if err := st.DeleteWithVersion("a", read); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("got %v deleting at a stale version", err)
+	}
+	if v, err := st.Get("a"); err != nil || v != "2" {
+		t.Fatalf("the key didn't survive the stale delete: %q, %v", v, err)
+	}
+
+	info, _ = st.Inspect("a")
+	if err := st.DeleteWithVersion("a", info.Version); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v after deleting at the current version", err)
+	}
+	if err := st.DeleteWithVersion("a", info.Version); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v deleting a missing key", err)
+	}
+}
+
+func TestDeleteIfMatchOverHTTP(t *testing.T) {
+	st := NewKVStore[string, string]()
+	st.Put("a", "1")
+	st.Put("a", "2")
+	info, _ := st.Inspect("a")
+	_, h := newTestServer(st)
+
+	stale := fmt.Sprintf(`"%d"`, info.Version-1)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", "If-Match", stale), http.StatusPreconditionFailed)
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", "If-Match", "three"), http.StatusBadRequest)
+	if _, err := st.Get("a"); err != nil {
+		t.Fatalf("the key didn't survive: %v", err)
+	}
+
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", "If-Match", fmt.Sprintf(`W/"%d"`, info.Version)), http.StatusOK)
+
+	// A recreated key starts over, the version can also be a plain number.
+	st.Put("a", "3")
+	info, _ = st.Inspect("a")
+	expectStatus(t, do(t, h, http.MethodDelete, "/kv/a", "", "If-Match", fmt.Sprint(info.Version)), http.StatusOK)
+}
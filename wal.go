@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The write-ahead log is a sequence of records, each one written as
+//
+//	op uvarint(len(key)) key [uvarint(len(value)) value]
+//
+// where op is walPut or walDelete and only puts carry a value, or of the op walClear alone for Clear. Like
+// snapshots it doesn't carry TTLs, and expirations and evictions aren't logged.
+
+const (
+	walPut    byte = 'p'
+	walDelete byte = 'd'
+	walClear  byte = 'c'
+)
+
+// ErrWALWrite is returned by the writes of a WALStrict store when the record couldn't be written to the log.
+var ErrWALWrite = errors.New("writing to the WAL failed")
+
+// WALMode decides what a store does when it can't write to its WAL.
+type WALMode int
+
+const (
+	// WALStrict fails the write with ErrWALWrite and leaves the store untouched, durability over availability.
+	WALStrict WALMode = iota
+	// WALLenient logs the error and applies the write in memory anyway, DurabilityDegraded reports it happened.
+	WALLenient
+)
+
+// WithWAL makes the store log every write to w before applying it, ReplayWAL rebuilds the store from the log.
+func WithWAL[K comparable, V any](w io.Writer, mode WALMode) Option[K, V] {
+	return func(s *KVStore[K, V]) {
+		s.wal = w
+		s.walMode = mode
+	}
+}
+
+// DurabilityDegraded reports whether a WALLenient store has applied writes that didn't make it to its WAL.
+func (s *KVStore[K, V]) DurabilityDegraded() bool {
+	return s.durabilityDegraded.Load()
+}
+
+type walRecord[K comparable, V any] struct {
+	op    byte
+	key   K
+	value V
+}
+
+func (s *KVStore[K, V]) logPut(key K, value V) error {
+	return s.appendWAL(walRecord[K, V]{op: walPut, key: key, value: value})
+}
+
+func (s *KVStore[K, V]) logDelete(key K) error {
+	return s.appendWAL(walRecord[K, V]{op: walDelete, key: key})
+}
+
+func (s *KVStore[K, V]) logClear() error {
+	return s.appendWAL(walRecord[K, V]{op: walClear})
+}
+
+// appendWAL writes the records of one operation to the WAL with a single Write, must be called with the write
+// lock held and before the operation is applied.
+func (s *KVStore[K, V]) appendWAL(records ...walRecord[K, V]) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	for _, record := range records {
+		if record.op == walClear {
+			bw.WriteByte(record.op)
+			continue
+		}
+		k, err := s.keyCodec.Marshal(record.key)
+		if err != nil {
+			return fmt.Errorf("encoding key (%v): %w", record.key, err)
+		}
+		bw.WriteByte(record.op)
+		writeFrame(bw, k)
+		if record.op == walPut {
+			v, err := s.valueCodec.Marshal(record.value)
+			if err != nil {
+				return fmt.Errorf("encoding value of key (%v): %w", record.key, err)
+			}
+			writeFrame(bw, v)
+		}
+	}
+	bw.Flush()
+
+	if _, err := s.wal.Write(buf.Bytes()); err != nil {
+		if s.walMode == WALStrict {
+			return fmt.Errorf("%w: %w", ErrWALWrite, err)
+		}
+		if !s.durabilityDegraded.Swap(true) {
+			s.logger.Error("writing to the WAL failed, writes are only kept in memory from now on: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReplayWAL applies the records read from r on top of the current contents of the store, without logging them
+// again. A record truncated by a crash at the end of the log is ignored.
+func (s *KVStore[K, V]) ReplayWAL(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	s.lock()
+	defer s.mu.Unlock()
+
+	for {
+		op, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if op == walClear {
+			s.clear()
+			continue
+		}
+		k, err := readFrame(br)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, err := s.keyCodec.Unmarshal(k)
+		if err != nil {
+			return fmt.Errorf("decoding key: %w", err)
+		}
+
+		switch op {
+		case walPut:
+			v, err := readFrame(br)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			value, err := s.valueCodec.Unmarshal(v)
+			if err != nil {
+				return fmt.Errorf("decoding value of key (%v): %w", key, err)
+			}
+			s.set(key, value)
+		case walDelete:
+			if s.Has(key) {
+				s.remove(key)
+			}
+		default:
+			return fmt.Errorf("unknown WAL record %q", op)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// failingWriter is a WAL on a full disk.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestWALStrictFailsWrites(t *testing.T) {
+	st := NewKVStore[string, string](WithWAL[string, string](failingWriter{}, WALStrict))
+	if err := st.Put("a", "1"); !errors.Is(err, ErrWALWrite) {
+		t.Fatalf("got %v, want ErrWALWrite", err)
+	}
+	if _, err := st.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("the failed write was applied: %v", err)
+	}
+	if st.DurabilityDegraded() {
+		t.Fatal("a strict store reports degraded durability")
+	}
+
+	_, h := newTestServer(st)
+	expectStatus(t, do(t, h, http.MethodPut, "/kv/a", "1"), http.StatusInsufficientStorage)
+}
+
+func TestWALLenientKeepsWriting(t *testing.T) {
+	logger := &recordingLogger{}
+	st := NewKVStore[string, string](
+		WithWAL[string, string](failingWriter{}, WALLenient),
+		WithStoreLogger[string, string](logger),
+	)
+	if err := st.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Get("a"); err != nil || v != "1" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if !st.DurabilityDegraded() {
+		t.Fatal("the store doesn't report degraded durability")
+	}
+	if len(logger.messages) != 1 || !logger.contains("WAL") {
+		t.Fatalf("got the messages %q, want a single warning", logger.messages)
+	}
+	if err := st.CheckPersistence(); err == nil {
+		t.Fatal("the persistence check passes")
+	}
+}
+
+func TestReplayWAL(t *testing.T) {
+	var wal bytes.Buffer
+	st := NewKVStore[string, string](WithWAL[string, string](&wal, WALStrict))
+	st.Put("a", "1")
+	st.Put("b", "2")
+	st.Delete("a")
+
+	replayed := NewKVStore[string, string]()
+	// A record cut short by a crash is ignored.
+	if err := replayed.ReplayWAL(bytes.NewReader(append(wal.Bytes(), walPut, 5, 'c'))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replayed.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v for a deleted key", err)
+	}
+	if v, _ := replayed.Get("b"); v != "2" {
+		t.Fatalf("got %q for b", v)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// watchBuffer is how many events a watcher can lag behind before new events are dropped for it,
+// writers never block on slow watchers.
+const watchBuffer = 64
+
+type EventType string
+
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+	EventExpire EventType = "expire"
+	EventEvict  EventType = "evict"
+	// EventClear is only in the change stream, for Clear, its key and value are the zero values.
+	EventClear EventType = "clear"
+)
+
+// Event describes a change to a key, Value is the new value for EventPut and the removed one otherwise.
+type Event[K comparable, V any] struct {
+	Type  EventType `json:"type"`
+	Key   K         `json:"key"`
+	Value V         `json:"value"`
+}
+
+type watcher[K comparable, V any] struct {
+	ch chan Event[K, V]
+	// closed is set under the hub lock once ch is closed, by its cancel func or by closeAll.
+	closed bool
+}
+
+// prefixNode is a node of the trie of prefix watchers, keyed by the bytes of the prefix.
+// A write only walks the path of its own key, so its cost doesn't grow with the number of prefix watchers.
+type prefixNode[K comparable, V any] struct {
+	children map[byte]*prefixNode[K, V]
+	watchers map[*watcher[K, V]]struct{}
+}
+
+func newPrefixNode[K comparable, V any]() *prefixNode[K, V] {
+	return &prefixNode[K, V]{
+		children: make(map[byte]*prefixNode[K, V]),
+		watchers: make(map[*watcher[K, V]]struct{}),
+	}
+}
+
+// watchHub fans the store's events out to the watchers, it has its own lock so watching doesn't contend with the store.
+type watchHub[K comparable, V any] struct {
+	mu       sync.Mutex
+	exact    map[K]map[*watcher[K, V]]struct{}
+	prefixes *prefixNode[K, V]
+	count    int
+	// closed is set by closeAll, the watchers registered afterwards get an already closed channel.
+	closed bool
+}
+
+func newWatchHub[K comparable, V any]() *watchHub[K, V] {
+	return &watchHub[K, V]{
+		exact:    make(map[K]map[*watcher[K, V]]struct{}),
+		prefixes: newPrefixNode[K, V](),
+	}
+}
+
+// Watch returns a channel that receives every change to the key, call the returned func to stop watching.
+func (s *KVStore[K, V]) Watch(key K) (<-chan Event[K, V], func()) {
+	key = s.canon(key)
+	h := s.watchers
+	w := &watcher[K, V]{ch: make(chan Event[K, V], watchBuffer)}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	if h.exact[key] == nil {
+		h.exact[key] = make(map[*watcher[K, V]]struct{})
+	}
+	h.exact[key][w] = struct{}{}
+	h.count++
+	h.mu.Unlock()
+
+	return w.ch, h.cancelFunc(w, func() {
+		delete(h.exact[key], w)
+		if len(h.exact[key]) == 0 {
+			delete(h.exact, key)
+		}
+	})
+}
+
+// WatchPrefix returns a channel that receives every change to the keys starting with prefix.
+// Keys are matched on their string form, so it's meant for string keys.
+func (s *KVStore[K, V]) WatchPrefix(prefix K) (<-chan Event[K, V], func()) {
+	prefix = s.canon(prefix)
+	h := s.watchers
+	w := &watcher[K, V]{ch: make(chan Event[K, V], watchBuffer)}
+	p := keyString(prefix)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	node := h.prefixes
+	for i := 0; i < len(p); i++ {
+		child, ok := node.children[p[i]]
+		if !ok {
+			child = newPrefixNode[K, V]()
+			node.children[p[i]] = child
+		}
+		node = child
+	}
+	node.watchers[w] = struct{}{}
+	h.count++
+	h.mu.Unlock()
+
+	return w.ch, h.cancelFunc(w, func() {
+		h.removePrefixWatcher(h.prefixes, p, w)
+	})
+}
+
+// cancelFunc returns the func that unregisters the watcher and closes its channel, it's safe to call more than once.
+func (h *watchHub[K, V]) cancelFunc(w *watcher[K, V], unregister func()) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			if w.closed {
+				return
+			}
+			unregister()
+			h.count--
+			w.closed = true
+			close(w.ch)
+		})
+	}
+}
+
+// closeAll closes the channel of every watcher, the store calls it on Close so that nobody waits on it forever.
+func (h *watchHub[K, V]) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	closing := func(watchers map[*watcher[K, V]]struct{}) {
+		for w := range watchers {
+			w.closed = true
+			close(w.ch)
+		}
+	}
+	for _, watchers := range h.exact {
+		closing(watchers)
+	}
+	nodes := []*prefixNode[K, V]{h.prefixes}
+	for len(nodes) > 0 {
+		node := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		closing(node.watchers)
+		for _, child := range node.children {
+			nodes = append(nodes, child)
+		}
+	}
+
+	h.exact = make(map[K]map[*watcher[K, V]]struct{})
+	h.prefixes = newPrefixNode[K, V]()
+	h.count = 0
+	h.closed = true
+}
+
+// removePrefixWatcher removes the watcher from the node of the prefix, and prunes the nodes left empty on the way back up.
+func (h *watchHub[K, V]) removePrefixWatcher(node *prefixNode[K, V], p string, w *watcher[K, V]) bool {
+	if p == "" {
+		delete(node.watchers, w)
+	} else if child, ok := node.children[p[0]]; ok && h.removePrefixWatcher(child, p[1:], w) {
+		delete(node.children, p[0])
+	}
+	return len(node.watchers) == 0 && len(node.children) == 0
+}
+
+// notify sends the event to the watchers of the key and of its prefixes.
+// The store calls it with its lock held, so watchers see the events in the order they were applied.
+func (s *KVStore[K, V]) notify(typ EventType, key K, value V) {
+	s.metrics.Counter(eventCounters[typ], 1)
+	s.metrics.Gauge("kv_keys", float64(len(s.data)))
+	if s.changes != nil {
+		s.changes.append(typ, key, value, s.epoch.Load())
+	}
+	s.notifyWebhook(typ, key, value)
+	if typ == EventDelete && s.aliasCascade {
+		s.dropAliasesTo(key)
+	}
+	if s.tombstones != nil {
+		s.recordTombstone(typ, key)
+	}
+
+	h := s.watchers
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return
+	}
+
+	event := Event[K, V]{Type: typ, Key: key, Value: value}
+	for w := range h.exact[key] {
+		w.send(event)
+	}
+
+	node, k := h.prefixes, keyString(key)
+	for i := 0; ; i++ {
+		for w := range node.watchers {
+			w.send(event)
+		}
+		if i == len(k) {
+			break
+		}
+		child, ok := node.children[k[i]]
+		if !ok {
+			break
+		}
+		node = child
+	}
+}
+
+func (w *watcher[K, V]) send(event Event[K, V]) {
+	select {
+	case w.ch <- event:
+	default:
+		// The watcher is too slow, drop the event rather than blocking the store.
+	}
+}
+
+func keyString[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
+
+// PrefixWatcher is implemented by stores that can stream the changes under a key prefix.
+type PrefixWatcher[K comparable, V any] interface {
+	WatchPrefix(K) (<-chan Event[K, V], func())
+}
+
+// handleWatchPrefix serves GET /watch/prefix/:prefix as a server-sent events stream, one event per change.
+func (s *Server) handleWatchPrefix(c echo.Context) error {
+	pw, ok := s.Storage.(PrefixWatcher[string, string])
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage does not support watching")
+	}
+
+	events, cancel := pw.WatchPrefix(c.Param("prefix"))
+	defer cancel()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
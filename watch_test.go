@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of ch, failing t if none comes.
+func nextEvent[K comparable, V any](t *testing.T, ch <-chan Event[K, V]) Event[K, V] {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event came")
+	}
+	return Event[K, V]{}
+}
+
+// expectNoEvent fails t if ch has an event pending.
+func expectNoEvent[K comparable, V any](t *testing.T, ch <-chan Event[K, V]) {
+	t.Helper()
+	select {
+	case event := <-ch:
+		t.Fatalf("got an unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	st := NewKVStore[string, string]()
+	events, cancel := st.WatchPrefix("user:")
+
+	st.Put("user:1", "a")
+	st.Put("order:1", "b")
+	st.Put("user", "c")
+	st.Delete("user:1")
+
+	if e := nextEvent(t, events); e.Type != EventPut || e.Key != "user:1" || e.Value != "a" {
+		t.Fatalf("got %+v", e)
+	}
+	if e := nextEvent(t, events); e.Type != EventDelete || e.Key != "user:1" {
+		t.Fatalf("got %+v", e)
+	}
+	expectNoEvent(t, events)
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("the channel is still open after cancel")
+	}
+	st.Put("user:2", "a")
+}
+
+func TestWatchPrefixOverSSE(t *testing.T) {
+	st := NewKVStore[string, string]()
+	_, h := newTestServer(st)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/watch/prefix/user:", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+
+	st.Put("order:1", "b")
+	st.Put("user:1", "a")
+
+	r := bufio.NewReader(res.Body)
+	line, err := r.ReadString('\n')
+	if err != nil || line != "event: put\n" {
+		t.Fatalf("got %q, %v", line, err)
+	}
+	line, _ = r.ReadString('\n')
+	if !strings.Contains(line, `"key":"user:1"`) {
+		t.Fatalf("got %q", line)
+	}
+}
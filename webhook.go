@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookQueueSize = 1000
+	defaultWebhookRetries   = 3
+	defaultWebhookBackoff   = 100 * time.Millisecond
+	defaultWebhookTimeout   = 5 * time.Second
+)
+
+// WebhookEvent is the JSON body POSTed to the eviction webhook, Reason is "evict" for a key evicted to make room
+// and "expire" for a key whose TTL elapsed. Value is only sent by the webhooks created WithWebhookValues.
+type WebhookEvent[K comparable, V any] struct {
+	Key    K         `json:"key"`
+	Reason EventType `json:"reason"`
+	Value  *V        `json:"value,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// WebhookOption configures the optional behaviour of the eviction webhook.
+type WebhookOption func(*webhook)
+
+// WithWebhookValues makes the webhook send the value of the removed key along with it.
+func WithWebhookValues() WebhookOption {
+	return func(w *webhook) {
+		w.values = true
+	}
+}
+
+// WithWebhookQueueSize sets how many events can wait to be delivered, once it's full the oldest ones are dropped.
+// The default is 1000.
+func WithWebhookQueueSize(n int) WebhookOption {
+	return func(w *webhook) {
+		w.queue = make(chan []byte, max(n, 1))
+	}
+}
+
+// WithWebhookRetries sets how many times a failed delivery is retried, waiting backoff before the first retry and
+// twice as long before every next one. The default is 3 retries starting at 100ms.
+func WithWebhookRetries(n int, backoff time.Duration) WebhookOption {
+	return func(w *webhook) {
+		w.retries, w.backoff = n, backoff
+	}
+}
+
+// WithWebhookHTTPClient sets the http.Client the events are POSTed with, the default one times out after 5s.
+func WithWebhookHTTPClient(client *http.Client) WebhookOption {
+	return func(w *webhook) {
+		w.client = client
+	}
+}
+
+// WithEvictionWebhook makes the store POST a WebhookEvent to url for every key that's evicted or expires. The
+// events are delivered in order by a background goroutine, so the writes never wait for the webhook. A failed
+// delivery is retried, and the event is dropped and logged once the retries are exhausted or the queue
+// overflows. The events still queued when the store is closed are dropped.
+func WithEvictionWebhook[K comparable, V any](url string, opts ...WebhookOption) Option[K, V] {
+	w := &webhook{
+		url:     url,
+		client:  &http.Client{Timeout: defaultWebhookTimeout},
+		queue:   make(chan []byte, defaultWebhookQueueSize),
+		retries: defaultWebhookRetries,
+		backoff: defaultWebhookBackoff,
+		logger:  defaultLogger,
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return func(s *KVStore[K, V]) {
+		s.webhook = w
+	}
+}
+
+// webhook delivers the queued events, encoded beforehand so it doesn't depend on the types of the store.
+type webhook struct {
+	url     string
+	client  *http.Client
+	values  bool
+	retries int
+	backoff time.Duration
+	logger  Logger
+
+	queue     chan []byte
+	startOnce sync.Once
+	stop      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// notifyWebhook queues the event for the webhook if the store has one and it's an eviction or an expiration.
+// It's called from notify, with the lock of the store held.
+func (s *KVStore[K, V]) notifyWebhook(typ EventType, key K, value V) {
+	w := s.webhook
+	if w == nil || typ != EventEvict && typ != EventExpire {
+		return
+	}
+
+	event := WebhookEvent[K, V]{Key: key, Reason: typ, Time: s.clock.Now()}
+	if w.values {
+		event.Value = &value
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("encoding the webhook event of key (%v): %v", key, err)
+		return
+	}
+	// The logger of the store isn't known before all the options were applied, so the delivery starts with the
+	// first event.
+	w.startOnce.Do(func() {
+		w.logger = s.logger
+		w.wg.Add(1)
+		go w.run()
+	})
+	w.enqueue(body)
+}
+
+// enqueue adds the event to the queue, dropping the oldest one if it's full.
+func (w *webhook) enqueue(body []byte) {
+	for {
+		select {
+		case w.queue <- body:
+			return
+		default:
+		}
+		select {
+		case dropped := <-w.queue:
+			w.logger.Error("the webhook queue is full, dropped the event %s", dropped)
+		default:
+		}
+	}
+}
+
+func (w *webhook) run() {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-w.stop:
+			if n := len(w.queue); n > 0 {
+				w.logger.Error("the store was closed with %d webhook events left, they were dropped", n)
+			}
+			return
+		case body := <-w.queue:
+			w.deliver(ctx, body)
+		}
+	}
+}
+
+// deliver POSTs the event, retrying until it gets a 2xx, the retries run out or the store is closed.
+func (w *webhook) deliver(ctx context.Context, body []byte) {
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		err := w.post(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.retries || ctx.Err() != nil {
+			w.logger.Error("delivering the webhook event %s failed, dropped it: %v", body, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("the webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// close stops the delivery and waits for it to return.
+func (w *webhook) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	// Makes sure an event racing with close can't start the delivery after we've waited for it.
+	w.startOnce.Do(func() {})
+	w.wg.Wait()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local webhook failing its first failures requests with 503, it sends the events it
+// receives after that to events.
+func webhookReceiver(t *testing.T, failures int32) (url string, events chan WebhookEvent[string, string], calls *atomic.Int32) {
+	t.Helper()
+	events = make(chan WebhookEvent[string, string], 100)
+	calls = &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var event WebhookEvent[string, string]
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, &event) != nil {
+			t.Errorf("got a %s of %s: %s", r.Method, r.Header.Get("Content-Type"), body)
+		}
+		events <- event
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, events, calls
+}
+
+// nextDelivery waits for the next event delivered to the receiver.
+func nextDelivery(t *testing.T, events chan WebhookEvent[string, string]) WebhookEvent[string, string] {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook wasn't called")
+	}
+	return WebhookEvent[string, string]{}
+}
+
+func TestEvictionWebhook(t *testing.T) {
+	url, events, _ := webhookReceiver(t, 0)
+	st := NewKVStoreWithByteCapacity[string, string](entrySize("a", "1")*2,
+		WithEvictionWebhook[string, string](url, WithWebhookValues()))
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.Put("b", "2")
+	st.Put("c", "3")
+
+	event := nextDelivery(t, events)
+	if event.Key != "a" || event.Reason != EventEvict || event.Value == nil || *event.Value != "1" || event.Time.IsZero() {
+		t.Fatalf("got %+v", event)
+	}
+	// Deletes and overwrites aren't sent.
+	st.Delete("b")
+	st.Put("c", "4")
+	select {
+	case event := <-events:
+		t.Fatalf("got %+v for a delete", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestExpirationWebhook(t *testing.T) {
+	url, events, _ := webhookReceiver(t, 0)
+	clock := NewFakeClock(time.Unix(1000, 0))
+	st := NewKVStore[string, string](WithClock[string, string](clock), WithEvictionWebhook[string, string](url))
+	defer st.Close()
+
+	st.PutWithTTL("session", "secret", time.Minute)
+	clock.Advance(time.Minute + time.Nanosecond)
+	st.Get("session")
+
+	event := nextDelivery(t, events)
+	if event.Key != "session" || event.Reason != EventExpire {
+		t.Fatalf("got %+v", event)
+	}
+	if event.Value != nil {
+		t.Fatal("the value was sent without WithWebhookValues")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	url, events, calls := webhookReceiver(t, 2)
+	st := NewKVStoreWithByteCapacity[string, string](entrySize("a", "1"),
+		WithEvictionWebhook[string, string](url, WithWebhookRetries(2, time.Millisecond)))
+	defer st.Close()
+
+	st.Put("a", "1")
+	st.Put("b", "2")
+	if event := nextDelivery(t, events); event.Key != "a" {
+		t.Fatalf("got %+v", event)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("the webhook was called %d times, want 2 failures and a success", n)
+	}
+}
+
+func TestWebhookQueueDropsTheOldest(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent[string, string]
+		json.NewDecoder(r.Body).Decode(&event)
+		<-release
+		received <- event.Key
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	logger := &recordingLogger{}
+	st := NewKVStoreWithByteCapacity[string, string](entrySize("a", "1"),
+		WithStoreLogger[string, string](logger),
+		WithEvictionWebhook[string, string](srv.URL, WithWebhookQueueSize(2)))
+	defer st.Close()
+
+	// The first eviction is held by the receiver, the next ones wait in the queue of 2.
+	st.Put("a", "1")
+	st.Put("b", "1")
+	waitFor(t, func() bool { return len(st.webhook.queue) == 0 })
+	for _, key := range []string{"c", "d", "e", "f"} {
+		st.Put(key, "1")
+	}
+	if !logger.contains("the webhook queue is full, dropped the event") {
+		t.Fatalf("the drops weren't logged: %q", logger.messages)
+	}
+
+	for _, want := range []string{"a", "d", "e"} {
+		release <- struct{}{}
+		if key := <-received; key != want {
+			t.Fatalf("got the eviction of %s, want %s", key, want)
+		}
+	}
+}